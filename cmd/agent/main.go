@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -16,10 +17,11 @@ import (
 	"github.com/25x8/metric-gathering/internal/agent/senders"
 	"github.com/25x8/metric-gathering/internal/buildinfo"
 	"github.com/25x8/metric-gathering/internal/config"
+	"github.com/25x8/metric-gathering/internal/storage"
 	"github.com/25x8/metric-gathering/internal/utils"
 )
 
-func worker(ctx context.Context, metricsChan <-chan map[string]interface{}, sender *senders.HTTPSender, wg *sync.WaitGroup, keyFlag string, publicKey *rsa.PublicKey) {
+func worker(ctx context.Context, metricsChan <-chan map[string]interface{}, sender senders.Sender, wg *sync.WaitGroup, keyFlag string, publicKey *rsa.PublicKey) {
 	defer wg.Done()
 	for {
 		select {
@@ -62,6 +64,9 @@ func main() {
 	rateLimit := flag.Int("l", 2, "Number of outgoing requests")
 	memProfile := flag.Bool("memprofile", false, "enable memory profiling")
 	cryptoKeyPath := flag.String("crypto-key", "", "Path to public key file for encryption")
+	grpcAddr := flag.String("grpc-address", "", "gRPC server address (HTTP is used if empty)")
+	batchEncoding := flag.String("encoding", senders.EncodingJSON, "Batch encoding for HTTP: json, protobuf or msgpack")
+	labelsFlag := flag.String("labels", "", "Comma-separated key=value labels attached to every metric, e.g. host=web-1")
 	configPath := flag.String("c", "", "Path to JSON config file")
 
 	configAltFlag := flag.String("config", "", "Path to JSON config file (alternative)")
@@ -108,6 +113,22 @@ func main() {
 			if flag.Lookup("crypto-key").Value.String() == "" {
 				*cryptoKeyPath = cfg.CryptoKey
 			}
+
+			if flag.Lookup("grpc-address").Value.String() == "" {
+				*grpcAddr = cfg.GRPCAddress
+			}
+
+			if flag.Lookup("encoding").Value.String() == senders.EncodingJSON && cfg.BatchEncoding != "" {
+				*batchEncoding = cfg.BatchEncoding
+			}
+
+			if flag.Lookup("labels").Value.String() == "" && len(cfg.Labels) > 0 {
+				pairs := make([]string, 0, len(cfg.Labels))
+				for k, v := range cfg.Labels {
+					pairs = append(pairs, k+"="+v)
+				}
+				*labelsFlag = strings.Join(pairs, ",")
+			}
 		}
 	}
 
@@ -147,6 +168,29 @@ func main() {
 		*cryptoKeyPath = envCryptoKey
 	}
 
+	if envGRPCAddr := os.Getenv("GRPC_ADDRESS"); envGRPCAddr != "" {
+		*grpcAddr = envGRPCAddr
+	}
+
+	if envBatchEncoding := os.Getenv("BATCH_ENCODING"); envBatchEncoding != "" {
+		*batchEncoding = envBatchEncoding
+	}
+
+	if envLabels := os.Getenv("LABELS"); envLabels != "" {
+		*labelsFlag = envLabels
+	}
+
+	labels, err := storage.ParseLabels(*labelsFlag)
+	if err != nil {
+		log.Fatalf("Invalid labels: %v", err)
+	}
+
+	switch *batchEncoding {
+	case senders.EncodingJSON, senders.EncodingProtobuf, senders.EncodingMsgpack:
+	default:
+		log.Fatalf("Unknown batch encoding: %s", *batchEncoding)
+	}
+
 	var publicKey *rsa.PublicKey
 	if *cryptoKeyPath != "" {
 		var err error
@@ -158,7 +202,26 @@ func main() {
 	}
 
 	collector := collectors.NewMetricsCollector()
-	sender := senders.NewHTTPSender("http://" + *addr)
+
+	var sender senders.Sender
+	if *grpcAddr != "" {
+		if publicKey != nil {
+			log.Fatalf("Encryption with crypto-key is not supported by the gRPC sender")
+		}
+		grpcSender, err := senders.NewGRPCSender(*grpcAddr, *keyFlag)
+		if err != nil {
+			log.Fatalf("Failed to create gRPC sender: %v", err)
+		}
+		defer grpcSender.Close()
+		grpcSender.Labels = labels
+		sender = grpcSender
+		log.Printf("Sending metrics via gRPC to %s", *grpcAddr)
+	} else {
+		httpSender := senders.NewHTTPSender("http://" + *addr)
+		httpSender.Encoding = *batchEncoding
+		httpSender.Labels = labels
+		sender = httpSender
+	}
 
 	tickerPoll := time.NewTicker(time.Duration(*pollInterval) * time.Second)
 	tickerReport := time.NewTicker(time.Duration(*reportInterval) * time.Second)
@@ -205,7 +268,8 @@ func main() {
 				log.Println("Stopping metrics reporting...")
 				return
 			case <-tickerReport.C:
-				metrics := collector.GetMetrics()
+				// Значения передаются со временем сбора: отправка может задержаться в очереди или при повторах
+				metrics := collector.GetSamples()
 				if len(metrics) == 0 {
 					continue
 				}
@@ -1,55 +1,46 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"syscall"
+	"time"
 
 	"github.com/25x8/metric-gathering/internal/app"
 	"github.com/25x8/metric-gathering/internal/buildinfo"
+	"github.com/25x8/metric-gathering/internal/graphite"
+	"github.com/25x8/metric-gathering/internal/grpcserver"
+	"github.com/25x8/metric-gathering/internal/statsd"
 	"github.com/25x8/metric-gathering/internal/storage"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"google.golang.org/grpc"
 )
 
-func init() {
-	flag.String("a", "localhost:8080", "HTTP server address")
-	flag.Int("i", 300, "Store interval in seconds (0 for synchronous saving)")
-	flag.String("f", "/tmp/metrics-db.json", "File storage path")
-	flag.Bool("r", true, "Restore metrics from file at startup")
-	flag.String("d", "", "Database connection string")
-	flag.String("k", "", "Secret key for hashing")
-}
+// shutdownTimeout - время на завершение активных HTTP-запросов при остановке сервера
+const shutdownTimeout = 10 * time.Second
 
 func main() {
 	buildinfo.PrintBuildInfo()
 
+	// Остальные флаги сервера объявляются и разбираются в app.InitializeApp
 	memProfile := flag.Bool("memprofile", false, "enable memory profiling")
 	cryptoKeyPath := flag.String("crypto-key", "", "Path to private key file for decryption")
-	configPath := flag.String("c", "", "Path to JSON config file")
-	configAltPath := flag.String("config", "", "Path to JSON config file (alternative)")
-
-	flag.Parse()
 
-	if *configPath == "" && *configAltPath != "" {
-		*configPath = *configAltPath
-	}
+	defer app.SyncLogger()
 
-	if envConfig := os.Getenv("CONFIG"); envConfig != "" && *configPath == "" {
-		*configPath = envConfig
-	}
+	h, cfg := app.InitializeApp()
 
 	if envCryptoKey := os.Getenv("CRYPTO_KEY"); envCryptoKey != "" {
 		*cryptoKeyPath = envCryptoKey
 	}
 
-	defer app.SyncLogger()
-
-	h, addr, key := app.InitializeApp()
-
 	privateKeyPath := *cryptoKeyPath
 
 	storageImpl := h.Storage
@@ -80,22 +71,94 @@ func main() {
 		}()
 	}
 
-	r := app.InitializeRouter(h, key, privateKeyPath)
+	r := app.InitializeRouter(h, cfg.Key, privateKeyPath)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
+	server := &http.Server{Addr: cfg.Address, Handler: r}
+	if h.Changes != nil {
+		server.RegisterOnShutdown(h.Changes.Close)
+	}
+
 	go func() {
-		log.Printf("Server started at %s\n", addr)
-		if err := http.ListenAndServe(addr, r); err != nil {
+		log.Printf("Server started at %s\n", cfg.Address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Printf("Server error: %v\n", err)
 			stop <- syscall.SIGTERM
 		}
 	}()
 
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddress != "" {
+		grpcListener, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+		grpcServer = grpcserver.NewGRPCServer(storageImpl, cfg.Key)
+		go func() {
+			log.Printf("gRPC server started at %s\n", cfg.GRPCAddress)
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				log.Printf("gRPC server error: %v\n", err)
+			}
+		}()
+	}
+
+	var statsdListener *statsd.Listener
+	if cfg.StatsdAddress != "" {
+		statsdListener = statsd.NewListener(cfg.StatsdAddress, storageImpl)
+		if err := statsdListener.Listen(); err != nil {
+			log.Fatalf("Failed to start StatsD listener: %v", err)
+		}
+		go func() {
+			log.Printf("StatsD listener started at %s\n", cfg.StatsdAddress)
+			if err := statsdListener.Serve(); err != nil {
+				log.Printf("StatsD listener error: %v\n", err)
+			}
+		}()
+	}
+
+	var graphiteListener *graphite.Listener
+	if cfg.GraphiteAddress != "" {
+		graphiteListener = graphite.NewListener(cfg.GraphiteAddress, storageImpl, cfg.GraphiteTypes)
+		graphiteListener.MaxConns = cfg.GraphiteMaxConns
+		graphiteListener.IdleTimeout = time.Duration(cfg.GraphiteIdleTimeout) * time.Second
+		if err := graphiteListener.Listen(); err != nil {
+			log.Fatalf("Failed to start Graphite listener: %v", err)
+		}
+		go func() {
+			log.Printf("Graphite listener started at %s\n", cfg.GraphiteAddress)
+			if err := graphiteListener.Serve(); err != nil {
+				log.Printf("Graphite listener error: %v\n", err)
+			}
+		}()
+	}
+
 	<-stop
 	log.Println("Server shutdown initiated...")
 
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error during HTTP server shutdown: %v", err)
+	}
+
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+
+	if graphiteListener != nil {
+		if err := graphiteListener.Close(); err != nil {
+			log.Printf("Error closing Graphite listener: %v", err)
+		}
+	}
+
+	if statsdListener != nil {
+		if err := statsdListener.Close(); err != nil {
+			log.Printf("Error closing StatsD listener: %v", err)
+		}
+	}
+
 	if memStorage, ok := storageImpl.(*storage.MemStorage); ok {
 		if err := memStorage.Flush(); err != nil {
 			log.Printf("Error during flush: %v", err)
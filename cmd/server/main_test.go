@@ -47,7 +47,7 @@ func TestGetNonExistentMetric(t *testing.T) {
 	assert.Equal(t, "metric not found", err.Error())
 }
 
-func TestGetAllMetrics(t *testing.T) {
+func TestListAllMetrics(t *testing.T) {
 	store := storage.NewMemStorage("")
 
 	// Сохраняем несколько метрик
@@ -58,11 +58,12 @@ func TestGetAllMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Извлекаем все метрики
-	allMetrics := store.GetAllMetrics()
+	allMetrics, err := store.ListAllMetrics()
+	require.NoError(t, err)
 
 	// Проверяем значения
-	assert.Equal(t, 12345.67, allMetrics["Alloc"])
-	assert.Equal(t, int64(3), allMetrics["PollCount"])
+	assert.Equal(t, 12345.67, allMetrics.Gauges["Alloc"])
+	assert.Equal(t, int64(3), allMetrics.Counters["PollCount"])
 }
 
 func TestSaveAndLoadMetrics(t *testing.T) {
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/25x8/metric-gathering/internal/agent/senders"
 	"github.com/25x8/metric-gathering/internal/agent/storage"
 
 	"github.com/shirou/gopsutil/v4/cpu"
@@ -20,12 +21,14 @@ type MetricsCollector struct {
 	lastPollCount int64
 	mu            sync.Mutex
 	metrics       map[string]interface{}
+	timestamps    map[string]time.Time // время сбора каждой метрики
 }
 
 // NewMetricsCollector - конструктор для MetricsCollector
 func NewMetricsCollector() *MetricsCollector {
 	return &MetricsCollector{
-		metrics: make(map[string]interface{}),
+		metrics:    make(map[string]interface{}),
+		timestamps: make(map[string]time.Time),
 	}
 }
 
@@ -44,57 +47,61 @@ func (c *MetricsCollector) CollectSystemMetrics() {
 		return
 	}
 
-	c.metrics["TotalMemory"] = float64(vMem.Total)
-	c.metrics["FreeMemory"] = float64(vMem.Free)
+	now := time.Now()
+	c.mu.Lock()
+	c.set("TotalMemory", float64(vMem.Total), now)
+	c.set("FreeMemory", float64(vMem.Free), now)
 
 	for i, cpuLoad := range cpuUtilization {
 		metricName := "CPUutilization" + strconv.Itoa(i+1)
-		c.metrics[metricName] = cpuLoad
+		c.set(metricName, cpuLoad, now)
 	}
+	c.mu.Unlock()
 
 	log.Println("System metrics collected")
 
 }
 
-// Collect - метод для сбора метрик
+// Collect - метод для сбора метрик. Каждая метрика получает время сбора, которое передается на сервер.
 func (c *MetricsCollector) Collect() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
-
-	c.metrics["Alloc"] = float64(memStats.Alloc)
-	c.metrics["BuckHashSys"] = float64(memStats.BuckHashSys)
-	c.metrics["Frees"] = float64(memStats.Frees)
-	c.metrics["GCCPUFraction"] = memStats.GCCPUFraction // Это уже float64
-	c.metrics["GCSys"] = float64(memStats.GCSys)
-	c.metrics["HeapAlloc"] = float64(memStats.HeapAlloc)
-	c.metrics["HeapIdle"] = float64(memStats.HeapIdle)
-	c.metrics["HeapInuse"] = float64(memStats.HeapInuse)
-	c.metrics["HeapObjects"] = float64(memStats.HeapObjects)
-	c.metrics["HeapReleased"] = float64(memStats.HeapReleased)
-	c.metrics["HeapSys"] = float64(memStats.HeapSys)
-	c.metrics["LastGC"] = float64(memStats.LastGC)
-	c.metrics["Lookups"] = float64(memStats.Lookups)
-	c.metrics["MCacheInuse"] = float64(memStats.MCacheInuse)
-	c.metrics["MCacheSys"] = float64(memStats.MCacheSys)
-	c.metrics["MSpanInuse"] = float64(memStats.MSpanInuse)
-	c.metrics["MSpanSys"] = float64(memStats.MSpanSys)
-	c.metrics["Mallocs"] = float64(memStats.Mallocs)
-	c.metrics["NextGC"] = float64(memStats.NextGC)
-	c.metrics["NumForcedGC"] = float64(memStats.NumForcedGC)
-	c.metrics["NumGC"] = float64(memStats.NumGC)
-	c.metrics["OtherSys"] = float64(memStats.OtherSys)
-	c.metrics["PauseTotalNs"] = float64(memStats.PauseTotalNs)
-	c.metrics["StackInuse"] = float64(memStats.StackInuse)
-	c.metrics["StackSys"] = float64(memStats.StackSys)
-	c.metrics["Sys"] = float64(memStats.Sys)
-	c.metrics["TotalAlloc"] = float64(memStats.TotalAlloc)
-	c.metrics["RandomValue"] = rand.Float64()
+	now := time.Now()
+
+	c.set("Alloc", float64(memStats.Alloc), now)
+	c.set("BuckHashSys", float64(memStats.BuckHashSys), now)
+	c.set("Frees", float64(memStats.Frees), now)
+	c.set("GCCPUFraction", memStats.GCCPUFraction, now) // Это уже float64
+	c.set("GCSys", float64(memStats.GCSys), now)
+	c.set("HeapAlloc", float64(memStats.HeapAlloc), now)
+	c.set("HeapIdle", float64(memStats.HeapIdle), now)
+	c.set("HeapInuse", float64(memStats.HeapInuse), now)
+	c.set("HeapObjects", float64(memStats.HeapObjects), now)
+	c.set("HeapReleased", float64(memStats.HeapReleased), now)
+	c.set("HeapSys", float64(memStats.HeapSys), now)
+	c.set("LastGC", float64(memStats.LastGC), now)
+	c.set("Lookups", float64(memStats.Lookups), now)
+	c.set("MCacheInuse", float64(memStats.MCacheInuse), now)
+	c.set("MCacheSys", float64(memStats.MCacheSys), now)
+	c.set("MSpanInuse", float64(memStats.MSpanInuse), now)
+	c.set("MSpanSys", float64(memStats.MSpanSys), now)
+	c.set("Mallocs", float64(memStats.Mallocs), now)
+	c.set("NextGC", float64(memStats.NextGC), now)
+	c.set("NumForcedGC", float64(memStats.NumForcedGC), now)
+	c.set("NumGC", float64(memStats.NumGC), now)
+	c.set("OtherSys", float64(memStats.OtherSys), now)
+	c.set("PauseTotalNs", float64(memStats.PauseTotalNs), now)
+	c.set("StackInuse", float64(memStats.StackInuse), now)
+	c.set("StackSys", float64(memStats.StackSys), now)
+	c.set("Sys", float64(memStats.Sys), now)
+	c.set("TotalAlloc", float64(memStats.TotalAlloc), now)
+	c.set("RandomValue", rand.Float64(), now)
 
 	c.PollCount++
-	c.metrics["PollCount"] = c.PollCount
+	c.set("PollCount", c.PollCount, now)
 
 }
 
@@ -125,10 +132,34 @@ func (c *MetricsCollector) CollectAndStore(store *storage.MemStorage) error {
 	return nil
 }
 
+// set сохраняет значение метрики и время его сбора. Вызывается под блокировкой.
+func (c *MetricsCollector) set(name string, value interface{}, ts time.Time) {
+	c.metrics[name] = value
+	c.timestamps[name] = ts
+}
+
+// GetSamples возвращает то же, что GetMetrics, но каждое значение обернуто в senders.Sample
+// со временем сбора.
+func (c *MetricsCollector) GetSamples() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := c.snapshot()
+	for name, value := range samples {
+		samples[name] = senders.Sample{Value: value, Timestamp: c.timestamps[name]}
+	}
+	return samples
+}
+
 func (c *MetricsCollector) GetMetrics() map[string]interface{} {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.snapshot()
+}
 
+// snapshot копирует текущие значения метрик, для PollCount - приращение с последнего сохранения.
+// Вызывается под блокировкой.
+func (c *MetricsCollector) snapshot() map[string]interface{} {
 	metricsCopy := make(map[string]interface{})
 	for k, v := range c.metrics {
 		if k == "PollCount" {
@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/25x8/metric-gathering/internal/agent/senders"
 	"github.com/25x8/metric-gathering/internal/agent/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type Metric struct {
@@ -168,3 +170,19 @@ func TestHTTPSender_Send(t *testing.T) {
 	})
 
 }
+
+func TestMetricsCollector_GetSamples(t *testing.T) {
+	collector := NewMetricsCollector()
+	before := time.Now()
+	collector.Collect()
+
+	samples := collector.GetSamples()
+	require.Contains(t, samples, "PollCount")
+
+	for name, value := range samples {
+		sample, ok := value.(senders.Sample)
+		require.True(t, ok, "Метрика %s должна содержать время сбора", name)
+		assert.False(t, sample.Timestamp.Before(before))
+	}
+	assert.Equal(t, int64(1), samples["PollCount"].(senders.Sample).Value)
+}
@@ -0,0 +1,116 @@
+package senders
+
+import (
+	"context"
+	"crypto/rsa"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/grpcapi"
+	"github.com/25x8/metric-gathering/internal/metricspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcRequestTimeout - ограничение времени одного вызова gRPC
+const grpcRequestTimeout = 10 * time.Second
+
+// GRPCSender - структура для отправки метрик на сервер по gRPC
+type GRPCSender struct {
+	Labels map[string]string // метки, добавляемые ко всем метрикам агента
+
+	conn   *grpc.ClientConn
+	client metricspb.MetricsClient
+}
+
+// NewGRPCSender - конструктор для GRPCSender.
+// Если key не пустой, запросы подписываются HMAC-SHA256. Все запросы сжимаются gzip.
+func NewGRPCSender(addr string, key string) (*GRPCSender, error) {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(
+			grpcapi.UnaryClientHashInterceptor(key),
+			grpcapi.UnaryClientGzipInterceptor(),
+		),
+		grpc.WithChainStreamInterceptor(
+			grpcapi.StreamClientHashInterceptor(key),
+			grpcapi.StreamClientGzipInterceptor(),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCSender{
+		conn:   conn,
+		client: metricspb.NewMetricsClient(conn),
+	}, nil
+}
+
+// SendBatch отправляет метрики одним вызовом UpdateMetrics.
+// Шифрование RSA в gRPC не поддерживается, publicKey игнорируется.
+func (s *GRPCSender) SendBatch(metrics map[string]interface{}, publicKey *rsa.PublicKey) error {
+	batch := toProtoMetrics(metrics, s.Labels)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grpcRequestTimeout)
+	defer cancel()
+
+	_, err := s.client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{Metrics: batch})
+	return err
+}
+
+// Send передает метрики по одной в потоке PushMetrics.
+// Подпись задается при создании GRPCSender, поэтому key и publicKey игнорируются.
+func (s *GRPCSender) Send(metrics map[string]interface{}, key string, publicKey *rsa.PublicKey) error {
+	batch := toProtoMetrics(metrics, s.Labels)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grpcRequestTimeout)
+	defer cancel()
+
+	stream, err := s.client.PushMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range batch {
+		if err := stream.Send(m); err != nil {
+			return err
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// Close закрывает соединение с сервером.
+func (s *GRPCSender) Close() error {
+	return s.conn.Close()
+}
+
+// toProtoMetrics преобразует карту метрик агента в сообщения gRPC.
+func toProtoMetrics(metrics map[string]interface{}, labels map[string]string) []*metricspb.Metric {
+	batch := make([]*metricspb.Metric, 0, len(metrics))
+	for name, value := range metrics {
+		m := &metricspb.Metric{Id: name, Labels: labels}
+		value, ts := unwrapSample(value)
+		if ts != nil {
+			m.Timestamp = timestamppb.New(*ts)
+		}
+		switch v := value.(type) {
+		case int64:
+			m.Type = metricspb.MetricType_COUNTER
+			m.Delta = &v
+		case float64:
+			m.Type = metricspb.MetricType_GAUGE
+			m.Value = &v
+		default:
+			continue
+		}
+		batch = append(batch, m)
+	}
+	return batch
+}
@@ -0,0 +1,49 @@
+package senders
+
+import (
+	"net"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/grpcserver"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGRPCSender(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpcserver.NewGRPCServer(memStorage, "secret")
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	sender, err := NewGRPCSender(lis.Addr().String(), "secret")
+	require.NoError(t, err)
+	defer sender.Close()
+
+	metrics := map[string]interface{}{
+		"Alloc":     12345.67,
+		"PollCount": int64(2),
+		"Ignored":   "text",
+	}
+
+	require.NoError(t, sender.SendBatch(metrics, nil))
+	require.NoError(t, sender.Send(metrics, "", nil))
+
+	alloc, err := memStorage.GetGaugeMetric("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 12345.67, alloc)
+
+	pollCount, err := memStorage.GetCounterMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), pollCount)
+
+	// Неверный ключ отклоняется сервером
+	badSender, err := NewGRPCSender(lis.Addr().String(), "wrong")
+	require.NoError(t, err)
+	defer badSender.Close()
+	assert.Error(t, badSender.SendBatch(metrics, nil))
+	assert.Error(t, badSender.Send(metrics, "", nil))
+}
@@ -8,21 +8,37 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
+	"github.com/25x8/metric-gathering/internal/metricspb"
 	"github.com/25x8/metric-gathering/internal/utils"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Кодировки пакета метрик, отправляемого на /updates/
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+	EncodingMsgpack  = "msgpack"
 )
 
 // Metric Структура метрики
 type Metric struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID     string            `json:"id" msgpack:"id"`
+	MType  string            `json:"type" msgpack:"type"`
+	Delta  *int64            `json:"delta,omitempty" msgpack:"delta,omitempty"`
+	Value  *float64          `json:"value,omitempty" msgpack:"value,omitempty"`
+	Labels map[string]string `json:"labels,omitempty" msgpack:"labels,omitempty"`
+
+	Timestamp *time.Time `json:"timestamp,omitempty" msgpack:"timestamp,omitempty"` // время сбора
 }
 
 // HTTPSender - структура для отправки метрик на сервер
 type HTTPSender struct {
 	ServerURL string
+	Encoding  string            // кодировка пакета метрик: json (по умолчанию), protobuf или msgpack
+	Labels    map[string]string // метки, добавляемые ко всем метрикам агента
 }
 
 // NewHTTPSender - конструктор для HTTPSender
@@ -42,6 +58,8 @@ func (s *HTTPSender) SendBatch(metrics map[string]interface{}, publicKey *rsa.Pu
 	for key, value := range metrics {
 		var metric Metric
 		metric.ID = key
+		metric.Labels = s.Labels
+		value, metric.Timestamp = unwrapSample(value)
 		switch v := value.(type) {
 		case int64:
 			metric.MType = "counter"
@@ -59,14 +77,14 @@ func (s *HTTPSender) SendBatch(metrics map[string]interface{}, publicKey *rsa.Pu
 		return nil
 	}
 
-	jsonData, err := json.Marshal(metricsSlice)
+	data, contentType, err := s.encodeBatch(metricsSlice, metrics)
 	if err != nil {
 		return err
 	}
 
 	var compressedBody bytes.Buffer
 	gzipWriter := gzip.NewWriter(&compressedBody)
-	_, err = gzipWriter.Write(jsonData)
+	_, err = gzipWriter.Write(data)
 	if err != nil {
 		return err
 	}
@@ -105,7 +123,7 @@ func (s *HTTPSender) SendBatch(metrics map[string]interface{}, publicKey *rsa.Pu
 	}
 
 	req.Header.Set("Accept-Encoding", "gzip")
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -120,7 +138,29 @@ func (s *HTTPSender) SendBatch(metrics map[string]interface{}, publicKey *rsa.Pu
 	return nil
 }
 
+// encodeBatch сериализует пакет метрик в кодировке s.Encoding и возвращает тело запроса и его Content-Type
+func (s *HTTPSender) encodeBatch(metricsSlice []Metric, metrics map[string]interface{}) ([]byte, string, error) {
+	switch s.Encoding {
+	case "", EncodingJSON:
+		data, err := json.Marshal(metricsSlice)
+		return data, "application/json", err
+	case EncodingProtobuf:
+		data, err := proto.Marshal(&metricspb.UpdateMetricsRequest{Metrics: toProtoMetrics(metrics, s.Labels)})
+		return data, "application/x-protobuf", err
+	case EncodingMsgpack:
+		data, err := msgpack.Marshal(metricsSlice)
+		return data, "application/msgpack", err
+	default:
+		return nil, "", fmt.Errorf("unknown batch encoding: %s", s.Encoding)
+	}
+}
+
 func (s *HTTPSender) Send(metrics map[string]interface{}, key string, publicKey *rsa.PublicKey) error {
+	// Метки и время сбора нельзя передать в URL /update/{type}/{name}/{value}, поэтому используется JSON-эндпоинт
+	if len(s.Labels) > 0 || hasSamples(metrics) {
+		return s.sendJSON(metrics, key, publicKey)
+	}
+
 	for keyName, value := range metrics {
 		var metricType string
 		switch value.(type) {
@@ -202,3 +242,76 @@ func (s *HTTPSender) Send(metrics map[string]interface{}, key string, publicKey
 	}
 	return nil
 }
+
+// sendJSON отправляет метрики по одной на /update/ в формате JSON вместе с метками агента и временем сбора
+func (s *HTTPSender) sendJSON(metrics map[string]interface{}, key string, publicKey *rsa.PublicKey) error {
+	for name, value := range metrics {
+		metric := Metric{ID: name, Labels: s.Labels}
+		value, metric.Timestamp = unwrapSample(value)
+		switch v := value.(type) {
+		case int64:
+			metric.MType = "counter"
+			metric.Delta = &v
+		case float64:
+			metric.MType = "gauge"
+			metric.Value = &v
+		default:
+			continue
+		}
+
+		jsonData, err := json.Marshal(metric)
+		if err != nil {
+			return err
+		}
+
+		// Сервер расшифровывает тело после распаковки gzip, поэтому шифруется несжатый JSON
+		var requestBody []byte
+		isEncrypted := publicKey != nil
+		if isEncrypted {
+			requestBody, err = utils.EncryptWithPublicKey(jsonData, publicKey)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt data: %w", err)
+			}
+		} else {
+			var compressedBody bytes.Buffer
+			gzipWriter := gzip.NewWriter(&compressedBody)
+			if _, err := gzipWriter.Write(jsonData); err != nil {
+				return err
+			}
+			gzipWriter.Close()
+			requestBody = compressedBody.Bytes()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, s.ServerURL+"/update/", bytes.NewReader(requestBody))
+		if err != nil {
+			return err
+		}
+
+		if isEncrypted {
+			req.Header.Set("Content-Encrypted", "true")
+		} else {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			// Сервер проверяет подпись до расшифровки, но после распаковки gzip:
+			// подписывается зашифрованное тело или исходный JSON
+			signed := jsonData
+			if isEncrypted {
+				signed = requestBody
+			}
+			req.Header.Set("HashSHA256", utils.CalculateHash(signed, key))
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("server returned status: %s", resp.Status)
+		}
+	}
+	return nil
+}
@@ -6,8 +6,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/25x8/metric-gathering/internal/handler"
+	"github.com/25x8/metric-gathering/internal/middleware"
+	"github.com/25x8/metric-gathering/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type Metrics struct {
@@ -141,3 +146,107 @@ func TestPostMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPSender_SendBatchEncodings(t *testing.T) {
+	tests := []struct {
+		encoding    string
+		contentType string
+	}{
+		{encoding: EncodingJSON, contentType: "application/json"},
+		{encoding: EncodingProtobuf, contentType: "application/x-protobuf"},
+		{encoding: EncodingMsgpack, contentType: "application/msgpack"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			memStorage := storage.NewMemStorage("")
+			h := &handler.Handler{Storage: memStorage}
+
+			server := httptest.NewServer(middleware.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tt.contentType, r.Header.Get("Content-Type"))
+				h.HandleUpdatesBatch(w, r)
+			})))
+			defer server.Close()
+
+			sender := NewHTTPSender(server.URL)
+			sender.Encoding = tt.encoding
+
+			err := sender.SendBatch(map[string]interface{}{
+				"Alloc":     12345.67,
+				"PollCount": int64(5),
+			}, nil)
+			require.NoError(t, err)
+
+			alloc, err := memStorage.GetGaugeMetric("Alloc")
+			require.NoError(t, err)
+			assert.Equal(t, 12345.67, alloc)
+
+			pollCount, err := memStorage.GetCounterMetric("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), pollCount)
+		})
+	}
+
+	sender := NewHTTPSender("http://localhost")
+	sender.Encoding = "xml"
+	assert.Error(t, sender.SendBatch(map[string]interface{}{"Alloc": 1.0}, nil))
+}
+
+func TestHTTPSender_Labels(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &handler.Handler{Storage: memStorage}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/updates/", h.HandleUpdatesBatch)
+	mux.HandleFunc("/update/", h.HandleUpdateMetricJSON)
+	server := httptest.NewServer(middleware.GzipMiddleware(mux))
+	defer server.Close()
+
+	sender := NewHTTPSender(server.URL)
+	sender.Labels = map[string]string{"host": "web-1"}
+
+	require.NoError(t, sender.SendBatch(map[string]interface{}{"Alloc": 1.5}, nil))
+	// Send с метками отправляет метрики на JSON-эндпоинт /update/
+	require.NoError(t, sender.Send(map[string]interface{}{"PollCount": int64(2)}, "", nil))
+
+	alloc, err := memStorage.GetGaugeMetric(`Alloc{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, alloc)
+
+	pollCount, err := memStorage.GetCounterMetric(`PollCount{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pollCount)
+}
+
+func TestHTTPSender_Timestamps(t *testing.T) {
+	collected := time.Date(2025, 6, 25, 12, 0, 0, 0, time.UTC)
+
+	for _, encoding := range []string{EncodingJSON, EncodingProtobuf, EncodingMsgpack} {
+		t.Run(encoding, func(t *testing.T) {
+			memStorage := storage.NewMemStorage("")
+			memStorage.OutOfOrder = storage.OutOfOrderLatest
+			h := &handler.Handler{Storage: memStorage}
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("/updates/", h.HandleUpdatesBatch)
+			mux.HandleFunc("/update/", h.HandleUpdateMetricJSON)
+			server := httptest.NewServer(middleware.GzipMiddleware(mux))
+			defer server.Close()
+
+			sender := NewHTTPSender(server.URL)
+			sender.Encoding = encoding
+
+			// Запоздавшая отправка более раннего значения не перезаписывает более позднее
+			require.NoError(t, sender.SendBatch(map[string]interface{}{
+				"Alloc": Sample{Value: 2.0, Timestamp: collected.Add(time.Second)},
+			}, nil))
+			require.NoError(t, sender.Send(map[string]interface{}{
+				"Alloc": Sample{Value: 1.0, Timestamp: collected},
+			}, "", nil))
+
+			alloc, err := memStorage.GetGaugeMetric("Alloc")
+			require.NoError(t, err)
+			assert.Equal(t, 2.0, alloc)
+		})
+	}
+}
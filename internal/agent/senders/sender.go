@@ -0,0 +1,43 @@
+package senders
+
+import (
+	"crypto/rsa"
+	"time"
+)
+
+// Sender - общий интерфейс отправителей метрик агента.
+// Метрики передаются как карта имя -> значение: int64 для counter, float64 для gauge.
+// Значение может быть обернуто в Sample, тогда на сервер передается и время сбора.
+type Sender interface {
+	// SendBatch отправляет все метрики одним запросом.
+	SendBatch(metrics map[string]interface{}, publicKey *rsa.PublicKey) error
+	// Send отправляет метрики по одной; используется, если пакетная отправка не удалась.
+	Send(metrics map[string]interface{}, key string, publicKey *rsa.PublicKey) error
+}
+
+// Sample - значение метрики вместе со временем его сбора.
+// Время передается серверу, чтобы задержки в очереди агента и повторные отправки
+// не сдвигали момент, к которому относится значение.
+type Sample struct {
+	Value     interface{} // int64 для counter, float64 для gauge
+	Timestamp time.Time   // время сбора
+}
+
+// unwrapSample возвращает значение метрики и время сбора (nil, если значение не обернуто в Sample).
+func unwrapSample(value interface{}) (interface{}, *time.Time) {
+	if s, ok := value.(Sample); ok {
+		ts := s.Timestamp
+		return s.Value, &ts
+	}
+	return value, nil
+}
+
+// hasSamples проверяет, что хотя бы одно значение карты обернуто в Sample.
+func hasSamples(metrics map[string]interface{}) bool {
+	for _, value := range metrics {
+		if _, ok := value.(Sample); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -11,9 +11,11 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/25x8/metric-gathering/internal/config"
+	"github.com/25x8/metric-gathering/internal/graphite"
 	"github.com/25x8/metric-gathering/internal/handler"
 	"github.com/25x8/metric-gathering/internal/logger"
 	"github.com/25x8/metric-gathering/internal/middleware"
@@ -54,6 +56,9 @@ func MiddlewareWithHash(key string) func(http.Handler) http.Handler {
 				return
 			}
 
+			// Восстанавливаем тело запроса для следующих обработчиков
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
 			w.Header().Set("HashSHA256", expectedHash)
 
 			next.ServeHTTP(w, r)
@@ -96,13 +101,50 @@ func MiddlewareWithDecryption(privateKey *rsa.PrivateKey) func(http.Handler) htt
 	}
 }
 
-func InitializeApp() (*handler.Handler, string, string) {
+// MiddlewareRejectBuffering отклоняет запросы к потоковому обработчику, которые пришлось бы
+// буферизовать целиком: проверка HashSHA256 и расшифровка требуют все тело до вызова обработчика,
+// а потоковый обработчик сохраняет метрики по мере чтения и не может отменить их после неудачной проверки.
+// Подписанные и зашифрованные пакеты нужно отправлять на /updates/.
+func MiddlewareRejectBuffering(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isValidSHA256(key) {
+				http.Error(w, "Streaming updates are unavailable when request signing is enabled, use /updates/", http.StatusNotImplemented)
+				return
+			}
+			if r.Header.Get("Content-Encrypted") == "true" {
+				http.Error(w, "Encrypted bodies are not supported by streaming updates, use /updates/", http.StatusUnsupportedMediaType)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// InitializeApp разбирает флаги, переменные окружения и конфигурационный файл,
+// создает хранилище и обработчик. Возвращает обработчик и итоговую конфигурацию сервера.
+func InitializeApp() (*handler.Handler, *config.ServerConfig) {
 	addrFlag := flag.String("a", "localhost:8080", "HTTP server address")
 	storeIntervalFlag := flag.Int("i", 300, "Store interval in seconds (0 for synchronous saving)")
 	fileStoragePathFlag := flag.String("f", "/tmp/metrics-db.json", "File storage path")
 	restoreFlag := flag.Bool("r", true, "Restore metrics from file at startup")
 	databaseDSNFlag := flag.String("d", "", "Database connection string")
 	keyFlag := flag.String("k", "", "Secret key for hashing")
+	remoteWriteCountersFlag := flag.String("remote-write-counters", "", "Comma-separated name patterns of remote_write series stored as counters")
+	histogramBucketsFlag := flag.String("histogram-buckets", "", "Comma-separated bucket bounds of new histograms, e.g. 0.1,0.5,1")
+	outOfOrderFlag := flag.String("out-of-order", string(storage.OutOfOrderAccept), "Policy for gauge samples older than the stored value: accept, reject or latest")
+	historySizeFlag := flag.Int("history-size", storage.DefaultHistorySize, "Number of history points kept per series in memory (0 disables history)")
+	historyMemoryFlag := flag.Int("history-memory", storage.DefaultHistoryMaxBytes>>20, "Memory limit for the history and rollups of all series in megabytes")
+	retentionFlag := flag.String("retention", "", "Comma-separated retention rules, e.g. CPUutilization*=raw:24h/rollups:720h/stale:10m")
+	retentionIntervalFlag := flag.Int("retention-interval", int(storage.DefaultRetentionInterval/time.Second), "Interval in seconds between retention runs")
+	rollupIntervalFlag := flag.Int("rollup-interval", int(storage.DefaultRollupInterval/time.Second), "Interval in seconds between history rollups into 1m and 1h buckets (0 disables rollups)")
+	statsdAddrFlag := flag.String("statsd-address", "", "UDP address of the StatsD listener (disabled if empty)")
+	grpcAddrFlag := flag.String("grpc-address", "", "gRPC server address (disabled if empty)")
+	graphiteAddrFlag := flag.String("graphite-address", "", "TCP address of the Graphite plaintext listener (disabled if empty)")
+	graphiteTypesFlag := flag.String("graphite-types", "", "Comma-separated prefix=type rules for Graphite paths, e.g. stats.counters.=counter")
+	graphiteMaxConnsFlag := flag.Int("graphite-max-conns", graphite.DefaultMaxConnections, "Maximum number of concurrent Graphite connections")
+	graphiteIdleTimeoutFlag := flag.Int("graphite-idle-timeout", int(graphite.DefaultIdleTimeout/time.Second), "Idle timeout of Graphite connections in seconds")
 	configPath := flag.String("c", "", "Path to JSON config file")
 	configAltPath := flag.String("config", "", "Path to JSON config file (alternative)")
 
@@ -148,6 +190,70 @@ func InitializeApp() (*handler.Handler, string, string) {
 			if flag.Lookup("k").Value.String() == "" {
 				*keyFlag = cfg.Key
 			}
+
+			if flag.Lookup("remote-write-counters").Value.String() == "" {
+				*remoteWriteCountersFlag = strings.Join(cfg.RemoteWriteCounters, ",")
+			}
+
+			if flag.Lookup("histogram-buckets").Value.String() == "" {
+				bounds := make([]string, 0, len(cfg.HistogramBuckets))
+				for _, b := range cfg.HistogramBuckets {
+					bounds = append(bounds, strconv.FormatFloat(b, 'g', -1, 64))
+				}
+				*histogramBucketsFlag = strings.Join(bounds, ",")
+			}
+
+			if flag.Lookup("out-of-order").Value.String() == string(storage.OutOfOrderAccept) && cfg.OutOfOrder != "" {
+				*outOfOrderFlag = cfg.OutOfOrder
+			}
+
+			if flag.Lookup("history-size").Value.String() == strconv.Itoa(storage.DefaultHistorySize) && cfg.HistorySize > 0 {
+				*historySizeFlag = cfg.HistorySize
+			}
+
+			if flag.Lookup("history-memory").Value.String() == strconv.Itoa(storage.DefaultHistoryMaxBytes>>20) && cfg.HistoryMemory > 0 {
+				*historyMemoryFlag = cfg.HistoryMemory
+			}
+
+			if flag.Lookup("rollup-interval").Value.String() == strconv.Itoa(int(storage.DefaultRollupInterval/time.Second)) && cfg.RollupInterval > 0 {
+				*rollupIntervalFlag = cfg.RollupInterval
+			}
+
+			if flag.Lookup("retention").Value.String() == "" {
+				*retentionFlag = strings.Join(cfg.Retention, ",")
+			}
+
+			if flag.Lookup("retention-interval").Value.String() == strconv.Itoa(int(storage.DefaultRetentionInterval/time.Second)) && cfg.RetentionInterval > 0 {
+				*retentionIntervalFlag = cfg.RetentionInterval
+			}
+
+			if flag.Lookup("statsd-address").Value.String() == "" {
+				*statsdAddrFlag = cfg.StatsdAddress
+			}
+
+			if flag.Lookup("grpc-address").Value.String() == "" {
+				*grpcAddrFlag = cfg.GRPCAddress
+			}
+
+			if flag.Lookup("graphite-address").Value.String() == "" {
+				*graphiteAddrFlag = cfg.GraphiteAddress
+			}
+
+			if flag.Lookup("graphite-types").Value.String() == "" {
+				rules := make([]string, 0, len(cfg.GraphiteTypes))
+				for prefix, metricType := range cfg.GraphiteTypes {
+					rules = append(rules, prefix+"="+metricType)
+				}
+				*graphiteTypesFlag = strings.Join(rules, ",")
+			}
+
+			if flag.Lookup("graphite-max-conns").Value.String() == strconv.Itoa(graphite.DefaultMaxConnections) && cfg.GraphiteMaxConns > 0 {
+				*graphiteMaxConnsFlag = cfg.GraphiteMaxConns
+			}
+
+			if flag.Lookup("graphite-idle-timeout").Value.String() == strconv.Itoa(int(graphite.DefaultIdleTimeout/time.Second)) && cfg.GraphiteIdleTimeout > 0 {
+				*graphiteIdleTimeoutFlag = cfg.GraphiteIdleTimeout
+			}
 		}
 	}
 
@@ -194,6 +300,113 @@ func InitializeApp() (*handler.Handler, string, string) {
 		key = envKey
 	}
 
+	remoteWriteCounters := *remoteWriteCountersFlag
+	if envRemoteWriteCounters := os.Getenv("REMOTE_WRITE_COUNTERS"); envRemoteWriteCounters != "" {
+		remoteWriteCounters = envRemoteWriteCounters
+	}
+
+	histogramBucketsRaw := *histogramBucketsFlag
+	if envHistogramBuckets := os.Getenv("HISTOGRAM_BUCKETS"); envHistogramBuckets != "" {
+		histogramBucketsRaw = envHistogramBuckets
+	}
+	var histogramBuckets []float64
+	if histogramBucketsRaw != "" {
+		histogramBuckets, err = handler.ParseHistogramBuckets(histogramBucketsRaw)
+		if err != nil {
+			log.Fatalf("Invalid HISTOGRAM_BUCKETS: %v", err)
+		}
+	}
+
+	outOfOrderRaw := *outOfOrderFlag
+	if envOutOfOrder := os.Getenv("OUT_OF_ORDER"); envOutOfOrder != "" {
+		outOfOrderRaw = envOutOfOrder
+	}
+	outOfOrder, err := storage.ParseOutOfOrderPolicy(outOfOrderRaw)
+	if err != nil {
+		log.Fatalf("Invalid OUT_OF_ORDER: %v", err)
+	}
+
+	historySize := *historySizeFlag
+	if envHistorySize := os.Getenv("HISTORY_SIZE"); envHistorySize != "" {
+		historySize, err = strconv.Atoi(envHistorySize)
+		if err != nil {
+			log.Fatalf("Invalid HISTORY_SIZE: %v", err)
+		}
+	}
+
+	historyMemory := *historyMemoryFlag
+	if envHistoryMemory := os.Getenv("HISTORY_MEMORY"); envHistoryMemory != "" {
+		historyMemory, err = strconv.Atoi(envHistoryMemory)
+		if err != nil {
+			log.Fatalf("Invalid HISTORY_MEMORY: %v", err)
+		}
+	}
+
+	rollupInterval := *rollupIntervalFlag
+	if envRollupInterval := os.Getenv("ROLLUP_INTERVAL"); envRollupInterval != "" {
+		rollupInterval, err = strconv.Atoi(envRollupInterval)
+		if err != nil {
+			log.Fatalf("Invalid ROLLUP_INTERVAL: %v", err)
+		}
+	}
+
+	retentionRaw := *retentionFlag
+	if envRetention := os.Getenv("RETENTION"); envRetention != "" {
+		retentionRaw = envRetention
+	}
+	retention, err := storage.ParseRetentionRules(retentionRaw)
+	if err != nil {
+		log.Fatalf("Invalid RETENTION: %v", err)
+	}
+
+	retentionInterval := *retentionIntervalFlag
+	if envRetentionInterval := os.Getenv("RETENTION_INTERVAL"); envRetentionInterval != "" {
+		retentionInterval, err = strconv.Atoi(envRetentionInterval)
+		if err != nil {
+			log.Fatalf("Invalid RETENTION_INTERVAL: %v", err)
+		}
+	}
+
+	statsdAddr := *statsdAddrFlag
+	if envStatsdAddr := os.Getenv("STATSD_ADDRESS"); envStatsdAddr != "" {
+		statsdAddr = envStatsdAddr
+	}
+
+	grpcAddr := *grpcAddrFlag
+	if envGRPCAddr := os.Getenv("GRPC_ADDRESS"); envGRPCAddr != "" {
+		grpcAddr = envGRPCAddr
+	}
+
+	graphiteAddr := *graphiteAddrFlag
+	if envGraphiteAddr := os.Getenv("GRAPHITE_ADDRESS"); envGraphiteAddr != "" {
+		graphiteAddr = envGraphiteAddr
+	}
+
+	graphiteTypesRaw := *graphiteTypesFlag
+	if envGraphiteTypes := os.Getenv("GRAPHITE_TYPES"); envGraphiteTypes != "" {
+		graphiteTypesRaw = envGraphiteTypes
+	}
+	graphiteTypes, err := graphite.ParseTypeRules(graphiteTypesRaw)
+	if err != nil {
+		log.Fatalf("Invalid GRAPHITE_TYPES: %v", err)
+	}
+
+	graphiteMaxConns := *graphiteMaxConnsFlag
+	if envGraphiteMaxConns := os.Getenv("GRAPHITE_MAX_CONNS"); envGraphiteMaxConns != "" {
+		graphiteMaxConns, err = strconv.Atoi(envGraphiteMaxConns)
+		if err != nil {
+			log.Fatalf("Invalid GRAPHITE_MAX_CONNS: %v", err)
+		}
+	}
+
+	graphiteIdleTimeout := *graphiteIdleTimeoutFlag
+	if envGraphiteIdleTimeout := os.Getenv("GRAPHITE_IDLE_TIMEOUT"); envGraphiteIdleTimeout != "" {
+		graphiteIdleTimeout, err = strconv.Atoi(envGraphiteIdleTimeout)
+		if err != nil {
+			log.Fatalf("Invalid GRAPHITE_IDLE_TIMEOUT: %v", err)
+		}
+	}
+
 	if err := logger.Initialize("info"); err != nil {
 		panic(err)
 	}
@@ -215,12 +428,18 @@ func InitializeApp() (*handler.Handler, string, string) {
 		if err != nil {
 			log.Fatalf("Failed to initialize database storage: %v", err)
 		}
+		dbStorage.OutOfOrder = outOfOrder
+		dbStorage.Retention = retention
 		storageEngine = dbStorage
 		dbConnection = dbStorage.DB()
 		log.Println("Using PostgreSQL storage")
 
 	} else {
 		memStorage = storage.NewMemStorage(fileStoragePath)
+		memStorage.OutOfOrder = outOfOrder
+		memStorage.HistorySize = historySize
+		memStorage.HistoryMaxBytes = historyMemory << 20
+		memStorage.Retention = retention
 		storageEngine = memStorage
 		if restore {
 			if err := memStorage.Load(); err != nil {
@@ -232,13 +451,56 @@ func InitializeApp() (*handler.Handler, string, string) {
 		}
 		log.Println("Using file or in-memory storage")
 	}
+	if downsampler, ok := storageEngine.(storage.Downsampler); ok && rollupInterval > 0 {
+		go storage.RunPeriodicRollup(downsampler, time.Duration(rollupInterval)*time.Second)
+	}
+	// Применение хранения запускается и без правил: записи об удалениях для ленты изменений
+	// удаляются по сроку TombstoneRetention
+	if expirer, ok := storageEngine.(storage.Expirer); ok && retentionInterval > 0 {
+		go storage.RunPeriodicRetention(expirer, time.Duration(retentionInterval)*time.Second)
+	}
 
 	h := handler.Handler{
-		Storage: storageEngine,
-		DB:      dbConnection,
+		Storage:          storageEngine,
+		DB:               dbConnection,
+		HistogramBuckets: histogramBuckets,
+	}
+	if notifier, ok := storageEngine.(storage.Notifier); ok {
+		h.Changes = handler.NewChangeHub()
+		notifier.OnChange(h.Changes.Publish)
+	}
+	if remoteWriteCounters != "" {
+		h.RemoteWriteCounters = strings.Split(remoteWriteCounters, ",")
 	}
 
-	return &h, addr, key
+	resolved := &config.ServerConfig{
+		Address:       addr,
+		Restore:       restore,
+		StoreInterval: int(storeInterval / time.Second),
+		StoreFile:     fileStoragePath,
+		DatabaseDSN:   databaseDSN,
+		Key:           key,
+		StatsdAddress: statsdAddr,
+		GRPCAddress:   grpcAddr,
+
+		GraphiteAddress:     graphiteAddr,
+		GraphiteTypes:       graphiteTypes,
+		GraphiteMaxConns:    graphiteMaxConns,
+		GraphiteIdleTimeout: graphiteIdleTimeout,
+
+		RemoteWriteCounters: h.RemoteWriteCounters,
+		HistogramBuckets:    h.HistogramBuckets,
+		OutOfOrder:          string(outOfOrder),
+
+		HistorySize:   historySize,
+		HistoryMemory: historyMemory,
+
+		RollupInterval:    rollupInterval,
+		Retention:         retention.Strings(),
+		RetentionInterval: retentionInterval,
+	}
+
+	return &h, resolved
 }
 
 func InitializeRouter(h *handler.Handler, key string, privateKeyPath string) *mux.Router {
@@ -260,7 +522,7 @@ func InitializeRouter(h *handler.Handler, key string, privateKeyPath string) *mu
 		wrapWithHash = MiddlewareWithHash(key)
 	} else {
 		wrapWithHash = func(next http.Handler) http.Handler {
-			return next 
+			return next
 		}
 	}
 
@@ -285,14 +547,39 @@ func InitializeRouter(h *handler.Handler, key string, privateKeyPath string) *mu
 
 	r.Handle("/update/{type}/{name}/{value}", wrapHandler(http.HandlerFunc(h.HandleUpdateMetric))).Methods(http.MethodPost)
 	r.Handle("/value/{type}/{name}", wrapHandler(http.HandlerFunc(h.HandleGetValue))).Methods(http.MethodGet)
+	r.Handle("/value/{type}/{name}", wrapHandler(http.HandlerFunc(h.HandleDeleteMetric))).Methods(http.MethodDelete)
 	r.Handle("/", wrapHandler(http.HandlerFunc(h.HandleGetAllMetrics))).Methods(http.MethodGet)
 
 	r.Handle("/update/", wrapHandler(http.HandlerFunc(h.HandleUpdateMetricJSON))).Methods(http.MethodPost)
 	r.Handle("/value/", wrapHandler(http.HandlerFunc(h.HandleGetValueJSON))).Methods(http.MethodPost)
+	r.Handle("/values/", wrapHandler(http.HandlerFunc(h.HandleGetValues))).Methods(http.MethodPost)
+	r.Handle("/delete/", wrapHandler(http.HandlerFunc(h.HandleDeleteMetrics))).Methods(http.MethodPost)
+	r.Handle("/history/{type}/{name}", wrapHandler(http.HandlerFunc(h.HandleGetHistory))).Methods(http.MethodGet)
+	r.Handle("/api/v1/query", wrapHandler(http.HandlerFunc(h.HandleQuery))).Methods(http.MethodGet)
+	r.Handle("/api/v1/metrics", wrapHandler(http.HandlerFunc(h.HandleListMetrics))).Methods(http.MethodGet)
+	r.Handle("/api/v1/changes", wrapHandler(http.HandlerFunc(h.HandleGetChanges))).Methods(http.MethodGet)
 
 	r.Handle("/ping", wrapHandler(http.HandlerFunc(h.HandlePing))).Methods(http.MethodGet)
 
 	r.Handle("/updates/", wrapHandler(http.HandlerFunc(h.HandleUpdatesBatch))).Methods(http.MethodPost)
+	// Поток не проходит через проверку подписи и расшифровку, которые читают тело целиком:
+	// при включенной подписи и для зашифрованных тел запрос отклоняется (см. MiddlewareRejectBuffering)
+	r.Handle("/updates/stream/", middleware.GzipMiddleware(
+		logger.RequestLogger(MiddlewareRejectBuffering(key)(http.HandlerFunc(h.HandleUpdatesStream))),
+	)).Methods(http.MethodPost)
+
+	r.Handle("/api/v1/write", wrapHandler(http.HandlerFunc(h.HandleRemoteWrite))).Methods(http.MethodPost)
+	r.Handle("/write", wrapHandler(http.HandlerFunc(h.HandleInfluxWrite))).Methods(http.MethodPost)
+	r.Handle("/v1/metrics", wrapHandler(http.HandlerFunc(h.HandleOTLPMetrics))).Methods(http.MethodPost)
+
+	// Prometheus не умеет подписывать запросы, поэтому /metrics не проверяет HashSHA256
+	r.Handle("/metrics", middleware.GzipMiddleware(
+		logger.RequestLogger(http.HandlerFunc(h.HandleGetMetricsPrometheus)),
+	)).Methods(http.MethodGet)
+
+	// SSE не сжимается (ответ должен отправляться сразу) и, как /metrics, не требует подписи:
+	// EventSource в браузере не умеет передавать заголовки
+	r.Handle("/stream", logger.RequestLogger(http.HandlerFunc(h.HandleStream))).Methods(http.MethodGet)
 
 	return r
 }
@@ -1,12 +1,24 @@
 package app
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/25x8/metric-gathering/internal/agent/senders"
+	"github.com/25x8/metric-gathering/internal/handler"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/25x8/metric-gathering/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsValidSHA256(t *testing.T) {
@@ -96,3 +108,104 @@ func TestMiddlewareWithHash(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareWithHashRestoresBody(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	body := "test body"
+
+	var received string
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		received = string(data)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(body))
+	req.Header.Set("HashSHA256", utils.CalculateHash([]byte(body), key))
+	rr := httptest.NewRecorder()
+
+	MiddlewareWithHash(key)(nextHandler).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, body, received)
+}
+
+func TestInitializeRouter_DeleteRequiresHash(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	memStorage := storage.NewMemStorage("")
+	assert.NoError(t, memStorage.SaveGaugeMetric("HeapAlloc", 1))
+	router := InitializeRouter(&handler.Handler{Storage: memStorage}, key, "")
+
+	// Удаление без подписи отклоняется, как и запись
+	req := httptest.NewRequest(http.MethodDelete, "/value/gauge/HeapAlloc", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/value/gauge/HeapAlloc", nil)
+	req.Header.Set("HashSHA256", utils.CalculateHash(nil, key))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	body := `{"names": ["Heap*"]}`
+	req = httptest.NewRequest(http.MethodPost, "/delete/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("HashSHA256", utils.CalculateHash([]byte(body), "wrong"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestInitializeRouter_UpdatesStream(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	body := `{"id": "Alloc", "type": "gauge", "value": 1}` + "\n"
+
+	post := func(router http.Handler, encrypted bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/stream/", strings.NewReader(body))
+		req.Header.Set("Content-Type", handler.NDJSONContentType)
+		req.Header.Set("HashSHA256", utils.CalculateHash([]byte(body), key))
+		if encrypted {
+			req.Header.Set("Content-Encrypted", "true")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// С включенной подписью поток не буферизуется для проверки, а отклоняется
+	memStorage := storage.NewMemStorage("")
+	assert.Equal(t, http.StatusNotImplemented, post(InitializeRouter(&handler.Handler{Storage: memStorage}, key, ""), false))
+	_, err := memStorage.GetGaugeMetric("Alloc")
+	assert.Error(t, err)
+
+	router := InitializeRouter(&handler.Handler{Storage: memStorage}, "", "")
+	assert.Equal(t, http.StatusUnsupportedMediaType, post(router, true))
+	assert.Equal(t, http.StatusOK, post(router, false))
+}
+
+func TestInitializeRouter_SignedEncryptedSend(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	memStorage := storage.NewMemStorage("")
+	server := httptest.NewServer(InitializeRouter(&handler.Handler{Storage: memStorage}, key, keyPath))
+	defer server.Close()
+
+	// Метки отправляются через JSON-эндпоинт /update/: подпись должна совпасть с зашифрованным телом
+	sender := senders.NewHTTPSender(server.URL)
+	sender.Labels = map[string]string{"host": "web-1"}
+	require.NoError(t, sender.Send(map[string]interface{}{"PollCount": int64(2)}, key, &privateKey.PublicKey))
+
+	// Без шифрования подписывается JSON, который сервер получает после распаковки gzip
+	require.NoError(t, sender.Send(map[string]interface{}{"PollCount": int64(3)}, key, nil))
+
+	value, err := memStorage.GetCounterMetric(`PollCount{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+}
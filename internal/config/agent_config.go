@@ -6,12 +6,15 @@ import (
 )
 
 type AgentConfig struct {
-	Address        string `json:"address"`
-	ReportInterval int    `json:"report_interval"`
-	PollInterval   int    `json:"poll_interval"`
-	CryptoKey      string `json:"crypto_key"`
-	Key            string `json:"key"`
-	RateLimit      int    `json:"rate_limit"`
+	Address        string            `json:"address"`
+	ReportInterval int               `json:"report_interval"`
+	PollInterval   int               `json:"poll_interval"`
+	CryptoKey      string            `json:"crypto_key"`
+	Key            string            `json:"key"`
+	RateLimit      int               `json:"rate_limit"`
+	GRPCAddress    string            `json:"grpc_address"`
+	BatchEncoding  string            `json:"batch_encoding"`
+	Labels         map[string]string `json:"labels"`
 }
 
 func LoadAgentConfig(filePath string) (*AgentConfig, error) {
@@ -14,6 +14,24 @@ type ServerConfig struct {
 	DatabaseDSN   string `json:"database_dsn"`
 	CryptoKey     string `json:"crypto_key"`
 	Key           string `json:"key"`
+	StatsdAddress string `json:"statsd_address"`
+	GRPCAddress   string `json:"grpc_address"`
+
+	GraphiteAddress     string            `json:"graphite_address"`
+	GraphiteTypes       map[string]string `json:"graphite_types"`        // префикс пути -> gauge или counter
+	GraphiteMaxConns    int               `json:"graphite_max_conns"`    // ограничение одновременных соединений
+	GraphiteIdleTimeout int               `json:"graphite_idle_timeout"` // таймаут простоя соединения в секундах
+
+	RemoteWriteCounters []string  `json:"remote_write_counters"`
+	HistogramBuckets    []float64 `json:"histogram_buckets"` // границы бакетов новых гистограмм
+	OutOfOrder          string    `json:"out_of_order"`      // политика для устаревших gauge-сэмплов: accept, reject или latest
+
+	HistorySize   int `json:"history_size"`   // число точек истории одной серии в памяти
+	HistoryMemory int `json:"history_memory"` // ограничение памяти под историю всех серий в мегабайтах
+
+	RollupInterval    int      `json:"rollup_interval"`    // период агрегации истории в секундах
+	Retention         []string `json:"retention"`          // правила хранения вида "шаблон=raw:24h/rollups:720h/stale:10m"
+	RetentionInterval int      `json:"retention_interval"` // период применения правил хранения в секундах
 }
 
 func LoadServerConfig(filePath string) (*ServerConfig, error) {
@@ -0,0 +1,227 @@
+package graphite
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+const (
+	// DefaultMaxConnections - ограничение одновременных соединений по умолчанию.
+	DefaultMaxConnections = 100
+	// DefaultIdleTimeout - время, после которого неактивное соединение закрывается.
+	DefaultIdleTimeout = 60 * time.Second
+)
+
+// Listener принимает метрики по протоколу Graphite plaintext через TCP
+// и сохраняет их в хранилище.
+type Listener struct {
+	Addr        string            // адрес для прослушивания, например ":2003"
+	Storage     storage.Storage   // хранилище метрик
+	Types       map[string]string // префикс пути -> тип метрики (gauge или counter)
+	MaxConns    int               // максимальное число одновременных соединений
+	IdleTimeout time.Duration     // таймаут простоя соединения
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewListener - конструктор для Listener
+func NewListener(addr string, s storage.Storage, types map[string]string) *Listener {
+	return &Listener{
+		Addr:        addr,
+		Storage:     s,
+		Types:       types,
+		MaxConns:    DefaultMaxConnections,
+		IdleTimeout: DefaultIdleTimeout,
+	}
+}
+
+// Listen открывает TCP-сокет. После успешного вызова адрес доступен через LocalAddr.
+func (l *Listener) Listen() error {
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.ln = ln
+	l.conns = make(map[net.Conn]struct{})
+	l.mu.Unlock()
+	return nil
+}
+
+// LocalAddr возвращает фактический адрес открытого сокета.
+func (l *Listener) LocalAddr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ln == nil {
+		return nil
+	}
+	return l.ln.Addr()
+}
+
+// Serve принимает соединения до закрытия слушателя.
+// Соединения сверх MaxConns сразу закрываются. Возвращает nil после вызова Close.
+func (l *Listener) Serve() error {
+	l.mu.Lock()
+	ln := l.ln
+	l.mu.Unlock()
+	if ln == nil {
+		return errors.New("graphite listener is not started")
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if !l.track(conn) {
+			log.Printf("Graphite connection limit reached, rejecting %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		go l.handleConn(conn)
+	}
+}
+
+// ListenAndServe открывает сокет и обрабатывает входящие соединения.
+func (l *Listener) ListenAndServe() error {
+	if err := l.Listen(); err != nil {
+		return err
+	}
+	return l.Serve()
+}
+
+// Close прекращает прием соединений, закрывает активные соединения
+// и дожидается завершения их обработки.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.ln == nil || l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	err := l.ln.Close()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	return err
+}
+
+// track регистрирует соединение, если не превышено ограничение MaxConns.
+// Зарегистрированное соединение учитывается в Close до завершения handleConn.
+func (l *Listener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed || (l.MaxConns > 0 && len(l.conns) >= l.MaxConns) {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	l.wg.Add(1)
+	return true
+}
+
+func (l *Listener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, conn)
+}
+
+// handleConn читает строки из соединения, пока клиент не закроет его
+// или не истечет IdleTimeout.
+func (l *Listener) handleConn(conn net.Conn) {
+	defer l.wg.Done()
+	defer l.untrack(conn)
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for {
+		if l.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(l.IdleTimeout))
+		}
+		if !scanner.Scan() {
+			break
+		}
+		l.HandleLine(scanner.Text())
+	}
+
+	var netErr net.Error
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) &&
+		!(errors.As(err, &netErr) && netErr.Timeout()) {
+		log.Printf("Error reading graphite connection %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// HandleLine разбирает одну строку и сохраняет метрику. Некорректные строки пропускаются.
+func (l *Listener) HandleLine(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	sample, err := ParseLine(line)
+	if err != nil {
+		log.Printf("Error parsing graphite line: %v", err)
+		return
+	}
+
+	if err := l.apply(sample); err != nil {
+		log.Printf("Error saving graphite metric %s: %v", sample.Path, err)
+	}
+}
+
+// apply сохраняет измерение как gauge или counter в зависимости от правил Types.
+// Время из строки становится временем сэмпла.
+func (l *Listener) apply(s Sample) error {
+	id, labels := storage.ParseSeriesKey(s.Path)
+	m := storage.Metrics{ID: id, Labels: labels}
+	if s.Timestamp >= 0 {
+		ts := time.Unix(s.Timestamp, 0).UTC()
+		m.Timestamp = &ts
+	}
+
+	if l.typeFor(s.Path) == storage.Counter {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			return nil
+		}
+		delta := int64(math.Round(s.Value))
+		m.MType, m.Delta = storage.Counter, &delta
+	} else {
+		value := s.Value
+		m.MType, m.Value = storage.Gauge, &value
+	}
+	return l.Storage.UpdateMetricsBatch([]storage.Metrics{m})
+}
+
+// typeFor возвращает тип метрики по самому длинному подходящему префиксу.
+// Если ни один префикс не подошел, метрика считается gauge.
+func (l *Listener) typeFor(path string) string {
+	metricType := storage.Gauge
+	longest := -1
+	for prefix, t := range l.Types {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			metricType = t
+			longest = len(prefix)
+		}
+	}
+	return metricType
+}
@@ -0,0 +1,135 @@
+package graphite
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startListener(t *testing.T, l *Listener) chan error {
+	t.Helper()
+	require.NoError(t, l.Listen())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- l.Serve()
+	}()
+	return served
+}
+
+func TestListener_TypeRules(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	l := NewListener("", memStorage, map[string]string{
+		"stats.":          "gauge",
+		"stats.counters.": "counter",
+	})
+
+	l.HandleLine("stats.counters.hits 3 1700000000")
+	l.HandleLine("stats.counters.hits 2 1700000010")
+	l.HandleLine("stats.load 0.5 1700000000")
+	l.HandleLine("other.value 42")
+	l.HandleLine("broken")
+
+	hits, err := memStorage.GetCounterMetric("stats.counters.hits")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), hits)
+
+	load, err := memStorage.GetGaugeMetric("stats.load")
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, load)
+
+	other, err := memStorage.GetGaugeMetric("other.value")
+	require.NoError(t, err)
+	assert.Equal(t, 42.0, other)
+
+	// Время из строки становится временем сэмпла
+	points, err := memStorage.GetHistory(storage.Counter, "stats.counters.hits", time.Time{}, time.Time{}, storage.ResolutionRaw)
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), points[0].Timestamp)
+	assert.Equal(t, time.Unix(1700000010, 0).UTC(), points[1].Timestamp)
+}
+
+func TestListener_Serve(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	l := NewListener("127.0.0.1:0", memStorage, nil)
+	served := startListener(t, l)
+
+	conn, err := net.Dial("tcp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "servers.a.cpu 1.5 %d\nservers.b.cpu 2.5 %d\n", time.Now().Unix(), time.Now().Unix())
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		value, err := memStorage.GetGaugeMetric("servers.b.cpu")
+		return err == nil && value == 2.5
+	}, time.Second, 10*time.Millisecond)
+
+	// Close закрывает и активные соединения
+	require.NoError(t, l.Close())
+	assert.NoError(t, <-served)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestListener_ConnectionLimit(t *testing.T) {
+	l := NewListener("127.0.0.1:0", storage.NewMemStorage(""), nil)
+	l.MaxConns = 1
+	served := startListener(t, l)
+	defer func() {
+		require.NoError(t, l.Close())
+		assert.NoError(t, <-served)
+	}()
+
+	first, err := net.Dial("tcp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer first.Close()
+
+	assert.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.conns) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	second, err := net.Dial("tcp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer second.Close()
+
+	_ = second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.False(t, isTimeout(err), "second connection should be closed by the server")
+}
+
+func TestListener_IdleTimeout(t *testing.T) {
+	l := NewListener("127.0.0.1:0", storage.NewMemStorage(""), nil)
+	l.IdleTimeout = 50 * time.Millisecond
+	served := startListener(t, l)
+	defer func() {
+		require.NoError(t, l.Close())
+		assert.NoError(t, <-served)
+	}()
+
+	conn, err := net.Dial("tcp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.False(t, isTimeout(err), "idle connection should be closed by the server")
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,66 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// ErrInvalidLine возвращается, если строка не соответствует формату "path value timestamp".
+var ErrInvalidLine = errors.New("invalid graphite line")
+
+// Sample - разобранная строка протокола Graphite plaintext.
+type Sample struct {
+	Path      string  // путь метрики, например servers.host1.cpu
+	Value     float64 // значение
+	Timestamp int64   // unix-время в секундах (-1, если клиент не указал время)
+}
+
+// ParseLine разбирает строку формата "path value timestamp".
+// Временная метка может быть опущена или равна -1, тогда используется время приема.
+func ParseLine(line string) (Sample, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return Sample{}, fmt.Errorf("%w: %q", ErrInvalidLine, line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("%w: bad value %q", ErrInvalidLine, fields[1])
+	}
+
+	s := Sample{Path: fields[0], Value: value, Timestamp: -1}
+	if len(fields) == 3 {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return Sample{}, fmt.Errorf("%w: bad timestamp %q", ErrInvalidLine, fields[2])
+		}
+		s.Timestamp = int64(ts)
+	}
+
+	return s, nil
+}
+
+// ParseTypeRules разбирает правила сопоставления типов из строки
+// вида "stats.counters.=counter,stats.gauges.=gauge".
+func ParseTypeRules(s string) (map[string]string, error) {
+	rules := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return rules, nil
+	}
+
+	for _, rule := range strings.Split(s, ",") {
+		prefix, metricType, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid graphite type rule %q", rule)
+		}
+		if metricType != storage.Gauge && metricType != storage.Counter {
+			return nil, fmt.Errorf("invalid metric type %q in graphite type rule", metricType)
+		}
+		rules[prefix] = metricType
+	}
+	return rules, nil
+}
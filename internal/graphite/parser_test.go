@@ -0,0 +1,68 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Sample
+	}{
+		{
+			name: "With timestamp",
+			line: "servers.host1.cpu 12.5 1700000000",
+			want: Sample{Path: "servers.host1.cpu", Value: 12.5, Timestamp: 1700000000},
+		},
+		{
+			name: "Without timestamp",
+			line: "servers.host1.cpu 3",
+			want: Sample{Path: "servers.host1.cpu", Value: 3, Timestamp: -1},
+		},
+		{
+			name: "Extra whitespace",
+			line: "  a.b\t7   -1 ",
+			want: Sample{Path: "a.b", Value: 7, Timestamp: -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseLine_Invalid(t *testing.T) {
+	for _, line := range []string{"", "path", "path value", "path 1 ts", "path 1 2 3"} {
+		t.Run(line, func(t *testing.T) {
+			_, err := ParseLine(line)
+			assert.ErrorIs(t, err, ErrInvalidLine)
+		})
+	}
+}
+
+func TestParseTypeRules(t *testing.T) {
+	rules, err := ParseTypeRules("stats.counters.=counter, stats.gauges.=gauge")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"stats.counters.": "counter",
+		"stats.gauges.":   "gauge",
+	}, rules)
+
+	rules, err = ParseTypeRules("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	_, err = ParseTypeRules("stats.=timer")
+	assert.Error(t, err)
+
+	_, err = ParseTypeRules("stats.")
+	assert.Error(t, err)
+}
@@ -0,0 +1,241 @@
+// Package grpcapi содержит перехватчики gRPC, общие для сервера и агента:
+// проверку и вычисление подписи HMAC-SHA256 и сжатие gzip. Унарный запрос подписывается
+// в метаданных, сообщения потоков - каждое в своем поле hash.
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/25x8/metric-gathering/internal/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// HashMetadataKey - ключ метаданных с подписью, аналог заголовка HashSHA256 в HTTP API.
+const HashMetadataKey = "hashsha256"
+
+// MessageHash вычисляет HMAC-SHA256 от детерминированной protobuf-сериализации сообщения.
+func MessageHash(msg proto.Message, key string) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return utils.CalculateHash(data, key), nil
+}
+
+// StreamMessageHash вычисляет подпись сообщения потока: HMAC-SHA256 от полного имени метода,
+// порядкового номера сообщения в потоке (с нуля) и детерминированной сериализации сообщения
+// с пустым полем hash. Номер и имя метода не дают переставить сообщения внутри потока
+// или переиспользовать подпись в другом методе.
+func StreamMessageHash(msg proto.Message, method string, seq int64, key string) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	payload := fmt.Appendf(nil, "%s\n%d\n", method, seq)
+	return utils.CalculateHash(append(payload, data...), key), nil
+}
+
+// hashField возвращает строковое поле hash сообщения потока, в котором передается подпись.
+func hashField(msg proto.Message) (protoreflect.FieldDescriptor, error) {
+	fd := msg.ProtoReflect().Descriptor().Fields().ByName("hash")
+	if fd == nil || fd.Kind() != protoreflect.StringKind || fd.IsList() {
+		return nil, status.Errorf(codes.Internal, "%s has no hash field", msg.ProtoReflect().Descriptor().FullName())
+	}
+	return fd, nil
+}
+
+// UnaryServerHashInterceptor проверяет подпись унарного запроса.
+// При пустом ключе проверка не выполняется.
+func UnaryServerHashInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if key == "" {
+			return handler(ctx, req)
+		}
+
+		received := incomingHash(ctx)
+		if received == "" {
+			return nil, status.Error(codes.Unauthenticated, "missing HashSHA256 metadata")
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "request is not a protobuf message")
+		}
+		expected, err := MessageHash(msg, key)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to serialize request")
+		}
+		if received != expected {
+			return nil, status.Error(codes.Unauthenticated, "invalid hash")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerHashInterceptor проверяет подпись каждого входящего сообщения потока
+// в поле hash (см. StreamMessageHash). Сообщение без подписи или с неверной подписью
+// завершает вызов с кодом Unauthenticated.
+func StreamServerHashInterceptor(key string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if key == "" {
+			return handler(srv, ss)
+		}
+		return handler(srv, &hashCheckingStream{ServerStream: ss, key: key, method: info.FullMethod})
+	}
+}
+
+// hashCheckingStream проверяет подпись сообщений, получаемых сервером.
+type hashCheckingStream struct {
+	grpc.ServerStream
+	key    string
+	method string
+	seq    int64 // номер следующего сообщения
+}
+
+func (s *hashCheckingStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Error(codes.Internal, "request is not a protobuf message")
+	}
+	fd, err := hashField(msg)
+	if err != nil {
+		return err
+	}
+
+	received := msg.ProtoReflect().Get(fd).String()
+	if received == "" {
+		return status.Error(codes.Unauthenticated, "missing message hash")
+	}
+	msg.ProtoReflect().Clear(fd)
+	expected, err := StreamMessageHash(msg, s.method, s.seq, s.key)
+	if err != nil {
+		return status.Error(codes.Internal, "failed to serialize request")
+	}
+	s.seq++
+	if received != expected {
+		return status.Error(codes.Unauthenticated, "invalid hash")
+	}
+	return nil
+}
+
+// UnaryServerGzipInterceptor сжимает ответ gzip, если клиент его поддерживает.
+// Распаковка запросов выполняется кодеком gzip, зарегистрированным при импорте пакета.
+func UnaryServerGzipInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		useGzipIfSupported(ctx)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerGzipInterceptor сжимает сообщения потока gzip, если клиент его поддерживает.
+func StreamServerGzipInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		useGzipIfSupported(ss.Context())
+		return handler(srv, ss)
+	}
+}
+
+// UnaryClientHashInterceptor добавляет подпись запроса в метаданные.
+func UnaryClientHashInterceptor(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if key != "" {
+			msg, ok := req.(proto.Message)
+			if !ok {
+				return status.Error(codes.Internal, "request is not a protobuf message")
+			}
+			hash, err := MessageHash(msg, key)
+			if err != nil {
+				return err
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, HashMetadataKey, hash)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientHashInterceptor подписывает каждое отправляемое сообщение потока в поле hash.
+func StreamClientHashInterceptor(key string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil || key == "" {
+			return cs, err
+		}
+		return &hashSigningStream{ClientStream: cs, key: key, method: method}, nil
+	}
+}
+
+// hashSigningStream подписывает сообщения, отправляемые клиентом.
+type hashSigningStream struct {
+	grpc.ClientStream
+	key    string
+	method string
+	seq    int64 // номер следующего сообщения
+}
+
+func (s *hashSigningStream) SendMsg(m any) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Error(codes.Internal, "request is not a protobuf message")
+	}
+	fd, err := hashField(msg)
+	if err != nil {
+		return err
+	}
+
+	// Подписывается копия, чтобы не менять сообщение вызывающего кода
+	signed := proto.Clone(msg)
+	signed.ProtoReflect().Clear(fd)
+	hash, err := StreamMessageHash(signed, s.method, s.seq, s.key)
+	if err != nil {
+		return err
+	}
+	signed.ProtoReflect().Set(fd, protoreflect.ValueOfString(hash))
+	s.seq++
+	return s.ClientStream.SendMsg(signed)
+}
+
+// UnaryClientGzipInterceptor сжимает запросы gzip.
+func UnaryClientGzipInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(ctx, method, req, reply, cc, append(opts, grpc.UseCompressor(gzip.Name))...)
+	}
+}
+
+// StreamClientGzipInterceptor сжимает сообщения потока gzip.
+func StreamClientGzipInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(ctx, desc, cc, method, append(opts, grpc.UseCompressor(gzip.Name))...)
+	}
+}
+
+// incomingHash возвращает подпись из входящих метаданных.
+func incomingHash(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(HashMetadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func useGzipIfSupported(ctx context.Context) {
+	supported, err := grpc.ClientSupportedCompressors(ctx)
+	if err == nil && slices.Contains(supported, gzip.Name) {
+		_ = grpc.SetSendCompressor(ctx, gzip.Name)
+	}
+}
@@ -0,0 +1,211 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/25x8/metric-gathering/internal/grpcapi"
+	"github.com/25x8/metric-gathering/internal/metricspb"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // регистрирует кодек gzip для входящих сообщений
+	"google.golang.org/grpc/status"
+)
+
+// Server реализует gRPC-сервис Metrics поверх storage.Storage.
+type Server struct {
+	metricspb.UnimplementedMetricsServer
+	Storage storage.Storage // хранилище метрик
+}
+
+// NewServer - конструктор для Server
+func NewServer(s storage.Storage) *Server {
+	return &Server{Storage: s}
+}
+
+// NewGRPCServer создает grpc.Server с зарегистрированным сервисом Metrics
+// и перехватчиками проверки подписи (если задан ключ) и сжатия gzip.
+func NewGRPCServer(s storage.Storage, key string) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			grpcapi.UnaryServerHashInterceptor(key),
+			grpcapi.UnaryServerGzipInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			grpcapi.StreamServerHashInterceptor(key),
+			grpcapi.StreamServerGzipInterceptor(),
+		),
+	)
+	metricspb.RegisterMetricsServer(srv, NewServer(s))
+	return srv
+}
+
+// UpdateMetrics сохраняет пакет метрик через UpdateMetricsBatch.
+// Пакет с gauge-сэмплом, отклоненным как устаревший, возвращает FailedPrecondition.
+func (s *Server) UpdateMetrics(ctx context.Context, req *metricspb.UpdateMetricsRequest) (*metricspb.UpdateMetricsResponse, error) {
+	if len(req.GetMetrics()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty metrics batch")
+	}
+
+	metrics := make([]storage.Metrics, 0, len(req.GetMetrics()))
+	for _, m := range req.GetMetrics() {
+		sm, err := toStorage(m)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, sm)
+	}
+
+	if err := s.Storage.UpdateMetricsBatch(metrics); err != nil {
+		return nil, updateError(err, "failed to update metrics")
+	}
+	return &metricspb.UpdateMetricsResponse{}, nil
+}
+
+// GetMetric возвращает текущее значение метрики.
+func (s *Server) GetMetric(ctx context.Context, req *metricspb.GetMetricRequest) (*metricspb.Metric, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	if err := storage.ValidateLabels(req.GetLabels()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	key := storage.SeriesKey(req.GetId(), req.GetLabels())
+
+	m := &metricspb.Metric{Id: req.GetId(), Type: req.GetType(), Labels: req.GetLabels()}
+	switch req.GetType() {
+	case metricspb.MetricType_GAUGE:
+		value, err := s.Storage.GetGaugeMetric(key)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "metric not found")
+		}
+		m.Value = &value
+	case metricspb.MetricType_COUNTER:
+		delta, err := s.Storage.GetCounterMetric(key)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "metric not found")
+		}
+		m.Delta = &delta
+	default:
+		return nil, status.Error(codes.InvalidArgument, "invalid metric type")
+	}
+	return m, nil
+}
+
+// ListMetrics передает все gauge и counter хранилища, отсортированные по имени, а при совпадении имен - по типу.
+func (s *Server) ListMetrics(req *metricspb.ListMetricsRequest, stream grpc.ServerStreamingServer[metricspb.Metric]) error {
+	all, err := s.Storage.ListAllMetrics()
+	if err != nil {
+		return status.Error(codes.Internal, "failed to list metrics")
+	}
+
+	var list []*metricspb.Metric
+	for key, value := range all.Gauges {
+		id, labels := storage.ParseSeriesKey(key)
+		list = append(list, &metricspb.Metric{Id: id, Labels: labels, Type: metricspb.MetricType_GAUGE, Value: &value})
+	}
+	for key, delta := range all.Counters {
+		id, labels := storage.ParseSeriesKey(key)
+		list = append(list, &metricspb.Metric{Id: id, Labels: labels, Type: metricspb.MetricType_COUNTER, Delta: &delta})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ki, kj := storage.SeriesKey(list[i].Id, list[i].Labels), storage.SeriesKey(list[j].Id, list[j].Labels)
+		if ki != kj {
+			return ki < kj
+		}
+		return list[i].Type < list[j].Type
+	})
+
+	for _, m := range list {
+		if err := stream.Send(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PushMetrics сохраняет метрики по мере их получения из потока.
+func (s *Server) PushMetrics(stream grpc.ClientStreamingServer[metricspb.Metric, metricspb.PushMetricsResponse]) error {
+	var accepted int64
+	for {
+		m, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&metricspb.PushMetricsResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+
+		sm, err := toStorage(m)
+		if err != nil {
+			return err
+		}
+
+		// Сэмпл со временем сбора сохраняется через UpdateMetricsBatch, чтобы хранилище учло его время
+		key := storage.SeriesKey(sm.ID, sm.Labels)
+		switch {
+		case sm.Timestamp != nil:
+			err = s.Storage.UpdateMetricsBatch([]storage.Metrics{sm})
+		case sm.MType == storage.Gauge:
+			err = s.Storage.SaveGaugeMetric(key, *sm.Value)
+		case sm.MType == storage.Counter:
+			err = s.Storage.SaveCounterMetric(key, *sm.Delta)
+		}
+		if err != nil {
+			return updateError(err, "failed to update metric")
+		}
+		accepted++
+	}
+}
+
+// updateError возвращает FailedPrecondition для устаревшего gauge-сэмпла и Internal для остальных ошибок.
+func updateError(err error, msg string) error {
+	if errors.Is(err, storage.ErrOutOfOrder) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+// toStorage проверяет метрику и преобразует ее в storage.Metrics.
+func toStorage(m *metricspb.Metric) (storage.Metrics, error) {
+	sm, err := toStorageValue(m)
+	if err != nil {
+		return storage.Metrics{}, err
+	}
+	if m.GetTimestamp() != nil {
+		ts := m.GetTimestamp().AsTime()
+		sm.Timestamp = &ts
+	}
+	return sm, nil
+}
+
+// toStorageValue проверяет имя, метки и значение метрики.
+func toStorageValue(m *metricspb.Metric) (storage.Metrics, error) {
+	if m.GetId() == "" {
+		return storage.Metrics{}, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if err := storage.ValidateLabels(m.GetLabels()); err != nil {
+		return storage.Metrics{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	switch m.GetType() {
+	case metricspb.MetricType_GAUGE:
+		if m.Value == nil {
+			return storage.Metrics{}, status.Errorf(codes.InvalidArgument, "value is required for gauge %s", m.GetId())
+		}
+		value := m.GetValue()
+		return storage.Metrics{ID: m.GetId(), MType: storage.Gauge, Value: &value, Labels: m.GetLabels()}, nil
+	case metricspb.MetricType_COUNTER:
+		if m.Delta == nil {
+			return storage.Metrics{}, status.Errorf(codes.InvalidArgument, "delta is required for counter %s", m.GetId())
+		}
+		delta := m.GetDelta()
+		return storage.Metrics{ID: m.GetId(), MType: storage.Counter, Delta: &delta, Labels: m.GetLabels()}, nil
+	default:
+		return storage.Metrics{}, status.Errorf(codes.InvalidArgument, "invalid metric type for %s", m.GetId())
+	}
+}
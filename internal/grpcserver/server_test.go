@@ -0,0 +1,193 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/grpcapi"
+	"github.com/25x8/metric-gathering/internal/metricspb"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const testKey = "secret"
+
+// startServer запускает gRPC-сервер на случайном порту и возвращает клиента с заданным ключом подписи
+func startServer(t *testing.T, s storage.Storage, clientKey string) metricspb.MetricsClient {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := NewGRPCServer(s, testKey)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(
+			grpcapi.UnaryClientHashInterceptor(clientKey),
+			grpcapi.UnaryClientGzipInterceptor(),
+		),
+		grpc.WithChainStreamInterceptor(
+			grpcapi.StreamClientHashInterceptor(clientKey),
+			grpcapi.StreamClientGzipInterceptor(),
+		),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return metricspb.NewMetricsClient(conn)
+}
+
+func gauge(id string, v float64) *metricspb.Metric {
+	return &metricspb.Metric{Id: id, Type: metricspb.MetricType_GAUGE, Value: &v}
+}
+
+func counter(id string, d int64) *metricspb.Metric {
+	return &metricspb.Metric{Id: id, Type: metricspb.MetricType_COUNTER, Delta: &d}
+}
+
+func TestServer_UpdateAndGet(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	client := startServer(t, memStorage, testKey)
+	ctx := context.Background()
+
+	_, err := client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{
+		Metrics: []*metricspb.Metric{gauge("Alloc", 1.5), counter("PollCount", 2), counter("PollCount", 3)},
+	})
+	require.NoError(t, err)
+
+	m, err := client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "Alloc", Type: metricspb.MetricType_GAUGE})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, m.GetValue())
+
+	m, err = client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "PollCount", Type: metricspb.MetricType_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), m.GetDelta())
+
+	_, err = client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "Missing", Type: metricspb.MetricType_GAUGE})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	labeled := gauge("Alloc", 7)
+	labeled.Labels = map[string]string{"host": "a"}
+	_, err = client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{Metrics: []*metricspb.Metric{labeled}})
+	require.NoError(t, err)
+
+	m, err = client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "Alloc", Type: metricspb.MetricType_GAUGE, Labels: map[string]string{"host": "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, m.GetValue())
+
+	m, err = client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "Alloc", Type: metricspb.MetricType_GAUGE})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, m.GetValue())
+
+	_, err = client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{
+		Metrics: []*metricspb.Metric{{Id: "NoValue", Type: metricspb.MetricType_GAUGE}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_Streams(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	client := startServer(t, memStorage, testKey)
+	ctx := context.Background()
+
+	push, err := client.PushMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, push.Send(gauge("HeapAlloc", 10)))
+	require.NoError(t, push.Send(counter("Requests", 4)))
+	require.NoError(t, push.Send(counter("HeapAlloc", 1)))
+	resp, err := push.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resp.GetAccepted())
+
+	list, err := client.ListMetrics(ctx, &metricspb.ListMetricsRequest{})
+	require.NoError(t, err)
+
+	var ids []string
+	for {
+		m, err := list.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		ids = append(ids, m.GetId()+":"+m.GetType().String())
+	}
+	// Gauge и counter с одним именем передаются оба
+	assert.Equal(t, []string{"HeapAlloc:GAUGE", "HeapAlloc:COUNTER", "Requests:COUNTER"}, ids)
+}
+
+func TestServer_InvalidHash(t *testing.T) {
+	client := startServer(t, storage.NewMemStorage(""), "wrong")
+	ctx := context.Background()
+
+	_, err := client.UpdateMetrics(ctx, &metricspb.UpdateMetricsRequest{
+		Metrics: []*metricspb.Metric{gauge("Alloc", 1)},
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	list, err := client.ListMetrics(ctx, &metricspb.ListMetricsRequest{})
+	require.NoError(t, err)
+	_, err = list.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	client = startServer(t, storage.NewMemStorage(""), "")
+	_, err = client.GetMetric(ctx, &metricspb.GetMetricRequest{Id: "Alloc", Type: metricspb.MetricType_GAUGE})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestServer_StreamMessageHash(t *testing.T) {
+	ctx := context.Background()
+
+	push, err := startServer(t, storage.NewMemStorage(""), "wrong").PushMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, push.Send(gauge("Alloc", 1)))
+	_, err = push.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Клиент без ключа подписывает сообщения вручную
+	memStorage := storage.NewMemStorage("")
+	client := startServer(t, memStorage, "")
+	signed := func(m *metricspb.Metric, seq int64) *metricspb.Metric {
+		hash, err := grpcapi.StreamMessageHash(m, metricspb.Metrics_PushMetrics_FullMethodName, seq, testKey)
+		require.NoError(t, err)
+		m.Hash = hash
+		return m
+	}
+
+	push, err = client.PushMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, push.Send(signed(gauge("Alloc", 1), 0)))
+	require.NoError(t, push.Send(signed(gauge("Alloc", 2), 1)))
+	resp, err := push.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.GetAccepted())
+
+	// Повтор подписанного сообщения не проходит: подпись привязана к номеру в потоке
+	replayed := signed(counter("Requests", 5), 0)
+	push, err = client.PushMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, push.Send(replayed))
+	require.NoError(t, push.Send(replayed))
+	_, err = push.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Сообщение без подписи отклоняется
+	push, err = client.PushMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, push.Send(gauge("Alloc", 3)))
+	_, err = push.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	value, err := memStorage.GetGaugeMetric("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, value)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	"github.com/25x8/metric-gathering/internal/metricspb"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ProtobufContentType - тип содержимого пакета метрик в кодировке protobuf (metricspb.UpdateMetricsRequest).
+	ProtobufContentType = "application/x-protobuf"
+	// MsgpackContentType - тип содержимого пакета метрик в кодировке MessagePack.
+	MsgpackContentType = "application/msgpack"
+)
+
+// decodeMetricsBatch декодирует пакет метрик в кодировке, заданной заголовком Content-Type.
+// Неизвестный или пустой Content-Type считается JSON, как и раньше.
+func decodeMetricsBatch(contentType string, body []byte) ([]storage.Metrics, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case ProtobufContentType:
+		var req metricspb.UpdateMetricsRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("invalid protobuf: %w", err)
+		}
+		return fromProtoMetrics(req.GetMetrics())
+	case MsgpackContentType:
+		var metrics []storage.Metrics
+		if err := msgpack.Unmarshal(body, &metrics); err != nil {
+			return nil, fmt.Errorf("invalid msgpack: %w", err)
+		}
+		return metrics, nil
+	default:
+		var metrics []storage.Metrics
+		if err := json.Unmarshal(body, &metrics); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return metrics, nil
+	}
+}
+
+// fromProtoMetrics преобразует метрики protobuf в storage.Metrics.
+func fromProtoMetrics(batch []*metricspb.Metric) ([]storage.Metrics, error) {
+	metrics := make([]storage.Metrics, 0, len(batch))
+	for _, m := range batch {
+		sm := storage.Metrics{ID: m.GetId(), Labels: m.GetLabels()}
+		switch m.GetType() {
+		case metricspb.MetricType_GAUGE:
+			sm.MType = Gauge
+			sm.Value = m.Value
+		case metricspb.MetricType_COUNTER:
+			sm.MType = Counter
+			sm.Delta = m.Delta
+		default:
+			return nil, fmt.Errorf("invalid metric type for %s", m.GetId())
+		}
+		if m.GetTimestamp() != nil {
+			ts := m.GetTimestamp().AsTime()
+			sm.Timestamp = &ts
+		}
+		metrics = append(metrics, sm)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/metricspb"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHandleUpdatesBatch_Encodings(t *testing.T) {
+	value := 1.5
+	delta := int64(3)
+	batch := []storage.Metrics{
+		{ID: "Alloc", MType: Gauge, Value: &value},
+		{ID: "PollCount", MType: Counter, Delta: &delta},
+	}
+
+	jsonBody, err := json.Marshal(batch)
+	require.NoError(t, err)
+	msgpackBody, err := msgpack.Marshal(batch)
+	require.NoError(t, err)
+	protoBody, err := proto.Marshal(&metricspb.UpdateMetricsRequest{Metrics: []*metricspb.Metric{
+		{Id: "Alloc", Type: metricspb.MetricType_GAUGE, Value: &value},
+		{Id: "PollCount", Type: metricspb.MetricType_COUNTER, Delta: &delta},
+	}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "JSON", contentType: "application/json", body: jsonBody},
+		{name: "JSON without content type", contentType: "", body: jsonBody},
+		{name: "Protobuf", contentType: ProtobufContentType, body: protoBody},
+		{name: "Msgpack", contentType: MsgpackContentType, body: msgpackBody},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memStorage := storage.NewMemStorage("")
+			h := &Handler{Storage: memStorage}
+
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.HandleUpdatesBatch(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			alloc, err := memStorage.GetGaugeMetric("Alloc")
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, alloc)
+
+			pollCount, err := memStorage.GetCounterMetric("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), pollCount)
+		})
+	}
+}
+
+func TestHandleUpdatesBatch_InvalidEncodings(t *testing.T) {
+	h := &Handler{Storage: storage.NewMemStorage("")}
+
+	unknownType, err := proto.Marshal(&metricspb.UpdateMetricsRequest{Metrics: []*metricspb.Metric{{Id: "Alloc"}}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "Invalid protobuf", contentType: ProtobufContentType, body: []byte("\x0a\xff")},
+		{name: "Protobuf without type", contentType: ProtobufContentType, body: unknownType},
+		{name: "Invalid msgpack", contentType: MsgpackContentType, body: []byte{0xc1}},
+		{name: "Invalid JSON", contentType: "application/json", body: []byte("{")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.HandleUpdatesBatch(w, r)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// changesResponse - ответ /api/v1/changes: текущая ревизия, метрики, измененные после since,
+// и удаленные после since метрики.
+type changesResponse struct {
+	Revision int64             `json:"revision"`
+	Reset    bool              `json:"reset"`
+	Metrics  []storage.Metrics `json:"metrics"`
+	Deleted  []storage.Metrics `json:"deleted"`
+}
+
+// HandleGetChanges обрабатывает GET-запросы на /api/v1/changes?since=<ревизия> и возвращает
+// {"revision": N, "reset": false, "metrics": [...], "deleted": [...]}: текущие значения метрик,
+// записанных после ревизии since, в тех же полях, что и /value/, и ссылки {"id", "type", "labels"}
+// на метрики, удаленные после нее. Клиент применяет сначала deleted, затем metrics; полученная ревизия
+// передается как since в следующем запросе. Если reset = true (без since или после сброса ревизий
+// хранилища), metrics - полный набор метрик, которым клиент заменяет свое состояние.
+func (h *Handler) HandleGetChanges(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || since < 0 {
+			http.Error(w, "since must be a non-negative revision", http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, err := h.Storage.ListChanges(since)
+	if err != nil {
+		http.Error(w, "Failed to list changes", http.StatusInternalServerError)
+		return
+	}
+
+	resp := changesResponse{
+		Revision: changes.Revision,
+		Reset:    changes.Reset,
+		Metrics:  []storage.Metrics{},
+		Deleted:  []storage.Metrics{},
+	}
+	for _, t := range metricTypes {
+		for _, key := range changes.Metrics.Keys(t) {
+			id, labels := storage.ParseSeriesKey(key)
+			m := storage.Metrics{ID: id, MType: t, Labels: labels}
+			fillValue(&m, changes.Metrics, key)
+			resp.Metrics = append(resp.Metrics, m)
+		}
+	}
+	for _, ref := range changes.Deleted {
+		id, labels := storage.ParseSeriesKey(ref.Name)
+		resp.Deleted = append(resp.Deleted, storage.Metrics{ID: id, MType: ref.Type, Labels: labels})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
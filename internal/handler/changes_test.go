@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getChanges(h *Handler, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/changes"+query, nil)
+	w := httptest.NewRecorder()
+	h.HandleGetChanges(w, r)
+	return w
+}
+
+func TestHandleGetChanges(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	w := getChanges(h, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revision": 0, "reset": true, "metrics": [], "deleted": []}`, w.Body.String())
+
+	require.NoError(t, memStorage.SaveGaugeMetric(`Alloc{host="a"}`, 1.5))
+	require.NoError(t, memStorage.SaveCounterMetric("requests", 7))
+
+	w = getChanges(h, "?since=0")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"revision": 2, "reset": true, "deleted": [], "metrics": [
+		{"id": "Alloc", "type": "gauge", "labels": {"host": "a"}, "value": 1.5},
+		{"id": "requests", "type": "counter", "delta": 7}
+	]}`, w.Body.String())
+
+	// После ревизии 2 возвращается только обновленный counter
+	require.NoError(t, memStorage.SaveCounterMetric("requests", 3))
+	w = getChanges(h, "?since=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revision": 3, "reset": false, "deleted": [],
+		"metrics": [{"id": "requests", "type": "counter", "delta": 10}]}`, w.Body.String())
+
+	// Удаленная метрика сообщается ссылкой в deleted
+	require.NoError(t, memStorage.DeleteMetric(Gauge, `Alloc{host="a"}`))
+	w = getChanges(h, "?since=3")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revision": 4, "reset": false, "metrics": [],
+		"deleted": [{"id": "Alloc", "type": "gauge", "labels": {"host": "a"}}]}`, w.Body.String())
+
+	for _, query := range []string{"?since=-1", "?since=abc"} {
+		assert.Equal(t, http.StatusBadRequest, getChanges(h, query).Code, query)
+	}
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/gorilla/mux"
+)
+
+// metricTypes - все типы метрик в порядке удаления при массовом удалении без типа.
+var metricTypes = []string{Gauge, Counter, Histogram, Summary, Set}
+
+// deleteRequest - тело запроса массового удаления.
+type deleteRequest struct {
+	Type  string   `json:"type,omitempty"` // тип удаляемых метрик; пустой - все типы
+	Names []string `json:"names"`          // ключи серий или шаблоны имен метрик (синтаксис path.Match)
+}
+
+// HandleDeleteMetric обрабатывает DELETE-запросы на /value/{type}/{name} и удаляет метрику вместе с ее историей.
+// Возвращает 404, если метрики нет.
+func (h *Handler) HandleDeleteMetric(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	metricType := vars["type"]
+	metricName := vars["name"]
+
+	if !isMetricType(metricType) {
+		http.Error(w, "Invalid metric type", http.StatusBadRequest)
+		return
+	}
+
+	err := h.Storage.DeleteMetric(metricType, metricName)
+	if errors.Is(err, storage.ErrMetricNotFound) {
+		http.Error(w, "Metric not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete metric", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Metric %s deleted", metricName)
+}
+
+// HandleDeleteMetrics обрабатывает POST-запросы на /delete/ с JSON-телом {"type": "gauge", "names": [...]}
+// и удаляет все подходящие метрики. Элемент names совпадает с метрикой, если равен ее ключу серии
+// или если это шаблон, под который подходит имя метрики без меток (например, "CPUutilization*").
+// Без type удаляются метрики всех типов. Все подходящие метрики удаляются за одно обращение к хранилищу:
+// при ошибке возвращается 500 и ни одна метрика не удаляется. Возвращает JSON-массив удаленных метрик
+// с полями id, type и labels.
+func (h *Handler) HandleDeleteMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req deleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Names) == 0 {
+		http.Error(w, "names are required", http.StatusBadRequest)
+		return
+	}
+
+	types := metricTypes
+	if req.Type != "" {
+		if !isMetricType(req.Type) {
+			http.Error(w, "Invalid metric type", http.StatusBadRequest)
+			return
+		}
+		types = []string{req.Type}
+	}
+	for _, pattern := range req.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid pattern %q", pattern), http.StatusBadRequest)
+			return
+		}
+	}
+
+	all, err := h.Storage.ListAllMetrics()
+	if err != nil {
+		http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
+		return
+	}
+	exact := make(map[string]bool, len(req.Names))
+	for _, name := range req.Names {
+		exact[storage.CanonicalKey(name)] = true
+	}
+
+	var refs []storage.MetricRef
+	for _, t := range types {
+		for _, key := range all.Keys(t) {
+			if name, _ := storage.ParseSeriesKey(key); exact[key] || matchesAny(name, req.Names) {
+				refs = append(refs, storage.MetricRef{Type: t, Name: key})
+			}
+		}
+	}
+
+	// Метрики удаляются одним обращением к хранилищу: при ошибке не удаляется ни одна
+	removed, err := h.Storage.DeleteMetrics(refs)
+	if err != nil {
+		http.Error(w, "Failed to delete metrics", http.StatusInternalServerError)
+		return
+	}
+	deleted := make([]storage.Metrics, len(removed))
+	for i, ref := range removed {
+		id, labels := storage.ParseSeriesKey(ref.Name)
+		deleted[i] = storage.Metrics{ID: id, MType: ref.Type, Labels: labels}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deleted)
+}
+
+// isMetricType проверяет, что metricType - один из поддерживаемых типов метрик.
+func isMetricType(metricType string) bool {
+	return slices.Contains(metricTypes, metricType)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteErrorStorage - хранилище, в котором массовое удаление завершается ошибкой.
+type deleteErrorStorage struct {
+	*storage.MemStorage
+}
+
+func (s deleteErrorStorage) DeleteMetrics(refs []storage.MetricRef) ([]storage.MetricRef, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHandleDeleteMetric(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+	require.NoError(t, memStorage.SaveGaugeMetric("HeapAlloc", 1))
+	require.NoError(t, memStorage.SaveCounterMetric("HeapAlloc", 2))
+
+	deleteMetric := func(metricType, name string) *httptest.ResponseRecorder {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/value/"+metricType+"/"+name, nil),
+			map[string]string{"type": metricType, "name": name})
+		w := httptest.NewRecorder()
+		h.HandleDeleteMetric(w, r)
+		return w
+	}
+
+	// Удаляется только метрика указанного типа
+	assert.Equal(t, http.StatusOK, deleteMetric(Gauge, "HeapAlloc").Code)
+	_, err := memStorage.GetGaugeMetric("HeapAlloc")
+	assert.Error(t, err)
+	_, err = memStorage.GetCounterMetric("HeapAlloc")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusNotFound, deleteMetric(Gauge, "HeapAlloc").Code)
+	assert.Equal(t, http.StatusBadRequest, deleteMetric("meter", "HeapAlloc").Code)
+}
+
+func TestHandleDeleteMetrics(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+	for _, host := range []string{"a", "b"} {
+		require.NoError(t, memStorage.SaveGaugeMetric(storage.SeriesKey("CPUutilization1", map[string]string{"host": host}), 1))
+	}
+	require.NoError(t, memStorage.SaveGaugeMetric("CPUutilization2", 1))
+	require.NoError(t, memStorage.SaveGaugeMetric("HeapAlloc", 1))
+	require.NoError(t, memStorage.SaveCounterMetric("PollCount", 1))
+	require.NoError(t, memStorage.SaveCounterMetric("CPUcount", 1))
+
+	deleteMetrics := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/delete/", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.HandleDeleteMetrics(w, r)
+		return w
+	}
+
+	// Шаблон по имени и точный ключ серии; counter CPUcount не затрагивается фильтром типа
+	w := deleteMetrics(`{"type": "gauge", "names": ["CPUutilization*", "HeapAlloc", "CPUcount"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var deleted []storage.Metrics
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&deleted))
+	assert.ElementsMatch(t, []storage.Metrics{
+		{ID: "CPUutilization1", MType: Gauge, Labels: map[string]string{"host": "a"}},
+		{ID: "CPUutilization1", MType: Gauge, Labels: map[string]string{"host": "b"}},
+		{ID: "CPUutilization2", MType: Gauge},
+		{ID: "HeapAlloc", MType: Gauge},
+	}, deleted)
+	all, err := memStorage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Empty(t, all.Gauges)
+	assert.Equal(t, []string{"CPUcount", "PollCount"}, all.Keys(Counter))
+
+	// Без типа удаляются метрики всех типов
+	w = deleteMetrics(`{"names": ["PollCount"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"id": "PollCount", "type": "counter"}]`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, deleteMetrics(`{"names": ["["]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, deleteMetrics(`{"names": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, deleteMetrics(`{"type": "meter", "names": ["PollCount"]}`).Code)
+}
+
+func TestHandleDeleteMetrics_StorageError(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	require.NoError(t, memStorage.SaveGaugeMetric("HeapAlloc", 1))
+	h := &Handler{Storage: deleteErrorStorage{memStorage}}
+
+	r := httptest.NewRequest(http.MethodPost, "/delete/", bytes.NewBufferString(`{"names": ["*"]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandleDeleteMetrics(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	_, err := memStorage.GetGaugeMetric("HeapAlloc")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+var (
+	errHistogramValueRequired = errors.New("value or histogram is required for histogram")
+	errSummaryValueRequired   = errors.New("value or summary is required for summary")
+	errSetMembersRequired     = errors.New("members or set is required for set")
+)
+
+// distributionResolver приводит histogram-, summary- и set-метрики запроса к приращению, которое сливается
+// с сохраненным значением. Одиночное наблюдение (поле value) раскладывается по границам уже
+// сохраненной гистограммы или добавляется в скетч с точностью сохраненного скетча, элементы set
+// (поле members) добавляются в скетч HyperLogLog; для новой метрики используются HistogramBuckets,
+// storage.DefaultSummaryAccuracy и storage.DefaultSetPrecision. Параметры, встреченные в запросе,
+// запоминаются, чтобы все метрики одной серии в пакете были согласованы.
+type distributionResolver struct {
+	storage   storage.Storage
+	defaults  []float64
+	bounds    map[string][]float64
+	accuracy  map[string]float64
+	precision map[string]uint8
+}
+
+func (h *Handler) newDistributionResolver() *distributionResolver {
+	defaults := h.HistogramBuckets
+	if len(defaults) == 0 {
+		defaults = storage.DefaultHistogramBuckets
+	}
+	return &distributionResolver{
+		storage:   h.Storage,
+		defaults:  defaults,
+		bounds:    make(map[string][]float64),
+		accuracy:  make(map[string]float64),
+		precision: make(map[string]uint8),
+	}
+}
+
+// resolve заполняет m.Histogram, m.Summary или m.Set и проверяет согласованность с сохраненным значением.
+// Метрики других типов не изменяются.
+func (r *distributionResolver) resolve(m *storage.Metrics) error {
+	switch m.MType {
+	case Histogram:
+		return r.resolveHistogram(m)
+	case Summary:
+		return r.resolveSummary(m)
+	case Set:
+		return r.resolveSet(m)
+	default:
+		return nil
+	}
+}
+
+func (r *distributionResolver) resolveHistogram(m *storage.Metrics) error {
+	key := storage.SeriesKey(m.ID, m.Labels)
+	known, ok := r.bounds[key]
+	if !ok {
+		if current, err := r.storage.GetHistogramMetric(key); err == nil {
+			known, ok = current.Bounds, true
+		}
+	}
+
+	switch {
+	case m.Histogram != nil:
+		if err := m.Histogram.Validate(); err != nil {
+			return err
+		}
+		if ok && !slices.Equal(known, m.Histogram.Bounds) {
+			return storage.ErrHistogramBounds
+		}
+	case m.Value != nil:
+		if !isFinite(*m.Value) {
+			return fmt.Errorf("%w: value is not finite", storage.ErrInvalidHistogram)
+		}
+		if !ok {
+			known = r.defaults
+		}
+		delta := storage.NewHistogramValue(known)
+		delta.Observe(*m.Value)
+		m.Histogram = &delta
+	default:
+		return errHistogramValueRequired
+	}
+
+	r.bounds[key] = m.Histogram.Bounds
+	return nil
+}
+
+func (r *distributionResolver) resolveSummary(m *storage.Metrics) error {
+	key := storage.SeriesKey(m.ID, m.Labels)
+	known, ok := r.accuracy[key]
+	if !ok {
+		if current, err := r.storage.GetSummaryMetric(key); err == nil {
+			known, ok = current.Accuracy, true
+		}
+	}
+
+	switch {
+	case m.Summary != nil:
+		if err := m.Summary.Validate(); err != nil {
+			return err
+		}
+		if ok && known != m.Summary.Accuracy {
+			return storage.ErrSummaryAccuracy
+		}
+	case m.Value != nil:
+		if !isFinite(*m.Value) {
+			return fmt.Errorf("%w: value is not finite", storage.ErrInvalidSummary)
+		}
+		if !ok {
+			known = storage.DefaultSummaryAccuracy
+		}
+		delta := storage.NewSummaryValue(known)
+		delta.Add(*m.Value)
+		m.Summary = &delta
+	default:
+		return errSummaryValueRequired
+	}
+
+	r.accuracy[key] = m.Summary.Accuracy
+	return nil
+}
+
+func (r *distributionResolver) resolveSet(m *storage.Metrics) error {
+	key := storage.SeriesKey(m.ID, m.Labels)
+	known, ok := r.precision[key]
+	if !ok {
+		if current, err := r.storage.GetSetMetric(key); err == nil {
+			known, ok = current.Precision, true
+		}
+	}
+
+	switch {
+	case m.Set != nil:
+		if err := m.Set.Validate(); err != nil {
+			return err
+		}
+		if ok && known != m.Set.Precision {
+			return storage.ErrSetPrecision
+		}
+		if len(m.Members) > 0 {
+			delta := m.Set.Clone()
+			m.Set = &delta
+		}
+	case len(m.Members) > 0:
+		if !ok {
+			known = storage.DefaultSetPrecision
+		}
+		delta := storage.NewSetValue(known)
+		m.Set = &delta
+	default:
+		return errSetMembersRequired
+	}
+
+	for _, member := range m.Members {
+		m.Set.Add(member)
+	}
+	r.precision[key] = m.Set.Precision
+	return nil
+}
+
+// isFinite проверяет, что наблюдение не NaN и не бесконечность.
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// saveDistribution сохраняет одиночную histogram-, summary- или set-метрику из запроса.
+// Метрика со временем сбора передается через UpdateMetricsBatch, как в saveSample.
+func (h *Handler) saveDistribution(m *storage.Metrics) error {
+	if err := h.newDistributionResolver().resolve(m); err != nil {
+		return err
+	}
+	if m.Timestamp != nil {
+		return h.Storage.UpdateMetricsBatch([]storage.Metrics{*m})
+	}
+	key := storage.SeriesKey(m.ID, m.Labels)
+	switch m.MType {
+	case Summary:
+		return h.Storage.SaveSummaryMetric(key, *m.Summary)
+	case Set:
+		return h.Storage.SaveSetMetric(key, *m.Set)
+	default:
+		return h.Storage.SaveHistogramMetric(key, *m.Histogram)
+	}
+}
+
+// isDistributionError проверяет, что ошибка вызвана некорректной гистограммой или скетчем в запросе.
+func isDistributionError(err error) bool {
+	for _, target := range []error{
+		storage.ErrInvalidHistogram, storage.ErrHistogramBounds, errHistogramValueRequired,
+		storage.ErrInvalidSummary, storage.ErrSummaryAccuracy, errSummaryValueRequired,
+		storage.ErrInvalidSet, storage.ErrSetPrecision, errSetMembersRequired,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDistributionError возвращает 400 для некорректной гистограммы или скетча и 500 для ошибок хранилища.
+func writeDistributionError(w http.ResponseWriter, err error) {
+	if isDistributionError(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to update metric", http.StatusInternalServerError)
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 
 	"github.com/25x8/metric-gathering/internal/storage"
@@ -19,6 +21,12 @@ const (
 	Gauge = "gauge"
 	// Counter - тип метрики-счетчика
 	Counter = "counter"
+	// Histogram - тип метрики-гистограммы
+	Histogram = "histogram"
+	// Summary - тип метрики со скетчем для вычисления квантилей
+	Summary = "summary"
+	// Set - тип метрики для оценки числа уникальных элементов
+	Set = "set"
 )
 
 // Handler обрабатывает HTTP-запросы для метрик.
@@ -26,12 +34,26 @@ const (
 type Handler struct {
 	Storage storage.Storage // хранилище метрик
 	DB      *sql.DB         // подключение к базе данных (если используется)
+	Changes *ChangeHub      // рассылка изменений метрик для /stream (nil, если хранилище не сообщает об изменениях)
+
+	// RemoteWriteCounters - шаблоны имен серий remote_write, сохраняемых как counter.
+	// Если не задано, используется DefaultRemoteWriteCounters.
+	RemoteWriteCounters []string
+
+	// HistogramBuckets - границы бакетов для новых гистограмм, создаваемых по одиночному наблюдению.
+	// Если не задано, используется storage.DefaultHistogramBuckets.
+	HistogramBuckets []float64
+
+	remoteWriteState cumulativeTracker // последние накопительные значения счетчиков remote_write
+	influxState      cumulativeTracker // последние накопительные значения целых полей line protocol
+	otlpState        cumulativeTracker // последние значения CUMULATIVE-сумм OTLP
 }
 
 // HandleGetValue обрабатывает GET-запросы для получения значения метрики по имени и типу.
-// URL формат: /value/{type}/{name}, где type - тип метрики (gauge или counter),
+// URL формат: /value/{type}/{name}, где type - тип метрики (gauge, counter, histogram, summary или set),
 // name - имя метрики.
-// Возвращает текстовое представление значения метрики.
+// Возвращает текстовое представление значения метрики. Для summary параметр запроса q
+// (например, ?q=0.95) возвращает значение одного квантиля.
 func (h *Handler) HandleGetValue(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	metricType := vars["type"]
@@ -54,6 +76,43 @@ func (h *Handler) HandleGetValue(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Fprintf(w, "%v", value)
 
+	case Histogram:
+		value, err := h.Storage.GetHistogramMetric(metricName)
+		if err != nil {
+			http.Error(w, "Metric not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "%v", value)
+
+	case Summary:
+		value, err := h.Storage.GetSummaryMetric(metricName)
+		if err != nil {
+			http.Error(w, "Metric not found", http.StatusNotFound)
+			return
+		}
+		if q := r.URL.Query().Get("q"); q != "" {
+			quantile, err := parseQuantile(q)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if value.Count == 0 {
+				http.Error(w, "Summary has no observations", http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, "%v", value.Quantile(quantile))
+			return
+		}
+		fmt.Fprintf(w, "%v", value)
+
+	case Set:
+		value, err := h.Storage.GetSetMetric(metricName)
+		if err != nil {
+			http.Error(w, "Metric not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "%d", value.Estimate())
+
 	default:
 		http.Error(w, "Invalid metric type", http.StatusBadRequest)
 	}
@@ -61,7 +120,9 @@ func (h *Handler) HandleGetValue(w http.ResponseWriter, r *http.Request) {
 
 // HandleGetValueJSON обрабатывает POST-запросы с JSON-телом для получения значения метрики.
 // Ожидает JSON в формате: {"id": "метрика", "type": "тип"}.
-// Возвращает JSON с добавленным значением метрики в поле value или delta.
+// Возвращает JSON с добавленным значением метрики в поле value, delta или histogram.
+// Для summary возвращается скетч в поле summary и квантили DefaultSummaryQuantiles в поле quantiles,
+// для set - оценка числа уникальных элементов в поле cardinality.
 func (h *Handler) HandleGetValueJSON(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("Content-Type") != "application/json" {
 		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
@@ -97,22 +158,49 @@ func (h *Handler) HandleGetValueJSON(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "ID and MType are required", http.StatusBadRequest)
 		return
 	}
+	if err := storage.ValidateLabels(m.Labels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := storage.SeriesKey(m.ID, m.Labels)
 
 	switch m.MType {
 	case Gauge:
-		value, err := h.Storage.GetGaugeMetric(m.ID)
+		value, err := h.Storage.GetGaugeMetric(key)
 		if err != nil {
 			http.Error(w, "Metric not found", http.StatusNotFound)
 			return
 		}
 		m.Value = &value
 	case Counter:
-		delta, err := h.Storage.GetCounterMetric(m.ID)
+		delta, err := h.Storage.GetCounterMetric(key)
 		if err != nil {
 			http.Error(w, "Metric not found", http.StatusNotFound)
 			return
 		}
 		m.Delta = &delta
+	case Histogram:
+		value, err := h.Storage.GetHistogramMetric(key)
+		if err != nil {
+			http.Error(w, "Metric not found", http.StatusNotFound)
+			return
+		}
+		m.Value = nil
+		m.Histogram = &value
+	case Summary:
+		value, err := h.Storage.GetSummaryMetric(key)
+		if err != nil {
+			http.Error(w, "Metric not found", http.StatusNotFound)
+			return
+		}
+		setSummaryValue(&m, value)
+	case Set:
+		value, err := h.Storage.GetSetMetric(key)
+		if err != nil {
+			http.Error(w, "Metric not found", http.StatusNotFound)
+			return
+		}
+		setSetValue(&m, value)
 	default:
 		http.Error(w, "Invalid metric type", http.StatusBadRequest)
 		return
@@ -122,10 +210,43 @@ func (h *Handler) HandleGetValueJSON(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(m)
 }
 
+// metricRow - строка таблицы на HTML-странице всех метрик.
+type metricRow struct {
+	Name  string
+	Type  string
+	Value interface{}
+}
+
 // HandleGetAllMetrics обрабатывает GET-запросы для получения всех метрик.
-// Возвращает HTML-страницу с таблицей всех метрик и их значений.
+// Возвращает HTML-страницу с таблицей всех метрик, их типов и значений, упорядоченной по имени и типу.
+// Метрики разных типов с одним именем выводятся отдельными строками.
 func (h *Handler) HandleGetAllMetrics(w http.ResponseWriter, r *http.Request) {
-	allMetrics := h.Storage.GetAllMetrics()
+	all, err := h.Storage.ListAllMetrics()
+	if err != nil {
+		http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
+		return
+	}
+
+	var rows []metricRow
+	for _, t := range metricTypes {
+		for _, key := range all.Keys(t) {
+			row := metricRow{Name: key, Type: t}
+			switch t {
+			case Gauge:
+				row.Value = all.Gauges[key]
+			case Counter:
+				row.Value = all.Counters[key]
+			case Histogram:
+				row.Value = all.Histograms[key]
+			case Summary:
+				row.Value = all.Summaries[key]
+			case Set:
+				row.Value = all.Sets[key]
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
 
 	w.Header().Set("Content-Type", "text/html")
 
@@ -137,12 +258,14 @@ func (h *Handler) HandleGetAllMetrics(w http.ResponseWriter, r *http.Request) {
 			<table border="1">
 				<tr>
 					<th>Name</th>
+					<th>Type</th>
 					<th>Value</th>
 				</tr>
-				{{range $name, $value := .}}
+				{{range .}}
 				<tr>
-					<td>{{$name}}</td>
-					<td>{{$value}}</td>
+					<td>{{.Name}}</td>
+					<td>{{.Type}}</td>
+					<td>{{.Value}}</td>
 				</tr>
 				{{end}}
 			</table>
@@ -151,12 +274,12 @@ func (h *Handler) HandleGetAllMetrics(w http.ResponseWriter, r *http.Request) {
 		`
 
 	t := template.Must(template.New("metrics").Parse(tmpl))
-	t.Execute(w, allMetrics)
+	t.Execute(w, rows)
 }
 
 // HandleUpdateMetric обрабатывает POST-запросы для обновления значения метрики.
-// URL формат: /update/{type}/{name}/{value}, где type - тип метрики (gauge или counter),
-// name - имя метрики, value - новое значение.
+// URL формат: /update/{type}/{name}/{value}, где type - тип метрики (gauge, counter, histogram, summary или set),
+// name - имя метрики, value - новое значение (для histogram и summary - одно наблюдение, для set - элемент множества).
 func (h *Handler) HandleUpdateMetric(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
@@ -186,6 +309,25 @@ func (h *Handler) HandleUpdateMetric(w http.ResponseWriter, r *http.Request) {
 		}
 		h.Storage.SaveCounterMetric(metricName, value)
 
+	case Histogram, Summary:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil || !isFinite(value) {
+			http.Error(w, "Invalid "+metricType+" value", http.StatusBadRequest)
+			return
+		}
+		m := storage.Metrics{ID: metricName, MType: metricType, Value: &value}
+		if err := h.saveDistribution(&m); err != nil {
+			writeDistributionError(w, err)
+			return
+		}
+
+	case Set:
+		m := storage.Metrics{ID: metricName, MType: Set, Members: []string{metricValue}}
+		if err := h.saveDistribution(&m); err != nil {
+			writeDistributionError(w, err)
+			return
+		}
+
 	default:
 		http.Error(w, "Invalid metric type", http.StatusBadRequest)
 		return
@@ -199,6 +341,11 @@ func (h *Handler) HandleUpdateMetric(w http.ResponseWriter, r *http.Request) {
 // HandleUpdateMetricJSON обрабатывает POST-запросы с JSON-телом для обновления значения метрики.
 // Ожидает JSON в формате: {"id": "метрика", "type": "тип", "value": число} для gauge
 // или {"id": "метрика", "type": "тип", "delta": число} для counter.
+// Для histogram передается одно наблюдение в value или приращение гистограммы в histogram,
+// для summary - одно наблюдение в value или скетч агента в summary,
+// для set - новые элементы в members и (или) скетч агента в set.
+// Необязательное поле timestamp задает время сбора сэмпла; gauge-сэмпл старше сохраненного
+// значения обрабатывается по политике хранилища (см. storage.OutOfOrderPolicy), отклоненный - 409.
 // Возвращает обновленный JSON с сохраненным значением.
 func (h *Handler) HandleUpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 
@@ -219,6 +366,11 @@ func (h *Handler) HandleUpdateMetricJSON(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "ID and MType are required", http.StatusBadRequest)
 		return
 	}
+	if err := storage.ValidateLabels(m.Labels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := storage.SeriesKey(m.ID, m.Labels)
 
 	switch m.MType {
 	case Gauge:
@@ -226,17 +378,45 @@ func (h *Handler) HandleUpdateMetricJSON(w http.ResponseWriter, r *http.Request)
 			http.Error(w, "Value is required for gauge", http.StatusBadRequest)
 			return
 		}
-		h.Storage.SaveGaugeMetric(m.ID, *m.Value)
-		updatedValue, _ := h.Storage.GetGaugeMetric(m.ID)
+		if err := h.saveSample(m); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+		updatedValue, _ := h.Storage.GetGaugeMetric(key)
 		m.Value = &updatedValue
 	case "counter":
 		if m.Delta == nil {
 			http.Error(w, "Delta is required for counter", http.StatusBadRequest)
 			return
 		}
-		h.Storage.SaveCounterMetric(m.ID, *m.Delta)
-		updatedDelta, _ := h.Storage.GetCounterMetric(m.ID)
+		if err := h.saveSample(m); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+		updatedDelta, _ := h.Storage.GetCounterMetric(key)
 		m.Delta = &updatedDelta
+	case Histogram:
+		if err := h.saveDistribution(&m); err != nil {
+			writeDistributionError(w, err)
+			return
+		}
+		updatedHistogram, _ := h.Storage.GetHistogramMetric(key)
+		m.Value = nil
+		m.Histogram = &updatedHistogram
+	case Summary:
+		if err := h.saveDistribution(&m); err != nil {
+			writeDistributionError(w, err)
+			return
+		}
+		updatedSummary, _ := h.Storage.GetSummaryMetric(key)
+		setSummaryValue(&m, updatedSummary)
+	case Set:
+		if err := h.saveDistribution(&m); err != nil {
+			writeDistributionError(w, err)
+			return
+		}
+		updatedSet, _ := h.Storage.GetSetMetric(key)
+		setSetValue(&m, updatedSet)
 	default:
 		http.Error(w, "Invalid metric type", http.StatusBadRequest)
 		return
@@ -270,19 +450,20 @@ func (h *Handler) CloseDB() {
 	}
 }
 
+// HandleUpdatesBatch обрабатывает пакетное обновление метрик на /updates/.
+// Кодировка тела выбирается по Content-Type: JSON (по умолчанию), protobuf или msgpack.
+// Пакет с gauge-сэмплом, отклоненным как устаревший, не применяется и возвращает 409.
 func (h *Handler) HandleUpdatesBatch(w http.ResponseWriter, r *http.Request) {
-	var metrics []storage.Metrics
-
-	// Декодирование JSON
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
 
-	err = json.Unmarshal(body, &metrics)
+	// Декодирование JSON, protobuf или msgpack в зависимости от Content-Type
+	metrics, err := decodeMetricsBatch(r.Header.Get("Content-Type"), body)
 	if err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
@@ -291,8 +472,29 @@ func (h *Handler) HandleUpdatesBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	distributions := h.newDistributionResolver()
+	for i := range metrics {
+		if err := storage.ValidateLabels(metrics[i].Labels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := distributions.resolve(&metrics[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Обновление метрик в хранилище в рамках одной транзакции
 	err = h.Storage.UpdateMetricsBatch(metrics)
+	if errors.Is(err, storage.ErrOutOfOrder) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if isDistributionError(err) {
+		// Гистограмма или скетч могли быть созданы с другими параметрами параллельным запросом
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update metrics", http.StatusInternalServerError)
 		return
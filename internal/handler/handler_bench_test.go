@@ -3,12 +3,17 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/25x8/metric-gathering/internal/metricspb"
 	"github.com/25x8/metric-gathering/internal/storage"
 	"github.com/gorilla/mux"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
 )
 
 // MockStorage - простая реализация интерфейса Storage для тестирования
@@ -30,17 +35,83 @@ func (m *MockStorage) GetCounterMetric(name string) (int64, error) {
 	return 100, nil
 }
 
-func (m *MockStorage) GetAllMetrics() map[string]interface{} {
-	metrics := make(map[string]interface{})
-	metrics["gauge_test"] = 42.0
-	metrics["counter_test"] = int64(100)
-	return metrics
+func (m *MockStorage) SaveHistogramMetric(name string, h storage.HistogramValue) error {
+	return nil
+}
+
+func (m *MockStorage) GetHistogramMetric(name string) (storage.HistogramValue, error) {
+	return storage.HistogramValue{}, fmt.Errorf("metric not found")
+}
+
+func (m *MockStorage) SaveSummaryMetric(name string, s storage.SummaryValue) error {
+	return nil
+}
+
+func (m *MockStorage) GetSummaryMetric(name string) (storage.SummaryValue, error) {
+	return storage.SummaryValue{}, fmt.Errorf("metric not found")
+}
+
+func (m *MockStorage) SaveSetMetric(name string, s storage.SetValue) error {
+	return nil
+}
+
+func (m *MockStorage) GetSetMetric(name string) (storage.SetValue, error) {
+	return storage.SetValue{}, fmt.Errorf("metric not found")
+}
+
+func (m *MockStorage) GetMetrics(refs []storage.MetricRef) (storage.MetricSet, error) {
+	metrics := storage.NewMetricSet()
+	for _, ref := range refs {
+		switch {
+		case ref.Type == storage.Gauge && ref.Name == "gauge_test":
+			metrics.Gauges[ref.Name] = 42.0
+		case ref.Type == storage.Counter && ref.Name == "counter_test":
+			metrics.Counters[ref.Name] = int64(100)
+		}
+	}
+	return metrics, nil
+}
+
+func (m *MockStorage) ListAllMetrics() (storage.MetricSet, error) {
+	metrics := storage.NewMetricSet()
+	metrics.Gauges["gauge_test"] = 42.0
+	metrics.Counters["counter_test"] = int64(100)
+	return metrics, nil
+}
+
+func (m *MockStorage) ListChanges(since int64) (storage.ChangeSet, error) {
+	metrics, err := m.ListAllMetrics()
+	return storage.ChangeSet{Metrics: metrics, Revision: 2}, err
+}
+
+func (m *MockStorage) ListMetrics(opts storage.ListOptions) (storage.MetricPage, error) {
+	gauge, counter := 42.0, int64(100)
+	return storage.MetricPage{Metrics: []storage.MetricEntry{
+		{ID: "counter_test", Type: storage.Counter, Delta: &counter},
+		{ID: "gauge_test", Type: storage.Gauge, Value: &gauge},
+	}}, nil
+}
+
+func (m *MockStorage) DeleteMetric(metricType, name string) error {
+	return storage.ErrMetricNotFound
+}
+
+func (m *MockStorage) DeleteMetrics(refs []storage.MetricRef) ([]storage.MetricRef, error) {
+	return []storage.MetricRef{}, nil
 }
 
 func (m *MockStorage) UpdateMetricsBatch(metrics []storage.Metrics) error {
 	return nil
 }
 
+func (m *MockStorage) GetHistory(metricType, name string, from, to time.Time, res storage.Resolution) ([]storage.HistoryPoint, error) {
+	return nil, storage.ErrMetricNotFound
+}
+
+func (m *MockStorage) GetHistories(refs []storage.MetricRef, from, to time.Time, res storage.Resolution) (map[storage.MetricRef][]storage.HistoryPoint, error) {
+	return map[storage.MetricRef][]storage.HistoryPoint{}, nil
+}
+
 // setupHandlerBench создает тестовый обработчик для бенчмарков
 func setupHandlerBench() *Handler {
 	mockStorage := &MockStorage{}
@@ -170,3 +241,57 @@ func BenchmarkHandleUpdatesBatch(b *testing.B) {
 		router.ServeHTTP(w, req)
 	}
 }
+
+// benchBatch создает пакет из n метрик, половина gauge и половина counter
+func benchBatch(n int) ([]storage.Metrics, *metricspb.UpdateMetricsRequest) {
+	metrics := make([]storage.Metrics, 0, n)
+	req := &metricspb.UpdateMetricsRequest{}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("metric_%d", i)
+		if i%2 == 0 {
+			value := float64(i) * 1.5
+			metrics = append(metrics, storage.Metrics{ID: id, MType: Gauge, Value: &value})
+			req.Metrics = append(req.Metrics, &metricspb.Metric{Id: id, Type: metricspb.MetricType_GAUGE, Value: &value})
+		} else {
+			delta := int64(i)
+			metrics = append(metrics, storage.Metrics{ID: id, MType: Counter, Delta: &delta})
+			req.Metrics = append(req.Metrics, &metricspb.Metric{Id: id, Type: metricspb.MetricType_COUNTER, Delta: &delta})
+		}
+	}
+	return metrics, req
+}
+
+// BenchmarkHandleUpdatesBatchEncodings сравнивает производительность пакетного обновления
+// в кодировках JSON, protobuf и msgpack на пакете типичного для агента размера
+func BenchmarkHandleUpdatesBatchEncodings(b *testing.B) {
+	h := setupHandlerBench()
+	metrics, protoReq := benchBatch(100)
+
+	jsonBody, _ := json.Marshal(metrics)
+	protoBody, _ := proto.Marshal(protoReq)
+	msgpackBody, _ := msgpack.Marshal(metrics)
+
+	encodings := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "JSON", contentType: "application/json", body: jsonBody},
+		{name: "Protobuf", contentType: ProtobufContentType, body: protoBody},
+		{name: "Msgpack", contentType: MsgpackContentType, body: msgpackBody},
+	}
+
+	for _, enc := range encodings {
+		b.Run(enc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(enc.body)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(enc.body))
+				req.Header.Set("Content-Type", enc.contentType)
+
+				w := httptest.NewRecorder()
+				h.HandleUpdatesBatch(w, req)
+			}
+		})
+	}
+}
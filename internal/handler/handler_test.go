@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/25x8/metric-gathering/internal/storage"
@@ -190,3 +191,80 @@ func TestHandleUpdateMetric(t *testing.T) {
 		})
 	}
 }
+
+// TestHandleUpdateMetricJSON_Labels проверяет, что метрики с разными метками хранятся раздельно
+func TestHandleUpdateMetricJSON_Labels(t *testing.T) {
+	h := setupHandler()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "Label host=a", body: `{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"a"}}`, wantStatus: http.StatusOK},
+		{name: "Label host=b", body: `{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"b"}}`, wantStatus: http.StatusOK},
+		{name: "Without labels", body: `{"id":"Alloc","type":"gauge","value":3}`, wantStatus: http.StatusOK},
+		{name: "Invalid label name", body: `{"id":"Alloc","type":"gauge","value":4,"labels":{"a=b":"c"}}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.HandleUpdateMetricJSON(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("HandleUpdateMetricJSON() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/value/", bytes.NewBufferString(`{"id":"Alloc","type":"gauge","labels":{"host":"a"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandleGetValueJSON(w, req)
+
+	var m storage.Metrics
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("HandleGetValueJSON() returned invalid JSON: %v", err)
+	}
+	if m.Value == nil || *m.Value != 1 || m.Labels["host"] != "a" {
+		t.Errorf("HandleGetValueJSON() = %+v, want Alloc{host=\"a\"} = 1", m)
+	}
+
+	if value, err := h.Storage.GetGaugeMetric("Alloc"); err != nil || value != 3 {
+		t.Errorf("GetGaugeMetric(Alloc) = %v, %v, want 3", value, err)
+	}
+	if value, err := h.Storage.GetGaugeMetric(`Alloc{host="b"}`); err != nil || value != 2 {
+		t.Errorf("GetGaugeMetric(Alloc{host=\"b\"}) = %v, %v, want 2", value, err)
+	}
+}
+
+// TestHandleGetAllMetrics проверяет, что gauge и counter с одним именем выводятся отдельными строками
+func TestHandleGetAllMetrics(t *testing.T) {
+	h := setupHandler()
+	h.Storage.SaveGaugeMetric("requests", 1.5)
+	h.Storage.SaveCounterMetric("requests", 7)
+
+	w := httptest.NewRecorder()
+	h.HandleGetAllMetrics(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleGetAllMetrics() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, row := range []string{
+		"<td>requests</td>\n\t\t\t\t\t<td>counter</td>\n\t\t\t\t\t<td>7</td>",
+		"<td>requests</td>\n\t\t\t\t\t<td>gauge</td>\n\t\t\t\t\t<td>1.5</td>",
+		"<td>gauge_test</td>\n\t\t\t\t\t<td>gauge</td>\n\t\t\t\t\t<td>42</td>",
+	} {
+		if !strings.Contains(body, row) {
+			t.Errorf("HandleGetAllMetrics() body does not contain row %q:\n%s", row, body)
+		}
+	}
+	if strings.Index(body, "<td>counter_test</td>") > strings.Index(body, "<td>gauge_test</td>") {
+		t.Errorf("HandleGetAllMetrics() rows are not sorted by name")
+	}
+}
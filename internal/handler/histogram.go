@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// ParseHistogramBuckets разбирает границы бакетов вида "0.1,0.5,1".
+// Границы должны быть конечными и строго возрастать.
+func ParseHistogramBuckets(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	bounds := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		b, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q", part)
+		}
+		bounds = append(bounds, b)
+	}
+	if err := storage.NewHistogramValue(bounds).Validate(); err != nil {
+		return nil, err
+	}
+	return bounds, nil
+}
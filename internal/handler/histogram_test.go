@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postHistogramJSON(t *testing.T, h *Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandleUpdateMetricJSON(w, r)
+	return w
+}
+
+func TestHandleUpdateMetricJSON_Histogram(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage, HistogramBuckets: []float64{1, 5}}
+
+	// Первое наблюдение создает гистограмму с границами HistogramBuckets
+	w := postHistogramJSON(t, h, `{"id":"latency","type":"histogram","value":0.5,"labels":{"host":"a"}}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var m storage.Metrics
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+	assert.Nil(t, m.Value)
+	assert.Equal(t, &storage.HistogramValue{Bounds: []float64{1, 5}, Counts: []int64{1, 1}, Sum: 0.5, Count: 1}, m.Histogram)
+
+	// Приращение гистограммы целиком
+	w = postHistogramJSON(t, h, `{"id":"latency","type":"histogram","labels":{"host":"a"},
+		"histogram":{"bounds":[1,5],"counts":[0,1],"sum":10.5,"count":2}}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	value, err := memStorage.GetHistogramMetric(`latency{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.HistogramValue{Bounds: []float64{1, 5}, Counts: []int64{1, 2}, Sum: 11, Count: 3}, value)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "No value", body: `{"id":"latency","type":"histogram"}`},
+		{name: "Bounds mismatch", body: `{"id":"latency","type":"histogram","labels":{"host":"a"},
+			"histogram":{"bounds":[2],"counts":[1],"sum":1,"count":1}}`},
+		{name: "Invalid histogram", body: `{"id":"other","type":"histogram",
+			"histogram":{"bounds":[2,1],"counts":[0,0],"sum":0,"count":0}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, postHistogramJSON(t, h, tt.body).Code)
+		})
+	}
+
+	// Получение значения через /value/
+	r := httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(`{"id":"latency","type":"histogram","labels":{"host":"a"}}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.HandleGetValueJSON(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	m = storage.Metrics{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+	assert.Equal(t, &value, m.Histogram)
+}
+
+func TestHandleUpdateMetric_Histogram(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	for _, v := range []string{"0.2", "7"} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/update/histogram/rtt/"+v, nil),
+			map[string]string{"type": Histogram, "name": "rtt", "value": v})
+		w := httptest.NewRecorder()
+		h.HandleUpdateMetric(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	value, err := memStorage.GetHistogramMetric("rtt")
+	require.NoError(t, err)
+	assert.Equal(t, storage.DefaultHistogramBuckets, value.Bounds)
+	assert.Equal(t, int64(2), value.Count)
+	assert.Equal(t, 7.2, value.Sum)
+
+	// NaN и бесконечности отклоняются и не портят сумму
+	for _, v := range []string{"NaN", "+Inf", "-Inf"} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/update/histogram/rtt/"+v, nil),
+			map[string]string{"type": Histogram, "name": "rtt", "value": v})
+		w := httptest.NewRecorder()
+		h.HandleUpdateMetric(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code, v)
+	}
+	resolver := h.newDistributionResolver()
+	nan := math.NaN()
+	assert.ErrorIs(t, resolver.resolve(&storage.Metrics{ID: "rtt", MType: Histogram, Value: &nan}), storage.ErrInvalidHistogram)
+	assert.ErrorIs(t, resolver.resolve(&storage.Metrics{ID: "latency", MType: Summary, Value: &nan}), storage.ErrInvalidSummary)
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/value/histogram/rtt", nil),
+		map[string]string{"type": Histogram, "name": "rtt"})
+	w := httptest.NewRecorder()
+	h.HandleGetValue(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, value.String(), w.Body.String())
+
+	// HTML-страница выводит гистограмму в кратком виде
+	w = httptest.NewRecorder()
+	h.HandleGetAllMetrics(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, w.Body.String(), "<td>count=2 sum=7.2 buckets=[")
+}
+
+func TestHandleUpdatesBatch_Histogram(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	// Наблюдение после явной гистограммы той же серии использует ее границы
+	body := `[
+		{"id":"size","type":"histogram","histogram":{"bounds":[10,100],"counts":[1,1],"sum":4,"count":1}},
+		{"id":"size","type":"histogram","value":50},
+		{"id":"hits","type":"counter","delta":1}
+	]`
+	r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.HandleUpdatesBatch(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	value, err := memStorage.GetHistogramMetric("size")
+	require.NoError(t, err)
+	assert.Equal(t, storage.HistogramValue{Bounds: []float64{10, 100}, Counts: []int64{1, 2}, Sum: 54, Count: 2}, value)
+
+	// Пакет с несовместимыми границами отклоняется целиком
+	body = `[
+		{"id":"hits","type":"counter","delta":1},
+		{"id":"size","type":"histogram","histogram":{"bounds":[1],"counts":[0],"sum":4,"count":1}}
+	]`
+	r = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(body))
+	w = httptest.NewRecorder()
+	h.HandleUpdatesBatch(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	hits, err := memStorage.GetCounterMetric("hits")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), hits)
+}
+
+func TestHandleGetMetricsPrometheus_Histogram(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	require.NoError(t, memStorage.SaveHistogramMetric(`req.duration{path="/a"}`,
+		storage.HistogramValue{Bounds: []float64{0.5, 1}, Counts: []int64{1, 3}, Sum: 2.25, Count: 4}))
+	h := &Handler{Storage: memStorage}
+
+	w := httptest.NewRecorder()
+	h.HandleGetMetricsPrometheus(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# TYPE req_duration histogram
+req_duration_bucket{le="0.5",path="/a"} 1
+req_duration_bucket{le="1",path="/a"} 3
+req_duration_bucket{le="+Inf",path="/a"} 4
+req_duration_sum{path="/a"} 2.25
+req_duration_count{path="/a"} 4
+`
+	assert.Equal(t, want, w.Body.String())
+}
+
+func TestParseHistogramBuckets(t *testing.T) {
+	bounds, err := ParseHistogramBuckets("0.1, 0.5,1")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 0.5, 1}, bounds)
+
+	_, err = ParseHistogramBuckets("1,x")
+	assert.Error(t, err)
+
+	_, err = ParseHistogramBuckets("1,0.5")
+	assert.ErrorIs(t, err, storage.ErrInvalidHistogram)
+}
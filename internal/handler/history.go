@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/gorilla/mux"
+)
+
+// HandleGetHistory обрабатывает GET-запросы на /history/{type}/{name} и возвращает JSON-массив
+// точек {"timestamp": ..., "value": ...} серии gauge или counter по возрастанию времени.
+// Параметры запроса from и to (RFC 3339 или секунды Unix) ограничивают интервал, обе границы включаются.
+// Для counter value - накопленное значение. Параметр resolution (raw, 1m или 1h) задает разрешение;
+// по умолчанию оно выбирается по длине интервала (без from - по длине всей истории серии), и для длинных
+// интервалов возвращаются агрегаты с полями min, max и avg для gauge и sum для counter.
+func (h *Handler) HandleGetHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	from, err := parseHistoryTime(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseHistoryTime(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := storage.ParseResolution(r.URL.Query().Get("resolution"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := h.Storage.GetHistory(vars["type"], vars["name"], from, to, res)
+	if errors.Is(err, storage.ErrHistoryType) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, storage.ErrMetricNotFound) {
+		http.Error(w, "Metric not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
+
+// parseHistoryTime разбирает границу интервала: RFC 3339 или целое число секунд Unix.
+// Пустая строка означает отсутствие границы.
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 time or Unix seconds, got %q", s)
+	}
+	return ts, nil
+}
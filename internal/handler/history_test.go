@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getHistory(h *Handler, metricType, name, query string) *httptest.ResponseRecorder {
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/history/"+metricType+"/"+name+query, nil),
+		map[string]string{"type": metricType, "name": name})
+	w := httptest.NewRecorder()
+	h.HandleGetHistory(w, r)
+	return w
+}
+
+// historyErrorStorage возвращает ошибку при чтении истории.
+type historyErrorStorage struct {
+	*storage.MemStorage
+}
+
+func (s *historyErrorStorage) GetHistory(metricType, name string, from, to time.Time, res storage.Resolution) ([]storage.HistoryPoint, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHandleGetHistory_StorageError(t *testing.T) {
+	h := &Handler{Storage: &historyErrorStorage{MemStorage: storage.NewMemStorage("")}}
+	assert.Equal(t, http.StatusInternalServerError, getHistory(h, Gauge, "HeapAlloc", "").Code)
+}
+
+func TestHandleGetHistory(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+	start := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		value := float64(i)
+		require.NoError(t, memStorage.UpdateMetricsBatch([]storage.Metrics{
+			{ID: "HeapAlloc", MType: Gauge, Value: &value, Timestamp: &ts},
+		}))
+	}
+
+	w := getHistory(h, Gauge, "HeapAlloc", "?from=2025-06-30T12:01:00Z&to="+
+		strconv.FormatInt(start.Add(time.Hour).Unix(), 10))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var points []storage.HistoryPoint
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&points))
+	assert.Equal(t, []storage.HistoryPoint{
+		{Timestamp: start.Add(time.Minute), Value: 1},
+		{Timestamp: start.Add(2 * time.Minute), Value: 2},
+	}, points)
+
+	assert.Equal(t, http.StatusNotFound, getHistory(h, Gauge, "Missing", "").Code)
+	assert.Equal(t, http.StatusBadRequest, getHistory(h, Histogram, "HeapAlloc", "").Code)
+	assert.Equal(t, http.StatusBadRequest, getHistory(h, Gauge, "HeapAlloc", "?from=yesterday").Code)
+	assert.Equal(t, http.StatusBadRequest, getHistory(h, Gauge, "HeapAlloc", "?resolution=5m").Code)
+
+	// Агрегаты за час после фоновой агрегации
+	require.NoError(t, memStorage.Rollup(start.Add(time.Hour)))
+	w = getHistory(h, Gauge, "HeapAlloc", "?resolution=1h")
+	require.Equal(t, http.StatusOK, w.Code)
+	var rollups []map[string]any
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rollups))
+	require.Len(t, rollups, 1)
+	assert.Equal(t, map[string]any{
+		"timestamp": "2025-06-30T12:00:00Z", "value": 2.0, "min": 0.0, "max": 2.0, "avg": 1.0, "count": 3.0,
+	}, rollups[0])
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/influx"
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// InfluxFieldSeparator соединяет имя измерения и имя поля в ID метрики,
+// например cpu + usage_idle -> cpu_usage_idle.
+const InfluxFieldSeparator = "_"
+
+// HandleInfluxWrite обрабатывает POST-запросы на /write в формате InfluxDB line protocol.
+// Теги точки становятся метками метрики.
+// Поля с плавающей точкой и логические поля сохраняются как gauge, целочисленные поля
+// (суффиксы i и u) - как counter. Целые значения считаются накопительными, как их отправляет
+// Telegraf, и переводятся в приращения по каждой серии (измерение, теги, поле); состояние серий
+// обновляется только после успешного сохранения.
+// Строковые поля пропускаются. Временная метка точки становится временем сэмпла,
+// ее точность задается параметром precision.
+func (h *Handler) HandleInfluxWrite(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeInfluxError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	points, err := influx.Parse(body, r.URL.Query().Get("precision"))
+	if err != nil {
+		var parseErr *influx.ParseError
+		if errors.As(err, &parseErr) || errors.Is(err, influx.ErrInvalidPrecision) {
+			writeInfluxError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeInfluxError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	state := h.influxState.begin()
+	defer state.rollback()
+
+	var metrics []storage.Metrics
+	for _, p := range points {
+		if err := storage.ValidateLabels(p.Tags); err != nil {
+			writeInfluxError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var ts *time.Time
+		if !p.Time.IsZero() {
+			ts = &p.Time
+		}
+		for _, f := range p.Fields {
+			id := p.Measurement + InfluxFieldSeparator + f.Key
+
+			switch v := f.Value.(type) {
+			case float64:
+				value := v
+				metrics = append(metrics, storage.Metrics{ID: id, MType: Gauge, Value: &value, Labels: p.Tags, Timestamp: ts})
+			case bool:
+				value := 0.0
+				if v {
+					value = 1
+				}
+				metrics = append(metrics, storage.Metrics{ID: id, MType: Gauge, Value: &value, Labels: p.Tags, Timestamp: ts})
+			case int64:
+				delta := state.delta(storage.SeriesKey(id, p.Tags), float64(v))
+				metrics = append(metrics, storage.Metrics{ID: id, MType: Counter, Delta: &delta, Labels: p.Tags, Timestamp: ts})
+			case uint64:
+				delta := state.delta(storage.SeriesKey(id, p.Tags), float64(v))
+				metrics = append(metrics, storage.Metrics{ID: id, MType: Counter, Delta: &delta, Labels: p.Tags, Timestamp: ts})
+			}
+		}
+	}
+
+	state.release()
+
+	if len(metrics) > 0 {
+		if err := h.Storage.UpdateMetricsBatch(metrics); err != nil {
+			writeInfluxError(w, http.StatusInternalServerError, "failed to update metrics")
+			return
+		}
+	}
+	state.commit()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeInfluxError возвращает ошибку в формате, который ожидают клиенты InfluxDB.
+func writeInfluxError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
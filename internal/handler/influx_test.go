@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleInfluxWrite(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	write := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/write?precision=s", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.HandleInfluxWrite(w, req)
+		return w
+	}
+
+	w := write("cpu,host=a usage_idle=92.5,up=true\n" +
+		"net,host=a bytes_recv=100i 1700000000\n" +
+		"net,host=b bytes_recv=50i 1700000000\n" +
+		"system uptime_format=\"1 day\"\n")
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	idle, err := memStorage.GetGaugeMetric(`cpu_usage_idle{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 92.5, idle)
+
+	up, err := memStorage.GetGaugeMetric(`cpu_up{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, up)
+
+	// Первое накопительное значение серии - точка отсчета
+	recv, err := memStorage.GetCounterMetric(`net_bytes_recv{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), recv)
+
+	recv, err = memStorage.GetCounterMetric(`net_bytes_recv{host="b"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), recv)
+
+	_, err = memStorage.GetGaugeMetric("system_uptime_format")
+	assert.Error(t, err)
+
+	// Накопительные значения каждой серии добавляют только приращение
+	w = write("net,host=a bytes_recv=130i 1700000010\n")
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	recv, err = memStorage.GetCounterMetric(`net_bytes_recv{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), recv)
+
+	// Временная метка точки становится временем сэмпла
+	points, err := memStorage.GetHistory(Counter, `net_bytes_recv{host="a"}`, time.Time{}, time.Time{}, storage.ResolutionRaw)
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), points[0].Timestamp)
+	assert.Equal(t, time.Unix(1700000010, 0).UTC(), points[1].Timestamp)
+}
+
+func TestHandleInfluxWrite_StorageError(t *testing.T) {
+	s := &failingStorage{MemStorage: storage.NewMemStorage(""), failOn: 2}
+	h := &Handler{Storage: s}
+
+	write := func(body string) int {
+		w := httptest.NewRecorder()
+		h.HandleInfluxWrite(w, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body)))
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNoContent, write("net,host=a bytes_recv=100i\n"))
+	// Состояние серии обновляется только после успешного сохранения
+	require.Equal(t, http.StatusInternalServerError, write("net,host=a bytes_recv=130i\n"))
+	require.Equal(t, http.StatusNoContent, write("net,host=a bytes_recv=130i\n"))
+
+	recv, err := s.GetCounterMetric(`net_bytes_recv{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), recv)
+}
+
+func TestHandleInfluxWrite_Invalid(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	req := httptest.NewRequest(http.MethodPost, "/write", strings.NewReader("cpu value=1\nmem"))
+	w := httptest.NewRecorder()
+	h.HandleInfluxWrite(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "line 2")
+
+	// При ошибке разбора ни одна точка не сохраняется
+	_, err := memStorage.GetGaugeMetric("cpu_value")
+	assert.Error(t, err)
+
+	req = httptest.NewRequest(http.MethodPost, "/write?precision=days", strings.NewReader("cpu value=1"))
+	w = httptest.NewRecorder()
+	h.HandleInfluxWrite(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+const (
+	// defaultListLimit и maxListLimit - размер страницы /api/v1/metrics по умолчанию и наибольший.
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// HandleListMetrics обрабатывает GET-запросы на /api/v1/metrics и возвращает страницу gauge и counter в JSON:
+// {"metrics": [{"id", "type", "labels", "value" или "delta", "updated_at"}], "next_cursor": "..."}.
+// Параметры запроса: type (gauge или counter), prefix, glob (синтаксис path.Match) и regex фильтруют
+// по имени метрики; sort - name, -name, updated_at или -updated_at; limit - размер страницы
+// (по умолчанию 100, не больше 1000); cursor - next_cursor предыдущей страницы.
+func (h *Handler) HandleListMetrics(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := storage.ListOptions{
+		Type:   q.Get("type"),
+		Prefix: q.Get("prefix"),
+		Glob:   q.Get("glob"),
+		Regex:  q.Get("regex"),
+		Cursor: q.Get("cursor"),
+		Limit:  defaultListLimit,
+	}
+
+	if opts.Type != "" && opts.Type != Gauge && opts.Type != Counter {
+		http.Error(w, "Invalid metric type: only gauge and counter are listed", http.StatusBadRequest)
+		return
+	}
+	sort, err := storage.ParseListSort(q.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Sort = sort
+	if _, err := path.Match(opts.Glob, ""); err != nil {
+		http.Error(w, "Invalid glob", http.StatusBadRequest)
+		return
+	}
+	if _, err := regexp.Compile(opts.Regex); err != nil {
+		http.Error(w, "Invalid regex: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxListLimit), http.StatusBadRequest)
+			return
+		}
+		opts.Limit = limit
+	}
+
+	page, err := h.Storage.ListMetrics(opts)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to list metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
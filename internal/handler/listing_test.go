@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listMetrics(h *Handler, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/metrics"+query, nil)
+	w := httptest.NewRecorder()
+	h.HandleListMetrics(w, r)
+	return w
+}
+
+func TestHandleListMetrics(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+	for _, name := range []string{"CPUutilization1", "CPUutilization2", "CPUutilization3", "HeapAlloc"} {
+		require.NoError(t, memStorage.SaveGaugeMetric(name, 1))
+	}
+	require.NoError(t, memStorage.SaveCounterMetric("PollCount", 5))
+
+	var page storage.MetricPage
+	w := listMetrics(h, "?glob=CPU*&limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	require.Len(t, page.Metrics, 2)
+	assert.Equal(t, "CPUutilization1", page.Metrics[0].ID)
+	assert.Equal(t, Gauge, page.Metrics[0].Type)
+	assert.NotNil(t, page.Metrics[0].UpdatedAt)
+	require.NotEmpty(t, page.NextCursor)
+
+	w = listMetrics(h, "?glob=CPU*&limit=2&cursor="+page.NextCursor)
+	require.Equal(t, http.StatusOK, w.Code)
+	page = storage.MetricPage{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, "CPUutilization3", page.Metrics[0].ID)
+	assert.Empty(t, page.NextCursor)
+
+	w = listMetrics(h, "?type=counter&sort=-name")
+	require.Equal(t, http.StatusOK, w.Code)
+	var raw map[string][]map[string]any
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&raw))
+	require.Len(t, raw["metrics"], 1)
+	assert.Equal(t, "PollCount", raw["metrics"][0]["id"])
+	assert.Equal(t, 5.0, raw["metrics"][0]["delta"])
+	assert.NotContains(t, raw["metrics"][0], "value")
+	assert.Contains(t, raw["metrics"][0], "updated_at")
+
+	for _, query := range []string{
+		"?type=histogram",
+		"?sort=value",
+		"?glob=[a-",
+		"?regex=(",
+		"?limit=0",
+		"?limit=1001",
+		"?limit=ten",
+		"?cursor=garbage",
+	} {
+		assert.Equal(t, http.StatusBadRequest, listMetrics(h, query).Code, query)
+	}
+}
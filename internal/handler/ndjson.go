@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+const (
+	// NDJSONContentType - тип содержимого потока метрик, по одному объекту storage.Metrics на строку.
+	NDJSONContentType = "application/x-ndjson"
+	// NDJSONChunkSize - число метрик, которое накапливается перед записью в хранилище.
+	NDJSONChunkSize = 1000
+	// NDJSONMaxLineSize - максимальная длина одной строки потока.
+	NDJSONMaxLineSize = 64 * 1024
+	// NDJSONMaxErrors - сколько ошибок по строкам возвращается в ответе; остальные только подсчитываются.
+	NDJSONMaxErrors = 100
+)
+
+// LineError описывает ошибку в отдельной строке потока.
+type LineError struct {
+	Line  int    `json:"line"`  // номер строки, начиная с 1
+	Error string `json:"error"` // описание ошибки
+}
+
+// StreamResult - ответ на загрузку потока метрик.
+type StreamResult struct {
+	Accepted int         `json:"accepted"`         // число сохраненных метрик
+	Rejected int         `json:"rejected"`         // число отклоненных строк
+	Errors   []LineError `json:"errors,omitempty"` // первые NDJSONMaxErrors ошибок
+	Error    string      `json:"error,omitempty"`  // ошибка, прервавшая обработку потока
+}
+
+// addError учитывает отклоненную строку.
+func (res *StreamResult) addError(line int, err string) {
+	res.Rejected++
+	if len(res.Errors) < NDJSONMaxErrors {
+		res.Errors = append(res.Errors, LineError{Line: line, Error: err})
+	}
+}
+
+// HandleUpdatesStream обрабатывает POST-запросы на /updates/stream/ с телом в формате NDJSON.
+// В отличие от HandleUpdatesBatch, тело читается построчно, а метрики записываются в хранилище
+// порциями по NDJSONChunkSize, поэтому память не зависит от размера запроса.
+// Некорректные строки, а также строки, которые отклонило хранилище (запоздавший сэмпл
+// или несовместимая гистограмма, скетч или set), пропускаются и перечисляются в ответе,
+// остальные метрики сохраняются.
+// Если запись порции в хранилище не удалась по другой причине, обработка прерывается: метрики предыдущих порций
+// остаются сохраненными, что отражено в поле accepted. Поэтому поток не принимает подписанные
+// (HashSHA256) и зашифрованные тела: их проверка требует прочитать тело целиком до записи.
+func (h *Handler) HandleUpdatesStream(w http.ResponseWriter, r *http.Request) {
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), NDJSONMaxLineSize)
+
+	var (
+		res           StreamResult
+		chunk         = make([]storage.Metrics, 0, NDJSONChunkSize)
+		chunkLines    = make([]int, 0, NDJSONChunkSize)
+		line          int
+		distributions = h.newDistributionResolver()
+	)
+
+	// save записывает метрики строк lines. Если хранилище отклоняет порцию из-за отдельных метрик
+	// (запоздавший сэмпл или несовместимые гистограмма, скетч или set), порция делится пополам,
+	// пока отклоненные строки не будут найдены: они попадают в ошибки, остальные сохраняются.
+	// Возвращает false при другой ошибке хранилища.
+	var save func(metrics []storage.Metrics, lines []int) bool
+	save = func(metrics []storage.Metrics, lines []int) bool {
+		err := h.Storage.UpdateMetricsBatch(metrics)
+		switch {
+		case err == nil:
+			res.Accepted += len(metrics)
+			return true
+		case !isRejectedMetric(err):
+			res.Error = fmt.Sprintf("failed to update metrics up to line %d", line)
+			return false
+		case len(metrics) == 1:
+			res.addError(lines[0], err.Error())
+			return true
+		}
+		half := len(metrics) / 2
+		return save(metrics[:half], lines[:half]) && save(metrics[half:], lines[half:])
+	}
+
+	flush := func() bool {
+		if len(chunk) == 0 {
+			return true
+		}
+		if !save(chunk, chunkLines) {
+			return false
+		}
+		chunk = chunk[:0]
+		chunkLines = chunkLines[:0]
+		return true
+	}
+
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var m storage.Metrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			res.addError(line, "invalid JSON")
+			continue
+		}
+		if err := validateMetric(m); err != nil {
+			res.addError(line, err.Error())
+			continue
+		}
+		if err := distributions.resolve(&m); err != nil {
+			res.addError(line, err.Error())
+			continue
+		}
+
+		chunk = append(chunk, m)
+		chunkLines = append(chunkLines, line)
+		if len(chunk) == NDJSONChunkSize && !flush() {
+			writeStreamResult(w, http.StatusInternalServerError, &res)
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		// Остаток потока прочитать нельзя, но уже разобранные строки сохраняются
+		if errors.Is(err, bufio.ErrTooLong) {
+			res.Error = fmt.Sprintf("line %d exceeds %d bytes", line+1, NDJSONMaxLineSize)
+		} else {
+			res.Error = "failed to read request body"
+		}
+		if !flush() {
+			writeStreamResult(w, http.StatusInternalServerError, &res)
+			return
+		}
+		writeStreamResult(w, http.StatusBadRequest, &res)
+		return
+	}
+
+	if !flush() {
+		writeStreamResult(w, http.StatusInternalServerError, &res)
+		return
+	}
+	writeStreamResult(w, http.StatusOK, &res)
+}
+
+// isRejectedMetric проверяет, что хранилище отклонило пакет из-за отдельной метрики, а не из-за сбоя:
+// такие ошибки HandleUpdatesBatch возвращает с кодами 409 и 400.
+func isRejectedMetric(err error) bool {
+	return errors.Is(err, storage.ErrOutOfOrder) || isDistributionError(err)
+}
+
+// validateMetric проверяет, что у метрики заданы имя, тип и значение для этого типа, а метки корректны.
+func validateMetric(m storage.Metrics) error {
+	if m.ID == "" {
+		return errors.New("id is required")
+	}
+	switch m.MType {
+	case Gauge:
+		if m.Value == nil {
+			return errors.New("value is required for gauge")
+		}
+	case Counter:
+		if m.Delta == nil {
+			return errors.New("delta is required for counter")
+		}
+	case Histogram:
+		if m.Value == nil && m.Histogram == nil {
+			return errHistogramValueRequired
+		}
+	case Summary:
+		if m.Value == nil && m.Summary == nil {
+			return errSummaryValueRequired
+		}
+	case Set:
+		if len(m.Members) == 0 && m.Set == nil {
+			return errSetMembersRequired
+		}
+	default:
+		return fmt.Errorf("invalid metric type %q", m.MType)
+	}
+	return storage.ValidateLabels(m.Labels)
+}
+
+func writeStreamResult(w http.ResponseWriter, status int, res *StreamResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
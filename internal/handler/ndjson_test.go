@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStorage возвращает ошибку на заданном по счету вызове UpdateMetricsBatch
+type failingStorage struct {
+	*storage.MemStorage
+	failOn int
+	calls  int
+}
+
+func (s *failingStorage) UpdateMetricsBatch(metrics []storage.Metrics) error {
+	s.calls++
+	if s.calls == s.failOn {
+		return errors.New("storage unavailable")
+	}
+	return s.MemStorage.UpdateMetricsBatch(metrics)
+}
+
+func postStream(t *testing.T, h *Handler, body string) (int, StreamResult) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/updates/stream/", strings.NewReader(body))
+	r.Header.Set("Content-Type", NDJSONContentType)
+	w := httptest.NewRecorder()
+	h.HandleUpdatesStream(w, r)
+
+	var res StreamResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	return w.Code, res
+}
+
+func TestHandleUpdatesStream(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	body := strings.Join([]string{
+		`{"id":"Alloc","type":"gauge","value":1.5}`,
+		`{"id":"PollCount","type":"counter","delta":2}`,
+		``,
+		`{"id":"PollCount","type":"counter","delta":3}`,
+		`not json`,
+		`{"id":"NoValue","type":"gauge"}`,
+		`{"id":"Bad","type":"meter","value":1}`,
+	}, "\n")
+
+	code, res := postStream(t, h, body)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, res.Accepted)
+	assert.Equal(t, 3, res.Rejected)
+	assert.Equal(t, []LineError{
+		{Line: 5, Error: "invalid JSON"},
+		{Line: 6, Error: "value is required for gauge"},
+		{Line: 7, Error: `invalid metric type "meter"`},
+	}, res.Errors)
+
+	alloc, err := memStorage.GetGaugeMetric("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, alloc)
+
+	pollCount, err := memStorage.GetCounterMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), pollCount)
+}
+
+func TestHandleUpdatesStream_Chunks(t *testing.T) {
+	s := &failingStorage{MemStorage: storage.NewMemStorage(""), failOn: 3}
+	h := &Handler{Storage: s}
+
+	var b strings.Builder
+	for i := 0; i < NDJSONChunkSize*3+10; i++ {
+		fmt.Fprintf(&b, `{"id":"m%d","type":"counter","delta":1}`+"\n", i)
+	}
+
+	code, res := postStream(t, h, b.String())
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, NDJSONChunkSize*2, res.Accepted)
+	assert.Equal(t, fmt.Sprintf("failed to update metrics up to line %d", NDJSONChunkSize*3), res.Error)
+	assert.Equal(t, 3, s.calls)
+}
+
+func TestHandleUpdatesStream_RejectedByStorage(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	memStorage.OutOfOrder = storage.OutOfOrderReject
+	h := &Handler{Storage: memStorage}
+	ts := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	value := 10.0
+	require.NoError(t, memStorage.UpdateMetricsBatch([]storage.Metrics{{ID: "Alloc", MType: Gauge, Value: &value, Timestamp: &ts}}))
+
+	// Запоздавший gauge отклоняется отдельной строкой, остальные строки порции сохраняются
+	body := strings.Join([]string{
+		`{"id":"PollCount","type":"counter","delta":1}`,
+		`{"id":"Alloc","type":"gauge","value":1,"timestamp":"2025-07-01T11:59:00Z"}`,
+		`{"id":"PollCount","type":"counter","delta":2}`,
+		`{"id":"Alloc","type":"gauge","value":2,"timestamp":"2025-07-01T12:01:00Z"}`,
+	}, "\n")
+
+	code, res := postStream(t, h, body)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, res.Accepted)
+	assert.Equal(t, 1, res.Rejected)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, 2, res.Errors[0].Line)
+	assert.Contains(t, res.Errors[0].Error, storage.ErrOutOfOrder.Error())
+
+	pollCount, err := memStorage.GetCounterMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), pollCount)
+	alloc, err := memStorage.GetGaugeMetric("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, alloc)
+}
+
+func TestHandleUpdatesStream_LineTooLong(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	body := `{"id":"Alloc","type":"gauge","value":1}` + "\n" + strings.Repeat("x", NDJSONMaxLineSize+1)
+
+	code, res := postStream(t, h, body)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, 1, res.Accepted)
+	assert.Contains(t, res.Error, "line 2 exceeds")
+
+	_, err := memStorage.GetGaugeMetric("Alloc")
+	assert.NoError(t, err)
+}
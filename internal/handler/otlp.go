@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// OTLPProtobufContentType - тип содержимого OTLP/HTTP в кодировке protobuf.
+	OTLPProtobufContentType = "application/x-protobuf"
+	// OTLPJSONContentType - тип содержимого OTLP/HTTP в кодировке JSON.
+	OTLPJSONContentType = "application/json"
+)
+
+// HandleOTLPMetrics обрабатывает POST-запросы OTLP/HTTP на /v1/metrics.
+// Кодировка запроса (protobuf или JSON) определяется по Content-Type, ответ возвращается в той же кодировке.
+//
+// Атрибуты ресурса и точки данных становятся метками метрики, time_unix_nano точки - временем сэмпла.
+//
+// Преобразование точек данных:
+//   - Gauge сохраняется как gauge;
+//   - монотонный Sum сохраняется как counter: при DELTA значение точки является приращением,
+//     при CUMULATIVE приращение вычисляется относительно предыдущей точки той же серии
+//     (см. cumulativeBatch.delta), а состояние серий обновляется после успешного сохранения;
+//   - немонотонный Sum (UpDownCounter) сохраняется как gauge: CUMULATIVE заменяет значение,
+//     DELTA атомарно добавляется к текущему в UpdateMetricsBatch.
+//
+// Остальные типы (Histogram, ExponentialHistogram, Summary) не поддерживаются,
+// и их точки возвращаются клиенту как отклоненные в partial_success.
+func (h *Handler) HandleOTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != OTLPProtobufContentType && mediaType != OTLPJSONContentType) {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var req colmetricspb.ExportMetricsServiceRequest
+	if mediaType == OTLPJSONContentType {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &req)
+	} else {
+		err = proto.Unmarshal(body, &req)
+	}
+	if err != nil {
+		http.Error(w, "Invalid OTLP request body", http.StatusBadRequest)
+		return
+	}
+
+	state := h.otlpState.begin()
+	defer state.rollback()
+
+	metrics, rejected := convertOTLP(&req, state)
+
+	state.release()
+
+	if len(metrics) > 0 {
+		if err := h.Storage.UpdateMetricsBatch(metrics); err != nil {
+			http.Error(w, "Failed to update metrics", http.StatusInternalServerError)
+			return
+		}
+	}
+	state.commit()
+
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       "only Gauge and Sum metrics are supported",
+		}
+	}
+
+	var out []byte
+	if mediaType == OTLPJSONContentType {
+		out, err = protojson.Marshal(resp)
+	} else {
+		out, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// convertOTLP переводит точки данных запроса в пакет метрик для UpdateMetricsBatch;
+// приращения CUMULATIVE-сумм вычисляются в state. Возвращает также число отклоненных точек
+// неподдерживаемых типов.
+func convertOTLP(req *colmetricspb.ExportMetricsServiceRequest, state *cumulativeBatch) ([]storage.Metrics, int64) {
+	var (
+		metrics  []storage.Metrics
+		rejected int64
+	)
+
+	for _, rm := range req.GetResourceMetrics() {
+		resourceAttrs := rm.GetResource().GetAttributes()
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				name := m.GetName()
+				if name == "" {
+					continue
+				}
+
+				switch data := m.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					for _, dp := range data.Gauge.GetDataPoints() {
+						if !hasRecordedValue(dp) {
+							continue
+						}
+						value := numberValue(dp)
+						labels := otlpLabels(resourceAttrs, dp.GetAttributes())
+						metrics = append(metrics, storage.Metrics{ID: name, MType: Gauge, Value: &value, Labels: labels, Timestamp: otlpTime(dp)})
+					}
+
+				case *metricspb.Metric_Sum:
+					sum := data.Sum
+					delta := sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+					for _, dp := range sum.GetDataPoints() {
+						if !hasRecordedValue(dp) {
+							continue
+						}
+						value := numberValue(dp)
+						labels := otlpLabels(resourceAttrs, dp.GetAttributes())
+
+						if !sum.GetIsMonotonic() {
+							if delta {
+								metrics = append(metrics, storage.Metrics{ID: name, MType: Gauge, GaugeDelta: &value, Labels: labels, Timestamp: otlpTime(dp)})
+							} else {
+								metrics = append(metrics, storage.Metrics{ID: name, MType: Gauge, Value: &value, Labels: labels, Timestamp: otlpTime(dp)})
+							}
+							continue
+						}
+
+						if math.IsNaN(value) || math.IsInf(value, 0) {
+							continue
+						}
+						var counterValue int64
+						if delta {
+							counterValue = int64(math.Round(value))
+						} else {
+							counterValue = state.delta(storage.SeriesKey(name, labels), value)
+						}
+						metrics = append(metrics, storage.Metrics{ID: name, MType: Counter, Delta: &counterValue, Labels: labels, Timestamp: otlpTime(dp)})
+					}
+
+				case *metricspb.Metric_Histogram:
+					rejected += int64(len(data.Histogram.GetDataPoints()))
+				case *metricspb.Metric_ExponentialHistogram:
+					rejected += int64(len(data.ExponentialHistogram.GetDataPoints()))
+				case *metricspb.Metric_Summary:
+					rejected += int64(len(data.Summary.GetDataPoints()))
+				}
+			}
+		}
+	}
+
+	return metrics, rejected
+}
+
+// hasRecordedValue проверяет, что точка не помечена флагом NO_RECORDED_VALUE.
+func hasRecordedValue(dp *metricspb.NumberDataPoint) bool {
+	return dp.GetFlags()&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) == 0
+}
+
+// otlpTime возвращает время точки или nil, если time_unix_nano не задано.
+func otlpTime(dp *metricspb.NumberDataPoint) *time.Time {
+	if dp.GetTimeUnixNano() == 0 {
+		return nil
+	}
+	ts := time.Unix(0, int64(dp.GetTimeUnixNano())).UTC()
+	return &ts
+}
+
+// numberValue возвращает значение точки как число с плавающей точкой.
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+// otlpLabels объединяет атрибуты ресурса и точки в метки метрики; атрибуты точки имеют приоритет.
+// Атрибуты, имена которых недопустимы для меток, пропускаются.
+func otlpLabels(resource, point []*commonpb.KeyValue) map[string]string {
+	if len(resource)+len(point) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(resource)+len(point))
+	for _, attrs := range [][]*commonpb.KeyValue{resource, point} {
+		for _, kv := range attrs {
+			if storage.ValidateLabels(map[string]string{kv.GetKey(): ""}) != nil {
+				continue
+			}
+			labels[kv.GetKey()] = otlpAttributeValue(kv.GetValue())
+		}
+	}
+	return labels
+}
+
+// otlpAttributeValue возвращает строковое представление значения атрибута.
+func otlpAttributeValue(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case nil:
+		return ""
+	default:
+		data, _ := protojson.Marshal(v)
+		return string(data)
+	}
+}
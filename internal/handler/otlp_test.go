@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpRequest(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+func otlpSum(name string, monotonic bool, temporality metricspb.AggregationTemporality, values ...int64) *metricspb.Metric {
+	sum := &metricspb.Sum{IsMonotonic: monotonic, AggregationTemporality: temporality}
+	for _, v := range values {
+		sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+			Attributes: []*commonpb.KeyValue{{
+				Key:   "host",
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "a"}},
+			}},
+			Value: &metricspb.NumberDataPoint_AsInt{AsInt: v},
+		})
+	}
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: sum}}
+}
+
+func postOTLP(t *testing.T, h *Handler, req *colmetricspb.ExportMetricsServiceRequest) *colmetricspb.ExportMetricsServiceResponse {
+	t.Helper()
+
+	body, err := proto.Marshal(req)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+	r.Header.Set("Content-Type", OTLPProtobufContentType)
+	w := httptest.NewRecorder()
+	h.HandleOTLPMetrics(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, OTLPProtobufContentType, w.Header().Get("Content-Type"))
+
+	var resp colmetricspb.ExportMetricsServiceResponse
+	require.NoError(t, proto.Unmarshal(w.Body.Bytes(), &resp))
+	return &resp
+}
+
+func TestHandleOTLPMetrics_Protobuf(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+	resp := postOTLP(t, h, otlpRequest(
+		&metricspb.Metric{
+			Name: "process.memory.usage",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{
+					{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 512.5}},
+				},
+			}},
+		},
+		otlpSum("requests.cumulative", true, cumulative, 10),
+		otlpSum("requests.delta", true, delta, 4),
+		otlpSum("queue.size", false, cumulative, 7),
+		otlpSum("connections", false, delta, 3),
+		&metricspb.Metric{
+			Name: "latency",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				DataPoints: []*metricspb.HistogramDataPoint{{Count: 1}},
+			}},
+		},
+	))
+	assert.Equal(t, int64(1), resp.GetPartialSuccess().GetRejectedDataPoints())
+
+	resp = postOTLP(t, h, otlpRequest(
+		otlpSum("requests.cumulative", true, cumulative, 25),
+		otlpSum("requests.delta", true, delta, 6),
+		otlpSum("queue.size", false, cumulative, 2),
+		otlpSum("connections", false, delta, -1),
+	))
+	assert.Nil(t, resp.GetPartialSuccess())
+
+	memory, err := memStorage.GetGaugeMetric("process.memory.usage")
+	require.NoError(t, err)
+	assert.Equal(t, 512.5, memory)
+
+	// Первая CUMULATIVE-точка - точка отсчета
+	cumulativeCounter, err := memStorage.GetCounterMetric(`requests.cumulative{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), cumulativeCounter)
+
+	deltaCounter, err := memStorage.GetCounterMetric(`requests.delta{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), deltaCounter)
+
+	queue, err := memStorage.GetGaugeMetric(`queue.size{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, queue)
+
+	connections, err := memStorage.GetGaugeMetric(`connections{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, connections)
+}
+
+func TestHandleOTLPMetrics_ConcurrentUpDown(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+	// DELTA-точки UpDownCounter применяются атомарно в хранилище и не теряются при одновременных запросах
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			postOTLP(t, h, otlpRequest(otlpSum("connections", false, delta, 1)))
+		}()
+	}
+	wg.Wait()
+
+	connections, err := memStorage.GetGaugeMetric(`connections{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, connections)
+}
+
+func TestHandleOTLPMetrics_JSON(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	body := `{
+		"resourceMetrics": [{
+			"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+			"scopeMetrics": [{
+				"scope": {"name": "meter"},
+				"metrics": [
+					{"name": "cpu.load", "gauge": {"dataPoints": [{"asDouble": 0.75, "timeUnixNano": "1700000000000000000"}]}},
+					{"name": "jobs.done", "sum": {"aggregationTemporality": 2, "isMonotonic": true,
+						"dataPoints": [{"asInt": "12"}]}}
+				]
+			}]
+		}]
+	}`
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w := httptest.NewRecorder()
+	h.HandleOTLPMetrics(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, OTLPJSONContentType, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{}`, w.Body.String())
+
+	load, err := memStorage.GetGaugeMetric(`cpu.load{service.name="api"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 0.75, load)
+
+	// time_unix_nano точки становится временем сэмпла
+	points, err := memStorage.GetHistory(Gauge, `cpu.load{service.name="api"}`, time.Time{}, time.Time{}, storage.ResolutionRaw)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), points[0].Timestamp)
+
+	jobs, err := memStorage.GetCounterMetric(`jobs.done{service.name="api"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), jobs)
+}
+
+func TestHandleOTLPMetrics_Invalid(t *testing.T) {
+	h := &Handler{Storage: storage.NewMemStorage("")}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "Unsupported content type", contentType: "text/plain", body: "x", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "Invalid JSON", contentType: OTLPJSONContentType, body: "{", wantStatus: http.StatusBadRequest},
+		{name: "Invalid protobuf", contentType: OTLPProtobufContentType, body: "\x0a\xff", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.HandleOTLPMetrics(w, r)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
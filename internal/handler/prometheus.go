@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// PrometheusContentType - тип содержимого текстового формата экспозиции Prometheus.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// HandleGetMetricsPrometheus обрабатывает GET-запросы на /metrics.
+// Возвращает все метрики хранилища в текстовом формате Prometheus:
+// для каждого имени выводится строка "# TYPE" с типом gauge, counter, histogram или summary
+// (set выводится как gauge с оценкой числа уникальных элементов),
+// затем строки со значениями всех серий этого имени с их метками.
+// Гистограмма выводится строками _bucket (с меткой le), _sum и _count,
+// summary - строками квантилей DefaultSummaryQuantiles (с меткой quantile), _sum и _count.
+//
+// Разные метрики могут совпасть после приведения имени к формату Prometheus (a.b и a_b, gauge и set
+// или gauge и counter с одним именем). Каждое имя выводится одним семейством: в нем остаются метрики
+// первого типа в порядке gauge, counter, histogram, summary, set, а из серий с одинаковыми метками -
+// метрика с наименьшим исходным именем. Остальные пропускаются с записью в лог.
+func (h *Handler) HandleGetMetricsPrometheus(w http.ResponseWriter, r *http.Request) {
+	all, err := h.Storage.ListAllMetrics()
+	if err != nil {
+		http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
+		return
+	}
+
+	type series struct {
+		name       string // имя после приведения к формату Prometheus
+		labels     string // метки в формате {k="v",...}
+		metricType string // тип в формате Prometheus
+		source     string // тип метрики в хранилище
+		key        string // исходный ключ серии
+		value      string
+		histogram  *storage.HistogramValue
+		summary    *storage.SummaryValue
+		rawLabels  map[string]string
+	}
+
+	list := make([]series, 0, all.Len())
+	add := func(key string, s series) {
+		name, labels := storage.ParseSeriesKey(key)
+		s.key = key
+		s.rawLabels = labels
+		s.name = sanitizePrometheusName(name)
+		s.labels = formatPrometheusLabels(labels)
+		list = append(list, s)
+	}
+	for key, v := range all.Gauges {
+		add(key, series{metricType: Gauge, source: Gauge, value: formatPrometheusFloat(v)})
+	}
+	for key, v := range all.Counters {
+		add(key, series{metricType: Counter, source: Counter, value: strconv.FormatInt(v, 10)})
+	}
+	for key, v := range all.Histograms {
+		add(key, series{metricType: Histogram, source: Histogram, histogram: &v})
+	}
+	for key, v := range all.Summaries {
+		add(key, series{metricType: Summary, source: Summary, summary: &v})
+	}
+	for key, v := range all.Sets {
+		add(key, series{metricType: Gauge, source: Set, value: strconv.FormatInt(v.Estimate(), 10)})
+	}
+	// Порядок типов, в котором они получают имя при совпадении
+	rank := func(source string) int { return slices.Index(metricTypes, source) }
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+		if list[i].source != list[j].source {
+			return rank(list[i].source) < rank(list[j].source)
+		}
+		if list[i].labels != list[j].labels {
+			return list[i].labels < list[j].labels
+		}
+		return list[i].key < list[j].key
+	})
+
+	w.Header().Set("Content-Type", PrometheusContentType)
+
+	bw := bufio.NewWriter(w)
+	var prev *series // последняя выведенная серия
+	for i := range list {
+		s := &list[i]
+		switch {
+		case prev == nil || prev.name != s.name:
+			bw.WriteString("# TYPE " + s.name + " " + s.metricType + "\n")
+		case prev.source != s.source:
+			log.Printf("Prometheus export: skipping %s %s: name %s is already used by %s", s.source, s.key, s.name, prev.source)
+			continue
+		case prev.labels == s.labels:
+			log.Printf("Prometheus export: skipping %s %s: duplicates series %s", s.source, s.key, prev.key)
+			continue
+		}
+		prev = s
+		if s.histogram != nil {
+			writePrometheusHistogram(bw, s.name, s.rawLabels, s.labels, *s.histogram)
+			continue
+		}
+		if s.summary != nil {
+			writePrometheusSummary(bw, s.name, s.rawLabels, s.labels, *s.summary)
+			continue
+		}
+		bw.WriteString(s.name + s.labels + " " + s.value + "\n")
+	}
+	bw.Flush()
+}
+
+// writePrometheusHistogram выводит строки _bucket, _sum и _count одной серии гистограммы.
+func writePrometheusHistogram(bw *bufio.Writer, name string, labels map[string]string, formatted string, h storage.HistogramValue) {
+	bucketLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		bucketLabels[k] = v
+	}
+	writeBucket := func(le string, count int64) {
+		bucketLabels["le"] = le
+		bw.WriteString(name + "_bucket" + formatPrometheusLabels(bucketLabels) + " " + strconv.FormatInt(count, 10) + "\n")
+	}
+	for i, bound := range h.Bounds {
+		writeBucket(formatPrometheusFloat(bound), h.Counts[i])
+	}
+	writeBucket("+Inf", h.Count)
+
+	bw.WriteString(name + "_sum" + formatted + " " + formatPrometheusFloat(h.Sum) + "\n")
+	bw.WriteString(name + "_count" + formatted + " " + strconv.FormatInt(h.Count, 10) + "\n")
+}
+
+// writePrometheusSummary выводит строки квантилей, _sum и _count одной серии summary.
+// Для пустого скетча строки квантилей не выводятся.
+func writePrometheusSummary(bw *bufio.Writer, name string, labels map[string]string, formatted string, s storage.SummaryValue) {
+	if s.Count > 0 {
+		quantileLabels := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			quantileLabels[k] = v
+		}
+		for _, q := range storage.DefaultSummaryQuantiles {
+			quantileLabels["quantile"] = formatPrometheusFloat(q)
+			bw.WriteString(name + formatPrometheusLabels(quantileLabels) + " " + formatPrometheusFloat(s.Quantile(q)) + "\n")
+		}
+	}
+
+	bw.WriteString(name + "_sum" + formatted + " " + formatPrometheusFloat(s.Sum) + "\n")
+	bw.WriteString(name + "_count" + formatted + " " + strconv.FormatInt(s.Count, 10) + "\n")
+}
+
+// formatPrometheusLabels выводит метки в порядке имен; имена приводятся к формату Prometheus.
+func formatPrometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	promLabels := make(map[string]string, len(labels))
+	for k, v := range labels {
+		k = sanitizePrometheusName(k)
+		if k == "__name__" {
+			continue
+		}
+		promLabels[strings.ReplaceAll(k, ":", "_")] = v
+	}
+	return storage.SeriesKey("", promLabels)
+}
+
+// sanitizePrometheusName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*,
+// заменяя недопустимые символы на подчеркивание.
+func sanitizePrometheusName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, c := range name {
+		switch {
+		case c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// formatPrometheusFloat форматирует число с плавающей точкой так, как этого ожидает Prometheus.
+func formatPrometheusFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
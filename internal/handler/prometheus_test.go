@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleGetMetricsPrometheus(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	memStorage.SaveGaugeMetric("Alloc", 1.5)
+	memStorage.SaveGaugeMetric("CPU.utilization", 0.25)
+	memStorage.SaveCounterMetric("PollCount", 7)
+
+	h := &Handler{Storage: memStorage}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	h.HandleGetMetricsPrometheus(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, PrometheusContentType, w.Header().Get("Content-Type"))
+
+	expected := "# TYPE Alloc gauge\n" +
+		"Alloc 1.5\n" +
+		"# TYPE CPU_utilization gauge\n" +
+		"CPU_utilization 0.25\n" +
+		"# TYPE PollCount counter\n" +
+		"PollCount 7\n"
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestHandleGetMetricsPrometheus_Labels(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	memStorage.SaveGaugeMetric(`Alloc{host="b"}`, 2)
+	memStorage.SaveGaugeMetric(`Alloc{host="a"}`, 1)
+	memStorage.SaveGaugeMetric(`cpu{service.name="api",path="a\"b"}`, 0.5)
+
+	h := &Handler{Storage: memStorage}
+
+	w := httptest.NewRecorder()
+	h.HandleGetMetricsPrometheus(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	expected := "# TYPE Alloc gauge\n" +
+		"Alloc{host=\"a\"} 1\n" +
+		"Alloc{host=\"b\"} 2\n" +
+		"# TYPE cpu gauge\n" +
+		"cpu{path=\"a\\\"b\",service_name=\"api\"} 0.5\n"
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestHandleGetMetricsPrometheus_Collisions(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	memStorage.SaveGaugeMetric("a_b", 1)
+	memStorage.SaveGaugeMetric("a.b", 2)
+	memStorage.SaveGaugeMetric(`a.b{host="x"}`, 3)
+	memStorage.SaveCounterMetric("a.b", 4)
+	memStorage.SaveGaugeMetric("users", 5)
+	users := storage.NewSetValue(storage.MinSetPrecision)
+	users.Add("alice")
+	memStorage.SaveSetMetric("users", users)
+
+	h := &Handler{Storage: memStorage}
+
+	w := httptest.NewRecorder()
+	h.HandleGetMetricsPrometheus(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// Каждое имя - одно семейство: первый тип и наименьшее исходное имя среди совпадающих серий
+	expected := "# TYPE a_b gauge\n" +
+		"a_b 2\n" +
+		"a_b{host=\"x\"} 3\n" +
+		"# TYPE users gauge\n" +
+		"users 5\n"
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestSanitizePrometheusName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "Valid name", in: "HeapAlloc", want: "HeapAlloc"},
+		{name: "Dots and dashes", in: "cpu.load-1", want: "cpu_load_1"},
+		{name: "Leading digit", in: "1min", want: "_1min"},
+		{name: "Colon allowed", in: "job:rate", want: "job:rate"},
+		{name: "Empty", in: "", want: "_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizePrometheusName(tt.in))
+		})
+	}
+}
+
+func TestFormatPrometheusFloat(t *testing.T) {
+	assert.Equal(t, "NaN", formatPrometheusFloat(math.NaN()))
+	assert.Equal(t, "+Inf", formatPrometheusFloat(math.Inf(1)))
+	assert.Equal(t, "-Inf", formatPrometheusFloat(math.Inf(-1)))
+	assert.Equal(t, "1e+21", formatPrometheusFloat(1e21))
+	assert.Equal(t, "42", formatPrometheusFloat(42))
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/query"
+)
+
+// queryResponse - ответ /api/v1/query в формате HTTP API Prometheus.
+type queryResponse struct {
+	Status    string     `json:"status"`              // success или error
+	Data      *queryData `json:"data,omitempty"`      // результат при успехе
+	ErrorType string     `json:"errorType,omitempty"` // bad_data или execution
+	Error     string     `json:"error,omitempty"`     // описание ошибки
+}
+
+// queryData - результат запроса: для vector - массив серий, для scalar - пара [время, значение].
+type queryData struct {
+	ResultType string `json:"resultType"`
+	Result     any    `json:"result"`
+}
+
+// querySample - серия в результате: метки и пара [время в секундах Unix, значение строкой].
+type querySample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]any            `json:"value"`
+}
+
+// HandleQuery обрабатывает GET-запросы на /api/v1/query?q=... и вычисляет запрос по сохраненной истории.
+// Поддерживаются селекторы серий с метками, rate(), increase(), avg_over_time(), min_over_time(), max_over_time(),
+// агрегации sum, avg, min, max и count с группировкой by (...) и арифметика между сериями и числами.
+// Gauge и counter с одинаковыми именем и метками различаются матчером __type__="gauge" или __type__="counter".
+// Параметр time (RFC 3339 или секунды Unix) задает момент вычисления, по умолчанию - текущий.
+// Ответ - JSON в формате HTTP API Prometheus; ошибка разбора возвращается с кодом 400, ошибка вычисления - с 422.
+func (h *Handler) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		writeQueryError(w, http.StatusBadRequest, "bad_data", "q is required")
+		return
+	}
+	ts, err := parseHistoryTime(r.URL.Query().Get("time"))
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, "bad_data", "invalid time: "+err.Error())
+		return
+	}
+
+	expr, err := query.Parse(q)
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+
+	evaluator := &query.Evaluator{Source: h.Storage, Time: ts}
+	value, err := evaluator.Eval(expr)
+	if err != nil {
+		writeQueryError(w, http.StatusUnprocessableEntity, "execution", err.Error())
+		return
+	}
+
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	at := float64(ts.UnixMilli()) / 1000
+
+	data := &queryData{ResultType: value.Type()}
+	switch v := value.(type) {
+	case query.Scalar:
+		data.Result = [2]any{at, formatQueryValue(float64(v))}
+	case query.Vector:
+		result := make([]querySample, 0, len(v))
+		for _, s := range v {
+			result = append(result, querySample{Metric: s.Labels, Value: [2]any{at, formatQueryValue(s.Value)}})
+		}
+		data.Result = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queryResponse{Status: "success", Data: data})
+}
+
+// formatQueryValue форматирует значение строкой, как Prometheus: бесконечности и NaN - как +Inf, -Inf и NaN.
+func formatQueryValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func writeQueryError(w http.ResponseWriter, status int, errorType, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(queryResponse{Status: "error", ErrorType: errorType, Error: msg})
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getQuery(h *Handler, params url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/query?"+params.Encode(), nil)
+	w := httptest.NewRecorder()
+	h.HandleQuery(w, r)
+	return w
+}
+
+func TestHandleQuery(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+	start := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		delta := int64(30)
+		require.NoError(t, memStorage.UpdateMetricsBatch([]storage.Metrics{
+			{ID: "requests", MType: Counter, Delta: &delta, Labels: map[string]string{"host": "a"}, Timestamp: &ts},
+		}))
+	}
+	at := strconv.FormatInt(start.Add(2*time.Minute).Unix(), 10)
+
+	w := getQuery(h, url.Values{"q": {"rate(requests[5m])"}, "time": {at}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"status":"success","data":{"resultType":"vector","result":[
+		{"metric":{"host":"a"},"value":[`+at+`,"0.5"]}
+	]}}`, w.Body.String())
+
+	w = getQuery(h, url.Values{"q": {"2 / 0"}, "time": {at}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"success","data":{"resultType":"scalar","result":[`+at+`,"+Inf"]}}`, w.Body.String())
+
+	// Без time селектор возвращает последнее значение
+	w = getQuery(h, url.Values{"q": {`requests{host="a"}`}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+				Value  []any             `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Data.Result, 1)
+	assert.Equal(t, map[string]string{"__name__": "requests", "host": "a"}, resp.Data.Result[0].Metric)
+	assert.Equal(t, "90", resp.Data.Result[0].Value[1])
+}
+
+func TestHandleQuery_Errors(t *testing.T) {
+	h := &Handler{Storage: storage.NewMemStorage("")}
+
+	tests := []struct {
+		name      string
+		params    url.Values
+		code      int
+		errorType string
+	}{
+		{"missing q", url.Values{}, http.StatusBadRequest, "bad_data"},
+		{"invalid time", url.Values{"q": {"a"}, "time": {"yesterday"}}, http.StatusBadRequest, "bad_data"},
+		{"parse error", url.Values{"q": {"rate(a)"}}, http.StatusBadRequest, "bad_data"},
+		{"execution error", url.Values{"q": {"sum(1)"}}, http.StatusUnprocessableEntity, "execution"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getQuery(h, tt.params)
+			require.Equal(t, tt.code, w.Code)
+
+			var resp queryResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, "error", resp.Status)
+			assert.Equal(t, tt.errorType, resp.ErrorType)
+			assert.NotEmpty(t, resp.Error)
+		})
+	}
+}
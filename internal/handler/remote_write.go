@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/prompb"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultRemoteWriteCounters - шаблоны имен, по которым серии remote_write
+// считаются счетчиками, если сопоставление типов не задано в конфигурации.
+var DefaultRemoteWriteCounters = []string{"*_total"}
+
+// staleNaN - битовое представление маркера устаревания серии в Prometheus.
+const staleNaN uint64 = 0x7ff0000000000002
+
+// cumulativeSeriesTTL - время, после которого cumulativeTracker забывает серию без новых значений.
+// Следующее значение такой серии снова становится точкой отсчета.
+const cumulativeSeriesTTL = time.Hour
+
+// cumulativeTracker преобразует накопительные значения счетчиков в целые приращения,
+// которые ожидает хранилище. Состояние хранится по ключу серии и меняется только через
+// cumulativeBatch; если приращения не удалось сохранить, изменения пакета отменяются.
+type cumulativeTracker struct {
+	mu    sync.Mutex
+	last  map[string]cumulativeState
+	swept time.Time        // время последней очистки устаревших серий
+	now   func() time.Time // источник времени, time.Now по умолчанию
+}
+
+// cumulativeState - последнее накопительное значение серии.
+type cumulativeState struct {
+	value     float64   // последнее накопительное значение
+	remainder float64   // дробная часть приращений, еще не переданная в хранилище
+	seen      time.Time // время получения значения
+}
+
+// cumulativeBatch - изменения состояния трекера в рамках одного запроса. Приращения считаются
+// под блокировкой трекера, чтобы одновременные запросы не посчитали одно приращение дважды.
+// release записывает состояние серий в трекер и снимает блокировку до сохранения в хранилище;
+// после неудачного сохранения rollback возвращает серии пакета к прежнему состоянию.
+type cumulativeBatch struct {
+	t        *cumulativeTracker
+	now      time.Time
+	pending  map[string]cumulativeState
+	counted  map[string]int64           // сумма приращений пакета по сериям
+	prev     map[string]cumulativeState // состояние серий до пакета; серий, которых не было, здесь нет
+	released bool
+	done     bool
+}
+
+// begin блокирует трекер, удаляет серии без значений дольше cumulativeSeriesTTL и начинает пакет.
+func (t *cumulativeTracker) begin() *cumulativeBatch {
+	t.mu.Lock()
+
+	now := time.Now()
+	if t.now != nil {
+		now = t.now()
+	}
+	if t.last == nil {
+		t.last = make(map[string]cumulativeState)
+	}
+	if now.Sub(t.swept) >= cumulativeSeriesTTL {
+		for key, state := range t.last {
+			if now.Sub(state.seen) >= cumulativeSeriesTTL {
+				delete(t.last, key)
+			}
+		}
+		t.swept = now
+	}
+	return &cumulativeBatch{
+		t:       t,
+		now:     now,
+		pending: make(map[string]cumulativeState),
+		counted: make(map[string]int64),
+		prev:    make(map[string]cumulativeState),
+	}
+}
+
+// delta возвращает целое приращение счетчика серии key относительно предыдущего значения.
+// Первое значение серии только запоминается как точка отсчета: после перезапуска сервера
+// накопленное значение не учитывается повторно. Значение меньше предыдущего означает сброс счетчика
+// и считается приращением целиком. Дробная часть приращения переносится на следующие значения.
+// Вызывается до release.
+func (b *cumulativeBatch) delta(key string, value float64) int64 {
+	prev, exists := b.pending[key]
+	if !exists {
+		prev, exists = b.t.last[key]
+	}
+
+	next := cumulativeState{value: value, seen: b.now}
+	if !exists {
+		b.pending[key] = next
+		return 0
+	}
+
+	delta := value - prev.value
+	if value < prev.value {
+		delta = value
+	}
+	delta += prev.remainder
+	whole := math.Floor(delta)
+	next.remainder = delta - whole
+	b.pending[key] = next
+	b.counted[key] += int64(whole)
+	return int64(whole)
+}
+
+// release записывает состояние серий пакета в трекер и снимает блокировку. Следующие запросы
+// считают приращения от новых значений, не дожидаясь сохранения этого пакета.
+func (b *cumulativeBatch) release() {
+	if b.released {
+		return
+	}
+	for key, next := range b.pending {
+		if prev, ok := b.t.last[key]; ok {
+			b.prev[key] = prev
+		}
+		b.t.last[key] = next
+	}
+	b.released = true
+	b.t.mu.Unlock()
+}
+
+// commit завершает пакет, сохраняя состояние его серий. После commit rollback ничего не делает.
+func (b *cumulativeBatch) commit() {
+	b.release()
+	b.done = true
+}
+
+// rollback отменяет изменения пакета. До release снимает блокировку, ничего не записывая. После release
+// серия, которую с тех пор не обновляли, возвращается к прежнему состоянию, и повтор запроса учитывает
+// приращение заново. Если серию уже обновил другой запрос, его значение остается точкой отсчета,
+// а несохраненное приращение переносится в остаток и учитывается со следующим значением.
+func (b *cumulativeBatch) rollback() {
+	if b.done {
+		return
+	}
+	b.done = true
+	if !b.released {
+		b.t.mu.Unlock()
+		return
+	}
+
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+	for key, next := range b.pending {
+		current, ok := b.t.last[key]
+		switch {
+		case !ok:
+			// серия уже забыта трекером
+		case current == next:
+			if prev, ok := b.prev[key]; ok {
+				b.t.last[key] = prev
+			} else {
+				delete(b.t.last, key)
+			}
+		default:
+			current.remainder += float64(b.counted[key])
+			b.t.last[key] = current
+		}
+	}
+}
+
+// HandleRemoteWrite обрабатывает POST-запросы протокола Prometheus remote_write.
+// Тело запроса - сжатое snappy сообщение WriteRequest в формате protobuf.
+// Серии, имя которых подходит под шаблоны RemoteWriteCounters, сохраняются как counter
+// (накопительное значение переводится в приращение, см. cumulativeBatch.delta), остальные - как gauge.
+// Метки серии, кроме __name__, становятся метками метрики, время сэмпла - временем метрики.
+// Все сэмплы запроса сохраняются одним пакетом.
+func (h *Handler) HandleRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, "Invalid snappy body", http.StatusBadRequest)
+		return
+	}
+
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid protobuf body", http.StatusBadRequest)
+		return
+	}
+
+	counters := h.RemoteWriteCounters
+	if counters == nil {
+		counters = DefaultRemoteWriteCounters
+	}
+
+	state := h.remoteWriteState.begin()
+	defer state.rollback()
+
+	var metrics []storage.Metrics
+	for _, ts := range req.GetTimeseries() {
+		name := seriesName(ts.GetLabels())
+		if name == "" {
+			continue
+		}
+
+		isCounter := matchesAny(name, counters)
+		labels := seriesLabels(ts.GetLabels())
+		key := storage.SeriesKey(name, labels)
+
+		for _, sample := range ts.GetSamples() {
+			value := sample.GetValue()
+			if math.Float64bits(value) == staleNaN {
+				continue
+			}
+
+			m := storage.Metrics{ID: name, Labels: labels}
+			if sample.GetTimestamp() != 0 {
+				ts := time.UnixMilli(sample.GetTimestamp()).UTC()
+				m.Timestamp = &ts
+			}
+			if isCounter {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					continue
+				}
+				delta := state.delta(key, value)
+				m.MType, m.Delta = Counter, &delta
+			} else {
+				m.MType, m.Value = Gauge, &value
+			}
+			metrics = append(metrics, m)
+		}
+	}
+
+	state.release()
+
+	if len(metrics) > 0 {
+		if err := h.Storage.UpdateMetricsBatch(metrics); err != nil {
+			log.Printf("Error saving remote_write samples: %v", err)
+			http.Error(w, "Failed to update metrics", http.StatusInternalServerError)
+			return
+		}
+	}
+	state.commit()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// seriesName возвращает значение метки __name__.
+func seriesName(labels []*prompb.Label) string {
+	for _, l := range labels {
+		if l.GetName() == "__name__" {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// seriesLabels возвращает метки серии без __name__.
+func seriesLabels(labels []*prompb.Label) map[string]string {
+	result := make(map[string]string, len(labels))
+	for _, l := range labels {
+		if l.GetName() != "__name__" {
+			result[l.GetName()] = l.GetValue()
+		}
+	}
+	return result
+}
+
+// matchesAny проверяет, подходит ли имя хотя бы под один шаблон path.Match.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
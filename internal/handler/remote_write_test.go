@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/prompb"
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// newRemoteWriteRequest собирает сжатый snappy запрос remote_write
+func newRemoteWriteRequest(t *testing.T, series ...*prompb.TimeSeries) *http.Request {
+	t.Helper()
+
+	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: series})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	return req
+}
+
+// remoteWriteStart - время первого сэмпла серий в тестах, миллисекунды Unix.
+const remoteWriteStart = 1700000000000
+
+func series(name string, instance string, values ...float64) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{
+		Labels: []*prompb.Label{
+			{Name: "__name__", Value: name},
+			{Name: "instance", Value: instance},
+		},
+	}
+	for i, v := range values {
+		ts.Samples = append(ts.Samples, &prompb.Sample{Value: v, Timestamp: remoteWriteStart + int64(i)*1000})
+	}
+	return ts
+}
+
+func TestHandleRemoteWrite(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	req := newRemoteWriteRequest(t,
+		series("go_memstats_alloc_bytes", "a", 100, 200),
+		series("http_requests_total", "a", 10, 15),
+		series("http_requests_total", "b", 3),
+	)
+	w := httptest.NewRecorder()
+	h.HandleRemoteWrite(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	gauge, err := memStorage.GetGaugeMetric(`go_memstats_alloc_bytes{instance="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 200.0, gauge)
+
+	// Время сэмпла (миллисекунды Unix) становится временем метрики
+	points, err := memStorage.GetHistory(Gauge, `go_memstats_alloc_bytes{instance="a"}`, time.Time{}, time.Time{}, storage.ResolutionRaw)
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, time.UnixMilli(remoteWriteStart).UTC(), points[0].Timestamp)
+	assert.Equal(t, time.UnixMilli(remoteWriteStart+1000).UTC(), points[1].Timestamp)
+
+	// Первое значение серии - точка отсчета, следующие добавляют приращение
+	counter, err := memStorage.GetCounterMetric(`http_requests_total{instance="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+
+	counter, err = memStorage.GetCounterMetric(`http_requests_total{instance="b"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counter)
+
+	// Повторная отправка накопительного значения добавляет только приращение,
+	// а уменьшение значения считается сбросом счетчика
+	req = newRemoteWriteRequest(t,
+		series("http_requests_total", "a", 20),
+		series("http_requests_total", "b", 1),
+	)
+	w = httptest.NewRecorder()
+	h.HandleRemoteWrite(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	counter, err = memStorage.GetCounterMetric(`http_requests_total{instance="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counter)
+
+	counter, err = memStorage.GetCounterMetric(`http_requests_total{instance="b"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counter)
+}
+
+func TestHandleRemoteWrite_TypeMapping(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage, RemoteWriteCounters: []string{"*_count"}}
+
+	req := newRemoteWriteRequest(t,
+		series("rpc_count", "a", 5),
+		series("rpc_total", "a", 7),
+		series("stale_gauge", "a", math.Float64frombits(staleNaN)),
+	)
+	w := httptest.NewRecorder()
+	h.HandleRemoteWrite(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	counter, err := memStorage.GetCounterMetric(`rpc_count{instance="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counter)
+
+	gauge, err := memStorage.GetGaugeMetric(`rpc_total{instance="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, gauge)
+
+	_, err = memStorage.GetGaugeMetric(`stale_gauge{instance="a"}`)
+	assert.Error(t, err)
+}
+
+func TestHandleRemoteWrite_InvalidBody(t *testing.T) {
+	h := &Handler{Storage: storage.NewMemStorage("")}
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{name: "Not snappy", body: []byte{0xff, 0xff, 0xff}},
+		{name: "Not protobuf", body: snappy.Encode(nil, []byte{0x0a, 0xff})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.HandleRemoteWrite(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestCumulativeTracker(t *testing.T) {
+	now := time.Date(2025, 7, 10, 12, 0, 0, 0, time.UTC)
+	tracker := &cumulativeTracker{now: func() time.Time { return now }}
+
+	apply := func(key string, value float64) int64 {
+		state := tracker.begin()
+		defer state.commit()
+		return state.delta(key, value)
+	}
+
+	// Первое значение - точка отсчета, дробные приращения накапливаются
+	assert.Equal(t, int64(0), apply("a", 10.25))
+	assert.Equal(t, int64(0), apply("a", 10.75))
+	assert.Equal(t, int64(1), apply("a", 11.5))
+	// Сброс счетчика: новое значение считается приращением целиком вместе с остатком
+	assert.Equal(t, int64(1), apply("a", 1.5))
+
+	// Отмененный пакет не меняет состояние
+	state := tracker.begin()
+	assert.Equal(t, int64(9), state.delta("a", 10))
+	state.rollback()
+	assert.Equal(t, int64(9), apply("a", 10))
+
+	// После release трекер не заблокирован на время сохранения пакета; отмененный пакет
+	// возвращает серию к прежнему состоянию, если ее не обновляли
+	state = tracker.begin()
+	assert.Equal(t, int64(5), state.delta("a", 15))
+	state.release()
+	assert.Equal(t, int64(0), apply("b", 1))
+	state.rollback()
+	assert.Equal(t, int64(5), apply("a", 15))
+
+	// Если серию успел обновить другой запрос, приращение отмененного пакета переносится в остаток
+	state = tracker.begin()
+	assert.Equal(t, int64(5), state.delta("a", 20))
+	state.release()
+	assert.Equal(t, int64(2), apply("a", 22))
+	state.rollback()
+	assert.Equal(t, int64(6), apply("a", 23))
+
+	// Серия без значений дольше cumulativeSeriesTTL забывается, и следующее значение снова точка отсчета
+	now = now.Add(cumulativeSeriesTTL)
+	assert.Equal(t, int64(0), apply("a", 50))
+}
+
+func TestHandleRemoteWrite_StorageError(t *testing.T) {
+	s := &failingStorage{MemStorage: storage.NewMemStorage(""), failOn: 2}
+	h := &Handler{Storage: s}
+
+	send := func(value float64) int {
+		w := httptest.NewRecorder()
+		h.HandleRemoteWrite(w, newRemoteWriteRequest(t, series("http_requests_total", "a", value)))
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNoContent, send(10))
+	// Пакет не сохранен - состояние серии не меняется, и повтор запроса учитывает приращение
+	require.Equal(t, http.StatusInternalServerError, send(15))
+	require.Equal(t, http.StatusNoContent, send(15))
+
+	counter, err := s.GetCounterMetric(`http_requests_total{instance="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+}
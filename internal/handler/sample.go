@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// saveSample сохраняет gauge или counter из запроса. Сэмпл со временем сбора (поле timestamp)
+// передается через UpdateMetricsBatch: одиночные Save*Metric используют время получения.
+func (h *Handler) saveSample(m storage.Metrics) error {
+	if m.Timestamp != nil {
+		return h.Storage.UpdateMetricsBatch([]storage.Metrics{m})
+	}
+	key := storage.SeriesKey(m.ID, m.Labels)
+	if m.MType == Gauge {
+		return h.Storage.SaveGaugeMetric(key, *m.Value)
+	}
+	return h.Storage.SaveCounterMetric(key, *m.Delta)
+}
+
+// writeUpdateError возвращает 409 для gauge-сэмпла, отклоненного как устаревший,
+// и код writeDistributionError для остальных ошибок.
+func writeUpdateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrOutOfOrder) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeDistributionError(w, err)
+}
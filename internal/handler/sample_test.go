@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleUpdateMetricJSON_OutOfOrder(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	memStorage.OutOfOrder = storage.OutOfOrderReject
+	h := &Handler{Storage: memStorage}
+
+	w := postHistogramJSON(t, h, `{"id":"temp","type":"gauge","value":2,"timestamp":"2025-06-25T12:00:10Z"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Сэмпл, собранный раньше сохраненного, отклоняется
+	w = postHistogramJSON(t, h, `{"id":"temp","type":"gauge","value":1,"timestamp":"2025-06-25T12:00:00Z"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Пакет с устаревшим сэмплом не применяется целиком
+	body := `[
+		{"id":"hits","type":"counter","delta":1,"timestamp":"2025-06-25T12:00:05Z"},
+		{"id":"temp","type":"gauge","value":1,"timestamp":"2025-06-25T12:00:05Z"}
+	]`
+	w = httptest.NewRecorder()
+	h.HandleUpdatesBatch(w, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	value, err := memStorage.GetGaugeMetric("temp")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, value)
+	_, err = memStorage.GetCounterMetric("hits")
+	assert.Error(t, err)
+
+	// Более поздний сэмпл принимается
+	w = postHistogramJSON(t, h, `{"id":"temp","type":"gauge","value":3,"timestamp":"2025-06-25T12:00:20Z"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	value, err = memStorage.GetGaugeMetric("temp")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, value)
+}
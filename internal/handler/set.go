@@ -0,0 +1,12 @@
+package handler
+
+import "github.com/25x8/metric-gathering/internal/storage"
+
+// setSetValue заполняет ответ оценкой числа уникальных элементов set.
+// Сам скетч в ответ не включается: он занимает 2^Precision байт.
+func setSetValue(m *storage.Metrics, s storage.SetValue) {
+	cardinality := s.Estimate()
+	m.Members = nil
+	m.Set = nil
+	m.Cardinality = &cardinality
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleUpdateMetricJSON_Set(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	// Повторный элемент не меняет оценку
+	w := postHistogramJSON(t, h, `{"id":"users","type":"set","members":["alice","bob","alice"],"labels":{"host":"a"}}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var m storage.Metrics
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+	require.NotNil(t, m.Cardinality)
+	assert.Equal(t, int64(2), *m.Cardinality)
+	assert.Nil(t, m.Set)
+	assert.Nil(t, m.Members)
+
+	// Скетч другого агента сливается с сохраненным
+	sketch := storage.NewSetValue(storage.DefaultSetPrecision)
+	for i := 0; i < 100; i++ {
+		sketch.Add("user-" + strconv.Itoa(i))
+	}
+	sketch.Add("bob")
+	body, err := json.Marshal(storage.Metrics{ID: "users", MType: Set, Labels: map[string]string{"host": "a"}, Set: &sketch})
+	require.NoError(t, err)
+
+	w = postHistogramJSON(t, h, string(body))
+	require.Equal(t, http.StatusOK, w.Code)
+	m = storage.Metrics{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+	assert.InDelta(t, 102, *m.Cardinality, 2)
+
+	// Скетч с другой точностью и запрос без элементов отклоняются
+	other := storage.NewSetValue(10)
+	body, err = json.Marshal(storage.Metrics{ID: "users", MType: Set, Labels: map[string]string{"host": "a"}, Set: &other})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, postHistogramJSON(t, h, string(body)).Code)
+	assert.Equal(t, http.StatusBadRequest, postHistogramJSON(t, h, `{"id":"users","type":"set"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, postHistogramJSON(t, h, `{"id":"x","type":"set","set":{"precision":2,"registers":""}}`).Code)
+}
+
+func TestHandleUpdateMetric_Set(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	for _, member := range []string{"s1", "s2", "s1", "s3"} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/update/set/sessions/"+member, nil),
+			map[string]string{"type": Set, "name": "sessions", "value": member})
+		w := httptest.NewRecorder()
+		h.HandleUpdateMetric(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/value/set/sessions", nil),
+		map[string]string{"type": Set, "name": "sessions"})
+	w := httptest.NewRecorder()
+	h.HandleGetValue(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3", w.Body.String())
+
+	// В формате Prometheus set выводится как gauge
+	w = httptest.NewRecorder()
+	h.HandleGetMetricsPrometheus(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "# TYPE sessions gauge\nsessions 3\n", w.Body.String())
+}
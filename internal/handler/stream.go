@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+const (
+	// StreamBufferSize - размер очереди событий одного подписчика.
+	// Если клиент не успевает читать и очередь заполнена, новые события для него отбрасываются.
+	StreamBufferSize = 256
+	// StreamHeartbeatInterval - период отправки комментариев, которые не дают прокси закрыть соединение.
+	StreamHeartbeatInterval = 15 * time.Second
+)
+
+// ChangeHub рассылает изменения метрик подписчикам /stream.
+type ChangeHub struct {
+	mu     sync.Mutex
+	subs   map[chan storage.Metrics]struct{}
+	closed bool
+}
+
+// NewChangeHub - конструктор для ChangeHub
+func NewChangeHub() *ChangeHub {
+	return &ChangeHub{subs: make(map[chan storage.Metrics]struct{})}
+}
+
+// Publish передает изменение всем подписчикам, не блокируясь на медленных.
+// Имеет сигнатуру storage.ChangeFunc и регистрируется в хранилище через OnChange.
+func (hub *ChangeHub) Publish(m storage.Metrics) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for ch := range hub.subs {
+		select {
+		case ch <- m:
+		default:
+		}
+	}
+}
+
+// Close завершает все подписки; используется при остановке сервера,
+// чтобы открытые SSE-соединения не задерживали http.Server.Shutdown.
+func (hub *ChangeHub) Close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.closed = true
+	for ch := range hub.subs {
+		close(ch)
+		delete(hub.subs, ch)
+	}
+}
+
+func (hub *ChangeHub) subscribe() chan storage.Metrics {
+	ch := make(chan storage.Metrics, StreamBufferSize)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		close(ch)
+		return ch
+	}
+	hub.subs[ch] = struct{}{}
+	return ch
+}
+
+func (hub *ChangeHub) unsubscribe(ch chan storage.Metrics) {
+	hub.mu.Lock()
+	delete(hub.subs, ch)
+	hub.mu.Unlock()
+}
+
+// HandleStream обрабатывает GET-запросы на /stream и передает изменения метрик
+// в формате Server-Sent Events: событие metric с JSON-объектом storage.Metrics.
+// Для gauge передается новое значение, для counter - приращение, для histogram и summary - добавленные наблюдения,
+// для set - новая оценка числа уникальных элементов.
+// Параметры запроса: prefix - префикс имени метрики, type - gauge, counter, histogram, summary или set.
+func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	if h.Changes == nil {
+		http.Error(w, "Change stream is not available", http.StatusNotImplemented)
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	metricType := r.URL.Query().Get("type")
+	if metricType != "" && metricType != Gauge && metricType != Counter && metricType != Histogram && metricType != Summary && metricType != Set {
+		http.Error(w, "Invalid metric type", http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	events := h.Changes.subscribe()
+	defer h.Changes.unsubscribe(events)
+
+	heartbeat := time.NewTicker(StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case m, ok := <-events:
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(m.ID, prefix) || (metricType != "" && m.MType != metricType) {
+				continue
+			}
+			data, err := json.Marshal(m)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: metric\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent читает из потока SSE следующее событие metric
+func readEvent(t *testing.T, reader *bufio.Reader) storage.Metrics {
+	t.Helper()
+
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			require.Equal(t, "metric", event)
+			var m storage.Metrics
+			require.NoError(t, json.Unmarshal([]byte(data), &m))
+			return m
+		}
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage, Changes: NewChangeHub()}
+	memStorage.OnChange(h.Changes.Publish)
+
+	server := httptest.NewServer(http.HandlerFunc(h.HandleStream))
+	defer server.Close()
+	defer h.Changes.Close()
+
+	resp, err := http.Get(server.URL + "/stream?prefix=cpu&type=gauge")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Подписка создается после отправки заголовков, дожидаемся ее
+	require.Eventually(t, func() bool {
+		h.Changes.mu.Lock()
+		defer h.Changes.mu.Unlock()
+		return len(h.Changes.subs) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, memStorage.SaveGaugeMetric("mem_used", 1))
+	require.NoError(t, memStorage.SaveCounterMetric("cpu_ticks", 5))
+	require.NoError(t, memStorage.SaveGaugeMetric("cpu_load", 0.5))
+
+	m := readEvent(t, bufio.NewReader(resp.Body))
+	assert.Equal(t, "cpu_load", m.ID)
+	assert.Equal(t, Gauge, m.MType)
+	assert.Equal(t, 0.5, *m.Value)
+}
+
+func TestHandleStream_Invalid(t *testing.T) {
+	h := &Handler{Storage: storage.NewMemStorage("")}
+
+	w := httptest.NewRecorder()
+	h.HandleStream(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	h.Changes = NewChangeHub()
+	w = httptest.NewRecorder()
+	h.HandleStream(w, httptest.NewRequest(http.MethodGet, "/stream?type=meter", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestChangeHub_Close(t *testing.T) {
+	hub := NewChangeHub()
+	ch := hub.subscribe()
+	hub.Close()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	_, ok = <-hub.subscribe()
+	assert.False(t, ok)
+	hub.Publish(storage.Metrics{ID: "x"})
+}
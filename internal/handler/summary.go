@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// parseQuantile разбирает квантиль из диапазона [0, 1].
+func parseQuantile(s string) (float64, error) {
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, fmt.Errorf("invalid quantile %q", s)
+	}
+	return q, nil
+}
+
+// summaryQuantiles вычисляет квантили DefaultSummaryQuantiles. Для пустого скетча возвращает nil.
+func summaryQuantiles(s storage.SummaryValue) map[string]float64 {
+	if s.Count == 0 {
+		return nil
+	}
+	quantiles := make(map[string]float64, len(storage.DefaultSummaryQuantiles))
+	for _, q := range storage.DefaultSummaryQuantiles {
+		quantiles[strconv.FormatFloat(q, 'g', -1, 64)] = s.Quantile(q)
+	}
+	return quantiles
+}
+
+// setSummaryValue заполняет ответ текущим скетчем summary и его квантилями.
+func setSummaryValue(m *storage.Metrics, s storage.SummaryValue) {
+	m.Value = nil
+	m.Summary = &s
+	m.Quantiles = summaryQuantiles(s)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleUpdateMetricJSON_Summary(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	// Одиночные наблюдения и скетч агента сливаются в один скетч
+	for _, v := range []string{"1", "2", "3"} {
+		w := postHistogramJSON(t, h, `{"id":"rtt","type":"summary","value":`+v+`}`)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	sketch := storage.NewSummaryValue(storage.DefaultSummaryAccuracy)
+	for i := 0; i < 97; i++ {
+		sketch.Add(100)
+	}
+	body, err := json.Marshal(storage.Metrics{ID: "rtt", MType: Summary, Summary: &sketch})
+	require.NoError(t, err)
+
+	w := postHistogramJSON(t, h, string(body))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var m storage.Metrics
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+	require.NotNil(t, m.Summary)
+	assert.Equal(t, int64(100), m.Summary.Count)
+	assert.InEpsilon(t, 100, m.Quantiles["0.5"], storage.DefaultSummaryAccuracy)
+	assert.InEpsilon(t, 100, m.Quantiles["0.99"], storage.DefaultSummaryAccuracy)
+
+	// Скетч с другой точностью отклоняется
+	other := storage.NewSummaryValue(0.05)
+	other.Add(1)
+	body, err = json.Marshal(storage.Metrics{ID: "rtt", MType: Summary, Summary: &other})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, postHistogramJSON(t, h, string(body)).Code)
+	assert.Equal(t, http.StatusBadRequest, postHistogramJSON(t, h, `{"id":"rtt","type":"summary"}`).Code)
+
+	// Квантили через /value/
+	r := httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(`{"id":"rtt","type":"summary"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.HandleGetValueJSON(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	m = storage.Metrics{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&m))
+	assert.Len(t, m.Quantiles, 3)
+	assert.InEpsilon(t, 1, m.Summary.Min, 1e-9)
+}
+
+func TestHandleGetValue_SummaryQuantile(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	h := &Handler{Storage: memStorage}
+
+	for i := 1; i <= 100; i++ {
+		v := float64(i)
+		m := storage.Metrics{ID: "size", MType: Summary, Value: &v}
+		require.NoError(t, h.saveDistribution(&m))
+	}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, url, nil),
+			map[string]string{"type": Summary, "name": "size"})
+		w := httptest.NewRecorder()
+		h.HandleGetValue(w, r)
+		return w
+	}
+
+	w := get("/value/summary/size?q=0.9")
+	require.Equal(t, http.StatusOK, w.Code)
+	p90, err := strconv.ParseFloat(w.Body.String(), 64)
+	require.NoError(t, err)
+	assert.InEpsilon(t, 90, p90, storage.DefaultSummaryAccuracy)
+
+	w = get("/value/summary/size")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "count=100 sum=5050 p50="))
+
+	assert.Equal(t, http.StatusBadRequest, get("/value/summary/size?q=1.5").Code)
+}
+
+func TestHandleGetMetricsPrometheus_Summary(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	sketch := storage.NewSummaryValue(storage.DefaultSummaryAccuracy)
+	sketch.Add(0)
+	require.NoError(t, memStorage.SaveSummaryMetric(`rtt{host="a"}`, sketch))
+	h := &Handler{Storage: memStorage}
+
+	w := httptest.NewRecorder()
+	h.HandleGetMetricsPrometheus(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# TYPE rtt summary
+rtt{host="a",quantile="0.5"} 0
+rtt{host="a",quantile="0.9"} 0
+rtt{host="a",quantile="0.99"} 0
+rtt_sum{host="a"} 0
+rtt_count{host="a"} 1
+`
+	assert.Equal(t, want, w.Body.String())
+}
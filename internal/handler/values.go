@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// valuesResponse - ответ /values/: найденные метрики со значениями и ненайденные в порядке запроса.
+type valuesResponse struct {
+	Metrics []storage.Metrics `json:"metrics"`
+	Missing []storage.Metrics `json:"missing"`
+}
+
+// HandleGetValues обрабатывает POST-запросы на /values/ для чтения нескольких метрик за один запрос.
+// Ожидает JSON-массив [{"id": "метрика", "type": "тип", "labels": {...}}, ...] и возвращает
+// {"metrics": [...], "missing": [...]}: найденные метрики со значениями в тех же полях, что и /value/,
+// и ссылки на отсутствующие метрики. Все метрики читаются из хранилища одним обращением.
+func (h *Handler) HandleGetValues(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var requested []storage.Metrics
+	if err := json.NewDecoder(r.Body).Decode(&requested); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	refs := make([]storage.MetricRef, len(requested))
+	for i, m := range requested {
+		if m.ID == "" || m.MType == "" {
+			http.Error(w, fmt.Sprintf("metric %d: ID and MType are required", i), http.StatusBadRequest)
+			return
+		}
+		if !isMetricType(m.MType) {
+			http.Error(w, fmt.Sprintf("metric %d: Invalid metric type", i), http.StatusBadRequest)
+			return
+		}
+		if err := storage.ValidateLabels(m.Labels); err != nil {
+			http.Error(w, fmt.Sprintf("metric %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		refs[i] = storage.MetricRef{Type: m.MType, Name: storage.SeriesKey(m.ID, m.Labels)}
+	}
+
+	found, err := h.Storage.GetMetrics(refs)
+	if err != nil {
+		http.Error(w, "Failed to get metrics", http.StatusInternalServerError)
+		return
+	}
+
+	resp := valuesResponse{Metrics: []storage.Metrics{}, Missing: []storage.Metrics{}}
+	for i, m := range requested {
+		ref := storage.Metrics{ID: m.ID, MType: m.MType, Labels: m.Labels}
+		if fillValue(&ref, found, refs[i].Name) {
+			resp.Metrics = append(resp.Metrics, ref)
+		} else {
+			resp.Missing = append(resp.Missing, ref)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// fillValue заполняет значение метрики m с ключом серии key из found так же, как /value/.
+// Возвращает false, если метрики нет в found.
+func fillValue(m *storage.Metrics, found storage.MetricSet, key string) bool {
+	switch m.MType {
+	case Gauge:
+		value, ok := found.Gauges[key]
+		if ok {
+			m.Value = &value
+		}
+		return ok
+	case Counter:
+		delta, ok := found.Counters[key]
+		if ok {
+			m.Delta = &delta
+		}
+		return ok
+	case Histogram:
+		value, ok := found.Histograms[key]
+		if ok {
+			m.Histogram = &value
+		}
+		return ok
+	case Summary:
+		value, ok := found.Summaries[key]
+		if ok {
+			setSummaryValue(m, value)
+		}
+		return ok
+	case Set:
+		value, ok := found.Sets[key]
+		if ok {
+			setSetValue(m, value)
+		}
+		return ok
+	default:
+		return false
+	}
+}
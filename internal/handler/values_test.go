@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage считает пакетные чтения.
+type countingStorage struct {
+	*storage.MemStorage
+	batches int
+}
+
+func (s *countingStorage) GetMetrics(refs []storage.MetricRef) (storage.MetricSet, error) {
+	s.batches++
+	return s.MemStorage.GetMetrics(refs)
+}
+
+func postValues(h *Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/values/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandleGetValues(w, r)
+	return w
+}
+
+func TestHandleGetValues(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	require.NoError(t, memStorage.SaveGaugeMetric("Alloc", 1.5))
+	require.NoError(t, memStorage.SaveCounterMetric(`requests{host="a"}`, 7))
+	users := storage.NewSetValue(storage.MinSetPrecision)
+	users.Add("alice")
+	require.NoError(t, memStorage.SaveSetMetric("users", users))
+
+	s := &countingStorage{MemStorage: memStorage}
+	h := &Handler{Storage: s}
+
+	w := postValues(h, `[
+		{"id": "Alloc", "type": "gauge"},
+		{"id": "missing", "type": "gauge"},
+		{"id": "requests", "type": "counter", "labels": {"host": "a"}},
+		{"id": "Alloc", "type": "counter"},
+		{"id": "users", "type": "set"}
+	]`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"metrics": [
+			{"id": "Alloc", "type": "gauge", "value": 1.5},
+			{"id": "requests", "type": "counter", "labels": {"host": "a"}, "delta": 7},
+			{"id": "users", "type": "set", "cardinality": 1}
+		],
+		"missing": [
+			{"id": "missing", "type": "gauge"},
+			{"id": "Alloc", "type": "counter"}
+		]
+	}`, w.Body.String())
+	assert.Equal(t, 1, s.batches)
+
+	// Пустой запрос возвращает пустые списки
+	w = postValues(h, `[]`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"metrics": [], "missing": []}`, w.Body.String())
+}
+
+func TestHandleGetValues_Errors(t *testing.T) {
+	h := &Handler{Storage: storage.NewMemStorage("")}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"invalid JSON", `{"id": "Alloc"`},
+		{"object instead of array", `{"id": "Alloc", "type": "gauge"}`},
+		{"missing type", `[{"id": "Alloc"}]`},
+		{"invalid type", `[{"id": "Alloc", "type": "gauge"}, {"id": "Alloc", "type": "meter"}]`},
+		{"invalid labels", `[{"id": "Alloc", "type": "gauge", "labels": {"": "a"}}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, postValues(h, tt.body).Code)
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/values/", strings.NewReader(`[]`))
+	w := httptest.NewRecorder()
+	h.HandleGetValues(w, r)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
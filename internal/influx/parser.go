@@ -0,0 +1,240 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidPrecision возвращается для неизвестной точности временных меток.
+var ErrInvalidPrecision = errors.New("invalid timestamp precision")
+
+// ParseError описывает ошибку разбора конкретной строки line protocol.
+type ParseError struct {
+	Line int    // номер строки, начиная с 1
+	Msg  string // описание ошибки
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Field - поле точки. Value имеет тип float64, int64, uint64, bool или string.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Point - одна строка line protocol.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Time        time.Time // нулевое значение, если временная метка не указана
+}
+
+// PrecisionMultiplier возвращает длительность одной единицы временной метки.
+// Поддерживаются обозначения API v1 (n, u, ms, s, m, h) и v2 (ns, us, ms, s).
+// Пустая строка означает наносекунды.
+func PrecisionMultiplier(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrInvalidPrecision, precision)
+	}
+}
+
+// Parse разбирает тело запроса в формате InfluxDB line protocol.
+// Пустые строки и комментарии (#) пропускаются. При первой ошибке возвращается *ParseError.
+func Parse(data []byte, precision string) ([]Point, error) {
+	unit, err := PrecisionMultiplier(precision)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []Point
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, err := parseLine(line, unit)
+		if err != nil {
+			return nil, &ParseError{Line: i + 1, Msg: err.Error()}
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// parseLine разбирает строку вида measurement[,tag=value...] field=value[,...] [timestamp].
+func parseLine(line string, unit time.Duration) (Point, error) {
+	var p Point
+
+	pos := 0
+	measurement, pos := scanUntil(line, pos, ", ")
+	if measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	p.Measurement = unescape(measurement)
+
+	// Теги
+	for pos < len(line) && line[pos] == ',' {
+		var tag string
+		tag, pos = scanUntil(line, pos+1, ", ")
+		key, value, ok := splitKeyValue(tag)
+		if !ok || key == "" || value == "" {
+			return p, fmt.Errorf("invalid tag %q", tag)
+		}
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+		p.Tags[unescape(key)] = unescape(value)
+	}
+
+	if pos >= len(line) || line[pos] != ' ' {
+		return p, errors.New("missing fields")
+	}
+	pos = skipSpaces(line, pos)
+
+	// Поля
+	for {
+		var field string
+		field, pos = scanField(line, pos)
+		key, raw, ok := splitKeyValue(field)
+		if !ok || key == "" || raw == "" {
+			return p, fmt.Errorf("invalid field %q", field)
+		}
+		value, err := parseFieldValue(raw)
+		if err != nil {
+			return p, fmt.Errorf("invalid value of field %q: %w", unescape(key), err)
+		}
+		p.Fields = append(p.Fields, Field{Key: unescape(key), Value: value})
+
+		if pos >= len(line) || line[pos] != ',' {
+			break
+		}
+		pos++
+	}
+
+	// Временная метка
+	if pos < len(line) {
+		rest := strings.TrimSpace(line[pos:])
+		if rest != "" {
+			ts, err := strconv.ParseInt(rest, 10, 64)
+			if err != nil {
+				return p, fmt.Errorf("invalid timestamp %q", rest)
+			}
+			p.Time = time.Unix(0, ts*int64(unit)).UTC()
+		}
+	}
+
+	return p, nil
+}
+
+// scanUntil читает строку с позиции pos до первого неэкранированного символа из stop.
+func scanUntil(line string, pos int, stop string) (string, int) {
+	start := pos
+	for pos < len(line) {
+		c := line[pos]
+		if c == '\\' && pos+1 < len(line) {
+			pos += 2
+			continue
+		}
+		if strings.IndexByte(stop, c) >= 0 {
+			break
+		}
+		pos++
+	}
+	return line[start:pos], pos
+}
+
+// scanField читает пару key=value до неэкранированной запятой или пробела вне кавычек.
+func scanField(line string, pos int) (string, int) {
+	start := pos
+	inQuotes := false
+	for pos < len(line) {
+		c := line[pos]
+		switch {
+		case c == '\\' && pos+1 < len(line):
+			pos += 2
+			continue
+		case c == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && (c == ',' || c == ' '):
+			return line[start:pos], pos
+		}
+		pos++
+	}
+	return line[start:pos], pos
+}
+
+func skipSpaces(line string, pos int) int {
+	for pos < len(line) && line[pos] == ' ' {
+		pos++
+	}
+	return pos
+}
+
+// splitKeyValue делит строку по первому неэкранированному '='.
+func splitKeyValue(s string) (string, string, bool) {
+	key, pos := scanUntil(s, 0, "=")
+	if pos >= len(s) {
+		return "", "", false
+	}
+	return key, s[pos+1:], true
+}
+
+// unescape убирает экранирование запятых, пробелов, знаков '=' и обратных слешей.
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, =\"`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseFieldValue определяет тип значения поля по его записи:
+// "строка", 12i (int64), 12u (uint64), true/false или число с плавающей точкой.
+func parseFieldValue(raw string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return nil, errors.New("unterminated string")
+		}
+		return unescape(raw[1 : len(raw)-1]), nil
+	case strings.HasSuffix(raw, "i"):
+		return strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+	case strings.HasSuffix(raw, "u"):
+		return strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+	}
+
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	return strconv.ParseFloat(raw, 64)
+}
@@ -0,0 +1,92 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`# comment
+cpu,host=server01,region=us-west usage_idle=92.5,usage_user=3i 1700000000000000000
+
+mem free=1024u,active=true
+weather,location=us\,midwest temperature=82,desc="sunny, \"warm\"" 1700000000
+my\ measurement,tag\=key=val\ ue count=1i
+`)
+
+	points, err := Parse(data, "")
+	require.NoError(t, err)
+	require.Len(t, points, 4)
+
+	assert.Equal(t, Point{
+		Measurement: "cpu",
+		Tags:        map[string]string{"host": "server01", "region": "us-west"},
+		Fields: []Field{
+			{Key: "usage_idle", Value: 92.5},
+			{Key: "usage_user", Value: int64(3)},
+		},
+		Time: time.Unix(1700000000, 0).UTC(),
+	}, points[0])
+
+	assert.Equal(t, Point{
+		Measurement: "mem",
+		Fields: []Field{
+			{Key: "free", Value: uint64(1024)},
+			{Key: "active", Value: true},
+		},
+	}, points[1])
+
+	assert.Equal(t, "weather", points[2].Measurement)
+	assert.Equal(t, map[string]string{"location": "us,midwest"}, points[2].Tags)
+	assert.Equal(t, []Field{
+		{Key: "temperature", Value: 82.0},
+		{Key: "desc", Value: `sunny, "warm"`},
+	}, points[2].Fields)
+	// Без указания точности метка интерпретируется в наносекундах
+	assert.Equal(t, time.Unix(0, 1700000000).UTC(), points[2].Time)
+
+	assert.Equal(t, "my measurement", points[3].Measurement)
+	assert.Equal(t, map[string]string{"tag=key": "val ue"}, points[3].Tags)
+}
+
+func TestParse_Precision(t *testing.T) {
+	points, err := Parse([]byte("cpu value=1 1700000000"), "s")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), points[0].Time)
+
+	points, err = Parse([]byte("cpu value=1 1700000000123"), "ms")
+	require.NoError(t, err)
+	assert.Equal(t, time.UnixMilli(1700000000123).UTC(), points[0].Time)
+
+	_, err = Parse([]byte("cpu value=1"), "days")
+	assert.ErrorIs(t, err, ErrInvalidPrecision)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		line int
+	}{
+		{name: "Missing fields", data: "cpu", line: 1},
+		{name: "Missing fields after tags", data: "cpu,host=a", line: 1},
+		{name: "Empty tag value", data: "cpu,host= value=1", line: 1},
+		{name: "Bad integer", data: "cpu value=1.5i", line: 1},
+		{name: "Bad float", data: "cpu value=abc", line: 1},
+		{name: "Unterminated string", data: `cpu value="abc`, line: 1},
+		{name: "Bad timestamp", data: "cpu value=1 now", line: 1},
+		{name: "Error on second line", data: "cpu value=1\nmem", line: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data), "")
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.line, parseErr.Line)
+		})
+	}
+}
@@ -106,3 +106,8 @@ func (w *responseWriterWrapper) Write(data []byte) (int, error) {
 	w.responseSize += size
 	return size, err
 }
+
+// Unwrap позволяет http.ResponseController добраться до исходного ResponseWriter (например, для Flush).
+func (w *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
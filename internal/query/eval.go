@@ -0,0 +1,483 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// LookbackDelta - насколько далеко в прошлое от момента вычисления ищется значение серии
+// для селектора без интервала, если момент задан явно.
+const LookbackDelta = 5 * time.Minute
+
+// nameLabel - метка с именем метрики.
+const nameLabel = "__name__"
+
+// typeLabel - метка с типом метрики (gauge или counter). Она доступна только матчерам и не попадает
+// в результат; ею выбирается одна из gauge и counter с одинаковыми именем и метками.
+const typeLabel = "__type__"
+
+// Source - хранилище, по которому вычисляются запросы. Ему удовлетворяет storage.Storage.
+type Source interface {
+	ListMetrics(opts storage.ListOptions) (storage.MetricPage, error)
+	GetHistories(refs []storage.MetricRef, from, to time.Time, res storage.Resolution) (map[storage.MetricRef][]storage.HistoryPoint, error)
+}
+
+// Value - результат вычисления: Vector или Scalar.
+type Value interface {
+	Type() string
+}
+
+// Sample - значение одной серии. Labels включают имя метрики в метке __name__, если оно сохранилось.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Vector - набор серий, упорядоченный по меткам.
+type Vector []Sample
+
+// Scalar - число.
+type Scalar float64
+
+// Type возвращает "vector".
+func (Vector) Type() string { return "vector" }
+
+// Type возвращает "scalar".
+func (Scalar) Type() string { return "scalar" }
+
+// Evaluator вычисляет запросы по данным Source на момент Time. Нулевой Time означает текущий момент:
+// селекторы без интервала тогда берут последние сохраненные значения, а не точки истории.
+type Evaluator struct {
+	Source Source
+	Time   time.Time
+}
+
+// series - серия хранилища: тип, ключ и метки вместе с __name__.
+type series struct {
+	metricType string
+	key        string
+	labels     map[string]string
+	value      float64
+}
+
+// Eval вычисляет выражение.
+func (e *Evaluator) Eval(expr Expr) (Value, error) {
+	switch n := expr.(type) {
+	case *NumberLiteral:
+		return Scalar(n.Value), nil
+	case *VectorSelector:
+		return e.evalSelector(n)
+	case *MatrixSelector:
+		return nil, errors.New("range selector must be passed to a function such as rate()")
+	case *Call:
+		return e.evalCall(n)
+	case *Aggregate:
+		return e.evalAggregate(n)
+	case *BinaryExpr:
+		return e.evalBinary(n)
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+// now возвращает момент вычисления.
+func (e *Evaluator) now() time.Time {
+	if e.Time.IsZero() {
+		return time.Now()
+	}
+	return e.Time
+}
+
+// selectSeries возвращает серии gauge и counter, подходящие под селектор. Имя и метки из матчеров
+// равенства фильтруются хранилищем, остальные матчеры проверяются здесь. Если под селектор подходят
+// gauge и counter с одинаковым ключом серии, возвращается ошибка: их нужно различить меткой __type__.
+func (e *Evaluator) selectSeries(sel *VectorSelector) ([]series, error) {
+	opts, ok := listOptions(sel)
+	if !ok {
+		return nil, nil
+	}
+	page, err := e.Source.ListMetrics(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics: %w", err)
+	}
+
+	var result []series
+	types := make(map[string]string, len(page.Metrics))
+	for _, m := range page.Metrics {
+		s := series{metricType: m.Type, key: storage.SeriesKey(m.ID, m.Labels), labels: make(map[string]string, len(m.Labels)+2)}
+		for k, v := range m.Labels {
+			s.labels[k] = v
+		}
+		s.labels[nameLabel] = m.ID
+		s.labels[typeLabel] = m.Type
+		matched := matchesAll(sel.Matchers, s.labels)
+		delete(s.labels, typeLabel)
+		if !matched {
+			continue
+		}
+
+		if other, ok := types[s.key]; ok && other != m.Type {
+			return nil, fmt.Errorf("series %s is both a gauge and a counter, select one with %s", s.key, typeLabel)
+		}
+		types[s.key] = m.Type
+		if m.Value != nil {
+			s.value = *m.Value
+		} else if m.Delta != nil {
+			s.value = float64(*m.Delta)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// listOptions переводит имя селектора и матчеры равенства в фильтры листинга. ok ложно,
+// если под селектор не может подойти ни одна серия.
+func listOptions(sel *VectorSelector) (opts storage.ListOptions, ok bool) {
+	opts.Name = sel.Name
+	for _, m := range sel.Matchers {
+		// Пустое значение совпадает и с отсутствующей меткой, поэтому такие матчеры проверяются после листинга
+		if m.Type != MatchEqual || m.Value == "" {
+			continue
+		}
+		switch m.Name {
+		case nameLabel:
+			if opts.Name != "" && opts.Name != m.Value {
+				return opts, false
+			}
+			opts.Name = m.Value
+		case typeLabel:
+			if m.Value != storage.Gauge && m.Value != storage.Counter || opts.Type != "" && opts.Type != m.Value {
+				return opts, false
+			}
+			opts.Type = m.Value
+		default:
+			if opts.Labels == nil {
+				opts.Labels = make(map[string]string)
+			}
+			if v, ok := opts.Labels[m.Name]; ok && v != m.Value {
+				return opts, false
+			}
+			opts.Labels[m.Name] = m.Value
+		}
+	}
+	return opts, true
+}
+
+func matchesAll(matchers []*Matcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Evaluator) evalSelector(sel *VectorSelector) (Value, error) {
+	selected, err := e.selectSeries(sel)
+	if err != nil {
+		return nil, err
+	}
+	vec := Vector{}
+	if e.Time.IsZero() {
+		for _, s := range selected {
+			vec = append(vec, Sample{Labels: s.labels, Value: s.value})
+		}
+		return sortVector(vec), nil
+	}
+
+	history, err := e.history(selected, e.Time.Add(-LookbackDelta), e.Time, storage.ResolutionRaw)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range selected {
+		if points := history[s.ref()]; len(points) > 0 {
+			vec = append(vec, Sample{Labels: s.labels, Value: points[len(points)-1].Value})
+		}
+	}
+	return sortVector(vec), nil
+}
+
+// ref возвращает ссылку на серию в хранилище.
+func (s series) ref() storage.MetricRef {
+	return storage.MetricRef{Type: s.metricType, Name: s.key}
+}
+
+// history читает точки истории всех серий selected одним обращением к хранилищу.
+// Удаленная во время вычисления серия не имеет точек.
+func (e *Evaluator) history(selected []series, from, to time.Time, res storage.Resolution) (map[storage.MetricRef][]storage.HistoryPoint, error) {
+	if len(selected) == 0 {
+		return nil, nil
+	}
+	refs := make([]storage.MetricRef, len(selected))
+	for i, s := range selected {
+		refs[i] = s.ref()
+	}
+	history, err := e.Source.GetHistories(refs, from, to, res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return history, nil
+}
+
+func (e *Evaluator) evalCall(call *Call) (Value, error) {
+	to := e.now()
+	from := to.Add(-call.Arg.Range)
+
+	selected, err := e.selectSeries(call.Arg.Selector)
+	if err != nil {
+		return nil, err
+	}
+	history, err := e.history(selected, from, to, storage.ResolutionAuto)
+	if err != nil {
+		return nil, err
+	}
+	vec := Vector{}
+	for _, s := range selected {
+		value, ok := applyFunction(call.Func, history[s.ref()])
+		if !ok {
+			continue
+		}
+		vec = append(vec, Sample{Labels: dropName(s.labels), Value: value})
+	}
+	return sortVector(vec), nil
+}
+
+// applyFunction вычисляет функцию по точкам истории. Точки агрегатов учитываются по их
+// min, max и avg; ok ложно, если точек недостаточно.
+func applyFunction(name string, points []storage.HistoryPoint) (value float64, ok bool) {
+	switch name {
+	case "rate", "increase":
+		if len(points) < 2 {
+			return 0, false
+		}
+		increase := 0.0
+		for i := 1; i < len(points); i++ {
+			// Уменьшение накопленного значения - сброс counter: отсчет начинается заново с нуля
+			if d := points[i].Value - points[i-1].Value; d >= 0 {
+				increase += d
+			} else {
+				increase += points[i].Value
+			}
+		}
+		if name == "increase" {
+			return increase, true
+		}
+		elapsed := points[len(points)-1].Timestamp.Sub(points[0].Timestamp).Seconds()
+		if elapsed <= 0 {
+			return 0, false
+		}
+		return increase / elapsed, true
+
+	case "avg_over_time":
+		if len(points) == 0 {
+			return 0, false
+		}
+		var sum float64
+		var count int64
+		for _, p := range points {
+			if p.Avg != nil {
+				sum += *p.Avg * float64(p.Count)
+				count += p.Count
+			} else {
+				sum += p.Value
+				count++
+			}
+		}
+		return sum / float64(count), true
+
+	case "min_over_time", "max_over_time":
+		if len(points) == 0 {
+			return 0, false
+		}
+		isMax := name == "max_over_time"
+		for i, p := range points {
+			v := p.Value
+			if isMax && p.Max != nil {
+				v = *p.Max
+			} else if !isMax && p.Min != nil {
+				v = *p.Min
+			}
+			if i == 0 || isMax && v > value || !isMax && v < value {
+				value = v
+			}
+		}
+		return value, true
+	}
+	return 0, false
+}
+
+func (e *Evaluator) evalAggregate(agg *Aggregate) (Value, error) {
+	v, err := e.Eval(agg.Expr)
+	if err != nil {
+		return nil, err
+	}
+	vec, ok := v.(Vector)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a vector, got %s", agg.Op, v.Type())
+	}
+
+	type group struct {
+		labels map[string]string
+		values []float64
+	}
+	groups := make(map[string]*group)
+	for _, s := range vec {
+		labels := make(map[string]string, len(agg.Grouping))
+		for _, l := range agg.Grouping {
+			if value, ok := s.Labels[l]; ok && value != "" {
+				labels[l] = value
+			}
+		}
+		sig := signature(labels)
+		g, ok := groups[sig]
+		if !ok {
+			g = &group{labels: labels}
+			groups[sig] = g
+		}
+		g.values = append(g.values, s.Value)
+	}
+
+	result := Vector{}
+	for _, g := range groups {
+		result = append(result, Sample{Labels: g.labels, Value: aggregate(agg.Op, g.values)})
+	}
+	return sortVector(result), nil
+}
+
+// aggregate применяет оператор агрегации к непустому набору значений.
+func aggregate(op string, values []float64) float64 {
+	switch op {
+	case "count":
+		return float64(len(values))
+	case "min", "max":
+		result := values[0]
+		for _, v := range values[1:] {
+			if op == "min" && v < result || op == "max" && v > result {
+				result = v
+			}
+		}
+		return result
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	if op == "avg" {
+		return sum / float64(len(values))
+	}
+	return sum
+}
+
+func (e *Evaluator) evalBinary(b *BinaryExpr) (Value, error) {
+	lhs, err := e.Eval(b.LHS)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := e.Eval(b.RHS)
+	if err != nil {
+		return nil, err
+	}
+
+	switch l := lhs.(type) {
+	case Scalar:
+		if r, ok := rhs.(Scalar); ok {
+			return Scalar(arith(b.Op, float64(l), float64(r))), nil
+		}
+		vec := Vector{}
+		for _, s := range rhs.(Vector) {
+			vec = append(vec, Sample{Labels: dropName(s.Labels), Value: arith(b.Op, float64(l), s.Value)})
+		}
+		return sortVector(vec), nil
+
+	case Vector:
+		if r, ok := rhs.(Scalar); ok {
+			vec := Vector{}
+			for _, s := range l {
+				vec = append(vec, Sample{Labels: dropName(s.Labels), Value: arith(b.Op, s.Value, float64(r))})
+			}
+			return sortVector(vec), nil
+		}
+		return vectorArith(b.Op, l, rhs.(Vector))
+	}
+	return nil, fmt.Errorf("unsupported operands for %s", b.Op)
+}
+
+// vectorArith сопоставляет серии один к одному по меткам без __name__ и применяет операцию к парам.
+// Серии без пары не попадают в результат.
+func vectorArith(op string, lhs, rhs Vector) (Vector, error) {
+	right := make(map[string]Sample, len(rhs))
+	for _, s := range rhs {
+		sig := signature(dropName(s.Labels))
+		if _, ok := right[sig]; ok {
+			return nil, fmt.Errorf("many-to-many matching not allowed: duplicate series %s on the right side of %s", sig, op)
+		}
+		right[sig] = s
+	}
+
+	seen := make(map[string]bool, len(lhs))
+	vec := Vector{}
+	for _, s := range lhs {
+		labels := dropName(s.Labels)
+		sig := signature(labels)
+		if seen[sig] {
+			return nil, fmt.Errorf("many-to-many matching not allowed: duplicate series %s on the left side of %s", sig, op)
+		}
+		seen[sig] = true
+		if r, ok := right[sig]; ok {
+			vec = append(vec, Sample{Labels: labels, Value: arith(op, s.Value, r.Value)})
+		}
+	}
+	return sortVector(vec), nil
+}
+
+// arith применяет арифметическую операцию. Деление на ноль дает ±Inf или NaN.
+func arith(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	}
+	return math.NaN()
+}
+
+// dropName возвращает копию меток без __name__.
+func dropName(labels map[string]string) map[string]string {
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k != nameLabel {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// signature возвращает метки в каноническом виде {k1="v1",k2="v2"}.
+func signature(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "{}"
+	}
+	return storage.SeriesKey("", labels)
+}
+
+// sortVector упорядочивает серии по именам и меткам.
+func sortVector(vec Vector) Vector {
+	sort.Slice(vec, func(i, j int) bool {
+		ni, nj := vec[i].Labels[nameLabel], vec[j].Labels[nameLabel]
+		if ni != nj {
+			return ni < nj
+		}
+		return signature(dropName(vec[i].Labels)) < signature(dropName(vec[j].Labels))
+	})
+	return vec
+}
@@ -0,0 +1,185 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var evalStart = time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+// newTestStorage сохраняет пять сэмплов по минуте для двух хостов: counter requests с приращениями
+// 10 и 5 и gauge Alloc со значениями 1..5 и 10..50.
+func newTestStorage(t *testing.T) *storage.MemStorage {
+	s := storage.NewMemStorage("")
+	for i := 0; i < 5; i++ {
+		ts := evalStart.Add(time.Duration(i) * time.Minute)
+		deltaA, deltaB := int64(10), int64(5)
+		allocA, allocB := float64(i+1), float64(10*(i+1))
+		require.NoError(t, s.UpdateMetricsBatch([]storage.Metrics{
+			{ID: "requests", MType: storage.Counter, Delta: &deltaA, Labels: map[string]string{"host": "a"}, Timestamp: &ts},
+			{ID: "requests", MType: storage.Counter, Delta: &deltaB, Labels: map[string]string{"host": "b"}, Timestamp: &ts},
+			{ID: "Alloc", MType: storage.Gauge, Value: &allocA, Labels: map[string]string{"host": "a"}, Timestamp: &ts},
+			{ID: "Alloc", MType: storage.Gauge, Value: &allocB, Labels: map[string]string{"host": "b"}, Timestamp: &ts},
+		}))
+	}
+	return s
+}
+
+// countingSource считает обращения к хранилищу.
+type countingSource struct {
+	*storage.MemStorage
+	lists, histories int
+	opts             []storage.ListOptions
+}
+
+func (s *countingSource) ListMetrics(opts storage.ListOptions) (storage.MetricPage, error) {
+	s.lists++
+	s.opts = append(s.opts, opts)
+	return s.MemStorage.ListMetrics(opts)
+}
+
+func (s *countingSource) GetHistories(refs []storage.MetricRef, from, to time.Time, res storage.Resolution) (map[storage.MetricRef][]storage.HistoryPoint, error) {
+	s.histories++
+	return s.MemStorage.GetHistories(refs, from, to, res)
+}
+
+func eval(t *testing.T, src Source, at time.Time, q string) Value {
+	expr, err := Parse(q)
+	require.NoError(t, err)
+	v, err := (&Evaluator{Source: src, Time: at}).Eval(expr)
+	require.NoError(t, err, q)
+	return v
+}
+
+func hostSample(host string, value float64) Sample {
+	return Sample{Labels: map[string]string{"host": host}, Value: value}
+}
+
+func TestEvaluator_Eval(t *testing.T) {
+	s := newTestStorage(t)
+	at := evalStart.Add(4 * time.Minute)
+
+	tests := []struct {
+		query string
+		want  Value
+	}{
+		{`Alloc{host="a"}`, Vector{{Labels: map[string]string{"__name__": "Alloc", "host": "a"}, Value: 5}}},
+		{`{__name__=~"Alloc|requests", host="b"}`, Vector{
+			{Labels: map[string]string{"__name__": "Alloc", "host": "b"}, Value: 50},
+			{Labels: map[string]string{"__name__": "requests", "host": "b"}, Value: 25},
+		}},
+		{"increase(requests[5m])", Vector{hostSample("a", 40), hostSample("b", 20)}},
+		{"rate(requests[5m])", Vector{hostSample("a", 40.0/240), hostSample("b", 20.0/240)}},
+		{"avg_over_time(Alloc[5m])", Vector{hostSample("a", 3), hostSample("b", 30)}},
+		{"min_over_time(Alloc[2m])", Vector{hostSample("a", 3), hostSample("b", 30)}},
+		{"max_over_time(Alloc[5m])", Vector{hostSample("a", 5), hostSample("b", 50)}},
+		{"sum(increase(requests[5m]))", Vector{{Labels: map[string]string{}, Value: 60}}},
+		{"sum by (host) (Alloc)", Vector{hostSample("a", 5), hostSample("b", 50)}},
+		{"count(Alloc) by (missing)", Vector{{Labels: map[string]string{}, Value: 2}}},
+		{"max(Alloc) - min(Alloc)", Vector{{Labels: map[string]string{}, Value: 45}}},
+		{"Alloc / requests", Vector{hostSample("a", 0.1), hostSample("b", 2)}},
+		{`Alloc{host="a"} * 2 + 1`, Vector{hostSample("a", 11)}},
+		{"1 + 2 * 3", Scalar(7)},
+		{"rate(missing[5m])", Vector{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.want, eval(t, s, at, tt.query))
+		})
+	}
+}
+
+func TestEvaluator_EvalInstant(t *testing.T) {
+	s := newTestStorage(t)
+
+	// Без момента вычисления селектор берет последние сохраненные значения
+	assert.Equal(t, Vector{hostSample("a", 50)}, eval(t, s, time.Time{}, `requests{host="a"} - 0`))
+
+	// На заданный момент - последнюю точку истории не старше LookbackDelta
+	assert.Equal(t, Vector{hostSample("a", 20)}, eval(t, s, evalStart.Add(time.Minute+30*time.Second), `requests{host="a"} - 0`))
+	assert.Equal(t, Vector{}, eval(t, s, evalStart.Add(-time.Minute), "Alloc"))
+	assert.Equal(t, Vector{}, eval(t, s, evalStart.Add(10*time.Minute), "Alloc"))
+}
+
+func TestEvaluator_EvalRollups(t *testing.T) {
+	s := newTestStorage(t)
+	require.NoError(t, s.Rollup(evalStart.Add(time.Hour)))
+	at := evalStart.Add(time.Hour)
+
+	// Интервал в сутки читается из минутных агрегатов
+	assert.Equal(t, Vector{hostSample("a", 3), hostSample("b", 30)}, eval(t, s, at, "avg_over_time(Alloc[1d])"))
+	assert.Equal(t, Vector{hostSample("a", 1), hostSample("b", 10)}, eval(t, s, at, "min_over_time(Alloc[1d])"))
+	assert.Equal(t, Vector{hostSample("a", 40), hostSample("b", 20)}, eval(t, s, at, "increase(requests[1d])"))
+}
+
+func TestEvaluator_EvalBatched(t *testing.T) {
+	s := &countingSource{MemStorage: newTestStorage(t)}
+	at := evalStart.Add(4 * time.Minute)
+
+	// Селектор читает список серий и их историю по одному разу; имя и метки фильтрует хранилище
+	assert.Equal(t, Vector{hostSample("a", 40)}, eval(t, s, at, `increase(requests{host="a", __type__="counter"}[5m])`))
+	assert.Equal(t, 1, s.lists)
+	assert.Equal(t, 1, s.histories)
+	assert.Equal(t, []storage.ListOptions{{Type: storage.Counter, Name: "requests", Labels: map[string]string{"host": "a"}}}, s.opts)
+
+	assert.Equal(t, Vector{hostSample("a", 40), hostSample("b", 20)}, eval(t, s, at, `increase({__name__="requests"}[5m])`))
+	assert.Equal(t, 2, s.histories)
+
+	// Противоречивые матчеры равенства не обращаются к хранилищу
+	assert.Equal(t, Vector{}, eval(t, s, at, `Alloc{__name__="requests"}`))
+	assert.Equal(t, Vector{}, eval(t, s, at, `Alloc{__type__="set"}`))
+	assert.Equal(t, 2, s.lists)
+}
+
+func TestEvaluator_EvalTypeAmbiguity(t *testing.T) {
+	s := newTestStorage(t)
+	require.NoError(t, s.SaveGaugeMetric(`requests{host="a"}`, 7))
+	at := evalStart.Add(4 * time.Minute)
+
+	// Gauge и counter с одинаковым ключом серии не смешиваются в один результат
+	expr, err := Parse(`requests{host="a"}`)
+	require.NoError(t, err)
+	_, err = (&Evaluator{Source: s}).Eval(expr)
+	assert.ErrorContains(t, err, "__type__")
+
+	assert.Equal(t, Vector{hostSample("a", 7)}, eval(t, s, time.Time{}, `requests{host="a", __type__="gauge"} - 0`))
+	assert.Equal(t, Vector{hostSample("a", 40)}, eval(t, s, at, `increase(requests{__type__!="gauge", host="a"}[5m])`))
+}
+
+func TestEvaluator_EvalErrors(t *testing.T) {
+	s := newTestStorage(t)
+	at := evalStart.Add(4 * time.Minute)
+
+	for _, q := range []string{
+		"sum(1)",
+		`Alloc + {__name__=~"Alloc|requests"}`,
+	} {
+		expr, err := Parse(q)
+		require.NoError(t, err)
+		_, err = (&Evaluator{Source: s, Time: at}).Eval(expr)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestApplyFunction_CounterReset(t *testing.T) {
+	var points []storage.HistoryPoint
+	for i, v := range []float64{10, 20, 5, 20} {
+		points = append(points, storage.HistoryPoint{Timestamp: evalStart.Add(time.Duration(i) * 10 * time.Second), Value: v})
+	}
+
+	increase, ok := applyFunction("increase", points)
+	require.True(t, ok)
+	assert.Equal(t, 30.0, increase)
+
+	rate, ok := applyFunction("rate", points)
+	require.True(t, ok)
+	assert.Equal(t, 1.0, rate)
+
+	_, ok = applyFunction("rate", points[:1])
+	assert.False(t, ok)
+}
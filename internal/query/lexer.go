@@ -0,0 +1,189 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind - вид лексемы.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenDuration
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenLBrace
+	tokenRBrace
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+	tokenAdd
+	tokenSub
+	tokenMul
+	tokenDiv
+	tokenEq       // =
+	tokenNeq      // !=
+	tokenRegex    // =~
+	tokenNotRegex // !~
+)
+
+// token - лексема и ее позиция в запросе (смещение в байтах).
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Error - ошибка разбора запроса с позицией в байтах.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("parse error at position %d: %s", e.Pos, e.Msg)
+}
+
+// lex разбивает запрос на лексемы. Последняя лексема - tokenEOF.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isIdentStart(c):
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, input[start:i], start})
+			continue
+		case isDigit(c) || c == '.' && i+1 < len(input) && isDigit(input[i+1]):
+			t, n := lexNumber(input, i)
+			tokens = append(tokens, t)
+			i = n
+			continue
+		case c == '"' || c == '\'':
+			t, n, err := lexString(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i = n
+			continue
+		}
+
+		kind, width := tokenEOF, 1
+		switch c {
+		case '(':
+			kind = tokenLParen
+		case ')':
+			kind = tokenRParen
+		case '{':
+			kind = tokenLBrace
+		case '}':
+			kind = tokenRBrace
+		case '[':
+			kind = tokenLBracket
+		case ']':
+			kind = tokenRBracket
+		case ',':
+			kind = tokenComma
+		case '+':
+			kind = tokenAdd
+		case '-':
+			kind = tokenSub
+		case '*':
+			kind = tokenMul
+		case '/':
+			kind = tokenDiv
+		case '=':
+			kind = tokenEq
+			if strings.HasPrefix(input[i:], "=~") {
+				kind, width = tokenRegex, 2
+			}
+		case '!':
+			switch {
+			case strings.HasPrefix(input[i:], "!="):
+				kind, width = tokenNeq, 2
+			case strings.HasPrefix(input[i:], "!~"):
+				kind, width = tokenNotRegex, 2
+			default:
+				return nil, &Error{Pos: i, Msg: "unexpected character '!'"}
+			}
+		default:
+			return nil, &Error{Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+		tokens = append(tokens, token{kind, input[i : i+width], i})
+		i += width
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+// lexNumber читает число (в том числе с экспонентой) или длительность вида 5m, 1h30m.
+func lexNumber(input string, start int) (token, int) {
+	i := start
+	for i < len(input) && (isDigit(input[i]) || input[i] == '.') {
+		i++
+	}
+	// Экспонента: за e следует цифра или знак с цифрой
+	if i < len(input) && (input[i] == 'e' || input[i] == 'E') {
+		j := i + 1
+		if j < len(input) && (input[j] == '+' || input[j] == '-') {
+			j++
+		}
+		if j < len(input) && isDigit(input[j]) {
+			i = j
+			for i < len(input) && isDigit(input[i]) {
+				i++
+			}
+			return token{tokenNumber, input[start:i], start}, i
+		}
+	}
+	if i < len(input) && unicode.IsLetter(rune(input[i])) {
+		for i < len(input) && (isDigit(input[i]) || unicode.IsLetter(rune(input[i]))) {
+			i++
+		}
+		return token{tokenDuration, input[start:i], start}, i
+	}
+	return token{tokenNumber, input[start:i], start}, i
+}
+
+// lexString читает строку в двойных или одинарных кавычках с экранированием как в Go.
+func lexString(input string, start int) (token, int, error) {
+	quote := input[start]
+	var b strings.Builder
+	for s := input[start+1:]; len(s) > 0; {
+		if s[0] == quote {
+			return token{tokenString, b.String(), start}, len(input) - len(s) + 1, nil
+		}
+		r, _, tail, err := strconv.UnquoteChar(s, quote)
+		if err != nil {
+			return token{}, 0, &Error{Pos: start, Msg: "invalid string literal"}
+		}
+		b.WriteRune(r)
+		s = tail
+	}
+	return token{}, 0, &Error{Pos: start, Msg: "unterminated string literal"}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isIdentChar допускает в именах точку и двоеточие: имена Graphite и StatsD содержат точки.
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.' || c == ':'
+}
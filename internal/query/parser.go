@@ -0,0 +1,508 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr - узел дерева разбора запроса.
+type Expr interface {
+	String() string
+}
+
+// NumberLiteral - числовая константа.
+type NumberLiteral struct {
+	Value float64
+}
+
+// VectorSelector выбирает серии по имени и меткам. Имя метрики доступно матчерам как метка __name__.
+type VectorSelector struct {
+	Name     string // пустое, если имя задано только матчером __name__
+	Matchers []*Matcher
+}
+
+// MatrixSelector выбирает точки истории серий за интервал Range до момента вычисления.
+type MatrixSelector struct {
+	Selector *VectorSelector
+	Range    time.Duration
+}
+
+// Call - вызов функции над точками истории: rate, increase, avg_over_time, min_over_time или max_over_time.
+type Call struct {
+	Func string
+	Arg  *MatrixSelector
+}
+
+// Aggregate - агрегация sum, avg, min, max или count с группировкой по меткам Grouping.
+type Aggregate struct {
+	Op       string
+	Grouping []string
+	Expr     Expr
+}
+
+// BinaryExpr - арифметическая операция +, -, * или / между числами и (или) наборами серий.
+type BinaryExpr struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+// MatchType - вид сравнения метки в матчере.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher сравнивает значение метки Name со значением Value. Регулярное выражение должно
+// совпадать со значением целиком; отсутствующая метка считается пустой.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+
+	re *regexp.Regexp
+}
+
+// Matches проверяет значение метки.
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+// functions - функции над точками истории.
+var functions = map[string]bool{
+	"rate":          true,
+	"increase":      true,
+	"avg_over_time": true,
+	"min_over_time": true,
+	"max_over_time": true,
+}
+
+// aggregations - операторы агрегации.
+var aggregations = map[string]bool{
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"count": true,
+}
+
+// Parse разбирает запрос. Грамматика - подмножество PromQL:
+//
+//	expr      = term { ("+" | "-") term }
+//	term      = unary { ("*" | "/") unary }
+//	unary     = "-" unary | primary
+//	primary   = number | "(" expr ")" | aggregate | call | selector
+//	aggregate = op [grouping] "(" expr ")" [grouping]
+//	grouping  = "by" "(" label { "," label } ")"
+//	call      = func "(" selector "[" duration "]" ")"
+//	selector  = name [ "{" matcher { "," matcher } "}" ] | "{" matcher { "," matcher } "}"
+//	matcher   = label ("=" | "!=" | "=~" | "!~") string
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &Error{Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expect читает лексему вида kind или возвращает ошибку с описанием what.
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		if t.kind == tokenEOF {
+			return t, p.errorf(t, "expected %s, got end of query", what)
+		}
+		return t, p.errorf(t, "expected %s, got %q", what, t.text)
+	}
+	return t, nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	lhs, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for k := p.peek().kind; k == tokenAdd || k == tokenSub; k = p.peek().kind {
+		op := p.next().text
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *parser) parseTerm() (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for k := p.peek().kind; k == tokenMul || k == tokenDiv; k = p.peek().kind {
+		op := p.next().text
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peek().kind != tokenSub {
+		return p.parsePrimary()
+	}
+	p.next()
+	expr, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := expr.(*NumberLiteral); ok {
+		return &NumberLiteral{Value: -n.Value}, nil
+	}
+	return &BinaryExpr{Op: "*", LHS: &NumberLiteral{Value: -1}, RHS: expr}, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.text)
+		}
+		return &NumberLiteral{Value: v}, nil
+
+	case tokenLParen:
+		p.next()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return expr, nil
+
+	case tokenLBrace:
+		return p.parseSelector()
+
+	case tokenIdent:
+		following := p.tokens[p.pos+1].kind
+		switch {
+		case aggregations[t.text] && (following == tokenLParen || following == tokenIdent && p.tokens[p.pos+1].text == "by"):
+			return p.parseAggregate()
+		case functions[t.text] && following == tokenLParen:
+			return p.parseCall()
+		}
+		return p.parseSelector()
+
+	case tokenEOF:
+		return nil, p.errorf(t, "unexpected end of query")
+	default:
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+}
+
+func (p *parser) parseAggregate() (Expr, error) {
+	agg := &Aggregate{Op: p.next().text}
+	if p.peek().kind == tokenIdent && p.peek().text == "by" {
+		grouping, err := p.parseGrouping()
+		if err != nil {
+			return nil, err
+		}
+		agg.Grouping = grouping
+	}
+
+	if _, err := p.expect(tokenLParen, `"("`); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	agg.Expr = expr
+	if _, err := p.expect(tokenRParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == tokenIdent && p.peek().text == "by" {
+		if agg.Grouping != nil {
+			return nil, p.errorf(p.peek(), "duplicate by clause")
+		}
+		grouping, err := p.parseGrouping()
+		if err != nil {
+			return nil, err
+		}
+		agg.Grouping = grouping
+	}
+	return agg, nil
+}
+
+func (p *parser) parseGrouping() ([]string, error) {
+	p.next() // by
+	if _, err := p.expect(tokenLParen, `"(" after by`); err != nil {
+		return nil, err
+	}
+	grouping := []string{}
+	for p.peek().kind != tokenRParen {
+		label, err := p.expect(tokenIdent, "label name")
+		if err != nil {
+			return nil, err
+		}
+		grouping = append(grouping, label.text)
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokenRParen, `")"`); err != nil {
+		return nil, err
+	}
+	return grouping, nil
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	call := &Call{Func: p.next().text}
+	p.next() // (
+
+	selector, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenLBracket, fmt.Sprintf(`range selector in %s(), e.g. "[5m]"`, call.Func)); err != nil {
+		return nil, err
+	}
+	t, err := p.expect(tokenDuration, "duration")
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseDuration(t.text)
+	if err != nil {
+		return nil, p.errorf(t, "%v", err)
+	}
+	if _, err := p.expect(tokenRBracket, `"]"`); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenRParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	call.Arg = &MatrixSelector{Selector: selector.(*VectorSelector), Range: d}
+	return call, nil
+}
+
+func (p *parser) parseSelector() (Expr, error) {
+	sel := &VectorSelector{}
+	if p.peek().kind == tokenIdent {
+		sel.Name = p.next().text
+	}
+
+	if p.peek().kind == tokenLBrace {
+		p.next()
+		for p.peek().kind != tokenRBrace {
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, m)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokenRBrace, `"}"`); err != nil {
+			return nil, err
+		}
+	}
+
+	if sel.Name == "" && len(sel.Matchers) == 0 {
+		return nil, p.errorf(p.peek(), "expected metric name or label matchers")
+	}
+	if sel.Name == "" && !selectsSomething(sel.Matchers) {
+		return nil, p.errorf(p.peek(), "selector must contain at least one matcher that does not match the empty string")
+	}
+	return sel, nil
+}
+
+func (p *parser) parseMatcher() (*Matcher, error) {
+	name, err := p.expect(tokenIdent, "label name")
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	m := &Matcher{Name: name.text}
+	switch op.kind {
+	case tokenEq, tokenNeq, tokenRegex, tokenNotRegex:
+		m.Type = MatchType(op.text)
+	default:
+		return nil, p.errorf(op, "expected label match operator, got %q", op.text)
+	}
+	value, err := p.expect(tokenString, "label value string")
+	if err != nil {
+		return nil, err
+	}
+	m.Value = value.text
+
+	if m.Type == MatchRegexp || m.Type == MatchNotRegexp {
+		m.re, err = regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return nil, p.errorf(value, "invalid regular expression: %v", err)
+		}
+	}
+	return m, nil
+}
+
+// selectsSomething проверяет, что хотя бы один матчер не совпадает с пустой строкой:
+// иначе селектор без имени выбрал бы все серии.
+func selectsSomething(matchers []*Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches("") {
+			return true
+		}
+	}
+	return false
+}
+
+// durationUnits - единицы длительности в порядке убывания.
+var durationUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+}
+
+// parseDuration разбирает длительность вида 30s, 5m, 1h30m, 7d или 2w.
+func parseDuration(s string) (time.Duration, error) {
+	var total time.Duration
+	for rest := s; rest != ""; {
+		i := 0
+		for i < len(rest) && isDigit(rest[i]) {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest = rest[i:]
+
+		found := false
+		for _, u := range durationUnits {
+			if strings.HasPrefix(rest, u.suffix) && (u.suffix != "m" || !strings.HasPrefix(rest, "ms")) {
+				total += time.Duration(n) * u.unit
+				rest = rest[len(u.suffix):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid duration %q: unknown unit", s)
+		}
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("duration must be positive, got %q", s)
+	}
+	return total, nil
+}
+
+func (n *NumberLiteral) String() string {
+	return strconv.FormatFloat(n.Value, 'g', -1, 64)
+}
+
+func (s *VectorSelector) String() string {
+	if len(s.Matchers) == 0 {
+		return s.Name
+	}
+	matchers := make([]string, 0, len(s.Matchers))
+	for _, m := range s.Matchers {
+		matchers = append(matchers, m.Name+string(m.Type)+strconv.Quote(m.Value))
+	}
+	return s.Name + "{" + strings.Join(matchers, ",") + "}"
+}
+
+func (m *MatrixSelector) String() string {
+	return m.Selector.String() + "[" + formatDuration(m.Range) + "]"
+}
+
+func (c *Call) String() string {
+	return c.Func + "(" + c.Arg.String() + ")"
+}
+
+func (a *Aggregate) String() string {
+	s := a.Op
+	if a.Grouping != nil {
+		s += " by (" + strings.Join(a.Grouping, ", ") + ")"
+	}
+	return s + " (" + a.Expr.String() + ")"
+}
+
+func (b *BinaryExpr) String() string {
+	return "(" + b.LHS.String() + " " + b.Op + " " + b.RHS.String() + ")"
+}
+
+// formatDuration выводит длительность в единицах durationUnits, например 1h30m.
+func formatDuration(d time.Duration) string {
+	var b strings.Builder
+	for _, u := range durationUnits {
+		if n := d / u.unit; n > 0 {
+			b.WriteString(strconv.FormatInt(int64(n), 10) + u.suffix)
+			d -= n * u.unit
+		}
+	}
+	return b.String()
+}
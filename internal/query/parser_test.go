@@ -0,0 +1,113 @@
+package query
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"selector", "HeapAlloc", "HeapAlloc"},
+		{"selector with matchers", `http_requests{host="a", code!~'5..'}`, `http_requests{host="a",code!~"5.."}`},
+		{"name matcher", `{__name__=~"Heap.*"}`, `{__name__=~"Heap.*"}`},
+		{"dotted name", "app.requests", "app.requests"},
+		{"rate", "rate(PollCount[5m])", "rate(PollCount[5m])"},
+		{"compound duration", "avg_over_time(Alloc[1h30m])", "avg_over_time(Alloc[1h30m])"},
+		{"sum by before", "sum by (host) (rate(requests[1m]))", "sum by (host) (rate(requests[1m]))"},
+		{"sum by after", "sum(rate(requests[1m])) by (host, code)", "sum by (host, code) (rate(requests[1m]))"},
+		{"sum without grouping", "sum(requests)", "sum (requests)"},
+		{"precedence", "a + b * 2", "(a + (b * 2))"},
+		{"left associativity", "a - b - c", "((a - b) - c)"},
+		{"parentheses", "(a + b) / 1e3", "((a + b) / 1000)"},
+		{"unary minus", "-a + -2", "((-1 * a) + -2)"},
+		{"function named metric", "rate", "rate"},
+		{"aggregation named metric", "sum + count", "(sum + count)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParse_Call(t *testing.T) {
+	expr, err := Parse(`increase(requests{code="200"}[2d])`)
+	require.NoError(t, err)
+
+	call, ok := expr.(*Call)
+	require.True(t, ok)
+	assert.Equal(t, "increase", call.Func)
+	assert.Equal(t, 48*time.Hour, call.Arg.Range)
+	assert.Equal(t, "requests", call.Arg.Selector.Name)
+	require.Len(t, call.Arg.Selector.Matchers, 1)
+	assert.Equal(t, &Matcher{Name: "code", Type: MatchEqual, Value: "200"}, call.Arg.Selector.Matchers[0])
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		pos   int
+	}{
+		{"empty", "", 0},
+		{"unbalanced parenthesis", "(a + b", 6},
+		{"trailing token", "a b", 2},
+		{"missing range", "rate(requests)", 13},
+		{"range without function", "requests[5m]", 8},
+		{"bad duration unit", "rate(requests[5y])", 14},
+		{"zero duration", "rate(requests[0s])", 14},
+		{"missing operand", "a +", 3},
+		{"bad matcher operator", `a{b>"c"}`, 3},
+		{"unquoted label value", "a{b=c}", 4},
+		{"invalid regexp", `a{b=~"("}`, 5},
+		{"empty selector", "{}", 2},
+		{"selector matching everything", `{host=~".*"}`, 12},
+		{"unterminated string", `a{b="c}`, 4},
+		{"unexpected character", "a % b", 2},
+		{"duplicate by", "sum by (a) (x) by (b)", 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var parseErr *Error
+			require.True(t, errors.As(err, &parseErr), "got %v", err)
+			assert.Equal(t, tt.pos, parseErr.Pos, parseErr.Error())
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30s":   30 * time.Second,
+		"5m":    5 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"7d":    7 * 24 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+		"500ms": 500 * time.Millisecond,
+	}
+	for s, want := range tests {
+		got, err := parseDuration(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+		roundTrip, err := parseDuration(formatDuration(got))
+		require.NoError(t, err, s)
+		assert.Equal(t, got, roundTrip, s)
+	}
+
+	for _, s := range []string{"5", "m", "5x", "0m"} {
+		_, err := parseDuration(s)
+		assert.Error(t, err, s)
+	}
+}
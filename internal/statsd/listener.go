@@ -0,0 +1,155 @@
+package statsd
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+)
+
+// maxPacketSize - максимальный размер UDP-датаграммы.
+const maxPacketSize = 65535
+
+// TimingCountSuffix добавляется к имени таймера для счетчика количества измерений.
+const TimingCountSuffix = "_count"
+
+// Listener принимает метрики в формате StatsD по UDP и сохраняет их в хранилище.
+type Listener struct {
+	Addr    string          // адрес для прослушивания, например ":8125"
+	Storage storage.Storage // хранилище метрик
+
+	mu      sync.Mutex
+	conn    net.PacketConn
+	serving bool
+	done    chan struct{}
+}
+
+// NewListener - конструктор для Listener
+func NewListener(addr string, s storage.Storage) *Listener {
+	return &Listener{
+		Addr:    addr,
+		Storage: s,
+	}
+}
+
+// Listen открывает UDP-сокет. После успешного вызова адрес доступен через LocalAddr.
+func (l *Listener) Listen() error {
+	conn, err := net.ListenPacket("udp", l.Addr)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.conn = conn
+	l.done = make(chan struct{})
+	l.mu.Unlock()
+	return nil
+}
+
+// LocalAddr возвращает фактический адрес открытого сокета.
+func (l *Listener) LocalAddr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	return l.conn.LocalAddr()
+}
+
+// Serve читает датаграммы до закрытия слушателя.
+// Возвращает nil после вызова Close.
+func (l *Listener) Serve() error {
+	l.mu.Lock()
+	conn, done := l.conn, l.done
+	l.serving = conn != nil
+	l.mu.Unlock()
+	if conn == nil {
+		return errors.New("statsd listener is not started")
+	}
+	defer close(done)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		l.HandlePacket(buf[:n])
+	}
+}
+
+// ListenAndServe открывает сокет и обрабатывает входящие пакеты.
+func (l *Listener) ListenAndServe() error {
+	if err := l.Listen(); err != nil {
+		return err
+	}
+	return l.Serve()
+}
+
+// Close закрывает сокет и дожидается завершения Serve.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	conn, done, serving := l.conn, l.done, l.serving
+	l.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+
+	err := conn.Close()
+	if serving {
+		<-done
+	}
+	return err
+}
+
+// HandlePacket разбирает пакет, который может содержать несколько строк, разделенных '\n',
+// и сохраняет метрики. Некорректные строки пропускаются.
+func (l *Listener) HandlePacket(packet []byte) {
+	for _, line := range strings.Split(string(packet), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		sample, err := ParseLine(line)
+		if err != nil {
+			log.Printf("Error parsing statsd line: %v", err)
+			continue
+		}
+
+		if err := l.apply(sample); err != nil {
+			log.Printf("Error saving statsd metric %s: %v", sample.Name, err)
+		}
+	}
+}
+
+// apply сохраняет одно измерение в хранилище.
+// Счетчики масштабируются с учетом частоты семплирования, таймеры сохраняются как gauge
+// с последним значением и счетчик количества измерений с суффиксом TimingCountSuffix.
+func (l *Listener) apply(s Sample) error {
+	switch s.Type {
+	case TypeCounter:
+		return l.Storage.SaveCounterMetric(s.Name, int64(math.Round(s.Value/s.Rate)))
+	case TypeGauge:
+		value := s.Value
+		if s.Relative {
+			if current, err := l.Storage.GetGaugeMetric(s.Name); err == nil {
+				value += current
+			}
+		}
+		return l.Storage.SaveGaugeMetric(s.Name, value)
+	case TypeTiming, TypeHist:
+		if err := l.Storage.SaveGaugeMetric(s.Name, s.Value); err != nil {
+			return err
+		}
+		return l.Storage.SaveCounterMetric(s.Name+TimingCountSuffix, int64(math.Round(1/s.Rate)))
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,60 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/25x8/metric-gathering/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListener_HandlePacket(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	l := NewListener("", memStorage)
+
+	l.HandlePacket([]byte("hits:1|c\nhits:1|c|@0.25\nqueue:10|g\nqueue:-3|g\nlatency:12.5|ms|@0.5\nbroken line\n"))
+
+	hits, err := memStorage.GetCounterMetric("hits")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), hits)
+
+	queue, err := memStorage.GetGaugeMetric("queue")
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, queue)
+
+	latency, err := memStorage.GetGaugeMetric("latency")
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, latency)
+
+	latencyCount, err := memStorage.GetCounterMetric("latency" + TimingCountSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), latencyCount)
+}
+
+func TestListener_Serve(t *testing.T) {
+	memStorage := storage.NewMemStorage("")
+	l := NewListener("127.0.0.1:0", memStorage)
+	require.NoError(t, l.Listen())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- l.Serve()
+	}()
+
+	conn, err := net.Dial("udp", l.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("requests:3|c"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		value, err := memStorage.GetCounterMetric("requests")
+		return err == nil && value == 3
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, l.Close())
+	assert.NoError(t, <-served)
+}
@@ -0,0 +1,78 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Типы метрик протокола StatsD, которые поддерживает сервер.
+const (
+	TypeCounter = "c"
+	TypeGauge   = "g"
+	TypeTiming  = "ms"
+	TypeHist    = "h"
+)
+
+// ErrInvalidLine возвращается, если строка не соответствует формату name:value|type[|@rate].
+var ErrInvalidLine = errors.New("invalid statsd line")
+
+// Sample - разобранная строка протокола StatsD.
+type Sample struct {
+	Name     string  // имя метрики
+	Value    float64 // значение
+	Type     string  // c, g, ms или h
+	Rate     float64 // частота семплирования (1, если не указана)
+	Relative bool    // для gauge: значение со знаком изменяет текущее, а не заменяет его
+}
+
+// ParseLine разбирает одну строку формата name:value|type[|@rate][|#tags].
+// Теги DogStatsD допускаются, но игнорируются.
+func ParseLine(line string) (Sample, error) {
+	line = strings.TrimSpace(line)
+
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return Sample{}, fmt.Errorf("%w: %q", ErrInvalidLine, line)
+	}
+
+	s := Sample{Name: line[:colon], Rate: 1}
+
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 {
+		return Sample{}, fmt.Errorf("%w: %q", ErrInvalidLine, line)
+	}
+
+	rawValue := parts[0]
+	s.Type = parts[1]
+
+	switch s.Type {
+	case TypeCounter, TypeGauge, TypeTiming, TypeHist:
+	default:
+		return Sample{}, fmt.Errorf("%w: unsupported type %q", ErrInvalidLine, s.Type)
+	}
+
+	if s.Type == TypeGauge && (strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")) {
+		s.Relative = true
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("%w: bad value %q", ErrInvalidLine, rawValue)
+	}
+	s.Value = value
+
+	for _, part := range parts[2:] {
+		if !strings.HasPrefix(part, "@") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(part[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return Sample{}, fmt.Errorf("%w: bad sample rate %q", ErrInvalidLine, part)
+		}
+		s.Rate = rate
+	}
+
+	return s, nil
+}
@@ -0,0 +1,70 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Sample
+	}{
+		{
+			name: "Counter",
+			line: "requests:1|c",
+			want: Sample{Name: "requests", Value: 1, Type: TypeCounter, Rate: 1},
+		},
+		{
+			name: "Counter with sample rate",
+			line: "requests:2|c|@0.1",
+			want: Sample{Name: "requests", Value: 2, Type: TypeCounter, Rate: 0.1},
+		},
+		{
+			name: "Gauge",
+			line: "temperature:3.2|g",
+			want: Sample{Name: "temperature", Value: 3.2, Type: TypeGauge, Rate: 1},
+		},
+		{
+			name: "Relative gauge",
+			line: "queue:-4|g",
+			want: Sample{Name: "queue", Value: -4, Type: TypeGauge, Rate: 1, Relative: true},
+		},
+		{
+			name: "Timing with tags",
+			line: "db.query:320|ms|@0.5|#host:a",
+			want: Sample{Name: "db.query", Value: 320, Type: TypeTiming, Rate: 0.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseLine_Invalid(t *testing.T) {
+	lines := []string{
+		"",
+		"no-value",
+		":1|c",
+		"name:1",
+		"name:abc|c",
+		"name:1|s",
+		"name:1|c|@0",
+		"name:1|c|@2",
+	}
+
+	for _, line := range lines {
+		t.Run(line, func(t *testing.T) {
+			_, err := ParseLine(line)
+			assert.ErrorIs(t, err, ErrInvalidLine)
+		})
+	}
+}
@@ -3,22 +3,30 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/pressly/goose/v3"
 )
 
 const (
-	Gauge   = "gauge"
-	Counter = "counter"
+	Gauge     = "gauge"
+	Counter   = "counter"
+	Histogram = "histogram"
+	Summary   = "summary"
+	Set       = "set"
 
 	// Константы для повторения операций при ошибках базы данных
 	maxRetries        = 4
@@ -30,7 +38,13 @@ const (
 var gooseUp = goose.Up
 
 type DBStorage struct {
-	db *sql.DB
+	changeHooks
+	OutOfOrder OutOfOrderPolicy // обработка gauge-сэмплов старше сохраненного значения
+	Retention  RetentionPolicy  // сроки хранения истории и устаревших gauge (см. ApplyRetention)
+
+	db              *sql.DB
+	rollupMu        sync.Mutex
+	rollupWatermark time.Time // граница сэмплов, агрегированных предыдущим вызовом Rollup
 }
 
 func (s *DBStorage) DB() *sql.DB {
@@ -71,185 +85,1312 @@ func NewDBStorage(db *sql.DB) (*DBStorage, error) {
 }
 
 func (s *DBStorage) SaveGaugeMetric(name string, value float64) error {
-	query := `INSERT INTO gauges (name, value) VALUES ($1, $2)
-              ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;`
-
-	ctx := context.Background()
+	id, labels := ParseSeriesKey(name)
+	ts := sampleTime(nil)
 
-	return retryOperation(ctx, func() error {
-		_, err := s.db.Exec(query, name, value)
+	var applied bool
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		applied, err = s.upsertGauge(tx, id, labels, value, ts)
 		return err
 	})
+	if err != nil || !applied {
+		return err
+	}
+	s.notifyGauge(id, labels, value)
+	return nil
 }
 
 func (s *DBStorage) SaveCounterMetric(name string, delta int64) error {
-	query := `INSERT INTO counters (name, value) VALUES ($1, $2)
-              ON CONFLICT (name) DO UPDATE SET value = counters.value + EXCLUDED.value;`
+	id, labels := ParseSeriesKey(name)
+	ts := sampleTime(nil)
 
-	ctx := context.Background()
+	err := s.inTx(func(tx *sql.Tx) error {
+		return upsertCounter(tx, id, labels, delta, ts)
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyCounter(id, labels, delta)
+	return nil
+}
+
+// upsertGauge сохраняет gauge-сэмпл со временем ts в gauges и добавляет его в журнал gauge_samples.
+// Если политика OutOfOrder не accept, строка обновляется только сэмплом не старше сохраненного;
+// пропущенный сэмпл не попадает в журнал и возвращает false (или ErrOutOfOrder при политике reject).
+func (s *DBStorage) upsertGauge(tx *sql.Tx, name string, labels map[string]string, value float64, ts time.Time) (bool, error) {
+	query := `INSERT INTO gauges (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)
+              ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`
+	if s.OutOfOrder == OutOfOrderReject || s.OutOfOrder == OutOfOrderLatest {
+		query += ` WHERE gauges.updated_at IS NULL OR gauges.updated_at <= EXCLUDED.updated_at`
+	}
+
+	result, err := tx.Exec(query, name, labelsJSON(labels), value, ts)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		if s.OutOfOrder == OutOfOrderReject {
+			return false, ErrOutOfOrder
+		}
+		return false, nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO gauge_samples (name, labels, value, ts) VALUES ($1, $2, $3, $4)`,
+		name, labelsJSON(labels), value, ts)
+	return err == nil, err
+}
+
+// addGaugeTx изменяет gauge на delta, записывает новое значение в журнал gauge_samples и возвращает его.
+// Время строки gauges не уменьшается; политика OutOfOrder к относительному изменению не применяется.
+func addGaugeTx(tx *sql.Tx, name string, labels map[string]string, delta float64, ts time.Time) (float64, error) {
+	query := `INSERT INTO gauges (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)
+              ON CONFLICT (name, labels) DO UPDATE SET value = gauges.value + EXCLUDED.value,
+              updated_at = GREATEST(gauges.updated_at, EXCLUDED.updated_at)
+              RETURNING value;`
+	var value float64
+	if err := tx.QueryRow(query, name, labelsJSON(labels), delta, ts).Scan(&value); err != nil {
+		return 0, err
+	}
+
+	_, err := tx.Exec(`INSERT INTO gauge_samples (name, labels, value, ts) VALUES ($1, $2, $3, $4)`,
+		name, labelsJSON(labels), value, ts)
+	return value, err
+}
 
-	return retryOperation(ctx, func() error {
-		_, err := s.db.Exec(query, name, delta)
+// upsertCounter добавляет приращение counter и записывает в журнал counter_samples приращение
+// вместе с накопленным значением. Время строки counters не уменьшается при запоздавшем приращении.
+func upsertCounter(tx *sql.Tx, name string, labels map[string]string, delta int64, ts time.Time) error {
+	query := `INSERT INTO counters (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)
+              ON CONFLICT (name, labels) DO UPDATE SET value = counters.value + EXCLUDED.value,
+              updated_at = GREATEST(counters.updated_at, EXCLUDED.updated_at)
+              RETURNING value;`
+	var total int64
+	if err := tx.QueryRow(query, name, labelsJSON(labels), delta, ts).Scan(&total); err != nil {
 		return err
+	}
+
+	_, err := tx.Exec(`INSERT INTO counter_samples (name, labels, delta, value, ts) VALUES ($1, $2, $3, $4, $5)`,
+		name, labelsJSON(labels), delta, total, ts)
+	return err
+}
+
+func (s *DBStorage) SaveHistogramMetric(name string, h HistogramValue) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+
+	id, labels := ParseSeriesKey(name)
+	err := s.inTx(func(tx *sql.Tx) error {
+		return mergeValueTx(tx, "histograms", id, labels, h, sampleTime(nil), (*HistogramValue).Merge, jsonCodec[HistogramValue]())
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyHistogram(id, labels, h.Clone())
+	return nil
+}
+
+func (s *DBStorage) GetHistogramMetric(name string) (HistogramValue, error) {
+	return getValueMetric(s.db, "histograms", name, jsonCodec[HistogramValue]())
+}
+
+func (s *DBStorage) SaveSummaryMetric(name string, sv SummaryValue) error {
+	if err := sv.Validate(); err != nil {
+		return err
+	}
+
+	id, labels := ParseSeriesKey(name)
+	err := s.inTx(func(tx *sql.Tx) error {
+		return mergeValueTx(tx, "summaries", id, labels, sv, sampleTime(nil), (*SummaryValue).Merge, jsonCodec[SummaryValue]())
 	})
+	if err != nil {
+		return err
+	}
+	s.notifySummary(id, labels, sv.Clone())
+	return nil
+}
+
+func (s *DBStorage) GetSummaryMetric(name string) (SummaryValue, error) {
+	return getValueMetric(s.db, "summaries", name, jsonCodec[SummaryValue]())
+}
+
+func (s *DBStorage) SaveSetMetric(name string, sv SetValue) error {
+	if err := sv.Validate(); err != nil {
+		return err
+	}
+
+	id, labels := ParseSeriesKey(name)
+	var merged SetValue
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		merged, err = mergeSetTx(tx, id, labels, sv, sampleTime(nil))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.notifySet(id, labels, merged.Estimate())
+	return nil
+}
+
+func (s *DBStorage) GetSetMetric(name string) (SetValue, error) {
+	return getValueMetric(s.db, "sets", name, setCodec)
+}
+
+// mergeSetTx объединяет скетч set с сохраненным и возвращает результат слияния.
+func mergeSetTx(tx *sql.Tx, name string, labels map[string]string, sv SetValue, ts time.Time) (SetValue, error) {
+	merged := sv.Clone()
+	err := mergeValueTx(tx, "sets", name, labels, sv, ts, func(current *SetValue, delta SetValue) error {
+		if err := current.Merge(delta); err != nil {
+			return err
+		}
+		merged = *current
+		return nil
+	}, setCodec)
+	return merged, err
+}
+
+// inTx выполняет fn в отдельной транзакции с повторными попытками.
+func (s *DBStorage) inTx(fn func(tx *sql.Tx) error) error {
+	return retryOperation(context.Background(), func() error {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// valueCodec описывает, как значение типа T хранится в колонке value: JSONB для histograms
+// и summaries, bytea для sets.
+type valueCodec[T any] struct {
+	encode func(v T) (any, error)
+	decode func(data []byte, v *T) error
+}
+
+// jsonCodec хранит значение в колонке JSONB.
+func jsonCodec[T any]() valueCodec[T] {
+	return valueCodec[T]{
+		encode: func(v T) (any, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		decode: func(data []byte, v *T) error {
+			return json.Unmarshal(data, v)
+		},
+	}
+}
+
+// floatCodec и intCodec читают значения числовых колонок gauges и counters:
+// database/sql передает их в decode в текстовом виде.
+var (
+	floatCodec = valueCodec[float64]{
+		decode: func(data []byte, v *float64) (err error) {
+			*v, err = strconv.ParseFloat(string(data), 64)
+			return err
+		},
+	}
+	intCodec = valueCodec[int64]{
+		decode: func(data []byte, v *int64) (err error) {
+			*v, err = strconv.ParseInt(string(data), 10, 64)
+			return err
+		},
+	}
+)
+
+// setCodec хранит скетч set в колонке bytea в двоичном виде (см. SetValue.MarshalBinary).
+var setCodec = valueCodec[SetValue]{
+	encode: func(v SetValue) (any, error) {
+		return v.MarshalBinary()
+	},
+	decode: func(data []byte, v *SetValue) error {
+		return v.UnmarshalBinary(data)
+	},
+}
+
+// mergeValueTx добавляет приращение к значению, хранимому в колонке value таблицы table
+// (histograms, summaries или sets). Новая серия записывается через INSERT ... ON CONFLICT DO NOTHING:
+// при одновременной первой записи вставка ждет конкурирующую транзакцию, и проигравшая сливает свое
+// приращение с уже зафиксированным значением. Существующая строка блокируется через SELECT ... FOR UPDATE,
+// слияние выполняется в Go. Время строки - наибольшее из сохраненного и ts.
+func mergeValueTx[T any](tx *sql.Tx, table, name string, labels map[string]string, delta T, ts time.Time, merge func(*T, T) error, codec valueCodec[T]) error {
+	value, err := codec.encode(delta)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`INSERT INTO `+table+` (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)
+                         ON CONFLICT (name, labels) DO NOTHING`,
+		name, labelsJSON(labels), value, ts)
+	if err != nil {
+		return err
+	}
+	if inserted, err := res.RowsAffected(); err != nil || inserted == 1 {
+		return err
+	}
+
+	var data []byte
+	err = tx.QueryRow(`SELECT value FROM `+table+` WHERE name = $1 AND labels = $2 FOR UPDATE`,
+		name, labelsJSON(labels)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Строку удалили между вставкой и блокировкой - запись повторяется
+		return mergeValueTx(tx, table, name, labels, delta, ts, merge, codec)
+	}
+	if err != nil {
+		return err
+	}
+
+	var current T
+	if err := codec.decode(data, &current); err != nil {
+		return fmt.Errorf("failed to decode %s %s: %w", table, name, err)
+	}
+	if err := merge(&current, delta); err != nil {
+		return err
+	}
+	if value, err = codec.encode(current); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE `+table+` SET value = $3, updated_at = GREATEST(updated_at, $4)
+                      WHERE name = $1 AND labels = $2`,
+		name, labelsJSON(labels), value, ts)
+	return err
+}
+
+// getValueMetric читает значение из колонки value таблицы table.
+func getValueMetric[T any](db *sql.DB, table, name string, codec valueCodec[T]) (T, error) {
+	var (
+		data  []byte
+		value T
+	)
+	query := `SELECT value FROM ` + table + ` WHERE name = $1 AND labels = $2`
+
+	ctx := context.Background()
+	id, labels := ParseSeriesKey(name)
+
+	err := retryOperation(ctx, func() error {
+		return db.QueryRow(query, id, labelsJSON(labels)).Scan(&data)
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return value, ErrMetricNotFound
+	}
+	if err != nil {
+		return value, err
+	}
+
+	if err := codec.decode(data, &value); err != nil {
+		return value, fmt.Errorf("failed to decode %s %s: %w", table, name, err)
+	}
+	return value, nil
 }
 
 func (s *DBStorage) GetGaugeMetric(name string) (float64, error) {
 	var value float64
-	query := `SELECT value FROM gauges WHERE name = $1`
+	query := `SELECT value FROM gauges WHERE name = $1 AND labels = $2`
 
 	ctx := context.Background()
+	id, labels := ParseSeriesKey(name)
 
 	err := retryOperation(ctx, func() error {
-		return s.db.QueryRow(query, name).Scan(&value)
+		return s.db.QueryRow(query, id, labelsJSON(labels)).Scan(&value)
 	})
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return 0, fmt.Errorf("metric not found")
+		return 0, ErrMetricNotFound
 	}
 	return value, err
 }
 
 func (s *DBStorage) GetCounterMetric(name string) (int64, error) {
 	var value int64
-	query := `SELECT value FROM counters WHERE name = $1`
+	query := `SELECT value FROM counters WHERE name = $1 AND labels = $2`
 
 	ctx := context.Background()
+	id, labels := ParseSeriesKey(name)
 
 	err := retryOperation(ctx, func() error {
-		return s.db.QueryRow(query, name).Scan(&value)
+		return s.db.QueryRow(query, id, labelsJSON(labels)).Scan(&value)
 	})
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return 0, fmt.Errorf("metric not found")
+		return 0, ErrMetricNotFound
 	}
 	return value, err
 }
 
-func (s *DBStorage) GetAllMetrics() map[string]interface{} {
+// metricTables - таблицы последних значений по типам метрик.
+var metricTables = map[string]string{
+	Gauge:     "gauges",
+	Counter:   "counters",
+	Histogram: "histograms",
+	Summary:   "summaries",
+	Set:       "sets",
+}
+
+// DeleteMetric удаляет строку метрики, а для gauge и counter также ее сэмплы и агрегаты.
+func (s *DBStorage) DeleteMetric(metricType, name string) error {
+	if _, ok := metricTables[metricType]; !ok {
+		return fmt.Errorf("unknown metric type: %s", metricType)
+	}
+
+	return s.inTx(func(tx *sql.Tx) error {
+		exists, err := deleteMetricTx(tx, metricType, name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrMetricNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteMetrics удаляет метрики refs и их историю в одной транзакции.
+func (s *DBStorage) DeleteMetrics(refs []MetricRef) ([]MetricRef, error) {
+	for _, ref := range refs {
+		if _, ok := metricTables[ref.Type]; !ok {
+			return nil, fmt.Errorf("unknown metric type: %s", ref.Type)
+		}
+	}
+
+	var deleted []MetricRef
+	err := s.inTx(func(tx *sql.Tx) error {
+		deleted = []MetricRef{}
+		for _, ref := range refs {
+			exists, err := deleteMetricTx(tx, ref.Type, ref.Name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				deleted = append(deleted, MetricRef{Type: ref.Type, Name: CanonicalKey(ref.Name)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// deleteMetricTx удаляет в транзакции tx строку метрики и для gauge и counter ее сэмплы и агрегаты.
+// Сообщает, была ли метрика в таблице.
+func deleteMetricTx(tx *sql.Tx, metricType, name string) (bool, error) {
+	id, labels := ParseSeriesKey(name)
+	result, err := tx.Exec(`DELETE FROM `+metricTables[metricType]+` WHERE name = $1 AND labels = $2`, id, labelsJSON(labels))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	if metricType != Gauge && metricType != Counter {
+		return true, nil
+	}
+	for _, history := range []string{metricType + "_samples", metricType + "_rollups"} {
+		if _, err := tx.Exec(`DELETE FROM `+history+` WHERE name = $1 AND labels = $2`, id, labelsJSON(labels)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// GetHistory возвращает сэмплы серии из gauge_samples или counter_samples с временем в [from, to]
+// или агрегаты из gauge_rollups или counter_rollups, если выбрано разрешение 1m или 1h.
+// Для counter значение точки - накопленное значение после приращения.
+func (s *DBStorage) GetHistory(metricType, name string, from, to time.Time, res Resolution) ([]HistoryPoint, error) {
+	var err error
+	switch metricType {
+	case Gauge:
+		_, err = s.GetGaugeMetric(name)
+	case Counter:
+		_, err = s.GetCounterMetric(name)
+	default:
+		return nil, ErrHistoryType
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id, labels := ParseSeriesKey(name)
+	args := []any{id, labelsJSON(labels)}
+	if res == ResolutionAuto && from.IsZero() {
+		// Без from разрешение выбирается по всей истории серии
+		oldest, newest, err := s.historyExtent(metricType, args)
+		if err != nil {
+			return nil, err
+		}
+		res = autoResolution(oldest, newest, to)
+	}
+	res = res.resolve(from, to)
+	timeColumn := "ts"
+	query := `SELECT ts, value FROM ` + metricType + `_samples WHERE name = $1 AND labels = $2`
+	if res != ResolutionRaw {
+		columns := "min, max, sum, last, count"
+		if metricType == Counter {
+			columns = "sum, last, count"
+		}
+		args = append(args, int(res.step()/time.Second))
+		timeColumn = "bucket"
+		query = `SELECT bucket, ` + columns + ` FROM ` + metricType + `_rollups
+                 WHERE name = $1 AND labels = $2 AND resolution = $3`
+		// Агрегат попадает в ответ, если его интервал пересекается с [from, to]
+		if !from.IsZero() {
+			from = from.Truncate(res.step())
+		}
+	}
+	if !from.IsZero() {
+		args = append(args, from.UTC())
+		query += fmt.Sprintf(" AND %s >= $%d", timeColumn, len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to.UTC())
+		query += fmt.Sprintf(" AND %s <= $%d", timeColumn, len(args))
+	}
+	query += ` ORDER BY ` + timeColumn
+
+	var points []HistoryPoint
+	err = retryOperation(context.Background(), func() error {
+		points = []HistoryPoint{}
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			p, err := scanHistoryPoint(rows, metricType, res)
+			if err != nil {
+				return err
+			}
+			points = append(points, p)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// GetHistories читает историю серий refs не больше чем двумя запросами, по одному на тип:
+// строки выбираются по name = ANY, а лишние серии с теми же именами отбрасываются по меткам.
+// История удаленной метрики удаляется вместе с ней, поэтому наличие метрики отдельно не проверяется.
+func (s *DBStorage) GetHistories(refs []MetricRef, from, to time.Time, res Resolution) (map[MetricRef][]HistoryPoint, error) {
+	wanted := make(map[MetricRef]bool, len(refs))
+	names := make(map[string][]string)
+	seenNames := make(map[string]bool)
+	for _, ref := range refs {
+		if ref.Type != Gauge && ref.Type != Counter {
+			return nil, ErrHistoryType
+		}
+		key := CanonicalKey(ref.Name)
+		wanted[MetricRef{Type: ref.Type, Name: key}] = true
+		if id, _ := ParseSeriesKey(key); !seenNames[ref.Type+"/"+id] {
+			seenNames[ref.Type+"/"+id] = true
+			names[ref.Type] = append(names[ref.Type], id)
+		}
+	}
+
+	res = res.resolve(from, to)
+	if res != ResolutionRaw && !from.IsZero() {
+		// Агрегат попадает в ответ, если его интервал пересекается с [from, to]
+		from = from.Truncate(res.step())
+	}
+	result := make(map[MetricRef][]HistoryPoint, len(wanted))
+	for _, metricType := range []string{Gauge, Counter} {
+		if len(names[metricType]) == 0 {
+			continue
+		}
+
+		args := []any{names[metricType]}
+		timeColumn := "ts"
+		query := `SELECT name, labels, ts, value FROM ` + metricType + `_samples WHERE name = ANY($1)`
+		if res != ResolutionRaw {
+			columns := "min, max, sum, last, count"
+			if metricType == Counter {
+				columns = "sum, last, count"
+			}
+			args = append(args, int(res.step()/time.Second))
+			timeColumn = "bucket"
+			query = `SELECT name, labels, bucket, ` + columns + ` FROM ` + metricType + `_rollups
+                     WHERE name = ANY($1) AND resolution = $2`
+		}
+		if !from.IsZero() {
+			args = append(args, from.UTC())
+			query += fmt.Sprintf(" AND %s >= $%d", timeColumn, len(args))
+		}
+		if !to.IsZero() {
+			args = append(args, to.UTC())
+			query += fmt.Sprintf(" AND %s <= $%d", timeColumn, len(args))
+		}
+		query += ` ORDER BY ` + timeColumn
+
+		var points map[MetricRef][]HistoryPoint
+		err := retryOperation(context.Background(), func() error {
+			points = make(map[MetricRef][]HistoryPoint)
+			rows, err := s.db.Query(query, args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var (
+					name   string
+					labels []byte
+				)
+				p, err := scanHistoryPoint(rows, metricType, res, &name, &labels)
+				if err != nil {
+					return err
+				}
+				ref := MetricRef{Type: metricType, Name: SeriesKey(name, parseLabelsJSON(labels))}
+				if wanted[ref] {
+					points[ref] = append(points[ref], p)
+				}
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+		for ref, p := range points {
+			result[ref] = p
+		}
+	}
+	return result, nil
+}
+
+// historyExtent возвращает время самого старого и самого нового сэмпла или агрегата серии
+// с именем и метками args. Для серии без истории возвращаются нулевые значения.
+func (s *DBStorage) historyExtent(metricType string, args []any) (oldest, newest time.Time, err error) {
+	var first, last sql.NullTime
+	err = retryOperation(context.Background(), func() error {
+		return s.db.QueryRow(`SELECT LEAST(MIN(s.ts), (SELECT MIN(bucket) FROM `+metricType+`_rollups WHERE name = $1 AND labels = $2)),
+                                     GREATEST(MAX(s.ts), (SELECT MAX(bucket) FROM `+metricType+`_rollups WHERE name = $1 AND labels = $2))
+                              FROM `+metricType+`_samples s WHERE s.name = $1 AND s.labels = $2`,
+			args...).Scan(&first, &last)
+	})
+	if err != nil || !first.Valid {
+		return time.Time{}, time.Time{}, err
+	}
+	return first.Time.UTC(), last.Time.UTC(), nil
+}
+
+// scanHistoryPoint читает точку истории из строки запроса GetHistory.
+func scanHistoryPoint(rows *sql.Rows, metricType string, res Resolution, prefix ...any) (HistoryPoint, error) {
+	var ts time.Time
+	if res == ResolutionRaw {
+		var value float64
+		if err := rows.Scan(append(prefix, &ts, &value)...); err != nil {
+			return HistoryPoint{}, err
+		}
+		return HistoryPoint{Timestamp: ts.UTC(), Value: value}, nil
+	}
+
+	var b rollupBucket
+	dest := []any{&ts, &b.min, &b.max, &b.sum, &b.last, &b.count}
+	if metricType == Counter {
+		dest = []any{&ts, &b.sum, &b.last, &b.count}
+	}
+	if err := rows.Scan(append(prefix, dest...)...); err != nil {
+		return HistoryPoint{}, err
+	}
+	b.start = ts.UnixNano()
+	return b.point(metricType), nil
+}
+
+// Rollup пересчитывает минутные и часовые агрегаты по сэмплам с временем до границы агрегации (см. RollupDelay).
+// Пересчитываются интервалы начиная с интервала, содержащего границу предыдущего вызова, а при первом
+// вызове - с последнего сохраненного минутного агрегата. Минутный агрегат всегда строится по всем сэмплам
+// минуты, а часовой - по всем минутным агрегатам часа, поэтому повторный пересчет безопасен.
+func (s *DBStorage) Rollup(now time.Time) error {
+	cutoff := rollupCutoff(now)
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+
+	from := s.rollupWatermark
+	if from.IsZero() {
+		var last sql.NullTime
+		err := retryOperation(context.Background(), func() error {
+			return s.db.QueryRow(`SELECT LEAST((SELECT MAX(bucket) FROM gauge_rollups WHERE resolution = $1),
+                                                (SELECT MAX(bucket) FROM counter_rollups WHERE resolution = $1))`,
+				int(time.Minute/time.Second)).Scan(&last)
+		})
+		if err != nil {
+			return err
+		}
+		from = last.Time
+	}
+
+	minute, hour := int(time.Minute/time.Second), int(time.Hour/time.Second)
+	err := s.inTx(func(tx *sql.Tx) error {
+		// Минутные агрегаты строятся по сэмплам новых минут
+		args := []any{minute, from.Truncate(time.Minute), cutoff}
+		if _, err := tx.Exec(`INSERT INTO gauge_rollups (resolution, name, labels, bucket, min, max, sum, last, count)
+            SELECT $1, name, labels, date_trunc('minute', ts) AS bucket, MIN(value), MAX(value), SUM(value),
+                   (ARRAY_AGG(value ORDER BY ts DESC))[1], COUNT(*)
+            FROM gauge_samples WHERE ts >= $2 AND ts < $3
+            GROUP BY name, labels, bucket
+            ON CONFLICT (resolution, name, labels, bucket) DO UPDATE SET min = EXCLUDED.min, max = EXCLUDED.max,
+                sum = EXCLUDED.sum, last = EXCLUDED.last, count = EXCLUDED.count`, args...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO counter_rollups (resolution, name, labels, bucket, sum, last, count)
+            SELECT $1, name, labels, date_trunc('minute', ts) AS bucket, SUM(delta),
+                   (ARRAY_AGG(value ORDER BY ts DESC))[1], COUNT(*)
+            FROM counter_samples WHERE ts >= $2 AND ts < $3
+            GROUP BY name, labels, bucket
+            ON CONFLICT (resolution, name, labels, bucket) DO UPDATE SET sum = EXCLUDED.sum,
+                last = EXCLUDED.last, count = EXCLUDED.count`, args...); err != nil {
+			return err
+		}
+
+		// Часовые агрегаты строятся по минутным, а не по сэмплам: сырые сэмплы начала часа
+		// могут быть уже удалены правилом хранения с коротким сроком raw
+		args = []any{hour, minute, from.Truncate(time.Hour), cutoff}
+		if _, err := tx.Exec(`INSERT INTO gauge_rollups (resolution, name, labels, bucket, min, max, sum, last, count)
+            SELECT $1, name, labels, date_trunc('hour', bucket) AS hour_bucket, MIN(min), MAX(max), SUM(sum),
+                   (ARRAY_AGG(last ORDER BY bucket DESC))[1], SUM(count)
+            FROM gauge_rollups WHERE resolution = $2 AND bucket >= $3 AND bucket < $4
+            GROUP BY name, labels, hour_bucket
+            ON CONFLICT (resolution, name, labels, bucket) DO UPDATE SET min = EXCLUDED.min, max = EXCLUDED.max,
+                sum = EXCLUDED.sum, last = EXCLUDED.last, count = EXCLUDED.count`, args...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO counter_rollups (resolution, name, labels, bucket, sum, last, count)
+            SELECT $1, name, labels, date_trunc('hour', bucket) AS hour_bucket, SUM(sum),
+                   (ARRAY_AGG(last ORDER BY bucket DESC))[1], SUM(count)
+            FROM counter_rollups WHERE resolution = $2 AND bucket >= $3 AND bucket < $4
+            GROUP BY name, labels, hour_bucket
+            ON CONFLICT (resolution, name, labels, bucket) DO UPDATE SET sum = EXCLUDED.sum,
+                last = EXCLUDED.last, count = EXCLUDED.count`, args...); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.rollupWatermark = cutoff
+	return nil
+}
+
+// ApplyRetention удаляет сэмплы и агрегаты старше сроков Raw и Rollups и gauge, updated_at которых
+// старше срока Stale, вместе с их сэмплами и агрегатами. Правила сопоставляются с именами
+// из gauges и counters, поэтому история метрик, которых уже нет в этих таблицах, здесь не удаляется.
+// Записи об удалениях старше TombstoneRetention удаляются и без правил, а их наибольшая ревизия
+// сохраняется в metric_tombstone_horizon (см. ListChanges).
+func (s *DBStorage) ApplyRetention(now time.Time) error {
+	err := retryOperation(context.Background(), func() error {
+		_, err := s.db.Exec(`WITH pruned AS (
+                DELETE FROM metric_tombstones WHERE deleted_at < $1 RETURNING revision
+            )
+            UPDATE metric_tombstone_horizon SET revision = GREATEST(revision, (SELECT MAX(revision) FROM pruned))
+            WHERE EXISTS (SELECT 1 FROM pruned)`, now.Add(-TombstoneRetention).UTC())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune tombstones: %w", err)
+	}
+	if len(s.Retention) == 0 {
+		return nil
+	}
+
+	var names []string
+	err = retryOperation(context.Background(), func() error {
+		names = nil
+		rows, err := s.db.Query(`SELECT name FROM gauges UNION SELECT name FROM counters`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[int][]string)
+	for _, name := range names {
+		if i := s.Retention.ruleFor(name); i >= 0 {
+			groups[i] = append(groups[i], name)
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	return s.inTx(func(tx *sql.Tx) error {
+		for i, rule := range s.Retention {
+			names, ok := groups[i]
+			if !ok {
+				continue
+			}
+			if rule.Stale > 0 {
+				if _, err := tx.Exec(`WITH stale AS (
+                        DELETE FROM gauges WHERE name = ANY($1) AND updated_at < $2 RETURNING name, labels
+                    ), samples AS (
+                        DELETE FROM gauge_samples s USING stale WHERE s.name = stale.name AND s.labels = stale.labels
+                    )
+                    DELETE FROM gauge_rollups r USING stale WHERE r.name = stale.name AND r.labels = stale.labels`,
+					names, now.Add(-rule.Stale).UTC()); err != nil {
+					return err
+				}
+			}
+			if rule.Raw > 0 {
+				for _, table := range []string{"gauge_samples", "counter_samples"} {
+					if _, err := tx.Exec(`DELETE FROM `+table+` WHERE name = ANY($1) AND ts < $2`,
+						names, now.Add(-rule.Raw).UTC()); err != nil {
+						return err
+					}
+				}
+			}
+			if rule.Rollups > 0 {
+				for _, table := range []string{"gauge_rollups", "counter_rollups"} {
+					if _, err := tx.Exec(`DELETE FROM `+table+` WHERE name = ANY($1) AND bucket < $2`,
+						names, now.Add(-rule.Rollups).UTC()); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ListMetrics возвращает страницу gauge и counter одним запросом: фильтры, порядок и курсор
+// переводятся в условия WHERE, ORDER BY и LIMIT. Шаблон Glob переводится в регулярное выражение,
+// Labels проверяется оператором @>. Метки упорядочиваются по правилам сравнения JSONB. Синтаксис Regex
+// (RE2) отличается от регулярных выражений PostgreSQL, поэтому в запрос передается только литеральный
+// префикс привязанного выражения (см. regexPrefix), а само выражение проверяется при чтении строк:
+// строки читаются без LIMIT, пока не наберется страница.
+func (s *DBStorage) ListMetrics(opts ListOptions) (MetricPage, error) {
+	f, err := opts.compile()
+	if err != nil {
+		return MetricPage{}, err
+	}
+
+	var branches []string
+	if f.includesType(Gauge) {
+		branches = append(branches, `SELECT 'gauge' AS type, name, labels, value, NULL::BIGINT AS delta, updated_at FROM gauges`)
+	}
+	if f.includesType(Counter) {
+		branches = append(branches, `SELECT 'counter', name, labels, NULL::DOUBLE PRECISION, value, updated_at FROM counters`)
+	}
+	query := `SELECT type, name, labels, value, delta, updated_at FROM (` + strings.Join(branches, " UNION ALL ") + `) AS m`
+
+	var (
+		conditions []string
+		args       []any
+	)
+	if f.Name != "" {
+		args = append(args, f.Name)
+		conditions = append(conditions, fmt.Sprintf("name = $%d", len(args)))
+	}
+	if f.Prefix != "" {
+		args = append(args, likePrefix(f.Prefix))
+		conditions = append(conditions, fmt.Sprintf("name LIKE $%d", len(args)))
+	}
+	if f.Glob != "" {
+		args = append(args, globRegexp(f.Glob))
+		conditions = append(conditions, fmt.Sprintf("name ~ $%d", len(args)))
+	}
+	if prefix := regexPrefix(f.Regex); prefix != "" {
+		args = append(args, likePrefix(prefix))
+		conditions = append(conditions, fmt.Sprintf("name LIKE $%d", len(args)))
+	}
+	if len(f.Labels) > 0 {
+		args = append(args, labelsJSON(f.Labels))
+		conditions = append(conditions, fmt.Sprintf("labels @> $%d::JSONB", len(args)))
+	}
+
+	// Метрики без времени упорядочиваются как метрики с нулевым временем, как и в курсоре
+	keys := []string{"name", "labels", "type"}
+	if f.Sort.byTime() {
+		keys = append([]string{"COALESCE(updated_at, '0001-01-01')"}, keys...)
+	}
+	if f.cursor != nil {
+		args = append(args, f.cursor.Name, labelsJSON(f.cursor.Labels), f.cursor.Type)
+		placeholders := fmt.Sprintf("$%d, $%d::JSONB, $%d", len(args)-2, len(args)-1, len(args))
+		if f.Sort.byTime() {
+			args = append(args, f.cursor.UpdatedAt.UTC())
+			placeholders = fmt.Sprintf("$%d, ", len(args)) + placeholders
+		}
+		op := ">"
+		if f.Sort.desc() {
+			op = "<"
+		}
+		conditions = append(conditions, "("+strings.Join(keys, ", ")+") "+op+" ("+placeholders+")")
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	order := keys
+	if f.Sort.desc() {
+		order = make([]string, len(keys))
+		for i, k := range keys {
+			order[i] = k + " DESC"
+		}
+	}
+	query += " ORDER BY " + strings.Join(order, ", ")
+	// Лишняя строка показывает, что есть следующая страница
+	if f.Limit > 0 && f.re == nil {
+		args = append(args, f.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	var entries []MetricEntry
+	err = retryOperation(context.Background(), func() error {
+		entries = []MetricEntry{}
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				e         MetricEntry
+				labels    []byte
+				value     sql.NullFloat64
+				delta     sql.NullInt64
+				updatedAt sql.NullTime
+			)
+			if err := rows.Scan(&e.Type, &e.ID, &labels, &value, &delta, &updatedAt); err != nil {
+				return err
+			}
+			if f.re != nil && !f.re.MatchString(e.ID) {
+				continue
+			}
+			e.Labels = parseLabelsJSON(labels)
+			if len(e.Labels) == 0 {
+				e.Labels = nil
+			}
+			if value.Valid {
+				e.Value = &value.Float64
+			}
+			if delta.Valid {
+				e.Delta = &delta.Int64
+			}
+			if updatedAt.Valid {
+				ts := updatedAt.Time.UTC()
+				e.UpdatedAt = &ts
+			}
+			entries = append(entries, e)
+			if f.Limit > 0 && len(entries) > f.Limit {
+				break
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return MetricPage{}, err
+	}
+	return f.trim(entries), nil
+}
+
+// batchColumns - выражения, которыми GetMetrics и ListChanges читают колонку value каждой таблицы в текстовом виде:
+// числа и JSONB приводятся к тексту, bytea sets кодируется в hex.
+var batchColumns = map[string]string{
+	Gauge:     "value::TEXT",
+	Counter:   "value::TEXT",
+	Histogram: "value::TEXT",
+	Summary:   "value::TEXT",
+	Set:       "encode(value, 'hex')",
+}
+
+// GetMetrics читает метрики refs одним запросом: таблицы запрошенных типов объединяются через UNION ALL,
+// строки выбираются по name = ANY($1), а лишние серии с теми же именами отбрасываются по меткам.
+func (s *DBStorage) GetMetrics(refs []MetricRef) (MetricSet, error) {
+	wanted := make(map[MetricRef]bool, len(refs))
+	types := make(map[string]bool)
+	seenNames := make(map[string]bool)
+	var names []string
+	for _, ref := range refs {
+		if _, ok := metricTables[ref.Type]; !ok {
+			continue
+		}
+		key := CanonicalKey(ref.Name)
+		wanted[MetricRef{Type: ref.Type, Name: key}] = true
+		types[ref.Type] = true
+		if id, _ := ParseSeriesKey(key); !seenNames[id] {
+			seenNames[id] = true
+			names = append(names, id)
+		}
+	}
+	if len(wanted) == 0 {
+		return NewMetricSet(), nil
+	}
+
+	var selects []string
+	for _, metricType := range []string{Gauge, Counter, Histogram, Summary, Set} {
+		if types[metricType] {
+			selects = append(selects, fmt.Sprintf(`SELECT '%s', name, labels, %s FROM %s WHERE name = ANY($1)`,
+				metricType, batchColumns[metricType], metricTables[metricType]))
+		}
+	}
+	return s.queryMetrics(strings.Join(selects, " UNION ALL "), []any{names}, func(ref MetricRef) bool {
+		return wanted[ref]
+	})
+}
+
+// ListChanges берет текущую ревизию из pg_snapshot_xmin текущего снимка, а затем читает метрики
+// всех типов одним запросом и удаления из metric_tombstones с ревизией в (since, ревизия].
+// Ревизию строки триггер выставляет по номеру записавшей или удалившей транзакции (см. миграцию
+// metric_revisions); транзакции с номером меньше xmin завершены, поэтому запись с ревизией
+// не больше текущей не может появиться позже. Долгая пишущая транзакция задерживает ленту
+// до своего завершения. Клиент с ревизией меньше metric_tombstone_horizon мог пропустить удаление,
+// запись о котором уже удалена ApplyRetention, и получает полный набор.
+func (s *DBStorage) ListChanges(since int64) (ChangeSet, error) {
 	ctx := context.Background()
-	allMetrics := make(map[string]interface{})
 
-	// Получаем все gauge метрики
-	gaugesQuery := `SELECT name, value FROM gauges`
-	var gaugeRows *sql.Rows
+	var revision, horizon int64
+	err := retryOperation(ctx, func() error {
+		return s.db.QueryRowContext(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::TEXT::BIGINT,
+                                          COALESCE((SELECT revision FROM metric_tombstone_horizon), 0)`).Scan(&revision, &horizon)
+	})
+	if err != nil {
+		return ChangeSet{}, fmt.Errorf("failed to fetch revision: %w", err)
+	}
+	changes := ChangeSet{Revision: revision}
+	if since == 0 || since > revision || since < horizon {
+		changes.Reset = true
+		since = 0
+	}
+
+	var selects []string
+	for _, metricType := range []string{Gauge, Counter, Histogram, Summary, Set} {
+		selects = append(selects, fmt.Sprintf(`SELECT '%s', name, labels, %s FROM %s WHERE revision > $1 AND revision <= $2`,
+			metricType, batchColumns[metricType], metricTables[metricType]))
+	}
+	changes.Metrics, err = s.queryMetrics(strings.Join(selects, " UNION ALL "), []any{since, revision}, nil)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	if !changes.Reset {
+		if changes.Deleted, err = s.queryTombstones(ctx, since, revision); err != nil {
+			return ChangeSet{}, err
+		}
+	}
+	return changes, nil
+}
 
+// queryTombstones читает метрики, удаленные с ревизией в (since, revision].
+func (s *DBStorage) queryTombstones(ctx context.Context, since, revision int64) ([]MetricRef, error) {
+	var rows *sql.Rows
 	err := retryOperation(ctx, func() error {
 		var err error
-		gaugeRows, err = s.db.QueryContext(ctx, gaugesQuery)
-		if err := gaugeRows.Err(); err != nil {
+		rows, err = s.db.QueryContext(ctx, `SELECT type, name, labels FROM metric_tombstones
+                                            WHERE revision > $1 AND revision <= $2`, since, revision)
+		if err != nil {
 			return err
 		}
-		return err
+		return rows.Err()
 	})
 	if err != nil {
-		log.Printf("Error fetching gauges: %v", err)
-		return allMetrics
+		return nil, fmt.Errorf("failed to fetch tombstones: %w", err)
 	}
 	defer func() {
-		if err := gaugeRows.Close(); err != nil {
-			log.Printf("Error closing gauge rows: %v", err)
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing tombstone rows: %v", err)
 		}
 	}()
 
-	for gaugeRows.Next() {
-		var name string
-		var value float64
-		if err := gaugeRows.Scan(&name, &value); err != nil {
-			log.Printf("Error scanning gauge: %v", err)
-			continue
+	var deleted []MetricRef
+	for rows.Next() {
+		var metricType, name string
+		var labels []byte
+		if err := rows.Scan(&metricType, &name, &labels); err != nil {
+			return nil, err
 		}
-		allMetrics[name] = value
+		deleted = append(deleted, MetricRef{Type: metricType, Name: SeriesKey(name, parseLabelsJSON(labels))})
 	}
-
-	// Проверка ошибок после итерации
-	if err := gaugeRows.Err(); err != nil {
-		log.Printf("Error iterating gauge rows: %v", err)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	sortRefs(deleted)
+	return deleted, nil
+}
 
-	// Получаем все counter метрики
-	countersQuery := `SELECT name, value FROM counters`
-	var counterRows *sql.Rows
-	err = retryOperation(ctx, func() error {
+// queryMetrics выполняет запрос, возвращающий тип, имя, метки и значение метрики в текстовом виде
+// (см. batchColumns), и собирает строки в набор. Если keep не nil, строки, для которых он
+// возвращает false, пропускаются.
+func (s *DBStorage) queryMetrics(query string, args []any, keep func(MetricRef) bool) (MetricSet, error) {
+	ctx := context.Background()
+	var rows *sql.Rows
+	err := retryOperation(ctx, func() error {
 		var err error
-		counterRows, err = s.db.QueryContext(ctx, countersQuery)
-		if err := counterRows.Err(); err != nil {
+		rows, err = s.db.QueryContext(ctx, query, args...)
+		if err != nil {
 			return err
 		}
-		return err
+		return rows.Err()
 	})
 	if err != nil {
-		log.Printf("Error fetching counters: %v", err)
-		return allMetrics
+		return MetricSet{}, fmt.Errorf("failed to fetch metrics: %w", err)
 	}
 	defer func() {
-		if err := counterRows.Close(); err != nil {
-			log.Printf("Error closing counter rows: %v", err)
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing metric rows: %v", err)
 		}
 	}()
 
-	for counterRows.Next() {
-		var name string
-		var value int64
-		if err := counterRows.Scan(&name, &value); err != nil {
-			log.Printf("Error scanning counter: %v", err)
+	found := NewMetricSet()
+	for rows.Next() {
+		var metricType, name string
+		var labels, data []byte
+		if err := rows.Scan(&metricType, &name, &labels, &data); err != nil {
+			return MetricSet{}, err
+		}
+		key := SeriesKey(name, parseLabelsJSON(labels))
+		if keep != nil && !keep(MetricRef{Type: metricType, Name: key}) {
 			continue
 		}
-		allMetrics[name] = value
+		if err := found.decode(metricType, key, data); err != nil {
+			return MetricSet{}, fmt.Errorf("failed to decode %s %s: %w", metricType, key, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return MetricSet{}, err
 	}
+	return found, nil
+}
 
-	// Проверка ошибок после итерации
-	if err := counterRows.Err(); err != nil {
-		log.Printf("Error iterating counter rows: %v", err)
+// decode разбирает текстовое значение метрики, прочитанное GetMetrics, и добавляет его в набор.
+func (m MetricSet) decode(metricType, key string, data []byte) error {
+	switch metricType {
+	case Gauge:
+		return decodeInto(m.Gauges, key, data, floatCodec)
+	case Counter:
+		return decodeInto(m.Counters, key, data, intCodec)
+	case Histogram:
+		return decodeInto(m.Histograms, key, data, jsonCodec[HistogramValue]())
+	case Summary:
+		return decodeInto(m.Summaries, key, data, jsonCodec[SummaryValue]())
+	case Set:
+		raw, err := hex.DecodeString(string(data))
+		if err != nil {
+			return err
+		}
+		return decodeInto(m.Sets, key, raw, setCodec)
+	default:
+		return fmt.Errorf("unknown metric type %s", metricType)
 	}
+}
 
-	return allMetrics
+func decodeInto[T any](dest map[string]T, key string, data []byte, codec valueCodec[T]) error {
+	var value T
+	if err := codec.decode(data, &value); err != nil {
+		return err
+	}
+	dest[key] = value
+	return nil
 }
 
-func (s *DBStorage) UpdateMetricsBatch(metrics []Metrics) error {
+// ListAllMetrics читает все метрики по типам. Строки, которые не удалось прочитать или разобрать,
+// пропускаются с записью в лог; ошибка запроса к таблице возвращается.
+func (s *DBStorage) ListAllMetrics() (MetricSet, error) {
 	ctx := context.Background()
+	all := NewMetricSet()
 
-	return retryOperation(ctx, func() error {
-		tx, err := s.db.Begin()
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if err != nil {
-				_ = tx.Rollback()
-			} else {
-				err = tx.Commit()
-			}
-		}()
+	if err := loadMetrics(ctx, s.db, "gauges", all.Gauges, floatCodec); err != nil {
+		return MetricSet{}, fmt.Errorf("failed to fetch gauges: %w", err)
+	}
+	if err := loadMetrics(ctx, s.db, "counters", all.Counters, intCodec); err != nil {
+		return MetricSet{}, fmt.Errorf("failed to fetch counters: %w", err)
+	}
+	if err := loadMetrics(ctx, s.db, "histograms", all.Histograms, jsonCodec[HistogramValue]()); err != nil {
+		return MetricSet{}, fmt.Errorf("failed to fetch histograms: %w", err)
+	}
+	if err := loadMetrics(ctx, s.db, "summaries", all.Summaries, jsonCodec[SummaryValue]()); err != nil {
+		return MetricSet{}, fmt.Errorf("failed to fetch summaries: %w", err)
+	}
+	if err := loadMetrics(ctx, s.db, "sets", all.Sets, setCodec); err != nil {
+		return MetricSet{}, fmt.Errorf("failed to fetch sets: %w", err)
+	}
+	return all, nil
+}
 
-		for _, metric := range metrics {
+func (s *DBStorage) UpdateMetricsBatch(metrics []Metrics) error {
+	// Оценки set после слияния, значения gauge после относительных изменений
+	// и пропущенные устаревшие gauge-сэмплы, по индексу метрики в пакете
+	var (
+		cardinality map[int]int64
+		gauges      map[int]float64
+		skipped     map[int]bool
+	)
+
+	// Ошибка фиксации транзакции возвращается из inTx, и обработчики изменений не вызываются
+	err := s.inTx(func(tx *sql.Tx) error {
+		cardinality = make(map[int]int64)
+		gauges = make(map[int]float64)
+		skipped = make(map[int]bool)
+
+		var err error
+		for i, metric := range metrics {
+			ts := sampleTime(metric.Timestamp)
 			switch metric.MType {
 			case Counter:
 				if metric.Delta == nil {
 					continue
 				}
-				query := `INSERT INTO counters (name, value) VALUES ($1, $2)
-                          ON CONFLICT (name) DO UPDATE SET value = counters.value + EXCLUDED.value;`
-				_, err = tx.Exec(query, metric.ID, *metric.Delta)
-				if err != nil {
+				if err = upsertCounter(tx, metric.ID, metric.Labels, *metric.Delta, ts); err != nil {
 					return err
 				}
 			case Gauge:
+				if metric.Value == nil && metric.GaugeDelta != nil {
+					if gauges[i], err = addGaugeTx(tx, metric.ID, metric.Labels, *metric.GaugeDelta, ts); err != nil {
+						return err
+					}
+					continue
+				}
 				if metric.Value == nil {
 					continue
 				}
-				query := `INSERT INTO gauges (name, value) VALUES ($1, $2)
-                          ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;`
-				_, err = tx.Exec(query, metric.ID, *metric.Value)
-				if err != nil {
+				var applied bool
+				if applied, err = s.upsertGauge(tx, metric.ID, metric.Labels, *metric.Value, ts); err != nil {
+					return err
+				}
+				skipped[i] = !applied
+			case Histogram:
+				if metric.Histogram == nil {
+					continue
+				}
+				if err = metric.Histogram.Validate(); err != nil {
+					return err
+				}
+				if err = mergeValueTx(tx, "histograms", metric.ID, metric.Labels, *metric.Histogram, ts, (*HistogramValue).Merge, jsonCodec[HistogramValue]()); err != nil {
+					return err
+				}
+			case Summary:
+				if metric.Summary == nil {
+					continue
+				}
+				if err = metric.Summary.Validate(); err != nil {
 					return err
 				}
+				if err = mergeValueTx(tx, "summaries", metric.ID, metric.Labels, *metric.Summary, ts, (*SummaryValue).Merge, jsonCodec[SummaryValue]()); err != nil {
+					return err
+				}
+			case Set:
+				if metric.Set == nil {
+					continue
+				}
+				if err = metric.Set.Validate(); err != nil {
+					return err
+				}
+				var merged SetValue
+				if merged, err = mergeSetTx(tx, metric.ID, metric.Labels, *metric.Set, ts); err != nil {
+					return err
+				}
+				cardinality[i] = merged.Estimate()
 			default:
 				continue
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	// Обработчики вызываются только после успешной транзакции
+	for i, metric := range metrics {
+		switch {
+		case metric.MType == Counter && metric.Delta != nil:
+			s.notifyCounter(metric.ID, metric.Labels, *metric.Delta)
+		case metric.MType == Gauge && metric.Value != nil && !skipped[i]:
+			s.notifyGauge(metric.ID, metric.Labels, *metric.Value)
+		case metric.MType == Gauge && metric.Value == nil && metric.GaugeDelta != nil:
+			s.notifyGauge(metric.ID, metric.Labels, gauges[i])
+		case metric.MType == Histogram && metric.Histogram != nil:
+			s.notifyHistogram(metric.ID, metric.Labels, metric.Histogram.Clone())
+		case metric.MType == Summary && metric.Summary != nil:
+			s.notifySummary(metric.ID, metric.Labels, metric.Summary.Clone())
+		case metric.MType == Set && metric.Set != nil:
+			s.notifySet(metric.ID, metric.Labels, cardinality[i])
+		}
+	}
+	return nil
+}
+
+// loadMetrics добавляет в dest все значения из колонки value таблицы table.
+// Строки, которые не удалось прочитать или разобрать, пропускаются с записью в лог.
+func loadMetrics[T any](ctx context.Context, db *sql.DB, table string, dest map[string]T, codec valueCodec[T]) error {
+	var rows *sql.Rows
+	err := retryOperation(ctx, func() error {
+		var err error
+		rows, err = db.QueryContext(ctx, `SELECT name, labels, value FROM `+table)
+		if err != nil {
+			return err
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing %s rows: %v", table, err)
+		}
+	}()
+
+	for rows.Next() {
+		var name string
+		var labels, data []byte
+		if err := rows.Scan(&name, &labels, &data); err != nil {
+			log.Printf("Error scanning %s: %v", table, err)
+			continue
+		}
+		var value T
+		if err := codec.decode(data, &value); err != nil {
+			log.Printf("Error decoding %s %s: %v", table, name, err)
+			continue
+		}
+		dest[SeriesKey(name, parseLabelsJSON(labels))] = value
+	}
+	return rows.Err()
+}
+
+// labelsJSON сериализует метки для колонки labels (JSONB). Пустой набор хранится как {}.
+func labelsJSON(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(labels)
+	return string(data)
+}
+
+// parseLabelsJSON разбирает значение колонки labels.
+func parseLabelsJSON(data []byte) map[string]string {
+	var labels map[string]string
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil
+	}
+	return labels
 }
 
 // retryOperation - переменная-функция для повторного выполнения операций с базой данных
@@ -28,7 +28,10 @@ func BenchmarkDBStorage_SaveGaugeMetric(b *testing.B) {
 	defer db.Close()
 
 	// Подготавливаем ожидание для запроса
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO gauges").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO gauge_samples").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -40,7 +43,10 @@ func BenchmarkDBStorage_SaveGaugeMetric(b *testing.B) {
 
 		// Сбрасываем ожидания для следующей итерации
 		if i+1 < b.N {
+			mock.ExpectBegin()
 			mock.ExpectExec("INSERT INTO gauges").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("INSERT INTO gauge_samples").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
 		}
 	}
 }
@@ -84,43 +90,57 @@ func BenchmarkDBStorage_GetGaugeMetric(b *testing.B) {
 	}
 }
 
-func BenchmarkDBStorage_GetAllMetrics(b *testing.B) {
+func BenchmarkDBStorage_ListAllMetrics(b *testing.B) {
 	db, mock, storage := setupMockDB(b)
 	defer db.Close()
 
 	// Исправляем проблемы с rows.Err()
-	mock.ExpectQuery("SELECT name, value FROM gauges").WillReturnRows(
-		sqlmock.NewRows([]string{"name", "value"}).
-			AddRow("gauge1", 42.0).
-			AddRow("gauge2", 84.0).
+	mock.ExpectQuery("SELECT name, labels, value FROM gauges").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}).
+			AddRow("gauge1", []byte("{}"), 42.0).
+			AddRow("gauge2", []byte("{}"), 84.0).
 			RowError(2, nil))
 
-	mock.ExpectQuery("SELECT name, value FROM counters").WillReturnRows(
-		sqlmock.NewRows([]string{"name", "value"}).
-			AddRow("counter1", 100).
-			AddRow("counter2", 200).
+	mock.ExpectQuery("SELECT name, labels, value FROM counters").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}).
+			AddRow("counter1", []byte("{}"), 100).
+			AddRow("counter2", []byte("{}"), 200).
 			RowError(2, nil))
 
+	mock.ExpectQuery("SELECT name, labels, value FROM histograms").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}))
+	mock.ExpectQuery("SELECT name, labels, value FROM summaries").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}))
+	mock.ExpectQuery("SELECT name, labels, value FROM sets").WillReturnRows(
+		sqlmock.NewRows([]string{"name", "labels", "value"}))
+
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		metrics := storage.GetAllMetrics()
-		if len(metrics) == 0 {
-			b.Fatal("GetAllMetrics returned empty map")
+		metrics, err := storage.ListAllMetrics()
+		if err != nil || metrics.Len() == 0 {
+			b.Fatal("ListAllMetrics returned no metrics")
 		}
 
 		// Сбрасываем ожидания для следующей итерации
 		if i+1 < b.N {
-			mock.ExpectQuery("SELECT name, value FROM gauges").WillReturnRows(
-				sqlmock.NewRows([]string{"name", "value"}).
-					AddRow("gauge1", 42.0).
-					AddRow("gauge2", 84.0).
+			mock.ExpectQuery("SELECT name, labels, value FROM gauges").WillReturnRows(
+				sqlmock.NewRows([]string{"name", "labels", "value"}).
+					AddRow("gauge1", []byte("{}"), 42.0).
+					AddRow("gauge2", []byte("{}"), 84.0).
 					RowError(2, nil))
 
-			mock.ExpectQuery("SELECT name, value FROM counters").WillReturnRows(
-				sqlmock.NewRows([]string{"name", "value"}).
-					AddRow("counter1", 100).
-					AddRow("counter2", 200).
+			mock.ExpectQuery("SELECT name, labels, value FROM counters").WillReturnRows(
+				sqlmock.NewRows([]string{"name", "labels", "value"}).
+					AddRow("counter1", []byte("{}"), 100).
+					AddRow("counter2", []byte("{}"), 200).
 					RowError(2, nil))
+
+			mock.ExpectQuery("SELECT name, labels, value FROM histograms").WillReturnRows(
+				sqlmock.NewRows([]string{"name", "labels", "value"}))
+			mock.ExpectQuery("SELECT name, labels, value FROM summaries").WillReturnRows(
+				sqlmock.NewRows([]string{"name", "labels", "value"}))
+			mock.ExpectQuery("SELECT name, labels, value FROM sets").WillReturnRows(
+				sqlmock.NewRows([]string{"name", "labels", "value"}))
 		}
 	}
 }
@@ -3,12 +3,20 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/pressly/goose/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewDBStorage(t *testing.T) {
@@ -81,10 +89,15 @@ func TestDBStorage_SaveGaugeMetric(t *testing.T) {
 	// Создаем хранилище
 	storage := &DBStorage{db: db}
 
-	// Ожидаем запрос на сохранение метрики
+	// Ожидаем обновление последнего значения и запись сэмпла в одной транзакции
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO gauges").
-		WithArgs("test_gauge", 123.456).
+		WithArgs("test_gauge", "{}", 123.456, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO gauge_samples").
+		WithArgs("test_gauge", "{}", 123.456, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	// Сохраняем метрику
 	err = storage.SaveGaugeMetric("test_gauge", 123.456)
@@ -115,10 +128,15 @@ func TestDBStorage_SaveCounterMetric(t *testing.T) {
 	// Создаем хранилище
 	storage := &DBStorage{db: db}
 
-	// Ожидаем запрос на сохранение метрики
-	mock.ExpectExec("INSERT INTO counters").
-		WithArgs("test_counter", int64(32)).
+	// Ожидаем обновление counter и запись приращения с накопленным значением
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO counters").
+		WithArgs("test_counter", "{}", int64(32), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(int64(42)))
+	mock.ExpectExec("INSERT INTO counter_samples").
+		WithArgs("test_counter", "{}", int64(32), int64(42), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	// Сохраняем метрику
 	err = storage.SaveCounterMetric("test_counter", 32)
@@ -152,7 +170,7 @@ func TestDBStorage_GetGaugeMetric(t *testing.T) {
 	// Подготавливаем заглушку для запроса метрики
 	rows := sqlmock.NewRows([]string{"value"}).AddRow(123.456)
 	mock.ExpectQuery("SELECT value FROM gauges").
-		WithArgs("test_gauge").
+		WithArgs("test_gauge", "{}").
 		WillReturnRows(rows)
 
 	// Получаем метрику
@@ -188,7 +206,7 @@ func TestDBStorage_GetCounterMetric(t *testing.T) {
 	// Подготавливаем заглушку для запроса метрики
 	rows := sqlmock.NewRows([]string{"value"}).AddRow(42)
 	mock.ExpectQuery("SELECT value FROM counters").
-		WithArgs("test_counter").
+		WithArgs("test_counter", "{}").
 		WillReturnRows(rows)
 
 	// Получаем метрику
@@ -202,7 +220,140 @@ func TestDBStorage_GetCounterMetric(t *testing.T) {
 	}
 }
 
-func TestDBStorage_GetAllMetrics(t *testing.T) {
+func TestDBStorage_GetMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+
+	users := NewSetValue(MinSetPrecision)
+	users.Add("alice")
+	usersData, _ := users.MarshalBinary()
+
+	// Один запрос по таблицам запрошенных типов; имена передаются один раз
+	rows := sqlmock.NewRows([]string{"type", "name", "labels", "value"}).
+		AddRow(Gauge, "Alloc", []byte("{}"), []byte("1.5")).
+		AddRow(Counter, "requests", []byte(`{"host": "a"}`), []byte("7")).
+		AddRow(Counter, "requests", []byte(`{"host": "b"}`), []byte("3")).
+		AddRow(Set, "users", []byte("{}"), []byte(hex.EncodeToString(usersData)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT 'gauge', name, labels, value::TEXT FROM gauges WHERE name = ANY($1) UNION ALL ` +
+		`SELECT 'counter', name, labels, value::TEXT FROM counters WHERE name = ANY($1) UNION ALL ` +
+		`SELECT 'set', name, labels, encode(value, 'hex') FROM sets WHERE name = ANY($1)`)).
+		WithArgs([]string{"Alloc", "requests", "missing", "users"}).
+		WillReturnRows(rows)
+
+	found, err := storage.GetMetrics([]MetricRef{
+		{Type: Gauge, Name: "Alloc"},
+		{Type: Counter, Name: `requests{host="a"}`},
+		{Type: Gauge, Name: "missing"},
+		{Type: Set, Name: "users"},
+		{Type: "meter", Name: "ignored"},
+	})
+	require.NoError(t, err)
+
+	// Серия requests{host="b"} не запрошена и отбрасывается
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, found.Gauges)
+	assert.Equal(t, map[string]int64{`requests{host="a"}`: 7}, found.Counters)
+	assert.Equal(t, int64(1), found.Sets["users"].Estimate())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Без известных типов запрос не выполняется
+	found, err = storage.GetMetrics([]MetricRef{{Type: "meter", Name: "Alloc"}})
+	require.NoError(t, err)
+	assert.Zero(t, found.Len())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_ListChanges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+
+	// Сначала читается граница зафиксированных транзакций, затем метрики всех типов в интервале ревизий
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_snapshot_xmin(pg_current_snapshot())")).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "horizon"}).AddRow(int64(12), int64(0)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT 'gauge', name, labels, value::TEXT FROM gauges WHERE revision > $1 AND revision <= $2 UNION ALL `+
+		`SELECT 'counter', name, labels, value::TEXT FROM counters WHERE revision > $1 AND revision <= $2 UNION ALL `+
+		`SELECT 'histogram', name, labels, value::TEXT FROM histograms WHERE revision > $1 AND revision <= $2 UNION ALL `+
+		`SELECT 'summary', name, labels, value::TEXT FROM summaries WHERE revision > $1 AND revision <= $2 UNION ALL `+
+		`SELECT 'set', name, labels, encode(value, 'hex') FROM sets WHERE revision > $1 AND revision <= $2`)).
+		WithArgs(int64(10), int64(12)).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "name", "labels", "value"}).
+			AddRow(Gauge, "Alloc", []byte(`{"host": "a"}`), []byte("1.5")).
+			AddRow(Histogram, "latency", []byte("{}"), []byte(`{"bounds":[0.1,1],"counts":[1,2],"sum":1.5,"count":3}`)))
+
+	// Затем удаления в том же интервале
+	mock.ExpectQuery("SELECT type, name, labels FROM metric_tombstones").
+		WithArgs(int64(10), int64(12)).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "name", "labels"}).
+			AddRow(Set, "users", []byte("{}")).
+			AddRow(Gauge, "Alloc", []byte(`{"host": "b"}`)))
+
+	changes, err := storage.ListChanges(10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), changes.Revision)
+	assert.False(t, changes.Reset)
+	assert.Equal(t, map[string]float64{`Alloc{host="a"}`: 1.5}, changes.Metrics.Gauges)
+	assert.Equal(t, int64(3), changes.Metrics.Histograms["latency"].Count)
+	assert.Equal(t, []MetricRef{{Type: Gauge, Name: `Alloc{host="b"}`}, {Type: Set, Name: "users"}}, changes.Deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Полный набор удаления не читает
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_snapshot_xmin(pg_current_snapshot())")).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "horizon"}).AddRow(int64(12), int64(0)))
+	mock.ExpectQuery("FROM gauges WHERE revision").
+		WithArgs(int64(0), int64(12)).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "name", "labels", "value"}).
+			AddRow(Gauge, "Alloc", []byte(`{"host": "a"}`), []byte("1.5")))
+
+	changes, err = storage.ListChanges(0)
+	require.NoError(t, err)
+	assert.True(t, changes.Reset)
+	assert.Equal(t, 1, changes.Metrics.Len())
+	assert.Empty(t, changes.Deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Ревизия больше текущей: изменения читаются с начала
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_snapshot_xmin(pg_current_snapshot())")).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "horizon"}).AddRow(int64(12), int64(0)))
+	mock.ExpectQuery("FROM gauges WHERE revision").
+		WithArgs(int64(0), int64(12)).
+		WillReturnError(errors.New("connection reset"))
+
+	_, err = storage.ListChanges(20)
+	assert.ErrorContains(t, err, "connection reset")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Ревизия меньше горизонта удаленных записей об удалениях: полный набор без удалений
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_snapshot_xmin(pg_current_snapshot())")).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "horizon"}).AddRow(int64(12), int64(8)))
+	mock.ExpectQuery("FROM gauges WHERE revision").
+		WithArgs(int64(0), int64(12)).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "name", "labels", "value"}))
+
+	changes, err = storage.ListChanges(7)
+	require.NoError(t, err)
+	assert.True(t, changes.Reset)
+	assert.Empty(t, changes.Deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_ListAllMetrics(t *testing.T) {
 	// Создаем фейковое подключение к базе данных
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -222,27 +373,56 @@ func TestDBStorage_GetAllMetrics(t *testing.T) {
 	storage := &DBStorage{db: db}
 
 	// Подготавливаем заглушки для gauge метрик
-	gaugeRows := sqlmock.NewRows([]string{"name", "value"}).
-		AddRow("gauge1", 123.456).
-		AddRow("gauge2", 789.012)
-	mock.ExpectQuery("SELECT name, value FROM gauges").
+	gaugeRows := sqlmock.NewRows([]string{"name", "labels", "value"}).
+		AddRow("gauge1", []byte("{}"), 123.456).
+		AddRow("gauge2", []byte(`{"host": "a"}`), 789.012).
+		AddRow("counter1", []byte("{}"), 0.5)
+	mock.ExpectQuery("SELECT name, labels, value FROM gauges").
 		WillReturnRows(gaugeRows)
 
 	// Подготавливаем заглушки для counter метрик
-	counterRows := sqlmock.NewRows([]string{"name", "value"}).
-		AddRow("counter1", 42).
-		AddRow("counter2", 84)
-	mock.ExpectQuery("SELECT name, value FROM counters").
+	counterRows := sqlmock.NewRows([]string{"name", "labels", "value"}).
+		AddRow("counter1", []byte("{}"), 42).
+		AddRow("counter2", []byte("{}"), 84)
+	mock.ExpectQuery("SELECT name, labels, value FROM counters").
 		WillReturnRows(counterRows)
 
+	// Подготавливаем заглушки для histogram метрик
+	histogramRows := sqlmock.NewRows([]string{"name", "labels", "value"}).
+		AddRow("latency", []byte("{}"), []byte(`{"bounds":[0.1,1],"counts":[1,2],"sum":1.5,"count":3}`))
+	mock.ExpectQuery("SELECT name, labels, value FROM histograms").
+		WillReturnRows(histogramRows)
+
+	// Подготавливаем заглушки для summary метрик
+	summaryRows := sqlmock.NewRows([]string{"name", "labels", "value"}).
+		AddRow("rtt", []byte(`{"host": "a"}`), []byte(`{"accuracy":0.01,"positive":{"0":2},"zero":0,"count":2,"sum":2,"min":1,"max":1}`))
+	mock.ExpectQuery("SELECT name, labels, value FROM summaries").
+		WillReturnRows(summaryRows)
+
+	// Подготавливаем заглушки для set метрик
+	users := NewSetValue(MinSetPrecision)
+	users.Add("alice")
+	usersData, _ := users.MarshalBinary()
+	setRows := sqlmock.NewRows([]string{"name", "labels", "value"}).
+		AddRow("users", []byte("{}"), usersData)
+	mock.ExpectQuery("SELECT name, labels, value FROM sets").
+		WillReturnRows(setRows)
+
 	// Получаем все метрики
-	metrics := storage.GetAllMetrics()
+	metrics, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+
+	// Проверяем, что все метрики получены, а gauge и counter с одним именем не скрывают друг друга
+	assert.Equal(t, map[string]float64{"gauge1": 123.456, `gauge2{host="a"}`: 789.012, "counter1": 0.5}, metrics.Gauges)
+	assert.Equal(t, map[string]int64{"counter1": 42, "counter2": 84}, metrics.Counters)
+	assert.Equal(t, HistogramValue{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2}, Sum: 1.5, Count: 3}, metrics.Histograms["latency"])
+	assert.Equal(t, SummaryValue{Accuracy: 0.01, Positive: map[int]int64{0: 2}, Count: 2, Sum: 2, Min: 1, Max: 1}, metrics.Summaries[`rtt{host="a"}`])
+	assert.Equal(t, users, metrics.Sets["users"])
 
-	// Проверяем, что все метрики получены
-	assert.Equal(t, float64(123.456), metrics["gauge1"])
-	assert.Equal(t, float64(789.012), metrics["gauge2"])
-	assert.Equal(t, int64(42), metrics["counter1"])
-	assert.Equal(t, int64(84), metrics["counter2"])
+	// Ошибка запроса возвращается, а не скрывается пустым результатом
+	mock.ExpectQuery("SELECT name, labels, value FROM gauges").WillReturnError(errors.New("connection reset"))
+	_, err = storage.ListAllMetrics()
+	assert.Error(t, err)
 
 	// Убеждаемся, что все ожидания выполнены
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -279,28 +459,750 @@ func TestDBStorage_UpdateMetricsBatch(t *testing.T) {
 			Delta: &delta,
 		},
 		{
-			ID:    "gauge1",
-			MType: Gauge,
-			Value: &value,
+			ID:     "gauge1",
+			MType:  Gauge,
+			Value:  &value,
+			Labels: map[string]string{"host": "a"},
 		},
 	}
 
 	// Ожидаем транзакцию
 	mock.ExpectBegin()
-	mock.ExpectExec("INSERT INTO counters").
-		WithArgs("counter1", delta).
+	mock.ExpectQuery("INSERT INTO counters").
+		WithArgs("counter1", "{}", delta, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(delta))
+	mock.ExpectExec("INSERT INTO counter_samples").
+		WithArgs("counter1", "{}", delta, delta, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("INSERT INTO gauges").
-		WithArgs("gauge1", value).
+		WithArgs("gauge1", `{"host":"a"}`, value, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO gauge_samples").
+		WithArgs("gauge1", `{"host":"a"}`, value, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
+	var changes []Metrics
+	storage.OnChange(func(m Metrics) { changes = append(changes, m) })
+
 	// Обновляем метрики пакетом
 	err = storage.UpdateMetricsBatch(metrics)
 	assert.NoError(t, err)
+	assert.Equal(t, metrics, changes)
+
+	// Относительное изменение gauge выполняется в базе, в событии - новое значение
+	changes = nil
+	up := 2.5
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SET value = gauges.value + EXCLUDED.value")).
+		WithArgs("connections", "{}", up, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(7.5))
+	mock.ExpectExec("INSERT INTO gauge_samples").
+		WithArgs("connections", "{}", 7.5, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = storage.UpdateMetricsBatch([]Metrics{{ID: "connections", MType: Gauge, GaugeDelta: &up}})
+	assert.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, 7.5, *changes[0].Value)
+
+	// Ошибка фиксации возвращается, обработчики изменений не вызываются
+	changes = nil
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO counters").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(delta))
+	mock.ExpectExec("INSERT INTO counter_samples").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit().WillReturnError(errors.New("commit failed"))
+
+	err = storage.UpdateMetricsBatch(metrics[:1])
+	assert.EqualError(t, err, "commit failed")
+	assert.Empty(t, changes)
 
 	// Убеждаемся, что все ожидания выполнены
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestDBStorage_SaveHistogramMetric(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+
+	delta := HistogramValue{Bounds: []float64{0.1, 1}, Counts: []int64{0, 1}, Sum: 0.5, Count: 1}
+
+	// Строка уже есть: сохраненное значение блокируется, сливается с приращением и записывается обратно
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO histograms (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)")).
+		WithArgs("latency", `{"host":"a"}`, `{"bounds":[0.1,1],"counts":[0,1],"sum":0.5,"count":1}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT value FROM histograms WHERE name = $1 AND labels = $2 FOR UPDATE")).
+		WithArgs("latency", `{"host":"a"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).
+			AddRow([]byte(`{"bounds":[0.1,1],"counts":[1,2],"sum":1.5,"count":3}`)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE histograms SET value = $3")).
+		WithArgs("latency", `{"host":"a"}`, `{"bounds":[0.1,1],"counts":[1,3],"sum":2,"count":4}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = storage.SaveHistogramMetric(`latency{host="a"}`, delta)
+	assert.NoError(t, err)
+
+	// Приращение с другими границами отклоняется, транзакция откатывается
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO histograms").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT value FROM histograms").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).
+			AddRow([]byte(`{"bounds":[0.5],"counts":[1],"sum":0.2,"count":1}`)))
+	mock.ExpectRollback()
+
+	err = storage.SaveHistogramMetric("latency", delta)
+	assert.ErrorIs(t, err, ErrHistogramBounds)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStorage_GetHistogramMetric(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+
+	mock.ExpectQuery("SELECT value FROM histograms").
+		WithArgs("latency", "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).
+			AddRow([]byte(`{"bounds":[1],"counts":[2],"sum":1.2,"count":2}`)))
+	mock.ExpectQuery("SELECT value FROM histograms").
+		WithArgs("missing", "{}").
+		WillReturnError(sql.ErrNoRows)
+
+	value, err := storage.GetHistogramMetric("latency")
+	assert.NoError(t, err)
+	assert.Equal(t, HistogramValue{Bounds: []float64{1}, Counts: []int64{2}, Sum: 1.2, Count: 2}, value)
+
+	_, err = storage.GetHistogramMetric("missing")
+	assert.Error(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStorage_SaveSummaryMetric(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+
+	delta := NewSummaryValue(0.01)
+	delta.Add(1)
+
+	// Новый скетч записывается как есть одной вставкой
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO summaries (name, labels, value, updated_at) VALUES ($1, $2, $3, $4)")).
+		WithArgs("rtt", "{}", `{"accuracy":0.01,"positive":{"0":1},"zero":0,"count":1,"sum":1,"min":1,"max":1}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = storage.SaveSummaryMetric("rtt", delta)
+	assert.NoError(t, err)
+
+	// Существующий скетч (в том числе записанный конкурирующей транзакцией) сливается с новым
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO summaries").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT value FROM summaries").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).
+			AddRow([]byte(`{"accuracy":0.01,"positive":{"0":1},"zero":1,"count":2,"sum":1,"min":0,"max":1}`)))
+	mock.ExpectExec("UPDATE summaries").
+		WithArgs("rtt", "{}", `{"accuracy":0.01,"positive":{"0":2},"zero":1,"count":3,"sum":2,"min":0,"max":1}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = storage.SaveSummaryMetric("rtt", delta)
+	assert.NoError(t, err)
+
+	// Скетч с другой точностью отклоняется
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO summaries").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT value FROM summaries").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).
+			AddRow([]byte(`{"accuracy":0.05,"zero":1,"count":1,"sum":0,"min":0,"max":0}`)))
+	mock.ExpectRollback()
+
+	err = storage.SaveSummaryMetric("rtt", delta)
+	assert.ErrorIs(t, err, ErrSummaryAccuracy)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStorage_SaveSetMetric(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+
+	stored := NewSetValue(MinSetPrecision)
+	stored.Add("alice")
+	storedData, _ := stored.MarshalBinary()
+
+	delta := NewSetValue(MinSetPrecision)
+	delta.Add("bob")
+
+	merged := stored.Clone()
+	require.NoError(t, merged.Merge(delta))
+	mergedData, _ := merged.MarshalBinary()
+
+	// Скетч хранится в bytea и объединяется с новым
+	deltaData, _ := delta.MarshalBinary()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO sets").
+		WithArgs("users", `{"host":"a"}`, deltaData, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT value FROM sets WHERE name = $1 AND labels = $2 FOR UPDATE")).
+		WithArgs("users", `{"host":"a"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(storedData))
+	mock.ExpectExec("UPDATE sets").
+		WithArgs("users", `{"host":"a"}`, mergedData, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var changes []Metrics
+	storage.OnChange(func(m Metrics) { changes = append(changes, m) })
+
+	err = storage.SaveSetMetric(`users{host="a"}`, delta)
+	assert.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, merged.Estimate(), *changes[0].Cardinality)
+
+	mock.ExpectQuery("SELECT value FROM sets").
+		WithArgs("users", `{"host":"a"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(mergedData))
+
+	value, err := storage.GetSetMetric(`users{host="a"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, merged, value)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDBStorage_OutOfOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db, OutOfOrder: OutOfOrderReject}
+	ts := time.Date(2025, 6, 25, 12, 0, 0, 0, time.UTC)
+	value := 1.5
+
+	// Время сэмпла передается в updated_at, устаревший сэмпл не обновляет строку
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("WHERE gauges.updated_at IS NULL OR gauges.updated_at <= EXCLUDED.updated_at")).
+		WithArgs("temp", "{}", value, ts).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err = storage.UpdateMetricsBatch([]Metrics{{ID: "temp", MType: Gauge, Value: &value, Timestamp: &ts}})
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+
+	// При политике latest сэмпл пропускается без ошибки и без уведомления
+	storage.OutOfOrder = OutOfOrderLatest
+	var changes []Metrics
+	storage.OnChange(func(m Metrics) { changes = append(changes, m) })
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO gauges").
+		WithArgs("temp", "{}", value, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.NoError(t, storage.SaveGaugeMetric("temp", value))
+	assert.Empty(t, changes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_GetHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	// Сначала проверяется наличие серии, затем читаются сэмплы из журнала в заданном интервале
+	mock.ExpectQuery("SELECT value FROM counters").
+		WithArgs("PollCount", `{"host":"a"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(int64(5)))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ts, value FROM counter_samples WHERE name = $1 AND labels = $2 AND ts >= $3 ORDER BY ts")).
+		WithArgs("PollCount", `{"host":"a"}`, start).
+		WillReturnRows(sqlmock.NewRows([]string{"ts", "value"}).
+			AddRow(start, int64(2)).
+			AddRow(start.Add(time.Minute), int64(5)))
+
+	points, err := storage.GetHistory(Counter, SeriesKey("PollCount", map[string]string{"host": "a"}), start, time.Time{}, ResolutionRaw)
+	require.NoError(t, err)
+	assert.Equal(t, []HistoryPoint{
+		{Timestamp: start, Value: 2},
+		{Timestamp: start.Add(time.Minute), Value: 5},
+	}, points)
+
+	// Без from разрешение выбирается по границам всей истории серии: сутки отдаются минутными агрегатами
+	mock.ExpectQuery("SELECT value FROM counters").
+		WithArgs("PollCount", "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(int64(5)))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT LEAST(MIN(s.ts)")).
+		WithArgs("PollCount", "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"least", "greatest"}).AddRow(start.Add(-24*time.Hour), start))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT bucket, sum, last, count FROM counter_rollups")).
+		WithArgs("PollCount", "{}", 60).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "sum", "last", "count"}).AddRow(start, 3.0, 5.0, int64(2)))
+
+	points, err = storage.GetHistory(Counter, "PollCount", time.Time{}, time.Time{}, ResolutionAuto)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, 3.0, *points[0].Sum)
+
+	// Неизвестная серия
+	mock.ExpectQuery("SELECT value FROM gauges").
+		WithArgs("Missing", "{}").
+		WillReturnError(sql.ErrNoRows)
+	_, err = storage.GetHistory(Gauge, "Missing", time.Time{}, time.Time{}, ResolutionAuto)
+	assert.Error(t, err)
+
+	_, err = storage.GetHistory(Histogram, "PollCount", time.Time{}, time.Time{}, ResolutionAuto)
+	assert.ErrorIs(t, err, ErrHistoryType)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_Rollup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+	last := time.Date(2025, 7, 2, 12, 5, 0, 0, time.UTC)
+	now := time.Date(2025, 7, 2, 12, 10, 30, 0, time.UTC)
+	cutoff := now.Add(-RollupDelay).Truncate(time.Minute)
+
+	// Первый вызов продолжает с последнего сохраненного минутного агрегата
+	mock.ExpectQuery("SELECT LEAST").
+		WithArgs(60).
+		WillReturnRows(sqlmock.NewRows([]string{"least"}).AddRow(last))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO gauge_rollups .* FROM gauge_samples").
+		WithArgs(60, last, cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec("INSERT INTO counter_rollups .* FROM counter_samples").
+		WithArgs(60, last, cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	// Часовые агрегаты строятся по минутным, а не по сэмплам, которые могли быть уже удалены
+	mock.ExpectExec(`INSERT INTO gauge_rollups .* FROM gauge_rollups WHERE resolution = \$2`).
+		WithArgs(3600, 60, last.Truncate(time.Hour), cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO counter_rollups .* FROM counter_rollups WHERE resolution = \$2`).
+		WithArgs(3600, 60, last.Truncate(time.Hour), cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, storage.Rollup(now))
+
+	// Агрегаты читаются с начала интервала, содержащего from
+	mock.ExpectQuery("SELECT value FROM gauges").
+		WithArgs("CPUutilization1", "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1.0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT bucket, min, max, sum, last, count FROM gauge_rollups")).
+		WithArgs("CPUutilization1", "{}", 60, last, last.Add(24*time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "min", "max", "sum", "last", "count"}).
+			AddRow(last, 1.0, 3.0, 4.0, 3.0, int64(2)))
+
+	points, err := storage.GetHistory(Gauge, "CPUutilization1", last.Add(30*time.Second), last.Add(24*time.Hour), ResolutionAuto)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, last, points[0].Timestamp)
+	assert.Equal(t, 2.0, *points[0].Avg)
+	assert.Equal(t, 3.0, points[0].Value)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// arrayConverter передает срезы в драйвер без преобразования, как pgx для параметров ANY($1).
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if names, ok := v.([]string); ok {
+		return names, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestDBStorage_ApplyRetention(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db, Retention: RetentionPolicy{
+		{Pattern: "CPUutilization*", Raw: time.Hour, Stale: 10 * time.Minute},
+		{Pattern: "Heap*", Rollups: 24 * time.Hour},
+	}}
+	now := time.Date(2025, 7, 3, 12, 0, 0, 0, time.UTC)
+
+	// Сначала удаляются старые записи об удалениях, затем имена группируются по первому подходящему
+	// правилу; метрики без правила не затрагиваются
+	mock.ExpectExec("DELETE FROM metric_tombstones WHERE deleted_at < \\$1 .* UPDATE metric_tombstone_horizon").
+		WithArgs(now.Add(-TombstoneRetention)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name FROM gauges UNION SELECT name FROM counters")).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).
+			AddRow("CPUutilization1").AddRow("CPUutilization2").AddRow("HeapAlloc").AddRow("PollCount"))
+	mock.ExpectBegin()
+	cpu := []string{"CPUutilization1", "CPUutilization2"}
+	mock.ExpectExec("DELETE FROM gauges WHERE name = ANY").
+		WithArgs(cpu, now.Add(-10*time.Minute)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM gauge_samples WHERE name = ANY").
+		WithArgs(cpu, now.Add(-time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("DELETE FROM counter_samples WHERE name = ANY").
+		WithArgs(cpu, now.Add(-time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM gauge_rollups WHERE name = ANY").
+		WithArgs([]string{"HeapAlloc"}, now.Add(-24*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM counter_rollups WHERE name = ANY").
+		WithArgs([]string{"HeapAlloc"}, now.Add(-24*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	require.NoError(t, storage.ApplyRetention(now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Без правил удаляются только записи об удалениях
+	storage.Retention = nil
+	mock.ExpectExec("DELETE FROM metric_tombstones").
+		WithArgs(now.Add(-TombstoneRetention)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, storage.ApplyRetention(now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_DeleteMetric(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+
+	// Для gauge удаляются также сэмплы и агрегаты серии
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM gauges WHERE name = $1 AND labels = $2")).
+		WithArgs("temp", `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM gauge_samples").
+		WithArgs("temp", `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM gauge_rollups").
+		WithArgs("temp", `{"host":"a"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, storage.DeleteMetric(Gauge, `temp{host="a"}`))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM sets").
+		WithArgs("users", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, storage.DeleteMetric(Set, "users"), ErrMetricNotFound)
+
+	assert.Error(t, storage.DeleteMetric("meter", "users"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_DeleteMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+	refs := []MetricRef{{Type: Set, Name: "missing"}, {Type: Counter, Name: "requests"}, {Type: Summary, Name: "latency"}}
+
+	// Все метрики удаляются в одной транзакции, отсутствующие пропускаются
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM sets WHERE name = $1 AND labels = $2")).
+		WithArgs("missing", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM counters WHERE name = $1 AND labels = $2")).
+		WithArgs("requests", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM counter_samples").
+		WithArgs("requests", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM counter_rollups").
+		WithArgs("requests", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM summaries WHERE name = $1 AND labels = $2")).
+		WithArgs("latency", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	deleted, err := storage.DeleteMetrics(refs)
+	require.NoError(t, err)
+	assert.Equal(t, []MetricRef{{Type: Counter, Name: "requests"}, {Type: Summary, Name: "latency"}}, deleted)
+
+	// Ошибка на любой метрике откатывает всю транзакцию
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM sets").
+		WithArgs("missing", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM counters").
+		WithArgs("requests", "{}").
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	deleted, err = storage.DeleteMetrics(refs)
+	assert.Error(t, err)
+	assert.Nil(t, deleted)
+
+	_, err = storage.DeleteMetrics([]MetricRef{{Type: "meter", Name: "users"}})
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_ListMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+	updatedAt := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"type", "name", "labels", "value", "delta", "updated_at"}
+
+	// Фильтры передаются в запрос, а регулярное выражение без литерального префикса проверяется
+	// при чтении строк: LIMIT не передается, чтение останавливается на лишней строке, которая дает
+	// курсор следующей страницы
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT type, name, labels, value, delta, updated_at FROM (`+
+		`SELECT 'gauge' AS type, name, labels, value, NULL::BIGINT AS delta, updated_at FROM gauges UNION ALL `+
+		`SELECT 'counter', name, labels, NULL::DOUBLE PRECISION, value, updated_at FROM counters) AS m `+
+		`WHERE name LIKE $1 AND name ~ $2 ORDER BY name, labels, type`)).
+		WithArgs(`CPU\_%`, `^[^/]*$`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(Counter, "CPU_1", []byte("{}"), nil, int64(7), updatedAt).
+			AddRow(Gauge, "CPU_a", []byte("{}"), 0.5, nil, nil).
+			AddRow(Gauge, "CPU_1", []byte(`{"host":"a"}`), 1.5, nil, nil).
+			AddRow(Gauge, "CPU_2", []byte("{}"), 2.5, nil, updatedAt).
+			AddRow(Gauge, "CPU_3", []byte("{}"), 3.5, nil, updatedAt))
+
+	page, err := storage.ListMetrics(ListOptions{Prefix: "CPU_", Glob: "*", Regex: "[0-9]$", Limit: 2})
+	require.NoError(t, err)
+	delta, value := int64(7), 1.5
+	assert.Equal(t, []MetricEntry{
+		{ID: "CPU_1", Type: Counter, Delta: &delta, UpdatedAt: &updatedAt},
+		{ID: "CPU_1", Type: Gauge, Labels: map[string]string{"host": "a"}, Value: &value},
+	}, page.Metrics)
+	require.NotEmpty(t, page.NextCursor)
+
+	// Курсор по времени переходит в сравнение строк в обратном порядке
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT type, name, labels, value, delta, updated_at FROM (`+
+		`SELECT 'gauge' AS type, name, labels, value, NULL::BIGINT AS delta, updated_at FROM gauges) AS m `+
+		`WHERE (COALESCE(updated_at, '0001-01-01'), name, labels, type) < ($4, $1, $2::JSONB, $3) `+
+		`ORDER BY COALESCE(updated_at, '0001-01-01') DESC, name DESC, labels DESC, type DESC`)).
+		WithArgs("CPU_1", "{}", Gauge, updatedAt).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	cursor := listCursor{UpdatedAt: updatedAt, Name: "CPU_1", Type: Gauge}.encode()
+	page, err = storage.ListMetrics(ListOptions{Type: Gauge, Sort: SortByUpdatedAtDesc, Cursor: cursor})
+	require.NoError(t, err)
+	assert.Empty(t, page.Metrics)
+	assert.Empty(t, page.NextCursor)
+
+	// Точное имя и метки фильтруются в запросе
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM counters) AS m WHERE name = $1 AND labels @> $2::JSONB ORDER BY name, labels, type`)).
+		WithArgs("requests", `{"host":"a"}`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(Counter, "requests", []byte(`{"host":"a","dc":"eu"}`), nil, int64(3), nil))
+	page, err = storage.ListMetrics(ListOptions{Type: Counter, Name: "requests", Labels: map[string]string{"host": "a"}})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, map[string]string{"host": "a", "dc": "eu"}, page.Metrics[0].Labels)
+
+	// Привязанное выражение сужает запрос литеральным префиксом; классы RE2 вроде \d в запрос не попадают
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM counters) AS m WHERE name LIKE $1 ORDER BY name, labels, type`)).
+		WithArgs(`disk%`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(Counter, "disk1", []byte("{}"), nil, int64(1), nil).
+			AddRow(Counter, "disks", []byte("{}"), nil, int64(2), nil))
+	page, err = storage.ListMetrics(ListOptions{Type: Counter, Regex: `^disk\d+$`})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, "disk1", page.Metrics[0].ID)
+
+	_, err = storage.ListMetrics(ListOptions{Cursor: "garbage"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_GetHistories(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	originalRetryOperation := retryOperation
+	defer func() { retryOperation = originalRetryOperation }()
+	retryOperation = func(ctx context.Context, operation func() error) error {
+		return operation()
+	}
+
+	storage := &DBStorage{db: db}
+	from := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Minute)
+	refs := []MetricRef{
+		{Type: Gauge, Name: `temp{host="a"}`},
+		{Type: Gauge, Name: `temp{host="b"}`},
+		{Type: Counter, Name: "requests"},
+	}
+
+	// Один запрос на тип; серии с теми же именами, но другими метками отбрасываются
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT name, labels, ts, value FROM gauge_samples WHERE name = ANY($1) AND ts >= $2 AND ts <= $3 ORDER BY ts`)).
+		WithArgs([]string{"temp"}, from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "labels", "ts", "value"}).
+			AddRow("temp", []byte(`{"host":"a"}`), from, 1.0).
+			AddRow("temp", []byte(`{"host":"c"}`), from, 9.0).
+			AddRow("temp", []byte(`{"host":"a"}`), from.Add(time.Minute), 2.0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT name, labels, ts, value FROM counter_samples WHERE name = ANY($1) AND ts >= $2 AND ts <= $3 ORDER BY ts`)).
+		WithArgs([]string{"requests"}, from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "labels", "ts", "value"}).
+			AddRow("requests", []byte("{}"), from, 10.0))
+
+	history, err := storage.GetHistories(refs, from, to, ResolutionAuto)
+	require.NoError(t, err)
+	assert.Equal(t, map[MetricRef][]HistoryPoint{
+		{Type: Gauge, Name: `temp{host="a"}`}: {{Timestamp: from, Value: 1}, {Timestamp: from.Add(time.Minute), Value: 2}},
+		{Type: Counter, Name: "requests"}:     {{Timestamp: from, Value: 10}},
+	}, history)
+
+	// Для часового разрешения читаются агрегаты, from выравнивается по началу часа
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT name, labels, bucket, sum, last, count FROM counter_rollups
+                     WHERE name = ANY($1) AND resolution = $2 AND bucket >= $3 ORDER BY bucket`)).
+		WithArgs([]string{"requests"}, 3600, from).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "labels", "bucket", "sum", "last", "count"}).
+			AddRow("requests", []byte("{}"), from, 30.0, 30.0, int64(3)))
+	history, err = storage.GetHistories(refs[2:], from.Add(30*time.Minute), time.Time{}, ResolutionHour)
+	require.NoError(t, err)
+	require.Len(t, history[refs[2]], 1)
+	assert.Equal(t, from, history[refs[2]][0].Timestamp)
+
+	_, err = storage.GetHistories([]MetricRef{{Type: Set, Name: "users"}}, from, to, ResolutionRaw)
+	assert.ErrorIs(t, err, ErrHistoryType)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsRetriableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadlock", &pgconn.PgError{Code: pgerrcode.DeadlockDetected}, true},
+		{"wrapped serialization failure", fmt.Errorf("failed to update: %w", &pgconn.PgError{Code: pgerrcode.SerializationFailure}), true},
+		{"syntax error", &pgconn.PgError{Code: pgerrcode.SyntaxError}, false},
+		{"connection done", sql.ErrConnDone, true},
+		{"other", errors.New("boom"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetriableError(tt.err))
+		})
+	}
+}
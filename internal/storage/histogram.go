@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultHistogramBuckets - границы бакетов по умолчанию (как в клиенте Prometheus).
+var DefaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ErrInvalidHistogram возвращается для гистограммы с некорректными границами или счетчиками.
+var ErrInvalidHistogram = errors.New("invalid histogram")
+
+// ErrHistogramBounds возвращается при слиянии гистограмм с разными границами бакетов.
+var ErrHistogramBounds = errors.New("histogram bucket bounds mismatch")
+
+// HistogramValue - состояние гистограммы: границы бакетов, накопительные счетчики, сумма и число наблюдений.
+// Бакет +Inf не хранится в Bounds, его значение равно Count.
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds" msgpack:"bounds"` // верхние границы бакетов по возрастанию
+	Counts []int64   `json:"counts" msgpack:"counts"` // Counts[i] - число наблюдений <= Bounds[i]
+	Sum    float64   `json:"sum" msgpack:"sum"`       // сумма наблюдений
+	Count  int64     `json:"count" msgpack:"count"`   // общее число наблюдений
+}
+
+// NewHistogramValue создает пустую гистограмму с заданными границами бакетов.
+func NewHistogramValue(bounds []float64) HistogramValue {
+	return HistogramValue{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]int64, len(bounds)),
+	}
+}
+
+// Validate проверяет, что границы строго возрастают, счетчики накопительные и согласованы с Count,
+// а сумма конечна.
+func (h HistogramValue) Validate() error {
+	if len(h.Counts) != len(h.Bounds) {
+		return fmt.Errorf("%w: %d bounds but %d counts", ErrInvalidHistogram, len(h.Bounds), len(h.Counts))
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("%w: bound %d is not finite", ErrInvalidHistogram, i)
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return fmt.Errorf("%w: bounds must be strictly increasing", ErrInvalidHistogram)
+		}
+	}
+	prev := int64(0)
+	for _, c := range h.Counts {
+		if c < prev {
+			return fmt.Errorf("%w: counts must be cumulative", ErrInvalidHistogram)
+		}
+		prev = c
+	}
+	if h.Count < prev {
+		return fmt.Errorf("%w: count is less than the last bucket", ErrInvalidHistogram)
+	}
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return fmt.Errorf("%w: sum is not finite", ErrInvalidHistogram)
+	}
+	return nil
+}
+
+// Observe добавляет одно наблюдение. NaN и бесконечности пропускаются.
+func (h *HistogramValue) Observe(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	for i, b := range h.Bounds {
+		if v <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Sum += v
+	h.Count++
+}
+
+// Merge добавляет к гистограмме счетчики и сумму другой гистограммы с теми же границами.
+func (h *HistogramValue) Merge(other HistogramValue) error {
+	if !sameBounds(h.Bounds, other.Bounds) {
+		return ErrHistogramBounds
+	}
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+	return nil
+}
+
+// Clone возвращает копию гистограммы, не разделяющую срезы с исходной.
+func (h HistogramValue) Clone() HistogramValue {
+	return HistogramValue{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: append([]int64(nil), h.Counts...),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// String возвращает краткое представление гистограммы для HTML-страницы и /value/.
+func (h HistogramValue) String() string {
+	var b strings.Builder
+	b.WriteString("count=" + strconv.FormatInt(h.Count, 10))
+	b.WriteString(" sum=" + strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	b.WriteString(" buckets=[")
+	for i, bound := range h.Bounds {
+		b.WriteString(strconv.FormatFloat(bound, 'g', -1, 64) + ":" + strconv.FormatInt(h.Counts[i], 10) + " ")
+	}
+	b.WriteString("+Inf:" + strconv.FormatInt(h.Count, 10) + "]")
+	return b.String()
+}
+
+func sameBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramValue_Observe(t *testing.T) {
+	h := NewHistogramValue([]float64{1, 5})
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(3)
+	h.Observe(10)
+	h.Observe(math.NaN())
+	h.Observe(math.Inf(-1))
+
+	assert.Equal(t, []int64{2, 3}, h.Counts)
+	assert.Equal(t, int64(4), h.Count)
+	assert.Equal(t, 14.5, h.Sum)
+	assert.NoError(t, h.Validate())
+	assert.Equal(t, "count=4 sum=14.5 buckets=[1:2 5:3 +Inf:4]", h.String())
+}
+
+func TestHistogramValue_Merge(t *testing.T) {
+	h := NewHistogramValue([]float64{1})
+	h.Observe(0.5)
+
+	other := NewHistogramValue([]float64{1})
+	other.Observe(2)
+	require.NoError(t, h.Merge(other))
+	assert.Equal(t, HistogramValue{Bounds: []float64{1}, Counts: []int64{1}, Sum: 2.5, Count: 2}, h)
+
+	assert.ErrorIs(t, h.Merge(NewHistogramValue([]float64{2})), ErrHistogramBounds)
+
+	// Clone не разделяет срезы с исходной гистограммой
+	clone := h.Clone()
+	clone.Counts[0] = 10
+	assert.Equal(t, int64(1), h.Counts[0])
+}
+
+func TestHistogramValue_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		h    HistogramValue
+	}{
+		{name: "Counts length mismatch", h: HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{1}, Count: 1}},
+		{name: "Bounds not increasing", h: HistogramValue{Bounds: []float64{2, 1}, Counts: []int64{0, 0}}},
+		{name: "Counts not cumulative", h: HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{2, 1}, Count: 2}},
+		{name: "Count less than last bucket", h: HistogramValue{Bounds: []float64{1}, Counts: []int64{3}, Count: 2}},
+		{name: "Sum not finite", h: HistogramValue{Bounds: []float64{1}, Counts: []int64{1}, Sum: math.NaN(), Count: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.h.Validate(), ErrInvalidHistogram)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultHistorySize - число точек истории одной серии по умолчанию (час при отправке раз в 10 секунд).
+	DefaultHistorySize = 360
+	// DefaultHistoryMaxBytes - ограничение памяти под историю и агрегаты всех серий по умолчанию (64 МБ).
+	DefaultHistoryMaxBytes = 64 << 20
+
+	// historyPointSize - размер одной точки истории в памяти.
+	historyPointSize = 24
+	// rollupBucketSize - размер одного агрегата в памяти.
+	rollupBucketSize = 48
+)
+
+// ErrHistoryType возвращается при запросе истории метрики, для которой история не ведется.
+var ErrHistoryType = errors.New("history is kept only for gauge and counter")
+
+// HistoryPoint - значение серии в момент времени. Для counter Value - накопленное значение.
+// Точка агрегата (разрешение 1m или 1h) относится к интервалу, начинающемуся в Timestamp:
+// Value - последнее значение в интервале, для gauge заполнены Min, Max и Avg, для counter - Sum.
+type HistoryPoint struct {
+	Timestamp time.Time `json:"timestamp"`       // время сэмпла или начало интервала
+	Value     float64   `json:"value"`           // значение gauge или накопленное значение counter
+	Min       *float64  `json:"min,omitempty"`   // минимальное значение gauge за интервал
+	Max       *float64  `json:"max,omitempty"`   // максимальное значение gauge за интервал
+	Avg       *float64  `json:"avg,omitempty"`   // среднее значение gauge за интервал
+	Sum       *float64  `json:"sum,omitempty"`   // сумма приращений counter за интервал
+	Count     int64     `json:"count,omitempty"` // число сэмплов в интервале
+}
+
+// historyPoint - точка истории в памяти: время в наносекундах Unix, значение и, для counter, приращение.
+type historyPoint struct {
+	ts    int64
+	value float64
+	delta float64
+}
+
+// historyRing - кольцевой буфер точек одной серии. Буфер растет до нужного размера постепенно,
+// пока это позволяет общий лимит, затем новые точки вытесняют самые старые.
+type historyRing struct {
+	points []historyPoint
+	next   int // индекс самой старой точки, если буфер заполнен по кругу
+}
+
+// historyKey - тип метрики и ключ серии: у gauge и counter с одним именем разная история.
+type historyKey struct {
+	metricType string
+	series     string
+}
+
+// history хранит кольцевые буферы и агрегаты всех серий и следит за занятой ими памятью.
+// Точки с временем раньше watermark уже добавлены в минутные и часовые агрегаты (см. rollup).
+type history struct {
+	rings     map[historyKey]*historyRing
+	bytes     int // память под точки всех буферов и все агрегаты
+	rollups   map[historyKey]*rollupSeries
+	watermark int64
+}
+
+func newHistory() *history {
+	return &history{
+		rings:   make(map[historyKey]*historyRing),
+		rollups: make(map[historyKey]*rollupSeries),
+	}
+}
+
+// add добавляет точку серии key; delta - приращение counter. Буфер серии растет не больше size точек и только пока
+// новая точка помещается в maxBytes; после этого точка заменяет самую старую точку серии.
+// Если у серии еще нет ни одной точки, а лимит исчерпан, точка не сохраняется.
+func (h *history) add(key historyKey, ts time.Time, value, delta float64, size, maxBytes int) {
+	if size <= 0 {
+		return
+	}
+	r, ok := h.rings[key]
+	if !ok {
+		r = &historyRing{}
+		h.rings[key] = r
+	}
+
+	p := historyPoint{ts: ts.UnixNano(), value: value, delta: delta}
+	switch {
+	case len(r.points) < size && r.next == 0 && h.bytes+historyPointSize <= maxBytes:
+		r.points = append(r.points, p)
+		h.bytes += historyPointSize
+	case len(r.points) == 0:
+		delete(h.rings, key)
+	default:
+		r.points[r.next] = p
+		r.next = (r.next + 1) % len(r.points)
+	}
+}
+
+// get возвращает точки серии key с временем в [from, to], упорядоченные по времени.
+// Нулевые from и to не ограничивают интервал.
+func (h *history) get(key historyKey, from, to time.Time) []HistoryPoint {
+	r, ok := h.rings[key]
+	if !ok {
+		return []HistoryPoint{}
+	}
+
+	fromNs, toNs := int64(math.MinInt64), int64(math.MaxInt64)
+	if !from.IsZero() {
+		fromNs = from.UnixNano()
+	}
+	if !to.IsZero() {
+		toNs = to.UnixNano()
+	}
+	points := make([]HistoryPoint, 0, len(r.points))
+	for i := range r.points {
+		p := r.points[(r.next+i)%len(r.points)]
+		if p.ts >= fromNs && p.ts <= toNs {
+			points = append(points, HistoryPoint{Timestamp: time.Unix(0, p.ts).UTC(), Value: p.value})
+		}
+	}
+	// При политике accept запоздавшие сэмплы записываются не по порядку
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+	return points
+}
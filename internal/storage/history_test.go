@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory_Ring(t *testing.T) {
+	h := newHistory()
+	key := historyKey{Gauge, "temp"}
+	start := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	// Буфер из трех точек хранит только последние три значения
+	for i := 0; i < 5; i++ {
+		h.add(key, start.Add(time.Duration(i)*time.Second), float64(i), 0, 3, 100)
+	}
+	points := h.get(key, time.Time{}, time.Time{})
+	require.Len(t, points, 3)
+	assert.Equal(t, []float64{2, 3, 4}, []float64{points[0].Value, points[1].Value, points[2].Value})
+	assert.Equal(t, start.Add(2*time.Second), points[0].Timestamp)
+
+	// Интервал [from, to] включает границы
+	points = h.get(key, start.Add(3*time.Second), start.Add(4*time.Second))
+	assert.Len(t, points, 2)
+	assert.Empty(t, h.get(historyKey{Counter, "temp"}, time.Time{}, time.Time{}))
+}
+
+func TestHistory_Limit(t *testing.T) {
+	h := newHistory()
+	start := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	// Общий лимит в 4 точки: первая серия занимает 3, вторая получает одну
+	for i := 0; i < 3; i++ {
+		h.add(historyKey{Gauge, "a"}, start.Add(time.Duration(i)*time.Second), float64(i), 0, 3, 4*historyPointSize)
+	}
+	for i := 0; i < 3; i++ {
+		h.add(historyKey{Gauge, "b"}, start.Add(time.Duration(i)*time.Second), float64(i), 0, 3, 4*historyPointSize)
+	}
+	h.add(historyKey{Gauge, "c"}, start, 1, 0, 3, 4*historyPointSize)
+
+	assert.Equal(t, 4*historyPointSize, h.bytes)
+	points := h.get(historyKey{Gauge, "b"}, time.Time{}, time.Time{})
+	require.Len(t, points, 1)
+	assert.Equal(t, 2.0, points[0].Value)
+	assert.Empty(t, h.get(historyKey{Gauge, "c"}, time.Time{}, time.Time{}))
+}
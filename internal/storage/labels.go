@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidLabel возвращается, если имя метки пустое или содержит служебные символы ключа серии.
+var ErrInvalidLabel = errors.New("invalid label name")
+
+// SeriesKey строит ключ серии из имени метрики и набора меток: name{k1="v1",k2="v2"}.
+// Метки сортируются по имени, значения экранируются. Без меток ключ совпадает с именем,
+// поэтому метрики без меток хранятся так же, как раньше.
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		escapeLabelValue(&b, labels[k])
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseSeriesKey разбирает ключ, построенный SeriesKey, на имя и метки.
+// Если ключ не содержит корректного набора меток, он целиком считается именем метрики.
+func ParseSeriesKey(key string) (string, map[string]string) {
+	start := strings.IndexByte(key, '{')
+	if start <= 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+
+	labels := make(map[string]string)
+	rest := key[start+1 : len(key)-1]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || eq+1 >= len(rest) || rest[eq+1] != '"' {
+			return key, nil
+		}
+		name := rest[:eq]
+
+		value, n, ok := unescapeLabelValue(rest[eq+2:])
+		if !ok {
+			return key, nil
+		}
+		labels[name] = value
+
+		rest = rest[eq+2+n:]
+		if rest != "" {
+			if rest[0] != ',' {
+				return key, nil
+			}
+			rest = rest[1:]
+		}
+	}
+	if len(labels) == 0 {
+		return key, nil
+	}
+	return key[:start], labels
+}
+
+// CanonicalKey приводит ключ серии к виду, который строит SeriesKey (метки по порядку).
+func CanonicalKey(key string) string {
+	if strings.IndexByte(key, '{') < 0 {
+		return key
+	}
+	return SeriesKey(ParseSeriesKey(key))
+}
+
+// ParseLabels разбирает метки из строки вида "k1=v1,k2=v2", например из флага командной строки.
+func ParseLabels(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", pair)
+		}
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	if err := ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// ValidateLabels проверяет, что имена меток не пустые и не содержат символов = , { } " \ и пробелов.
+func ValidateLabels(labels map[string]string) error {
+	for k := range labels {
+		if k == "" || strings.ContainsAny(k, "=,{}\"\\ \t\n") {
+			return fmt.Errorf("%w: %q", ErrInvalidLabel, k)
+		}
+	}
+	return nil
+}
+
+func escapeLabelValue(b *strings.Builder, v string) {
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+}
+
+// unescapeLabelValue читает экранированное значение до закрывающей кавычки.
+// Возвращает значение и число прочитанных байт вместе с кавычкой.
+func unescapeLabelValue(s string) (string, int, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, true
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, false
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case '\\', '"':
+				b.WriteByte(s[i])
+			default:
+				return "", 0, false
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesKey(t *testing.T) {
+	assert.Equal(t, "Alloc", SeriesKey("Alloc", nil))
+	assert.Equal(t, `Alloc{host="a",region="eu"}`, SeriesKey("Alloc", map[string]string{"region": "eu", "host": "a"}))
+	assert.Equal(t, `m{v="a\"b\\c\nd"}`, SeriesKey("m", map[string]string{"v": "a\"b\\c\nd"}))
+}
+
+func TestParseSeriesKey(t *testing.T) {
+	tests := []struct {
+		key        string
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{key: "Alloc", wantName: "Alloc"},
+		{key: `Alloc{host="a",region="eu"}`, wantName: "Alloc", wantLabels: map[string]string{"host": "a", "region": "eu"}},
+		{key: `m{v="a\"b\\c\nd,e=\"f"}`, wantName: "m", wantLabels: map[string]string{"v": "a\"b\\c\nd,e=\"f"}},
+		{key: `m{}`, wantName: `m{}`},
+		{key: `{host="a"}`, wantName: `{host="a"}`},
+		{key: `m{host=a}`, wantName: `m{host=a}`},
+		{key: `m{host="a"`, wantName: `m{host="a"`},
+		{key: `m{host="a"x}`, wantName: `m{host="a"x}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			name, labels := ParseSeriesKey(tt.key)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	assert.Equal(t, "Alloc", CanonicalKey("Alloc"))
+	assert.Equal(t, `Alloc{a="1",b="2"}`, CanonicalKey(`Alloc{b="2",a="1"}`))
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, ValidateLabels(nil))
+	assert.NoError(t, ValidateLabels(map[string]string{"service.name": "api", "host": ""}))
+	assert.ErrorIs(t, ValidateLabels(map[string]string{"": "x"}), ErrInvalidLabel)
+	assert.ErrorIs(t, ValidateLabels(map[string]string{"a=b": "x"}), ErrInvalidLabel)
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("host=web-1, region=eu")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "web-1", "region": "eu"}, labels)
+
+	labels, err = ParseLabels("")
+	assert.NoError(t, err)
+	assert.Nil(t, labels)
+
+	_, err = ParseLabels("host")
+	assert.Error(t, err)
+
+	_, err = ParseLabels("=x")
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+}
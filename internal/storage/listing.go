@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor возвращается ListMetrics, если курсор не получен из NextCursor предыдущей страницы.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListSort - порядок листинга метрик.
+type ListSort string
+
+const (
+	// SortByName - по имени, затем по меткам и типу.
+	SortByName ListSort = "name"
+	// SortByNameDesc - по имени в обратном порядке.
+	SortByNameDesc ListSort = "-name"
+	// SortByUpdatedAt - по времени последнего сэмпла, метрики без времени первыми.
+	SortByUpdatedAt ListSort = "updated_at"
+	// SortByUpdatedAtDesc - по времени последнего сэмпла, сначала свежие.
+	SortByUpdatedAtDesc ListSort = "-updated_at"
+)
+
+// ParseListSort разбирает порядок листинга. Пустая строка означает SortByName.
+func ParseListSort(s string) (ListSort, error) {
+	switch o := ListSort(s); o {
+	case "":
+		return SortByName, nil
+	case SortByName, SortByNameDesc, SortByUpdatedAt, SortByUpdatedAtDesc:
+		return o, nil
+	default:
+		return "", fmt.Errorf("unknown sort order: %s", s)
+	}
+}
+
+func (o ListSort) desc() bool {
+	return strings.HasPrefix(string(o), "-")
+}
+
+func (o ListSort) byTime() bool {
+	return strings.TrimPrefix(string(o), "-") == string(SortByUpdatedAt)
+}
+
+// ListOptions - фильтры, порядок и страница листинга gauge и counter. Фильтры по имени
+// применяются к имени метрики без меток и объединяются по И.
+type ListOptions struct {
+	Type   string            // gauge или counter; пустой - оба типа
+	Name   string            // точное имя; пустое - любое
+	Prefix string            // префикс имени
+	Glob   string            // шаблон имени (синтаксис path.Match)
+	Regex  string            // регулярное выражение, которое должно найтись в имени
+	Labels map[string]string // метки, которые должны быть у серии с этими значениями; остальные метки не проверяются
+	Sort   ListSort          // порядок; пустой - SortByName
+	Limit  int               // размер страницы; 0 - без ограничения
+	Cursor string            // NextCursor предыдущей страницы; пустой - первая страница
+}
+
+// MetricEntry - gauge или counter в листинге.
+type MetricEntry struct {
+	ID        string            `json:"id"`                   // имя метрики
+	Type      string            `json:"type"`                 // gauge или counter
+	Labels    map[string]string `json:"labels,omitempty"`     // метки серии
+	Value     *float64          `json:"value,omitempty"`      // значение gauge
+	Delta     *int64            `json:"delta,omitempty"`      // накопленное значение counter
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"` // время последнего сэмпла, если известно
+}
+
+// MetricPage - страница листинга.
+type MetricPage struct {
+	Metrics    []MetricEntry `json:"metrics"`
+	NextCursor string        `json:"next_cursor,omitempty"` // пустой на последней странице
+}
+
+// listCursor - ключ сортировки последней метрики страницы. Метрика без времени имеет нулевой UpdatedAt.
+type listCursor struct {
+	UpdatedAt time.Time         `json:"u"`
+	Name      string            `json:"n"`
+	Labels    map[string]string `json:"l,omitempty"`
+	Type      string            `json:"t"`
+}
+
+func entryCursor(e MetricEntry) listCursor {
+	c := listCursor{Name: e.ID, Labels: e.Labels, Type: e.Type}
+	if e.UpdatedAt != nil {
+		c.UpdatedAt = *e.UpdatedAt
+	}
+	return c
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Type != Gauge && c.Type != Counter {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// compare сравнивает ключи сортировки по возрастанию: время (для сортировки по updated_at), имя, метки, тип.
+func (c listCursor) compare(other listCursor, o ListSort) int {
+	if o.byTime() {
+		if n := c.UpdatedAt.Compare(other.UpdatedAt); n != 0 {
+			return n
+		}
+	}
+	if n := strings.Compare(c.Name, other.Name); n != 0 {
+		return n
+	}
+	if n := strings.Compare(SeriesKey("", c.Labels), SeriesKey("", other.Labels)); n != 0 {
+		return n
+	}
+	return strings.Compare(c.Type, other.Type)
+}
+
+// listFilter - проверенные ListOptions.
+type listFilter struct {
+	ListOptions
+	re     *regexp.Regexp
+	cursor *listCursor
+}
+
+// compile проверяет параметры листинга и разбирает курсор.
+func (opts ListOptions) compile() (*listFilter, error) {
+	f := &listFilter{ListOptions: opts}
+	if opts.Type != "" && opts.Type != Gauge && opts.Type != Counter {
+		return nil, fmt.Errorf("listing supports only gauge and counter, got %s", opts.Type)
+	}
+	if f.Sort == "" {
+		f.Sort = SortByName
+	}
+	if _, err := ParseListSort(string(f.Sort)); err != nil {
+		return nil, err
+	}
+	if opts.Glob != "" {
+		if _, err := path.Match(opts.Glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", opts.Glob, err)
+		}
+	}
+	if opts.Regex != "" {
+		re, err := regexp.Compile(opts.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", opts.Regex, err)
+		}
+		f.re = re
+	}
+
+	cursor, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	f.cursor = cursor
+	return f, nil
+}
+
+func (f *listFilter) includesType(metricType string) bool {
+	return f.Type == "" || f.Type == metricType
+}
+
+func (f *listFilter) matchesName(name string) bool {
+	if f.Name != "" && name != f.Name {
+		return false
+	}
+	if !strings.HasPrefix(name, f.Prefix) {
+		return false
+	}
+	if f.Glob != "" {
+		if ok, _ := path.Match(f.Glob, name); !ok {
+			return false
+		}
+	}
+	return f.re == nil || f.re.MatchString(name)
+}
+
+func (f *listFilter) matchesLabels(labels map[string]string) bool {
+	for k, v := range f.Labels {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// page сортирует отфильтрованные метрики, пропускает метрики до курсора и возвращает страницу.
+func (f *listFilter) page(entries []MetricEntry) MetricPage {
+	sort.Slice(entries, func(i, j int) bool {
+		n := entryCursor(entries[i]).compare(entryCursor(entries[j]), f.Sort)
+		if f.Sort.desc() {
+			return n > 0
+		}
+		return n < 0
+	})
+	if f.cursor != nil {
+		i := sort.Search(len(entries), func(i int) bool {
+			n := entryCursor(entries[i]).compare(*f.cursor, f.Sort)
+			if f.Sort.desc() {
+				return n < 0
+			}
+			return n > 0
+		})
+		entries = entries[i:]
+	}
+	return f.trim(entries)
+}
+
+// trim оставляет не больше Limit метрик и заполняет NextCursor, если метрик больше.
+func (f *listFilter) trim(entries []MetricEntry) MetricPage {
+	page := MetricPage{Metrics: entries}
+	if f.Limit > 0 && len(entries) > f.Limit {
+		page.Metrics = entries[:f.Limit]
+		page.NextCursor = entryCursor(entries[f.Limit-1]).encode()
+	}
+	return page
+}
+
+// globRegexp переводит шаблон path.Match в эквивалентное регулярное выражение POSIX для PostgreSQL.
+// Шаблон должен быть проверен path.Match.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			b.WriteByte('[')
+			i++
+			if glob[i] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+			for ; glob[i] != ']'; i++ {
+				escaped := glob[i] == '\\'
+				if escaped {
+					i++
+				}
+				// Внутри класса экранируются символы, особые для регулярных выражений, и экранированные в шаблоне
+				if escaped || strings.IndexByte(`\^[]`, glob[i]) >= 0 {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(glob[i])
+			}
+			b.WriteByte(']')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// likePrefix возвращает шаблон LIKE для строк, начинающихся с prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+}
+
+// regexPrefix возвращает литеральный префикс, которым начинается любая строка, подходящая под регулярное
+// выражение expr, привязанное к началу строки (например, "abc" для ^abc[0-9]+). Для выражений без привязки
+// и без литерального начала возвращается пустая строка.
+func regexPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil || re.Op != syntax.OpConcat || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	var b strings.Builder
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		b.WriteString(string(sub.Rune))
+	}
+	return b.String()
+}
+
+// timeOrNil возвращает nil для нулевого времени.
+func timeOrNil(ts time.Time) *time.Time {
+	if ts.IsZero() {
+		return nil
+	}
+	return &ts
+}
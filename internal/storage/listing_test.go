@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"path"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	names := []string{"", "CPU", "CPUutilization1", "cpu", "a.b", "a*b", "a?b", "a/b", "ab", "a-b", "a]b", "x^y"}
+	for _, glob := range []string{"*", "CPU*", "CPUutilization?", "a.b", `a\*b`, "a?b", "[a-c]*", "[^a]*", `a[\-]b`, `a[\]]b`, "x^y", `[\^]*`, "*/*"} {
+		_, err := path.Match(glob, "")
+		require.NoError(t, err, glob)
+
+		re, err := regexp.Compile(globRegexp(glob))
+		require.NoError(t, err, glob)
+		for _, name := range names {
+			want, _ := path.Match(glob, name)
+			assert.Equal(t, want, re.MatchString(name), "glob %q, name %q", glob, name)
+		}
+	}
+}
+
+func TestLikePrefix(t *testing.T) {
+	assert.Equal(t, `CPU%`, likePrefix("CPU"))
+	assert.Equal(t, `a\_b\%c\\%`, likePrefix(`a_b%c\`))
+}
+
+func TestRegexPrefix(t *testing.T) {
+	for expr, want := range map[string]string{
+		"^CPU":        "CPU",
+		`^disk\d+$`:   "disk",
+		"^ab?c":       "a",
+		"^a.b":        "a",
+		"^abc$":       "abc",
+		"CPU":         "",
+		"(?i)^cpu":    "",
+		"^a|b":        "",
+		"^(abc|abd)":  "",
+		"(?m)^abc":    "",
+		"[":           "",
+		`^a\.b[0-9]*`: "a.b",
+	} {
+		assert.Equal(t, want, regexPrefix(expr), expr)
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	c := listCursor{UpdatedAt: time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC), Name: "temp", Labels: map[string]string{"host": "a"}, Type: Gauge}
+	decoded, err := decodeCursor(c.encode())
+	require.NoError(t, err)
+	assert.Equal(t, c, *decoded)
+
+	decoded, err = decodeCursor("")
+	require.NoError(t, err)
+	assert.Nil(t, decoded)
+
+	for _, s := range []string{"not base64!", "bm90IGpzb24", listCursor{Name: "temp", Type: Histogram}.encode()} {
+		_, err := decodeCursor(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
+
+func TestListOptions_Compile(t *testing.T) {
+	for _, opts := range []ListOptions{
+		{Type: Histogram},
+		{Sort: "value"},
+		{Glob: "[a-"},
+		{Regex: "("},
+		{Cursor: "garbage"},
+	} {
+		_, err := opts.compile()
+		assert.Error(t, err, "%+v", opts)
+	}
+
+	f, err := ListOptions{}.compile()
+	require.NoError(t, err)
+	assert.Equal(t, SortByName, f.Sort)
+}
@@ -4,75 +4,618 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
 )
 
+// MemStorage хранит метрики в памяти. Ключ в картах - ключ серии (см. SeriesKey).
+// Кроме последних значений для gauge и counter ведется история точек и ее минутные и часовые
+// агрегаты (см. GetHistory и Rollup); история не сохраняется в файл.
 type MemStorage struct {
 	sync.Mutex
-	gauges   map[string]float64
-	counters map[string]int64
-	filePath string
+	changeHooks
+	OutOfOrder      OutOfOrderPolicy // обработка gauge-сэмплов старше сохраненного значения
+	HistorySize     int              // число точек истории одной серии, 0 - история не ведется
+	HistoryMaxBytes int              // ограничение памяти под историю и агрегаты всех серий
+	Retention       RetentionPolicy  // сроки хранения истории и устаревших gauge (см. ApplyRetention)
+
+	gauges       map[string]float64
+	gaugeTimes   map[string]time.Time // время последнего сохраненного gauge-сэмпла
+	counters     map[string]int64
+	counterTimes map[string]time.Time // время самого позднего counter-сэмпла
+	histograms   map[string]HistogramValue
+	summaries    map[string]SummaryValue
+	sets         map[string]SetValue
+	revision     int64                   // ревизия последней записи или удаления (см. ListChanges)
+	revisions    map[MetricRef]int64     // ревизия последней записи каждой метрики
+	tombstones   map[MetricRef]tombstone // удаления метрик, которых нет в хранилище
+	resetBefore  int64                   // изменения до этой ревизии неизвестны (загрузка из файла или срок TombstoneRetention)
+	history      *history
+	filePath     string
 }
 
 func NewMemStorage(filePath string) *MemStorage {
 	return &MemStorage{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
-		filePath: filePath,
+		gauges:       make(map[string]float64),
+		gaugeTimes:   make(map[string]time.Time),
+		counters:     make(map[string]int64),
+		counterTimes: make(map[string]time.Time),
+		histograms:   make(map[string]HistogramValue),
+		summaries:    make(map[string]SummaryValue),
+		sets:         make(map[string]SetValue),
+		revisions:    make(map[MetricRef]int64),
+		tombstones:   make(map[MetricRef]tombstone),
+		history:      newHistory(),
+		filePath:     filePath,
+
+		HistorySize:     DefaultHistorySize,
+		HistoryMaxBytes: DefaultHistoryMaxBytes,
 	}
 }
 
 func (s *MemStorage) SaveGaugeMetric(name string, value float64) error {
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+	applied, err := s.setGauge(key, value, sampleTime(nil))
+	if !applied {
+		return err
+	}
+	id, labels := ParseSeriesKey(key)
+	s.notifyGauge(id, labels, value)
+	return nil
+}
+
+// setGauge сохраняет gauge-сэмпл с учетом OutOfOrder и возвращает false, если сэмпл пропущен.
+// Вызывается под блокировкой.
+func (s *MemStorage) setGauge(key string, value float64, ts time.Time) (bool, error) {
+	if ok, err := s.OutOfOrder.checkOrder(s.gaugeTimes[key], ts); !ok {
+		return false, err
+	}
+	s.gauges[key] = value
+	s.gaugeTimes[key] = ts
+	s.addHistory(Gauge, key, ts, value, 0)
+	s.touch(Gauge, key)
+	return true, nil
+}
+
+// addGauge изменяет gauge на delta и возвращает новое значение. Изменение не зависит от порядка сэмплов,
+// поэтому политика OutOfOrder к нему не применяется, а время gauge не уменьшается.
+// Вызывается под блокировкой.
+func (s *MemStorage) addGauge(key string, delta float64, ts time.Time) float64 {
+	s.gauges[key] += delta
+	if ts.After(s.gaugeTimes[key]) {
+		s.gaugeTimes[key] = ts
+	}
+	s.addHistory(Gauge, key, ts, s.gauges[key], 0)
+	s.touch(Gauge, key)
+	return s.gauges[key]
+}
+
+// addCounter добавляет приращение counter и записывает накопленное значение в историю.
+// Вызывается под блокировкой.
+func (s *MemStorage) addCounter(key string, delta int64, ts time.Time) {
+	s.counters[key] += delta
+	if ts.After(s.counterTimes[key]) {
+		s.counterTimes[key] = ts
+	}
+	s.addHistory(Counter, key, ts, float64(s.counters[key]), float64(delta))
+	s.touch(Counter, key)
+}
+
+// touch увеличивает ревизию хранилища и отмечает ею метрику. Вызывается под блокировкой.
+func (s *MemStorage) touch(metricType, key string) {
+	s.revision++
+	ref := MetricRef{Type: metricType, Name: key}
+	s.revisions[ref] = s.revision
+	delete(s.tombstones, ref)
+}
+
+// tombstone - ревизия и время удаления метрики.
+type tombstone struct {
+	revision  int64
+	deletedAt time.Time
+}
+
+// bury увеличивает ревизию хранилища и отмечает ею удаление метрики. Вызывается под блокировкой.
+func (s *MemStorage) bury(metricType, key string) {
+	s.revision++
+	ref := MetricRef{Type: metricType, Name: key}
+	delete(s.revisions, ref)
+	s.tombstones[ref] = tombstone{revision: s.revision, deletedAt: time.Now()}
+}
+
+// pruneTombstones удаляет записи об удалениях старше TombstoneRetention и сдвигает resetBefore
+// за их ревизии: клиент, не видевший удаленной записи, получит полный набор. Вызывается под блокировкой.
+func (s *MemStorage) pruneTombstones(now time.Time) {
+	horizon := now.Add(-TombstoneRetention)
+	for ref, t := range s.tombstones {
+		if t.deletedAt.Before(horizon) {
+			delete(s.tombstones, ref)
+			s.resetBefore = max(s.resetBefore, t.revision)
+		}
+	}
+}
+
+// addHistory добавляет точку в историю серии. Вызывается под блокировкой.
+func (s *MemStorage) addHistory(metricType, key string, ts time.Time, value, delta float64) {
+	s.history.add(historyKey{metricType, key}, ts, value, delta, s.HistorySize, s.HistoryMaxBytes)
+}
+
+// GetHistory возвращает точки истории gauge или counter с временем в [from, to] по возрастанию времени.
+// Агрегаты строятся только по сэмплам, которые еще были в истории при вызове Rollup.
+// Для существующей серии без точек в интервале возвращается пустой срез.
+func (s *MemStorage) GetHistory(metricType, name string, from, to time.Time, res Resolution) ([]HistoryPoint, error) {
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+
+	var exists bool
+	switch metricType {
+	case Gauge:
+		_, exists = s.gauges[key]
+	case Counter:
+		_, exists = s.counters[key]
+	default:
+		return nil, ErrHistoryType
+	}
+	if !exists {
+		return nil, ErrMetricNotFound
+	}
+	hk := historyKey{metricType, key}
+	if res == ResolutionAuto && from.IsZero() {
+		// Без from разрешение выбирается по всей истории серии
+		oldest, newest := s.history.extent(hk)
+		res = autoResolution(oldest, newest, to)
+	}
+	if res = res.resolve(from, to); res != ResolutionRaw {
+		return s.history.getRollup(hk, res, from, to), nil
+	}
+	return s.history.get(hk, from, to), nil
+}
+
+// GetHistories возвращает точки истории серий refs под одной блокировкой.
+func (s *MemStorage) GetHistories(refs []MetricRef, from, to time.Time, res Resolution) (map[MetricRef][]HistoryPoint, error) {
+	for _, ref := range refs {
+		if ref.Type != Gauge && ref.Type != Counter {
+			return nil, ErrHistoryType
+		}
+	}
+	res = res.resolve(from, to)
+
+	s.Lock()
+	defer s.Unlock()
+
+	result := make(map[MetricRef][]HistoryPoint, len(refs))
+	for _, ref := range refs {
+		key := CanonicalKey(ref.Name)
+		var exists bool
+		if ref.Type == Gauge {
+			_, exists = s.gauges[key]
+		} else {
+			_, exists = s.counters[key]
+		}
+		if !exists {
+			continue
+		}
+
+		var points []HistoryPoint
+		if hk := (historyKey{ref.Type, key}); res != ResolutionRaw {
+			points = s.history.getRollup(hk, res, from, to)
+		} else {
+			points = s.history.get(hk, from, to)
+		}
+		if len(points) > 0 {
+			result[MetricRef{Type: ref.Type, Name: key}] = points
+		}
+	}
+	return result, nil
+}
+
+// Rollup добавляет сэмплы истории с временем до границы агрегации (см. RollupDelay) в минутные и часовые агрегаты.
+func (s *MemStorage) Rollup(now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	s.history.rollup(rollupCutoff(now), s.HistoryMaxBytes)
+	return nil
+}
+
+// ApplyRetention удаляет gauge, не обновлявшиеся дольше срока Stale своего правила, вместе с их историей,
+// а также сырые точки и агрегаты старше сроков Raw и Rollups. Удаленные gauge не попадают в файл
+// при следующем сохранении. Записи об удалениях старше TombstoneRetention удаляются и без правил.
+func (s *MemStorage) ApplyRetention(now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	s.pruneTombstones(now)
+	if len(s.Retention) == 0 {
+		return nil
+	}
+
+	rule := func(series string) (RetentionRule, bool) {
+		id, _ := ParseSeriesKey(series)
+		if i := s.Retention.ruleFor(id); i >= 0 {
+			return s.Retention[i], true
+		}
+		return RetentionRule{}, false
+	}
+
+	for key, ts := range s.gaugeTimes {
+		if r, ok := rule(key); ok && r.Stale > 0 && ts.Before(now.Add(-r.Stale)) {
+			delete(s.gauges, key)
+			delete(s.gaugeTimes, key)
+			s.bury(Gauge, key)
+			s.history.remove(historyKey{Gauge, key})
+		}
+	}
+	s.history.expire(now, rule)
+	return nil
+}
+
+// DeleteMetric удаляет метрику и ее историю. Удаленная метрика не попадает в файл при следующем сохранении.
+func (s *MemStorage) DeleteMetric(metricType, name string) error {
 	s.Lock()
 	defer s.Unlock()
-	s.gauges[name] = value
+
+	exists, err := s.deleteMetric(metricType, CanonicalKey(name))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrMetricNotFound
+	}
 	return nil
 }
 
+// DeleteMetrics удаляет метрики refs под одной блокировкой. Типы проверяются до удаления,
+// поэтому ссылка неизвестного типа не оставляет хранилище частично измененным.
+func (s *MemStorage) DeleteMetrics(refs []MetricRef) ([]MetricRef, error) {
+	for _, ref := range refs {
+		if _, ok := metricTables[ref.Type]; !ok {
+			return nil, fmt.Errorf("unknown metric type: %s", ref.Type)
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	deleted := []MetricRef{}
+	for _, ref := range refs {
+		key := CanonicalKey(ref.Name)
+		exists, err := s.deleteMetric(ref.Type, key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			deleted = append(deleted, MetricRef{Type: ref.Type, Name: key})
+		}
+	}
+	return deleted, nil
+}
+
+// deleteMetric удаляет метрику с ключом серии key и ее историю и сообщает, была ли она в хранилище.
+// Вызывается под блокировкой.
+func (s *MemStorage) deleteMetric(metricType, key string) (bool, error) {
+	var exists bool
+	switch metricType {
+	case Gauge:
+		_, exists = s.gauges[key]
+		delete(s.gauges, key)
+		delete(s.gaugeTimes, key)
+	case Counter:
+		_, exists = s.counters[key]
+		delete(s.counters, key)
+		delete(s.counterTimes, key)
+	case Histogram:
+		_, exists = s.histograms[key]
+		delete(s.histograms, key)
+	case Summary:
+		_, exists = s.summaries[key]
+		delete(s.summaries, key)
+	case Set:
+		_, exists = s.sets[key]
+		delete(s.sets, key)
+	default:
+		return false, fmt.Errorf("unknown metric type: %s", metricType)
+	}
+	if exists {
+		s.bury(metricType, key)
+		s.history.remove(historyKey{metricType, key})
+	}
+	return exists, nil
+}
+
 func (s *MemStorage) SaveCounterMetric(name string, delta int64) error {
+	key := CanonicalKey(name)
 	s.Lock()
 	defer s.Unlock()
-	s.counters[name] += delta
+	s.addCounter(key, delta, sampleTime(nil))
+	id, labels := ParseSeriesKey(key)
+	s.notifyCounter(id, labels, delta)
 	return nil
 }
 
+func (s *MemStorage) SaveHistogramMetric(name string, h HistogramValue) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+	if err := s.mergeHistogram(key, h); err != nil {
+		return err
+	}
+	id, labels := ParseSeriesKey(key)
+	s.notifyHistogram(id, labels, h.Clone())
+	return nil
+}
+
+// mergeHistogram добавляет приращение к гистограмме key. Вызывается под блокировкой.
+func (s *MemStorage) mergeHistogram(key string, h HistogramValue) error {
+	current, exists := s.histograms[key]
+	if !exists {
+		current = h.Clone()
+	} else if err := current.Merge(h); err != nil {
+		return err
+	}
+	s.histograms[key] = current
+	s.touch(Histogram, key)
+	return nil
+}
+
+func (s *MemStorage) GetHistogramMetric(name string) (HistogramValue, error) {
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+	value, exists := s.histograms[key]
+	if !exists {
+		return HistogramValue{}, ErrMetricNotFound
+	}
+	return value.Clone(), nil
+}
+
+func (s *MemStorage) SaveSummaryMetric(name string, sv SummaryValue) error {
+	if err := sv.Validate(); err != nil {
+		return err
+	}
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+	if err := s.mergeSummary(key, sv); err != nil {
+		return err
+	}
+	id, labels := ParseSeriesKey(key)
+	s.notifySummary(id, labels, sv.Clone())
+	return nil
+}
+
+// mergeSummary сливает скетч с сохраненным скетчем key. Вызывается под блокировкой.
+func (s *MemStorage) mergeSummary(key string, sv SummaryValue) error {
+	current, exists := s.summaries[key]
+	if !exists {
+		current = sv.Clone()
+	} else if err := current.Merge(sv); err != nil {
+		return err
+	}
+	s.summaries[key] = current
+	s.touch(Summary, key)
+	return nil
+}
+
+func (s *MemStorage) GetSummaryMetric(name string) (SummaryValue, error) {
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+	value, exists := s.summaries[key]
+	if !exists {
+		return SummaryValue{}, ErrMetricNotFound
+	}
+	return value.Clone(), nil
+}
+
+func (s *MemStorage) SaveSetMetric(name string, sv SetValue) error {
+	if err := sv.Validate(); err != nil {
+		return err
+	}
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+	cardinality, err := s.mergeSet(key, sv)
+	if err != nil {
+		return err
+	}
+	id, labels := ParseSeriesKey(key)
+	s.notifySet(id, labels, cardinality)
+	return nil
+}
+
+// mergeSet объединяет скетч с сохраненным скетчем key и возвращает новую оценку.
+// Вызывается под блокировкой.
+func (s *MemStorage) mergeSet(key string, sv SetValue) (int64, error) {
+	current, exists := s.sets[key]
+	if !exists {
+		current = sv.Clone()
+	} else if err := current.Merge(sv); err != nil {
+		return 0, err
+	}
+	s.sets[key] = current
+	s.touch(Set, key)
+	return current.Estimate(), nil
+}
+
+func (s *MemStorage) GetSetMetric(name string) (SetValue, error) {
+	key := CanonicalKey(name)
+	s.Lock()
+	defer s.Unlock()
+	value, exists := s.sets[key]
+	if !exists {
+		return SetValue{}, ErrMetricNotFound
+	}
+	return value.Clone(), nil
+}
+
 func (s *MemStorage) GetGaugeMetric(name string) (float64, error) {
+	key := CanonicalKey(name)
 	s.Lock()
 	defer s.Unlock()
-	value, exists := s.gauges[name]
+	value, exists := s.gauges[key]
 	if !exists {
-		return 0, fmt.Errorf("metric not found")
+		return 0, ErrMetricNotFound
 	}
 	return value, nil
 }
 
 func (s *MemStorage) GetCounterMetric(name string) (int64, error) {
+	key := CanonicalKey(name)
 	s.Lock()
 	defer s.Unlock()
-	value, exists := s.counters[name]
+	value, exists := s.counters[key]
 	if !exists {
-		return 0, fmt.Errorf("metric not found")
+		return 0, ErrMetricNotFound
 	}
 	return value, nil
 }
 
-// GetAllMetrics - возвращает все метрики
-func (s *MemStorage) GetAllMetrics() map[string]interface{} {
+// GetMetrics возвращает копии найденных метрик refs, читая их под одной блокировкой.
+func (s *MemStorage) GetMetrics(refs []MetricRef) (MetricSet, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	found := NewMetricSet()
+	for _, ref := range refs {
+		key := CanonicalKey(ref.Name)
+		switch ref.Type {
+		case Gauge:
+			if value, ok := s.gauges[key]; ok {
+				found.Gauges[key] = value
+			}
+		case Counter:
+			if value, ok := s.counters[key]; ok {
+				found.Counters[key] = value
+			}
+		case Histogram:
+			if value, ok := s.histograms[key]; ok {
+				found.Histograms[key] = value.Clone()
+			}
+		case Summary:
+			if value, ok := s.summaries[key]; ok {
+				found.Summaries[key] = value.Clone()
+			}
+		case Set:
+			if value, ok := s.sets[key]; ok {
+				found.Sets[key] = value.Clone()
+			}
+		}
+	}
+	return found, nil
+}
+
+// ListAllMetrics возвращает копию всех метрик по типам.
+func (s *MemStorage) ListAllMetrics() (MetricSet, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	allMetrics := make(map[string]interface{})
-	for name, value := range s.gauges {
-		allMetrics[name] = value
+	all := NewMetricSet()
+	for key, value := range s.gauges {
+		all.Gauges[key] = value
+	}
+	for key, value := range s.counters {
+		all.Counters[key] = value
 	}
-	for name, value := range s.counters {
-		allMetrics[name] = value
+	for key, value := range s.histograms {
+		all.Histograms[key] = value.Clone()
 	}
-	return allMetrics
+	for key, value := range s.summaries {
+		all.Summaries[key] = value.Clone()
+	}
+	for key, value := range s.sets {
+		all.Sets[key] = value.Clone()
+	}
+	return all, nil
+}
+
+// ListChanges возвращает копии метрик, записанных после ревизии since, удаленные после нее метрики
+// и текущую ревизию. Если since = 0, больше текущей ревизии или меньше ревизии загрузки из файла
+// либо последнего удаления, запись о котором вышла за TombstoneRetention (такие удаления неизвестны),
+// возвращаются все метрики с признаком Reset.
+func (s *MemStorage) ListChanges(since int64) (ChangeSet, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	changes := ChangeSet{Metrics: NewMetricSet(), Revision: s.revision}
+	if since == 0 || since > s.revision || since < s.resetBefore {
+		changes.Reset = true
+		since = 0
+	}
+	for ref, revision := range s.revisions {
+		if revision <= since {
+			continue
+		}
+		switch ref.Type {
+		case Gauge:
+			changes.Metrics.Gauges[ref.Name] = s.gauges[ref.Name]
+		case Counter:
+			changes.Metrics.Counters[ref.Name] = s.counters[ref.Name]
+		case Histogram:
+			changes.Metrics.Histograms[ref.Name] = s.histograms[ref.Name].Clone()
+		case Summary:
+			changes.Metrics.Summaries[ref.Name] = s.summaries[ref.Name].Clone()
+		case Set:
+			changes.Metrics.Sets[ref.Name] = s.sets[ref.Name].Clone()
+		}
+	}
+	if !changes.Reset {
+		for ref, t := range s.tombstones {
+			if t.revision > since {
+				changes.Deleted = append(changes.Deleted, ref)
+			}
+		}
+		sortRefs(changes.Deleted)
+	}
+	return changes, nil
+}
+
+// ListMetrics возвращает страницу gauge и counter, отфильтрованных и упорядоченных по opts.
+func (s *MemStorage) ListMetrics(opts ListOptions) (MetricPage, error) {
+	f, err := opts.compile()
+	if err != nil {
+		return MetricPage{}, err
+	}
+
+	s.Lock()
+	entries := []MetricEntry{}
+	if f.includesType(Gauge) {
+		for key, value := range s.gauges {
+			if id, labels := ParseSeriesKey(key); f.matchesName(id) && f.matchesLabels(labels) {
+				value := value
+				entries = append(entries, MetricEntry{ID: id, Type: Gauge, Labels: labels, Value: &value, UpdatedAt: timeOrNil(s.gaugeTimes[key])})
+			}
+		}
+	}
+	if f.includesType(Counter) {
+		for key, delta := range s.counters {
+			if id, labels := ParseSeriesKey(key); f.matchesName(id) && f.matchesLabels(labels) {
+				delta := delta
+				entries = append(entries, MetricEntry{ID: id, Type: Counter, Labels: labels, Delta: &delta, UpdatedAt: timeOrNil(s.counterTimes[key])})
+			}
+		}
+	}
+	s.Unlock()
+
+	return f.page(entries), nil
+}
+
+// fileSnapshot - содержимое файла MemStorage: метрики по типам, время последних сэмплов gauge и counter
+// и ревизия хранилища.
+type fileSnapshot struct {
+	MetricSet
+	GaugeTimes   map[string]time.Time `json:"gauge_times"`
+	CounterTimes map[string]time.Time `json:"counter_times"`
+	Revision     int64                `json:"revision"`
 }
 
 func (s *MemStorage) Flush() error {
@@ -84,18 +627,39 @@ func (s *MemStorage) Flush() error {
 	}
 
 	return retryFileOperation(func() error {
-		file, err := os.Create(s.filePath)
+		// Снимок пишется во временный файл рядом с основным и заменяет его только после успешной записи,
+		// поэтому ошибка кодирования не стирает предыдущий снимок
+		file, err := os.CreateTemp(filepath.Dir(s.filePath), filepath.Base(s.filePath)+".*.tmp")
 		if err != nil {
 			return err
 		}
+		defer os.Remove(file.Name())
 		defer file.Close()
+		// Права как у файла, созданного os.Create при обычной umask
+		if err := file.Chmod(0o644); err != nil {
+			return err
+		}
 
-		data := map[string]interface{}{
-			"gauges":   s.gauges,
-			"counters": s.counters,
+		data := fileSnapshot{
+			MetricSet: MetricSet{
+				Gauges:     s.gauges,
+				Counters:   s.counters,
+				Histograms: s.histograms,
+				Summaries:  s.summaries,
+				Sets:       s.sets,
+			},
+			GaugeTimes:   s.gaugeTimes,
+			CounterTimes: s.counterTimes,
+			Revision:     s.revision,
 		}
 
-		return json.NewEncoder(file).Encode(data)
+		if err := json.NewEncoder(file).Encode(data); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return os.Rename(file.Name(), s.filePath)
 	})
 }
 
@@ -117,8 +681,13 @@ func (s *MemStorage) Load() error {
 		}
 		defer file.Close()
 
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+
 		data := map[string]interface{}{}
-		if err := json.NewDecoder(file).Decode(&data); err != nil {
+		if err := json.Unmarshal(content, &data); err != nil {
 			return err
 		}
 
@@ -138,6 +707,68 @@ func (s *MemStorage) Load() error {
 			}
 		}
 
+		// Гистограммы и скетчи - структуры, поэтому читаются отдельным типизированным проходом
+		var snapshot struct {
+			GaugeTimes   map[string]time.Time       `json:"gauge_times"`
+			CounterTimes map[string]time.Time       `json:"counter_times"`
+			Revision     int64                      `json:"revision"`
+			Histograms   map[string]json.RawMessage `json:"histograms"`
+			Summaries    map[string]json.RawMessage `json:"summaries"`
+			Sets         map[string]json.RawMessage `json:"sets"`
+		}
+		if err := json.Unmarshal(content, &snapshot); err == nil {
+			for k, ts := range snapshot.GaugeTimes {
+				if _, ok := s.gauges[k]; ok {
+					s.gaugeTimes[k] = ts
+				}
+			}
+			for k, ts := range snapshot.CounterTimes {
+				if _, ok := s.counters[k]; ok {
+					s.counterTimes[k] = ts
+				}
+			}
+			// Для gauge из файла без времени срок Stale отсчитывается от загрузки
+			for k := range s.gauges {
+				if _, ok := s.gaugeTimes[k]; !ok {
+					s.gaugeTimes[k] = time.Now().UTC()
+				}
+			}
+			for k, raw := range snapshot.Histograms {
+				var h HistogramValue
+				if err := json.Unmarshal(raw, &h); err == nil && h.Validate() == nil {
+					s.histograms[k] = h
+				}
+			}
+			for k, raw := range snapshot.Summaries {
+				var sv SummaryValue
+				if err := json.Unmarshal(raw, &sv); err == nil && sv.Validate() == nil {
+					s.summaries[k] = sv
+				}
+			}
+			for k, raw := range snapshot.Sets {
+				var sv SetValue
+				if err := json.Unmarshal(raw, &sv); err == nil && sv.Validate() == nil {
+					s.sets[k] = sv
+				}
+			}
+		}
+
+		// Ревизии отдельных метрик и удаления в файл не сохраняются: загруженные метрики отмечаются
+		// новой ревизией, и клиенты ленты изменений получают полный набор с признаком Reset
+		s.revision = max(s.revision, snapshot.Revision) + 1
+		s.resetBefore = s.revision
+		for metricType, keys := range map[string][]string{
+			Gauge:     mapKeys(s.gauges),
+			Counter:   mapKeys(s.counters),
+			Histogram: mapKeys(s.histograms),
+			Summary:   mapKeys(s.summaries),
+			Set:       mapKeys(s.sets),
+		} {
+			for _, key := range keys {
+				s.revisions[MetricRef{Type: metricType, Name: key}] = s.revision
+			}
+		}
+
 		return nil
 	})
 }
@@ -163,18 +794,60 @@ func (s *MemStorage) UpdateMetricsBatch(metrics []Metrics) error {
 	s.Lock()
 	defer s.Unlock()
 
+	if err := s.checkBatch(metrics); err != nil {
+		return err
+	}
+
 	for _, metric := range metrics {
 		switch metric.MType {
 		case "counter":
 			if metric.Delta == nil {
 				continue
 			}
-			s.counters[metric.ID] += *metric.Delta
+			s.addCounter(SeriesKey(metric.ID, metric.Labels), *metric.Delta, sampleTime(metric.Timestamp))
+			s.notifyCounter(metric.ID, metric.Labels, *metric.Delta)
 		case "gauge":
+			if metric.Value == nil && metric.GaugeDelta != nil {
+				value := s.addGauge(SeriesKey(metric.ID, metric.Labels), *metric.GaugeDelta, sampleTime(metric.Timestamp))
+				s.notifyGauge(metric.ID, metric.Labels, value)
+				continue
+			}
 			if metric.Value == nil {
 				continue
 			}
-			s.gauges[metric.ID] = *metric.Value
+			applied, err := s.setGauge(SeriesKey(metric.ID, metric.Labels), *metric.Value, sampleTime(metric.Timestamp))
+			if err != nil {
+				return err
+			}
+			if !applied {
+				continue
+			}
+			s.notifyGauge(metric.ID, metric.Labels, *metric.Value)
+		case "histogram":
+			if metric.Histogram == nil {
+				continue
+			}
+			if err := s.mergeHistogram(SeriesKey(metric.ID, metric.Labels), *metric.Histogram); err != nil {
+				return err
+			}
+			s.notifyHistogram(metric.ID, metric.Labels, metric.Histogram.Clone())
+		case "summary":
+			if metric.Summary == nil {
+				continue
+			}
+			if err := s.mergeSummary(SeriesKey(metric.ID, metric.Labels), *metric.Summary); err != nil {
+				return err
+			}
+			s.notifySummary(metric.ID, metric.Labels, metric.Summary.Clone())
+		case "set":
+			if metric.Set == nil {
+				continue
+			}
+			cardinality, err := s.mergeSet(SeriesKey(metric.ID, metric.Labels), *metric.Set)
+			if err != nil {
+				return err
+			}
+			s.notifySet(metric.ID, metric.Labels, cardinality)
 		default:
 			continue
 		}
@@ -182,6 +855,74 @@ func (s *MemStorage) UpdateMetricsBatch(metrics []Metrics) error {
 	return nil
 }
 
+// checkBatch заранее проверяет гистограммы, скетчи и время gauge-сэмплов пакета, чтобы пакет
+// с несовместимыми границами, точностью или устаревшими сэмплами не применялся частично.
+// Вызывается под блокировкой.
+func (s *MemStorage) checkBatch(metrics []Metrics) error {
+	times := make(map[string]time.Time)
+	bounds := make(map[string][]float64)
+	accuracy := make(map[string]float64)
+	precision := make(map[string]uint8)
+	for _, metric := range metrics {
+		key := SeriesKey(metric.ID, metric.Labels)
+		switch {
+		case metric.MType == Gauge && metric.Value != nil && s.OutOfOrder == OutOfOrderReject:
+			ts := sampleTime(metric.Timestamp)
+			current, ok := times[key]
+			if !ok {
+				current = s.gaugeTimes[key]
+			}
+			if _, err := s.OutOfOrder.checkOrder(current, ts); err != nil {
+				return err
+			}
+			times[key] = ts
+		case metric.MType == Histogram && metric.Histogram != nil:
+			if err := metric.Histogram.Validate(); err != nil {
+				return err
+			}
+			known, ok := bounds[key]
+			if !ok {
+				if current, exists := s.histograms[key]; exists {
+					known, ok = current.Bounds, true
+				}
+			}
+			if ok && !sameBounds(known, metric.Histogram.Bounds) {
+				return ErrHistogramBounds
+			}
+			bounds[key] = metric.Histogram.Bounds
+		case metric.MType == Summary && metric.Summary != nil:
+			if err := metric.Summary.Validate(); err != nil {
+				return err
+			}
+			known, ok := accuracy[key]
+			if !ok {
+				if current, exists := s.summaries[key]; exists {
+					known, ok = current.Accuracy, true
+				}
+			}
+			if ok && known != metric.Summary.Accuracy {
+				return ErrSummaryAccuracy
+			}
+			accuracy[key] = metric.Summary.Accuracy
+		case metric.MType == Set && metric.Set != nil:
+			if err := metric.Set.Validate(); err != nil {
+				return err
+			}
+			known, ok := precision[key]
+			if !ok {
+				if current, exists := s.sets[key]; exists {
+					known, ok = current.Precision, true
+				}
+			}
+			if ok && known != metric.Set.Precision {
+				return ErrSetPrecision
+			}
+			precision[key] = metric.Set.Precision
+		}
+	}
+	return nil
+}
+
 // retryFileOperation выполняет операцию с файлами с повторными попытками в случае временных ошибок
 func retryFileOperation(operation func() error) error {
 	maxRetries := 4 // Первоначальная попытка + 3 дополнительных
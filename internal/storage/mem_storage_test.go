@@ -2,10 +2,12 @@ package storage
 
 import (
 	"errors"
+	"math"
 	"os"
 	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -50,10 +52,11 @@ func TestMemStorage_Basic(t *testing.T) {
 	assert.Error(t, err)
 
 	// Тестируем получение всех метрик
-	allMetrics := storage.GetAllMetrics()
-	assert.Len(t, allMetrics, 2)
-	assert.Equal(t, 123.456, allMetrics["test_gauge"])
-	assert.Equal(t, int64(52), allMetrics["test_counter"])
+	allMetrics, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 2, allMetrics.Len())
+	assert.Equal(t, 123.456, allMetrics.Gauges["test_gauge"])
+	assert.Equal(t, int64(52), allMetrics.Counters["test_counter"])
 }
 
 func TestMemStorage_Flush(t *testing.T) {
@@ -87,6 +90,35 @@ func TestMemStorage_Flush(t *testing.T) {
 	assert.Equal(t, int64(42), value2)
 }
 
+func TestMemStorage_UpdateMetricsBatch_GaugeDelta(t *testing.T) {
+	storage := NewMemStorage("")
+	storage.OutOfOrder = OutOfOrderReject
+	require.NoError(t, storage.SaveGaugeMetric("connections", 5))
+
+	var changes []Metrics
+	storage.OnChange(func(m Metrics) { changes = append(changes, m) })
+
+	// Относительные изменения складываются с текущим значением независимо от времени сэмпла
+	up, down := 3.0, -1.5
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+		{ID: "connections", MType: Gauge, GaugeDelta: &up},
+		{ID: "connections", MType: Gauge, GaugeDelta: &down, Timestamp: &old},
+		{ID: "queue", MType: Gauge, GaugeDelta: &up},
+	}))
+
+	value, err := storage.GetGaugeMetric("connections")
+	require.NoError(t, err)
+	assert.Equal(t, 6.5, value)
+	value, err = storage.GetGaugeMetric("queue")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, value)
+
+	require.Len(t, changes, 3)
+	assert.Equal(t, 8.0, *changes[0].Value)
+	assert.Equal(t, 6.5, *changes[1].Value)
+}
+
 func TestMemStorage_UpdateMetricsBatch(t *testing.T) {
 	storage := NewMemStorage("")
 
@@ -186,12 +218,13 @@ func TestMemStorage_InvalidMetricsBatch(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func TestMemStorage_GetAllMetrics(t *testing.T) {
+func TestMemStorage_ListAllMetrics(t *testing.T) {
 	storage := NewMemStorage("")
 
 	// Проверка на пустом хранилище
-	allMetrics := storage.GetAllMetrics()
-	assert.Empty(t, allMetrics)
+	allMetrics, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Zero(t, allMetrics.Len())
 
 	// Добавляем метрики
 	storage.SaveGaugeMetric("gauge1", 1.1)
@@ -200,12 +233,56 @@ func TestMemStorage_GetAllMetrics(t *testing.T) {
 	storage.SaveCounterMetric("counter2", 4)
 
 	// Проверяем, что все метрики возвращаются
-	allMetrics = storage.GetAllMetrics()
-	assert.Len(t, allMetrics, 4)
-	assert.Equal(t, 1.1, allMetrics["gauge1"])
-	assert.Equal(t, 2.2, allMetrics["gauge2"])
-	assert.Equal(t, int64(3), allMetrics["counter1"])
-	assert.Equal(t, int64(4), allMetrics["counter2"])
+	allMetrics, err = storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"gauge1": 1.1, "gauge2": 2.2}, allMetrics.Gauges)
+	assert.Equal(t, map[string]int64{"counter1": 3, "counter2": 4}, allMetrics.Counters)
+}
+
+func TestMemStorage_GetMetrics(t *testing.T) {
+	storage := NewMemStorage("")
+	require.NoError(t, storage.SaveGaugeMetric("Alloc", 1.5))
+	require.NoError(t, storage.SaveCounterMetric(`requests{host="a",code="200"}`, 7))
+	users := NewSetValue(MinSetPrecision)
+	users.Add("alice")
+	require.NoError(t, storage.SaveSetMetric("users", users))
+
+	found, err := storage.GetMetrics([]MetricRef{
+		{Type: Gauge, Name: "Alloc"},
+		{Type: Counter, Name: `requests{code="200",host="a"}`},
+		{Type: Counter, Name: `requests{host="b"}`},
+		{Type: Counter, Name: "Alloc"},
+		{Type: Set, Name: "users"},
+		{Type: "meter", Name: "Alloc"},
+	})
+	require.NoError(t, err)
+
+	// Ненайденные метрики и неизвестные типы пропускаются, ключи приводятся к каноническому виду
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, found.Gauges)
+	assert.Equal(t, map[string]int64{`requests{code="200",host="a"}`: 7}, found.Counters)
+	assert.Equal(t, int64(1), found.Sets["users"].Estimate())
+	assert.Equal(t, 3, found.Len())
+}
+
+func TestMemStorage_ListAllMetrics_SameKeyAcrossTypes(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewMemStorage(filePath)
+	require.NoError(t, storage.SaveGaugeMetric(`requests{host="a"}`, 1.5))
+	require.NoError(t, storage.SaveCounterMetric(`requests{host="a"}`, 7))
+	require.NoError(t, storage.SaveSetMetric(`requests{host="a"}`, NewSetValue(DefaultSetPrecision)))
+
+	// Gauge, counter и set с одним ключом не скрывают друг друга ни в листинге, ни в файле
+	require.NoError(t, storage.Flush())
+	restored := NewMemStorage(filePath)
+	require.NoError(t, restored.Load())
+	for _, s := range []*MemStorage{storage, restored} {
+		all, err := s.ListAllMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, 3, all.Len())
+		assert.Equal(t, 1.5, all.Gauges[`requests{host="a"}`])
+		assert.Equal(t, int64(7), all.Counters[`requests{host="a"}`])
+		assert.Equal(t, []string{`requests{host="a"}`}, all.Keys(Set))
+	}
 }
 
 func TestMemStorage_EmptyFilePath(t *testing.T) {
@@ -269,6 +346,28 @@ func TestRunPeriodicSaveShort(t *testing.T) {
 	t.Skip("Cannot mock RunPeriodicSave function")
 }
 
+func TestMemStorage_FlushKeepsSnapshotOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.json")
+	storage := NewMemStorage(path)
+	require.NoError(t, storage.SaveCounterMetric("requests", 42))
+	require.NoError(t, storage.Flush())
+
+	// NaN не кодируется в JSON: Flush завершается ошибкой, а прежний снимок остается на месте
+	require.NoError(t, storage.SaveGaugeMetric("broken", math.NaN()))
+	assert.Error(t, storage.Flush())
+
+	restored := NewMemStorage(path)
+	require.NoError(t, restored.Load())
+	value, err := restored.GetCounterMetric("requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), value)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
 func TestMemStorage_FlushNonexistentDir(t *testing.T) {
 	// Создаем хранилище с несуществующим каталогом
 	nonExistentPath := "/tmp/nonexistent/dir/test_metrics.json"
@@ -413,3 +512,621 @@ func TestMemStorage_FlushFileError(t *testing.T) {
 	err = storage.Flush()
 	assert.Error(t, err)
 }
+
+func TestMemStorage_OnChange(t *testing.T) {
+	storage := NewMemStorage("")
+
+	var changes []Metrics
+	storage.OnChange(func(m Metrics) { changes = append(changes, m) })
+
+	require.NoError(t, storage.SaveGaugeMetric("Alloc", 1.5))
+	require.NoError(t, storage.SaveCounterMetric("PollCount", 2))
+
+	delta := int64(3)
+	value := 2.5
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+		{ID: "PollCount", MType: Counter, Delta: &delta},
+		{ID: "Alloc", MType: Gauge, Value: &value},
+		{ID: "Skipped", MType: Gauge},
+	}))
+
+	require.Len(t, changes, 4)
+	assert.Equal(t, "Alloc", changes[0].ID)
+	assert.Equal(t, 1.5, *changes[0].Value)
+	assert.Equal(t, int64(2), *changes[1].Delta)
+	assert.Equal(t, int64(3), *changes[2].Delta)
+	assert.Equal(t, 2.5, *changes[3].Value)
+}
+
+func TestMemStorage_Labels(t *testing.T) {
+	storage := NewMemStorage("")
+
+	a, b := 1.0, 2.0
+	delta := int64(5)
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+		{ID: "Alloc", MType: Gauge, Value: &a, Labels: map[string]string{"host": "a", "dc": "1"}},
+		{ID: "Alloc", MType: Gauge, Value: &b, Labels: map[string]string{"host": "b"}},
+		{ID: "PollCount", MType: Counter, Delta: &delta, Labels: map[string]string{"host": "a"}},
+	}))
+	require.NoError(t, storage.SaveCounterMetric(`PollCount{host="a"}`, 1))
+
+	// Ключ с метками в другом порядке приводится к каноническому виду
+	value, err := storage.GetGaugeMetric(`Alloc{host="a",dc="1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, value)
+
+	count, err := storage.GetCounterMetric(`PollCount{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+
+	_, err = storage.GetGaugeMetric("Alloc")
+	assert.Error(t, err)
+
+	all, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, all.Gauges[`Alloc{dc="1",host="a"}`])
+	assert.Equal(t, 2.0, all.Gauges[`Alloc{host="b"}`])
+}
+
+func TestMemStorage_Histogram(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test_metrics_*.json")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+	tempFile.Close()
+
+	storage := NewMemStorage(tempFile.Name())
+
+	first := NewHistogramValue([]float64{0.1, 1})
+	first.Observe(0.0625)
+	second := NewHistogramValue([]float64{0.1, 1})
+	second.Observe(0.5)
+	second.Observe(3)
+
+	require.NoError(t, storage.SaveHistogramMetric(`latency{host="a"}`, first))
+	require.NoError(t, storage.SaveHistogramMetric(`latency{host="a"}`, second))
+
+	// Приращения с другими границами отклоняются
+	err = storage.SaveHistogramMetric(`latency{host="a"}`, NewHistogramValue([]float64{1}))
+	assert.ErrorIs(t, err, ErrHistogramBounds)
+
+	want := HistogramValue{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2}, Sum: 3.5625, Count: 3}
+	value, err := storage.GetHistogramMetric(`latency{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, want, value)
+	all, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, want, all.Histograms[`latency{host="a"}`])
+
+	// Пакет с несовместимой гистограммой не применяется частично
+	delta := int64(1)
+	err = storage.UpdateMetricsBatch([]Metrics{
+		{ID: "requests", MType: Counter, Delta: &delta},
+		{ID: "latency", MType: Histogram, Labels: map[string]string{"host": "a"}, Histogram: &HistogramValue{Bounds: []float64{5}, Counts: []int64{1}, Count: 1}},
+	})
+	assert.ErrorIs(t, err, ErrHistogramBounds)
+	_, err = storage.GetCounterMetric("requests")
+	assert.Error(t, err)
+
+	err = storage.UpdateMetricsBatch([]Metrics{
+		{ID: "latency", MType: Histogram, Labels: map[string]string{"host": "a"}, Histogram: &first},
+	})
+	require.NoError(t, err)
+
+	// Гистограммы сохраняются в файл и загружаются обратно
+	require.NoError(t, storage.Flush())
+	storage2 := NewMemStorage(tempFile.Name())
+	require.NoError(t, storage2.Load())
+
+	loaded, err := storage2.GetHistogramMetric(`latency{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, HistogramValue{Bounds: []float64{0.1, 1}, Counts: []int64{2, 3}, Sum: 3.625, Count: 4}, loaded)
+}
+
+func TestMemStorage_Summary(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test_metrics_*.json")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+	tempFile.Close()
+
+	storage := NewMemStorage(tempFile.Name())
+
+	sketch := NewSummaryValue(DefaultSummaryAccuracy)
+	sketch.Add(2)
+	sketch.Add(4)
+	require.NoError(t, storage.SaveSummaryMetric(`rtt{host="a"}`, sketch))
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+		{ID: "rtt", MType: Summary, Labels: map[string]string{"host": "a"}, Summary: &sketch},
+	}))
+
+	err = storage.SaveSummaryMetric(`rtt{host="a"}`, NewSummaryValue(0.05))
+	assert.ErrorIs(t, err, ErrSummaryAccuracy)
+
+	other := NewSummaryValue(0.05)
+	err = storage.UpdateMetricsBatch([]Metrics{
+		{ID: "rtt", MType: Summary, Labels: map[string]string{"host": "a"}, Summary: &other},
+	})
+	assert.ErrorIs(t, err, ErrSummaryAccuracy)
+
+	value, err := storage.GetSummaryMetric(`rtt{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), value.Count)
+	assert.Equal(t, 12.0, value.Sum)
+
+	// Скетчи сохраняются в файл и загружаются обратно
+	require.NoError(t, storage.Flush())
+	storage2 := NewMemStorage(tempFile.Name())
+	require.NoError(t, storage2.Load())
+
+	loaded, err := storage2.GetSummaryMetric(`rtt{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, value, loaded)
+	all, err := storage2.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, value, all.Summaries[`rtt{host="a"}`])
+}
+
+func TestMemStorage_Set(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test_metrics_*.json")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+	tempFile.Close()
+
+	storage := NewMemStorage(tempFile.Name())
+
+	var changes []Metrics
+	storage.OnChange(func(m Metrics) { changes = append(changes, m) })
+
+	first := NewSetValue(DefaultSetPrecision)
+	first.Add("alice")
+	first.Add("bob")
+	second := NewSetValue(DefaultSetPrecision)
+	second.Add("bob")
+	second.Add("carol")
+
+	require.NoError(t, storage.SaveSetMetric(`users{host="a"}`, first))
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+		{ID: "users", MType: Set, Labels: map[string]string{"host": "a"}, Set: &second},
+	}))
+	require.Len(t, changes, 2)
+	assert.Equal(t, int64(3), *changes[1].Cardinality)
+
+	err = storage.SaveSetMetric(`users{host="a"}`, NewSetValue(MinSetPrecision))
+	assert.ErrorIs(t, err, ErrSetPrecision)
+
+	value, err := storage.GetSetMetric(`users{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), value.Estimate())
+
+	// Скетчи сохраняются в файл и загружаются обратно
+	require.NoError(t, storage.Flush())
+	storage2 := NewMemStorage(tempFile.Name())
+	require.NoError(t, storage2.Load())
+
+	loaded, err := storage2.GetSetMetric(`users{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, value, loaded)
+}
+
+func TestMemStorage_OutOfOrder(t *testing.T) {
+	t1 := time.Date(2025, 6, 25, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(10 * time.Second)
+	gauge := func(v float64, ts time.Time) Metrics {
+		return Metrics{ID: "temp", MType: Gauge, Value: &v, Timestamp: &ts}
+	}
+
+	tests := []struct {
+		policy  OutOfOrderPolicy
+		wantErr error
+		want    float64
+	}{
+		{policy: OutOfOrderAccept, want: 1},
+		{policy: OutOfOrderLatest, want: 2},
+		{policy: OutOfOrderReject, wantErr: ErrOutOfOrder, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			storage := NewMemStorage("")
+			storage.OutOfOrder = tt.policy
+
+			require.NoError(t, storage.UpdateMetricsBatch([]Metrics{gauge(2, t2)}))
+
+			// Запоздавший сэмпл вместе с counter: при reject пакет не применяется целиком
+			delta := int64(1)
+			err := storage.UpdateMetricsBatch([]Metrics{
+				{ID: "hits", MType: Counter, Delta: &delta},
+				gauge(1, t1),
+			})
+			assert.ErrorIs(t, err, tt.wantErr)
+
+			value, err := storage.GetGaugeMetric("temp")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, value)
+
+			_, err = storage.GetCounterMetric("hits")
+			assert.Equal(t, tt.wantErr != nil, err != nil)
+		})
+	}
+}
+
+func TestMemStorage_History(t *testing.T) {
+	storage := NewMemStorage("")
+	storage.HistorySize = 10
+	start := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		value, delta := float64(100+i), int64(2)
+		require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+			{ID: "HeapAlloc", MType: Gauge, Value: &value, Timestamp: &ts},
+			{ID: "PollCount", MType: Counter, Delta: &delta, Timestamp: &ts},
+		}))
+	}
+
+	points, err := storage.GetHistory(Gauge, "HeapAlloc", start.Add(time.Minute), time.Time{}, ResolutionRaw)
+	require.NoError(t, err)
+	assert.Equal(t, []HistoryPoint{
+		{Timestamp: start.Add(time.Minute), Value: 101},
+		{Timestamp: start.Add(2 * time.Minute), Value: 102},
+	}, points)
+
+	// Для counter в истории накопленное значение
+	points, err = storage.GetHistory(Counter, "PollCount", time.Time{}, time.Time{}, ResolutionAuto)
+	require.NoError(t, err)
+	require.Len(t, points, 3)
+	assert.Equal(t, 6.0, points[2].Value)
+
+	_, err = storage.GetHistory(Gauge, "Missing", time.Time{}, time.Time{}, ResolutionAuto)
+	assert.Error(t, err)
+	_, err = storage.GetHistory(Histogram, "HeapAlloc", time.Time{}, time.Time{}, ResolutionAuto)
+	assert.ErrorIs(t, err, ErrHistoryType)
+}
+
+func TestMemStorage_RollupLateSample(t *testing.T) {
+	storage := NewMemStorage("")
+	start := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	save := func(ts time.Time, value float64) {
+		require.NoError(t, storage.UpdateMetricsBatch([]Metrics{{ID: "temp", MType: Gauge, Value: &value, Timestamp: &ts}}))
+	}
+
+	// Сэмпл конца минуты приходит после агрегации, запущенной сразу по ее окончании
+	save(start.Add(10*time.Second), 1)
+	require.NoError(t, storage.Rollup(start.Add(time.Minute+time.Second)))
+	save(start.Add(50*time.Second), 3)
+	require.NoError(t, storage.Rollup(start.Add(RollupDelay+time.Minute)))
+
+	points, err := storage.GetHistory(Gauge, "temp", start, start.Add(time.Hour), ResolutionMinute)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, int64(2), points[0].Count)
+	assert.Equal(t, 2.0, *points[0].Avg)
+	assert.Equal(t, 3.0, points[0].Value)
+}
+
+func TestMemStorage_Rollup(t *testing.T) {
+	storage := NewMemStorage("")
+	start := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		ts := start.Add(time.Duration(i) * 30 * time.Second)
+		value := float64(10 * i)
+		require.NoError(t, storage.UpdateMetricsBatch([]Metrics{{ID: "CPUutilization1", MType: Gauge, Value: &value, Timestamp: &ts}}))
+	}
+	require.NoError(t, storage.Rollup(start.Add(RollupDelay+2*time.Minute+time.Second)))
+
+	// Интервал в сутки отдается минутными агрегатами
+	points, err := storage.GetHistory(Gauge, "CPUutilization1", start, start.Add(24*time.Hour), ResolutionAuto)
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, 5.0, *points[0].Avg)
+	assert.Equal(t, 30.0, *points[1].Max)
+
+	points, err = storage.GetHistory(Gauge, "CPUutilization1", start, start.Add(24*time.Hour), ResolutionHour)
+	require.NoError(t, err)
+	require.Len(t, points, 1)
+	assert.Equal(t, int64(4), points[0].Count)
+	assert.Equal(t, 30.0, points[0].Value)
+}
+
+func TestMemStorage_ApplyRetention(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewMemStorage(filePath)
+	storage.Retention = RetentionPolicy{{Pattern: "CPUutilization*", Stale: 10 * time.Minute}}
+	now := time.Date(2025, 7, 3, 12, 0, 0, 0, time.UTC)
+
+	old, fresh := now.Add(-time.Hour), now.Add(-time.Minute)
+	value, delta := 1.0, int64(1)
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+		{ID: "CPUutilization1", MType: Gauge, Labels: map[string]string{"host": "old"}, Value: &value, Timestamp: &old},
+		{ID: "CPUutilization1", MType: Gauge, Labels: map[string]string{"host": "new"}, Value: &value, Timestamp: &fresh},
+		{ID: "HeapAlloc", MType: Gauge, Value: &value, Timestamp: &old},
+		{ID: "CPUutilization_total", MType: Counter, Delta: &delta, Timestamp: &old},
+	}))
+	require.NoError(t, storage.ApplyRetention(now))
+
+	// Устаревший gauge удаляется вместе с историей, правило не затрагивает другие имена и counter
+	staleKey := SeriesKey("CPUutilization1", map[string]string{"host": "old"})
+	all, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.NotContains(t, all.Gauges, staleKey)
+	assert.Contains(t, all.Gauges, SeriesKey("CPUutilization1", map[string]string{"host": "new"}))
+	assert.Contains(t, all.Gauges, "HeapAlloc")
+	assert.Contains(t, all.Counters, "CPUutilization_total")
+	_, err = storage.GetHistory(Gauge, staleKey, time.Time{}, time.Time{}, ResolutionRaw)
+	assert.Error(t, err)
+
+	// Удаленный gauge не попадает в файл
+	require.NoError(t, storage.Flush())
+	restored := NewMemStorage(filePath)
+	require.NoError(t, restored.Load())
+	all, err = restored.ListAllMetrics()
+	require.NoError(t, err)
+	assert.NotContains(t, all.Gauges, staleKey)
+	assert.Equal(t, 3, all.Len())
+}
+
+func TestMemStorage_DeleteMetric(t *testing.T) {
+	storage := NewMemStorage("")
+	require.NoError(t, storage.SaveGaugeMetric(`temp{host="a"}`, 1))
+	require.NoError(t, storage.SaveSetMetric("users", NewSetValue(DefaultSetPrecision)))
+
+	require.NoError(t, storage.DeleteMetric(Gauge, `temp{host="a"}`))
+	_, err := storage.GetGaugeMetric(`temp{host="a"}`)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+	assert.Empty(t, storage.history.get(historyKey{Gauge, `temp{host="a"}`}, time.Time{}, time.Time{}))
+
+	require.NoError(t, storage.DeleteMetric(Set, "users"))
+	assert.ErrorIs(t, storage.DeleteMetric(Set, "users"), ErrMetricNotFound)
+	assert.Error(t, storage.DeleteMetric("meter", "users"))
+	all, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Zero(t, all.Len())
+}
+
+func TestMemStorage_DeleteMetrics(t *testing.T) {
+	storage := NewMemStorage("")
+	require.NoError(t, storage.SaveGaugeMetric(`temp{host="a"}`, 1))
+	require.NoError(t, storage.SaveCounterMetric("requests", 2))
+
+	// Неизвестный тип отклоняется до удаления
+	_, err := storage.DeleteMetrics([]MetricRef{{Type: Gauge, Name: `temp{host="a"}`}, {Type: "meter", Name: "x"}})
+	assert.Error(t, err)
+	all, err := storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 2, all.Len())
+
+	deleted, err := storage.DeleteMetrics([]MetricRef{
+		{Type: Gauge, Name: `temp{host="a"}`},
+		{Type: Gauge, Name: "missing"},
+		{Type: Counter, Name: "requests"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []MetricRef{{Type: Gauge, Name: `temp{host="a"}`}, {Type: Counter, Name: "requests"}}, deleted)
+	all, err = storage.ListAllMetrics()
+	require.NoError(t, err)
+	assert.Zero(t, all.Len())
+	assert.Empty(t, storage.history.get(historyKey{Counter, "requests"}, time.Time{}, time.Time{}))
+}
+
+func TestMemStorage_GetHistories(t *testing.T) {
+	storage := NewMemStorage("")
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		value, delta := float64(i), int64(10)
+		require.NoError(t, storage.UpdateMetricsBatch([]Metrics{
+			{ID: "temp", MType: Gauge, Value: &value, Labels: map[string]string{"host": "a"}, Timestamp: &ts},
+			{ID: "temp", MType: Gauge, Value: &value, Labels: map[string]string{"host": "b"}, Timestamp: &ts},
+			{ID: "requests", MType: Counter, Delta: &delta, Timestamp: &ts},
+		}))
+	}
+
+	history, err := storage.GetHistories([]MetricRef{
+		{Type: Gauge, Name: `temp{host="a"}`},
+		{Type: Counter, Name: "requests"},
+		{Type: Gauge, Name: "missing"},
+	}, start.Add(time.Minute), start.Add(2*time.Minute), ResolutionRaw)
+	require.NoError(t, err)
+	assert.Equal(t, map[MetricRef][]HistoryPoint{
+		{Type: Gauge, Name: `temp{host="a"}`}: {{Timestamp: start.Add(time.Minute), Value: 1}, {Timestamp: start.Add(2 * time.Minute), Value: 2}},
+		{Type: Counter, Name: "requests"}:     {{Timestamp: start.Add(time.Minute), Value: 20}, {Timestamp: start.Add(2 * time.Minute), Value: 30}},
+	}, history)
+
+	_, err = storage.GetHistories([]MetricRef{{Type: Set, Name: "users"}}, time.Time{}, time.Time{}, ResolutionRaw)
+	assert.ErrorIs(t, err, ErrHistoryType)
+}
+
+func TestMemStorage_ListMetrics(t *testing.T) {
+	storage := NewMemStorage("")
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	for i, m := range []struct {
+		id, mtype string
+		labels    map[string]string
+	}{
+		{"CPUutilization1", Gauge, nil},
+		{"CPUutilization2", Gauge, nil},
+		{"PollCount", Counter, nil},
+		{"Alloc", Gauge, map[string]string{"host": "b"}},
+		{"Alloc", Gauge, map[string]string{"host": "a"}},
+		{"Alloc", Counter, nil},
+	} {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		value, delta := float64(i), int64(i)
+		metric := Metrics{ID: m.id, MType: m.mtype, Labels: m.labels, Timestamp: &ts}
+		if m.mtype == Gauge {
+			metric.Value = &value
+		} else {
+			metric.Delta = &delta
+		}
+		require.NoError(t, storage.UpdateMetricsBatch([]Metrics{metric}))
+	}
+
+	ids := func(page MetricPage) []string {
+		result := []string{}
+		for _, e := range page.Metrics {
+			result = append(result, e.Type+":"+SeriesKey(e.ID, e.Labels))
+		}
+		return result
+	}
+
+	page, err := storage.ListMetrics(ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"counter:Alloc", `gauge:Alloc{host="a"}`, `gauge:Alloc{host="b"}`,
+		"gauge:CPUutilization1", "gauge:CPUutilization2", "counter:PollCount"}, ids(page))
+	assert.Empty(t, page.NextCursor)
+	assert.Equal(t, int64(5), *page.Metrics[0].Delta)
+	assert.Nil(t, page.Metrics[0].Value)
+	assert.Equal(t, start.Add(5*time.Minute), *page.Metrics[0].UpdatedAt)
+
+	page, err = storage.ListMetrics(ListOptions{Type: Gauge, Prefix: "CPU"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge:CPUutilization1", "gauge:CPUutilization2"}, ids(page))
+
+	page, err = storage.ListMetrics(ListOptions{Glob: "*Count", Regex: "^P"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"counter:PollCount"}, ids(page))
+
+	page, err = storage.ListMetrics(ListOptions{Name: "Alloc", Labels: map[string]string{"host": "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`gauge:Alloc{host="a"}`}, ids(page))
+
+	// Постраничный обход по времени, сначала свежие
+	var all []string
+	opts := ListOptions{Sort: SortByUpdatedAtDesc, Limit: 4}
+	for {
+		page, err := storage.ListMetrics(opts)
+		require.NoError(t, err)
+		all = append(all, ids(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"counter:Alloc", `gauge:Alloc{host="a"}`, `gauge:Alloc{host="b"}`,
+		"counter:PollCount", "gauge:CPUutilization2", "gauge:CPUutilization1"}, all)
+
+	_, err = storage.ListMetrics(ListOptions{Cursor: "garbage"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestMemStorage_CounterTimesSurviveFlush(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewMemStorage(filePath)
+	ts := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	delta := int64(3)
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{{ID: "PollCount", MType: Counter, Delta: &delta, Timestamp: &ts}}))
+	require.NoError(t, storage.Flush())
+
+	loaded := NewMemStorage(filePath)
+	require.NoError(t, loaded.Load())
+	page, err := loaded.ListMetrics(ListOptions{Type: Counter})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, ts, *page.Metrics[0].UpdatedAt)
+}
+
+func TestMemStorage_ListChanges(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewMemStorage(filePath)
+	storage.OutOfOrder = OutOfOrderLatest
+
+	changes, err := storage.ListChanges(0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), changes.Revision)
+	assert.True(t, changes.Reset)
+	assert.Zero(t, changes.Metrics.Len())
+
+	require.NoError(t, storage.SaveGaugeMetric("Alloc", 1))
+	require.NoError(t, storage.SaveCounterMetric("Alloc", 2))
+	changes, err = storage.ListChanges(0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), changes.Revision)
+	assert.True(t, changes.Reset)
+	assert.Equal(t, map[string]float64{"Alloc": 1}, changes.Metrics.Gauges)
+	assert.Equal(t, map[string]int64{"Alloc": 2}, changes.Metrics.Counters)
+
+	// После ревизии 2 возвращаются только новые записи
+	users := NewSetValue(MinSetPrecision)
+	users.Add("alice")
+	require.NoError(t, storage.SaveSetMetric("users", users))
+	require.NoError(t, storage.SaveCounterMetric("Alloc", 3))
+	changes, err = storage.ListChanges(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), changes.Revision)
+	assert.False(t, changes.Reset)
+	assert.Empty(t, changes.Metrics.Gauges)
+	assert.Equal(t, map[string]int64{"Alloc": 5}, changes.Metrics.Counters)
+	assert.Equal(t, []string{"users"}, changes.Metrics.Keys(Set))
+	assert.Empty(t, changes.Deleted)
+
+	// Пропущенный устаревший сэмпл ревизию не увеличивает
+	past := time.Now().Add(-time.Hour)
+	value := 0.5
+	require.NoError(t, storage.UpdateMetricsBatch([]Metrics{{ID: "Alloc", MType: Gauge, Value: &value, Timestamp: &past}}))
+	changes, err = storage.ListChanges(4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), changes.Revision)
+	assert.Zero(t, changes.Metrics.Len())
+
+	// Удаление и истечение срока хранения сообщаются как удаленные метрики
+	storage.Retention = RetentionPolicy{{Pattern: "Alloc", Stale: time.Minute}}
+	require.NoError(t, storage.DeleteMetric(Set, "users"))
+	require.NoError(t, storage.ApplyRetention(time.Now().Add(time.Hour)))
+	changes, err = storage.ListChanges(4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), changes.Revision)
+	assert.Zero(t, changes.Metrics.Len())
+	assert.Equal(t, []MetricRef{{Type: Gauge, Name: "Alloc"}, {Type: Set, Name: "users"}}, changes.Deleted)
+
+	// Метрика, созданная заново, больше не считается удаленной
+	require.NoError(t, storage.SaveSetMetric("users", users))
+	changes, err = storage.ListChanges(4)
+	require.NoError(t, err)
+	assert.Equal(t, []MetricRef{{Type: Gauge, Name: "Alloc"}}, changes.Deleted)
+	assert.Equal(t, []string{"users"}, changes.Metrics.Keys(Set))
+
+	// После перезапуска удаления до загрузки неизвестны: клиент получает полный набор
+	require.NoError(t, storage.Flush())
+	restored := NewMemStorage(filePath)
+	require.NoError(t, restored.Load())
+	changes, err = restored.ListChanges(7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), changes.Revision)
+	assert.True(t, changes.Reset)
+	assert.Equal(t, 2, changes.Metrics.Len())
+	assert.Empty(t, changes.Deleted)
+
+	// Ревизия больше текущей означает сброс счетчика
+	changes, err = restored.ListChanges(100)
+	require.NoError(t, err)
+	assert.True(t, changes.Reset)
+	assert.Equal(t, 2, changes.Metrics.Len())
+}
+
+func TestMemStorage_ListChangesTombstoneHorizon(t *testing.T) {
+	storage := NewMemStorage("")
+	require.NoError(t, storage.SaveGaugeMetric("Alloc", 1))
+	require.NoError(t, storage.SaveGaugeMetric("HeapAlloc", 2))
+	require.NoError(t, storage.DeleteMetric(Gauge, "Alloc"))
+
+	// До истечения срока запись об удалении сохраняется и без правил хранения
+	require.NoError(t, storage.ApplyRetention(time.Now()))
+	changes, err := storage.ListChanges(2)
+	require.NoError(t, err)
+	assert.False(t, changes.Reset)
+	assert.Equal(t, []MetricRef{{Type: Gauge, Name: "Alloc"}}, changes.Deleted)
+
+	// После срока запись удаляется, и клиент, не видевший удаления, получает полный набор
+	require.NoError(t, storage.ApplyRetention(time.Now().Add(TombstoneRetention+time.Minute)))
+	assert.Empty(t, storage.tombstones)
+	changes, err = storage.ListChanges(2)
+	require.NoError(t, err)
+	assert.True(t, changes.Reset)
+	assert.Empty(t, changes.Deleted)
+	assert.Equal(t, map[string]float64{"HeapAlloc": 2}, changes.Metrics.Gauges)
+
+	// Клиент, уже видевший удаление, продолжает получать изменения
+	changes, err = storage.ListChanges(3)
+	require.NoError(t, err)
+	assert.False(t, changes.Reset)
+	assert.Zero(t, changes.Metrics.Len())
+}
@@ -1,11 +1,113 @@
 package storage
 
+import (
+	"sort"
+	"time"
+)
+
 // Metrics представляет структуру данных для передачи метрик между сервисами.
 // Используется как для входящих запросов, так и для ответов API.
-// Поддерживает два типа метрик: gauge (плавающая точка) и counter (целочисленный счетчик).
+// Поддерживает типы метрик gauge (плавающая точка), counter (целочисленный счетчик),
+// histogram (распределение по бакетам), summary (скетч для вычисления квантилей)
+// и set (оценка числа уникальных элементов).
+// Метрика идентифицируется именем и набором меток (см. SeriesKey).
+// Timestamp - время сбора сэмпла на стороне клиента; если оно не передано, используется время получения.
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение для counter
-	Value *float64 `json:"value,omitempty"` // значение для gauge
+	ID     string            `json:"id" msgpack:"id"`                             // имя метрики
+	MType  string            `json:"type" msgpack:"type"`                         // gauge, counter, histogram, summary или set
+	Delta  *int64            `json:"delta,omitempty" msgpack:"delta,omitempty"`   // значение для counter
+	Value  *float64          `json:"value,omitempty" msgpack:"value,omitempty"`   // значение для gauge или одно наблюдение histogram и summary
+	Labels map[string]string `json:"labels,omitempty" msgpack:"labels,omitempty"` // метки серии, необязательные
+
+	// GaugeDelta - изменение gauge относительно текущего значения, если Value не задано.
+	// UpdateMetricsBatch применяет его атомарно; по сети поле не передается.
+	GaugeDelta *float64 `json:"-" msgpack:"-"`
+
+	Timestamp *time.Time `json:"timestamp,omitempty" msgpack:"timestamp,omitempty"` // время сбора сэмпла, необязательное
+
+	Histogram *HistogramValue    `json:"histogram,omitempty" msgpack:"histogram,omitempty"` // приращение или текущее состояние histogram
+	Summary   *SummaryValue      `json:"summary,omitempty" msgpack:"summary,omitempty"`     // скетч наблюдений или текущее состояние summary
+	Quantiles map[string]float64 `json:"quantiles,omitempty" msgpack:"quantiles,omitempty"` // квантили summary, только в ответах
+
+	Members     []string  `json:"members,omitempty" msgpack:"members,omitempty"`         // новые элементы set
+	Set         *SetValue `json:"set,omitempty" msgpack:"set,omitempty"`                 // скетч HyperLogLog для set
+	Cardinality *int64    `json:"cardinality,omitempty" msgpack:"cardinality,omitempty"` // оценка числа уникальных элементов set, только в ответах и событиях
+}
+
+// MetricSet - все метрики хранилища, разделенные по типам. Ключ в картах - ключ серии (см. SeriesKey).
+// Метрики разных типов с одинаковым ключом хранятся независимо и попадают каждая в свою карту.
+type MetricSet struct {
+	Gauges     map[string]float64        `json:"gauges"`
+	Counters   map[string]int64          `json:"counters"`
+	Histograms map[string]HistogramValue `json:"histograms"`
+	Summaries  map[string]SummaryValue   `json:"summaries"`
+	Sets       map[string]SetValue       `json:"sets"`
+}
+
+// NewMetricSet создает пустой набор метрик.
+func NewMetricSet() MetricSet {
+	return MetricSet{
+		Gauges:     make(map[string]float64),
+		Counters:   make(map[string]int64),
+		Histograms: make(map[string]HistogramValue),
+		Summaries:  make(map[string]SummaryValue),
+		Sets:       make(map[string]SetValue),
+	}
+}
+
+// Len возвращает число метрик всех типов.
+func (m MetricSet) Len() int {
+	return len(m.Gauges) + len(m.Counters) + len(m.Histograms) + len(m.Summaries) + len(m.Sets)
+}
+
+// Keys возвращает отсортированные ключи серий метрик типа metricType.
+func (m MetricSet) Keys(metricType string) []string {
+	var keys []string
+	switch metricType {
+	case Gauge:
+		keys = mapKeys(m.Gauges)
+	case Counter:
+		keys = mapKeys(m.Counters)
+	case Histogram:
+		keys = mapKeys(m.Histograms)
+	case Summary:
+		keys = mapKeys(m.Summaries)
+	case Set:
+		keys = mapKeys(m.Sets)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// MetricRef - ссылка на метрику для пакетного чтения GetMetrics.
+type MetricRef struct {
+	Type string // gauge, counter, histogram, summary или set
+	Name string // ключ серии (см. SeriesKey)
+}
+
+// ChangeSet - метрики, записанные и удаленные после запрошенной ревизии, и текущая ревизия хранилища.
+// Клиент применяет сначала Deleted, затем Metrics: метрика, удаленная и созданная заново, попадает в оба списка.
+type ChangeSet struct {
+	Metrics  MetricSet   // текущие значения измененных метрик
+	Deleted  []MetricRef // удаленные метрики, по типу и ключу серии
+	Reset    bool        // Metrics - полный набор метрик; клиент заменяет им свое состояние, Deleted пуст
+	Revision int64       // текущая ревизия; передается в следующий запрос как since
+}
+
+// sortRefs упорядочивает ссылки по типу и ключу серии.
+func sortRefs(refs []MetricRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Type != refs[j].Type {
+			return refs[i].Type < refs[j].Type
+		}
+		return refs[i].Name < refs[j].Name
+	})
 }
@@ -0,0 +1,62 @@
+package storage
+
+import "sync"
+
+// ChangeFunc вызывается после каждого изменения метрики.
+// Для gauge в Value передается новое значение, для counter в Delta - примененное приращение,
+// для histogram в Histogram и для summary в Summary - добавленные наблюдения,
+// для set в Cardinality - новая оценка числа уникальных элементов.
+// Функция вызывается синхронно на пути записи, поэтому не должна блокироваться
+// и не должна обращаться к хранилищу.
+type ChangeFunc func(m Metrics)
+
+// Notifier реализуется хранилищами, которые сообщают об изменениях метрик.
+type Notifier interface {
+	OnChange(fn ChangeFunc)
+}
+
+// changeHooks хранит зарегистрированные обработчики изменений.
+type changeHooks struct {
+	hooksMu sync.RWMutex
+	hooks   []ChangeFunc
+}
+
+// OnChange регистрирует обработчик изменений метрик.
+func (c *changeHooks) OnChange(fn ChangeFunc) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	c.hooks = append(c.hooks, fn)
+}
+
+// notifyGauge сообщает обработчикам о новом значении gauge.
+func (c *changeHooks) notifyGauge(name string, labels map[string]string, value float64) {
+	c.notify(Metrics{ID: name, MType: Gauge, Value: &value, Labels: labels})
+}
+
+// notifyCounter сообщает обработчикам о приращении counter.
+func (c *changeHooks) notifyCounter(name string, labels map[string]string, delta int64) {
+	c.notify(Metrics{ID: name, MType: Counter, Delta: &delta, Labels: labels})
+}
+
+// notifyHistogram сообщает обработчикам о приращении histogram.
+func (c *changeHooks) notifyHistogram(name string, labels map[string]string, delta HistogramValue) {
+	c.notify(Metrics{ID: name, MType: Histogram, Histogram: &delta, Labels: labels})
+}
+
+// notifySummary сообщает обработчикам о наблюдениях, добавленных в summary.
+func (c *changeHooks) notifySummary(name string, labels map[string]string, delta SummaryValue) {
+	c.notify(Metrics{ID: name, MType: Summary, Summary: &delta, Labels: labels})
+}
+
+// notifySet сообщает обработчикам о новой оценке числа уникальных элементов set.
+func (c *changeHooks) notifySet(name string, labels map[string]string, cardinality int64) {
+	c.notify(Metrics{ID: name, MType: Set, Cardinality: &cardinality, Labels: labels})
+}
+
+func (c *changeHooks) notify(m Metrics) {
+	c.hooksMu.RLock()
+	defer c.hooksMu.RUnlock()
+	for _, fn := range c.hooks {
+		fn(m)
+	}
+}
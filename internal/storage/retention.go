@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultRetentionInterval - период применения правил хранения по умолчанию.
+const DefaultRetentionInterval = time.Minute
+
+// TombstoneRetention - срок хранения записей об удаленных метриках для ленты изменений. Клиент,
+// отставший больше чем на этот срок, получает полный набор метрик с признаком Reset.
+const TombstoneRetention = 24 * time.Hour
+
+// minRawRetention - наименьший срок хранения сырых сэмплов: сэмпл должен дожить до агрегации
+// своей минуты, которая отстает от текущего времени на RollupDelay.
+const minRawRetention = RollupDelay + time.Minute
+
+// RetentionRule - сроки хранения метрик, имя которых подходит под шаблон Pattern (синтаксис path.Match).
+// Нулевой срок не ограничивает хранение.
+type RetentionRule struct {
+	Pattern string
+	Raw     time.Duration // срок хранения сырых сэмплов истории
+	Rollups time.Duration // срок хранения минутных и часовых агрегатов
+	Stale   time.Duration // gauge без новых сэмплов дольше Stale удаляется вместе с историей
+}
+
+// String возвращает правило в формате ParseRetentionRules.
+func (r RetentionRule) String() string {
+	var fields []string
+	for _, f := range []struct {
+		name  string
+		value time.Duration
+	}{{"raw", r.Raw}, {"rollups", r.Rollups}, {"stale", r.Stale}} {
+		if f.value > 0 {
+			fields = append(fields, f.name+":"+f.value.String())
+		}
+	}
+	return r.Pattern + "=" + strings.Join(fields, "/")
+}
+
+// RetentionPolicy - правила хранения. К метрике применяется первое правило, шаблон которого подходит под ее имя.
+type RetentionPolicy []RetentionRule
+
+// ParseRetentionRules разбирает правила вида "CPUutilization*=raw:24h/rollups:720h/stale:10m,*=stale:1h".
+// Сроки задаются в формате time.ParseDuration, отсутствующий срок не ограничивает хранение.
+// Срок raw не может быть короче minRawRetention, иначе сэмплы удалялись бы до агрегации.
+func ParseRetentionRules(s string) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	if strings.TrimSpace(s) == "" {
+		return policy, nil
+	}
+
+	for _, raw := range strings.Split(s, ",") {
+		pattern, fields, ok := strings.Cut(strings.TrimSpace(raw), "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid retention rule %q", raw)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in retention rule %q: %w", raw, err)
+		}
+
+		rule := RetentionRule{Pattern: pattern}
+		for _, field := range strings.Split(fields, "/") {
+			name, value, ok := strings.Cut(field, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid retention field %q in rule %q", field, raw)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid duration %q in retention rule %q", value, raw)
+			}
+			switch name {
+			case "raw":
+				if d > 0 && d < minRawRetention {
+					return nil, fmt.Errorf("raw retention %s in rule %q is shorter than %s: samples would expire before rollup", value, raw, minRawRetention)
+				}
+				rule.Raw = d
+			case "rollups":
+				rule.Rollups = d
+			case "stale":
+				rule.Stale = d
+			default:
+				return nil, fmt.Errorf("unknown retention field %q in rule %q", name, raw)
+			}
+		}
+		policy = append(policy, rule)
+	}
+	return policy, nil
+}
+
+// Strings возвращает правила в формате ParseRetentionRules.
+func (p RetentionPolicy) Strings() []string {
+	rules := make([]string, 0, len(p))
+	for _, r := range p {
+		rules = append(rules, r.String())
+	}
+	return rules
+}
+
+// ruleFor возвращает индекс первого правила, подходящего под имя метрики, или -1.
+func (p RetentionPolicy) ruleFor(name string) int {
+	for i, r := range p {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// Expirer реализуется хранилищами, которые удаляют устаревшие данные по правилам хранения.
+type Expirer interface {
+	// ApplyRetention удаляет сэмплы, агрегаты, gauge и записи об удалениях, срок хранения которых
+	// истек к моменту now.
+	ApplyRetention(now time.Time) error
+}
+
+// RunPeriodicRetention - применяет правила хранения при запуске и затем периодически.
+func RunPeriodicRetention(e Expirer, interval time.Duration) {
+	if err := e.ApplyRetention(time.Now()); err != nil {
+		log.Printf("Error applying retention: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := e.ApplyRetention(now); err != nil {
+			log.Printf("Error applying retention: %v", err)
+		}
+	}
+}
+
+// remove удаляет историю и агрегаты серии key.
+func (h *history) remove(key historyKey) {
+	if r, ok := h.rings[key]; ok {
+		h.bytes -= len(r.points) * historyPointSize
+		delete(h.rings, key)
+	}
+	if series, ok := h.rollups[key]; ok {
+		h.bytes -= series.size()
+		delete(h.rollups, key)
+	}
+}
+
+// expire удаляет сырые точки и агрегаты старше сроков правила, которое rule возвращает для серии.
+func (h *history) expire(now time.Time, rule func(series string) (RetentionRule, bool)) {
+	for key, r := range h.rings {
+		if rr, ok := rule(key.series); ok && rr.Raw > 0 {
+			before := now.Add(-rr.Raw).UnixNano()
+			kept := make([]historyPoint, 0, len(r.points))
+			for i := range r.points {
+				if p := r.points[(r.next+i)%len(r.points)]; p.ts >= before {
+					kept = append(kept, p)
+				}
+			}
+			h.bytes -= (len(r.points) - len(kept)) * historyPointSize
+			r.points, r.next = kept, 0
+			if len(kept) == 0 {
+				delete(h.rings, key)
+			}
+		}
+	}
+
+	for key, series := range h.rollups {
+		if rr, ok := rule(key.series); ok && rr.Rollups > 0 {
+			before := now.Add(-rr.Rollups).UnixNano()
+			h.bytes -= series.size()
+			series.minutes = expireBuckets(series.minutes, before)
+			series.hours = expireBuckets(series.hours, before)
+			h.bytes += series.size()
+			if len(series.minutes) == 0 && len(series.hours) == 0 {
+				delete(h.rollups, key)
+			}
+		}
+	}
+}
+
+// expireBuckets удаляет агрегаты, начавшиеся раньше before. Агрегаты упорядочены по началу интервала.
+func expireBuckets(buckets []rollupBucket, before int64) []rollupBucket {
+	i := 0
+	for i < len(buckets) && buckets[i].start < before {
+		i++
+	}
+	return append(buckets[:0], buckets[i:]...)
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionRules(t *testing.T) {
+	policy, err := ParseRetentionRules("CPUutilization*=raw:24h/rollups:720h/stale:10m, *=stale:1h")
+	require.NoError(t, err)
+	assert.Equal(t, RetentionPolicy{
+		{Pattern: "CPUutilization*", Raw: 24 * time.Hour, Rollups: 720 * time.Hour, Stale: 10 * time.Minute},
+		{Pattern: "*", Stale: time.Hour},
+	}, policy)
+	assert.Equal(t, []string{"CPUutilization*=raw:24h0m0s/rollups:720h0m0s/stale:10m0s", "*=stale:1h0m0s"}, policy.Strings())
+
+	// Первое подходящее правило
+	assert.Equal(t, 0, policy.ruleFor("CPUutilization1"))
+	assert.Equal(t, 1, policy.ruleFor("HeapAlloc"))
+
+	policy, err = ParseRetentionRules("")
+	require.NoError(t, err)
+	assert.Empty(t, policy)
+
+	for _, s := range []string{"CPU*", "=stale:1h", "[=stale:1h", "CPU*=stale", "CPU*=stale:soon", "CPU*=stale:-1h", "CPU*=forever:1h", "CPU*=raw:1m"} {
+		_, err := ParseRetentionRules(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestHistory_Expire(t *testing.T) {
+	h := newHistory()
+	key := historyKey{Gauge, "temp"}
+	start := time.Date(2025, 7, 3, 12, 0, 0, 0, time.UTC)
+
+	// Буфер заполнен по кругу: точки 2..5
+	for i := 0; i < 6; i++ {
+		h.add(key, start.Add(time.Duration(i)*time.Minute), float64(i), 0, 4, 1<<10)
+	}
+	h.rollup(start.Add(6*time.Minute), 1<<10)
+
+	rule := func(series string) (RetentionRule, bool) {
+		return RetentionRule{Raw: 2 * time.Minute, Rollups: 3 * time.Minute}, series == "temp"
+	}
+	h.expire(start.Add(6*time.Minute), rule)
+
+	points := h.get(key, time.Time{}, time.Time{})
+	require.Len(t, points, 2)
+	assert.Equal(t, 4.0, points[0].Value)
+	assert.Equal(t, 2*historyPointSize+3*rollupBucketSize, h.bytes)
+	assert.Len(t, h.getRollup(key, ResolutionMinute, time.Time{}, time.Time{}), 3)
+	assert.Empty(t, h.getRollup(key, ResolutionHour, time.Time{}, time.Time{}))
+
+	// После очистки буфер снова растет до своего размера
+	h.add(key, start.Add(6*time.Minute), 6, 0, 4, 1<<10)
+	assert.Len(t, h.get(key, time.Time{}, time.Time{}), 3)
+
+	h.remove(key)
+	assert.Equal(t, 0, h.bytes)
+	assert.Empty(t, h.get(key, time.Time{}, time.Time{}))
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultRollupInterval - период фоновой агрегации истории по умолчанию.
+	DefaultRollupInterval = time.Minute
+
+	// RollupDelay - на сколько агрегация отстает от текущего времени. Сэмпл записывается со временем
+	// сбора на стороне агента и приходит с задержкой до интервала отправки; пока сэмпл моложе RollupDelay,
+	// его интервал еще не агрегирован. Сэмплы, пришедшие позже, в агрегаты не попадают.
+	RollupDelay = 5 * time.Minute
+
+	// rawSpanLimit и minuteSpanLimit - наибольшая длина интервала, для которой ResolutionFor
+	// выбирает сырые точки и минутные интервалы соответственно.
+	rawSpanLimit    = 3 * time.Hour
+	minuteSpanLimit = 3 * 24 * time.Hour
+
+	// minuteBucketsLimit и hourBucketsLimit - наибольшее число агрегированных интервалов одной серии в памяти
+	// (три дня минутных и 90 дней часовых). Агрегаты занимают память из того же лимита, что и сырые точки.
+	minuteBucketsLimit = 3 * 24 * 60
+	hourBucketsLimit   = 90 * 24
+)
+
+// Resolution - разрешение точек истории: сырые сэмплы или агрегаты за минуту или час.
+type Resolution string
+
+const (
+	// ResolutionAuto - разрешение выбирается по длине запрошенного интервала (см. ResolutionFor).
+	ResolutionAuto Resolution = ""
+	// ResolutionRaw - сырые сэмплы.
+	ResolutionRaw Resolution = "raw"
+	// ResolutionMinute - агрегаты за минуту.
+	ResolutionMinute Resolution = "1m"
+	// ResolutionHour - агрегаты за час.
+	ResolutionHour Resolution = "1h"
+)
+
+// ParseResolution разбирает разрешение: raw, 1m или 1h. Пустая строка и auto означают ResolutionAuto.
+func ParseResolution(s string) (Resolution, error) {
+	switch r := Resolution(s); r {
+	case ResolutionAuto, "auto":
+		return ResolutionAuto, nil
+	case ResolutionRaw, ResolutionMinute, ResolutionHour:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown resolution: %s", s)
+	}
+}
+
+// ResolutionFor выбирает разрешение для интервала [from, to]: сырые сэмплы для интервалов до 3 часов,
+// минутные агрегаты до 3 дней, часовые для более длинных. Нулевой to означает текущее время.
+// При запросе без from хранилища передают границы всей истории серии (см. extent),
+// поэтому нулевой from означает, что истории у серии нет.
+func ResolutionFor(from, to time.Time) Resolution {
+	if from.IsZero() {
+		return ResolutionRaw
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	switch span := to.Sub(from); {
+	case span <= rawSpanLimit:
+		return ResolutionRaw
+	case span <= minuteSpanLimit:
+		return ResolutionMinute
+	default:
+		return ResolutionHour
+	}
+}
+
+// resolve заменяет ResolutionAuto разрешением для интервала [from, to].
+func (r Resolution) resolve(from, to time.Time) Resolution {
+	if r == ResolutionAuto {
+		return ResolutionFor(from, to)
+	}
+	return r
+}
+
+// step возвращает длину агрегированного интервала.
+func (r Resolution) step() time.Duration {
+	if r == ResolutionHour {
+		return time.Hour
+	}
+	return time.Minute
+}
+
+// Downsampler реализуется хранилищами, которые агрегируют историю в минутные и часовые интервалы.
+type Downsampler interface {
+	// Rollup агрегирует сэмплы, время которых раньше начала минуты, содержащей now - RollupDelay.
+	Rollup(now time.Time) error
+}
+
+// RunPeriodicRollup - запускает периодическую агрегацию истории.
+func RunPeriodicRollup(d Downsampler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := d.Rollup(now); err != nil {
+			log.Printf("Error rolling up history: %v", err)
+		}
+	}
+}
+
+// rollupBucket - агрегат сэмплов серии за интервал. Для gauge sum - сумма значений,
+// для counter - сумма приращений; last - последнее значение (для counter накопленное).
+type rollupBucket struct {
+	start int64 // начало интервала в наносекундах Unix
+	min   float64
+	max   float64
+	sum   float64
+	last  float64
+	count int64
+}
+
+// add добавляет сэмпл в агрегат. Сэмплы добавляются по возрастанию времени.
+func (b *rollupBucket) add(value, delta float64, metricType string) {
+	if b.count == 0 || value < b.min {
+		b.min = value
+	}
+	if b.count == 0 || value > b.max {
+		b.max = value
+	}
+	if metricType == Counter {
+		b.sum += delta
+	} else {
+		b.sum += value
+	}
+	b.last = value
+	b.count++
+}
+
+// point возвращает агрегат как точку истории: для gauge с min, max и avg, для counter с sum.
+func (b rollupBucket) point(metricType string) HistoryPoint {
+	p := HistoryPoint{Timestamp: time.Unix(0, b.start).UTC(), Value: b.last, Count: b.count}
+	if metricType == Counter {
+		sum := b.sum
+		p.Sum = &sum
+		return p
+	}
+	minValue, maxValue, avg := b.min, b.max, b.sum/float64(b.count)
+	p.Min, p.Max, p.Avg = &minValue, &maxValue, &avg
+	return p
+}
+
+// rollupSeries - агрегаты одной серии, упорядоченные по началу интервала.
+type rollupSeries struct {
+	minutes []rollupBucket
+	hours   []rollupBucket
+}
+
+// size возвращает память, занятую агрегатами серии.
+func (s *rollupSeries) size() int {
+	return (len(s.minutes) + len(s.hours)) * rollupBucketSize
+}
+
+// addBucket добавляет сэмпл с временем ts в агрегат интервала step в конце buckets. Новый агрегат
+// добавляется, пока у серии меньше limit агрегатов и он помещается в maxBytes; иначе вытесняется
+// самый старый агрегат серии. Если у серии еще нет агрегатов, а память исчерпана, сэмпл пропускается.
+func (h *history) addBucket(buckets []rollupBucket, ts int64, step time.Duration, value, delta float64, metricType string, limit, maxBytes int) []rollupBucket {
+	start := time.Unix(0, ts).Truncate(step).UnixNano()
+	if n := len(buckets); n == 0 || buckets[n-1].start != start {
+		switch {
+		case n < limit && h.bytes+rollupBucketSize <= maxBytes:
+			h.bytes += rollupBucketSize
+		case n == 0:
+			return buckets
+		default:
+			buckets = append(buckets[:0], buckets[1:]...)
+		}
+		buckets = append(buckets, rollupBucket{start: start})
+	}
+	buckets[len(buckets)-1].add(value, delta, metricType)
+	return buckets
+}
+
+// rollup добавляет в агрегаты все точки с временем в [h.watermark, cutoff) и сдвигает watermark.
+// Точки, записанные с временем раньше watermark после агрегации, в агрегаты не попадают,
+// поэтому cutoff отстает от текущего времени на RollupDelay.
+// Агрегаты занимают память из лимита maxBytes вместе с сырыми точками (см. addBucket).
+func (h *history) rollup(cutoff time.Time, maxBytes int) {
+	end := cutoff.UnixNano()
+	if end <= h.watermark {
+		return
+	}
+	for key, r := range h.rings {
+		points := make([]historyPoint, 0, len(r.points))
+		for _, p := range r.points {
+			if p.ts >= h.watermark && p.ts < end {
+				points = append(points, p)
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		sort.SliceStable(points, func(i, j int) bool { return points[i].ts < points[j].ts })
+
+		series, ok := h.rollups[key]
+		if !ok {
+			series = &rollupSeries{}
+			h.rollups[key] = series
+		}
+		for _, p := range points {
+			series.minutes = h.addBucket(series.minutes, p.ts, time.Minute, p.value, p.delta, key.metricType, minuteBucketsLimit, maxBytes)
+			series.hours = h.addBucket(series.hours, p.ts, time.Hour, p.value, p.delta, key.metricType, hourBucketsLimit, maxBytes)
+		}
+		if len(series.minutes) == 0 && len(series.hours) == 0 {
+			delete(h.rollups, key)
+		}
+	}
+	h.watermark = end
+}
+
+// extent возвращает время самой старой и самой новой сырой точки или агрегата серии key.
+// Для серии без истории возвращаются нулевые значения.
+func (h *history) extent(key historyKey) (oldest, newest time.Time) {
+	var first, last int64
+	found := false
+	keep := func(ts int64) {
+		if !found {
+			first, last, found = ts, ts, true
+		}
+		first, last = min(first, ts), max(last, ts)
+	}
+	if r, ok := h.rings[key]; ok {
+		for _, p := range r.points {
+			keep(p.ts)
+		}
+	}
+	if series, ok := h.rollups[key]; ok {
+		for _, buckets := range [][]rollupBucket{series.minutes, series.hours} {
+			if n := len(buckets); n > 0 {
+				keep(buckets[0].start)
+				keep(buckets[n-1].start)
+			}
+		}
+	}
+	if !found {
+		return time.Time{}, time.Time{}
+	}
+	return time.Unix(0, first).UTC(), time.Unix(0, last).UTC()
+}
+
+// autoResolution выбирает разрешение для запроса без from по границам истории серии [oldest, newest]:
+// запрос всей истории длиннее 3 часов отдается агрегатами, а не всеми сырыми сэмплами.
+// Заданный to заменяет newest.
+func autoResolution(oldest, newest, to time.Time) Resolution {
+	if !to.IsZero() {
+		newest = to
+	}
+	if oldest.IsZero() {
+		return ResolutionRaw
+	}
+	return ResolutionFor(oldest, newest)
+}
+
+// getRollup возвращает агрегаты серии key с разрешением res, пересекающиеся с [from, to].
+func (h *history) getRollup(key historyKey, res Resolution, from, to time.Time) []HistoryPoint {
+	points := []HistoryPoint{}
+	series, ok := h.rollups[key]
+	if !ok {
+		return points
+	}
+	buckets := series.minutes
+	if res == ResolutionHour {
+		buckets = series.hours
+	}
+
+	for _, b := range buckets {
+		start := time.Unix(0, b.start)
+		if !from.IsZero() && !start.Add(res.step()).After(from) {
+			continue
+		}
+		if !to.IsZero() && start.After(to) {
+			continue
+		}
+		points = append(points, b.point(key.metricType))
+	}
+	return points
+}
+
+// rollupCutoff возвращает границу агрегации для момента now: начало минуты, содержащей now - RollupDelay.
+func rollupCutoff(now time.Time) time.Time {
+	return now.UTC().Add(-RollupDelay).Truncate(time.Minute)
+}
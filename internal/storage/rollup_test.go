@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolution(t *testing.T) {
+	for s, want := range map[string]Resolution{"": ResolutionAuto, "auto": ResolutionAuto, "raw": ResolutionRaw, "1m": ResolutionMinute, "1h": ResolutionHour} {
+		res, err := ParseResolution(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, res)
+	}
+	_, err := ParseResolution("5m")
+	assert.Error(t, err)
+}
+
+func TestResolutionFor(t *testing.T) {
+	to := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, ResolutionRaw, ResolutionFor(time.Time{}, to))
+	assert.Equal(t, ResolutionRaw, ResolutionFor(to.Add(-time.Hour), to))
+	assert.Equal(t, ResolutionMinute, ResolutionFor(to.Add(-24*time.Hour), to))
+	assert.Equal(t, ResolutionHour, ResolutionFor(to.Add(-14*24*time.Hour), to))
+
+	// Запрос без from выбирает разрешение по границам истории серии
+	assert.Equal(t, ResolutionRaw, autoResolution(time.Time{}, time.Time{}, to))
+	assert.Equal(t, ResolutionRaw, autoResolution(to.Add(-time.Hour), to, time.Time{}))
+	assert.Equal(t, ResolutionMinute, autoResolution(to.Add(-time.Hour), to, to.Add(5*time.Hour)))
+	assert.Equal(t, ResolutionHour, autoResolution(to.Add(-30*24*time.Hour), to, time.Time{}))
+}
+
+func TestHistory_Rollup(t *testing.T) {
+	h := newHistory()
+	gauge, counter := historyKey{Gauge, "temp"}, historyKey{Counter, "hits"}
+	start := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	// Две минуты по три сэмпла; counter растет на 1 каждые 20 секунд
+	for i := 0; i < 6; i++ {
+		ts := start.Add(time.Duration(i) * 20 * time.Second)
+		h.add(gauge, ts, float64(i), 0, 100, 1<<20)
+		h.add(counter, ts, float64(i+1), 1, 100, 1<<20)
+	}
+
+	// Агрегируется только завершенная первая минута
+	h.rollup(start.Add(time.Minute), 1<<20)
+	points := h.getRollup(gauge, ResolutionMinute, time.Time{}, time.Time{})
+	require.Len(t, points, 1)
+	assert.Equal(t, start, points[0].Timestamp)
+	assert.Equal(t, 2.0, points[0].Value)
+	assert.Equal(t, 0.0, *points[0].Min)
+	assert.Equal(t, 2.0, *points[0].Max)
+	assert.Equal(t, 1.0, *points[0].Avg)
+	assert.Equal(t, int64(3), points[0].Count)
+
+	// Повторный вызов добавляет только новые сэмплы, часовой агрегат накапливает обе минуты
+	h.rollup(start.Add(2*time.Minute), 1<<20)
+	assert.Len(t, h.getRollup(gauge, ResolutionMinute, time.Time{}, time.Time{}), 2)
+	points = h.getRollup(counter, ResolutionHour, time.Time{}, time.Time{})
+	require.Len(t, points, 1)
+	assert.Equal(t, 6.0, *points[0].Sum)
+	assert.Equal(t, 6.0, points[0].Value)
+	assert.Nil(t, points[0].Min)
+
+	oldest, newest := h.extent(gauge)
+	assert.Equal(t, start, oldest)
+	assert.Equal(t, start.Add(100*time.Second), newest)
+
+	// Интервал включает агрегаты, пересекающиеся с [from, to]
+	points = h.getRollup(gauge, ResolutionMinute, start.Add(30*time.Second), start.Add(30*time.Second))
+	require.Len(t, points, 1)
+	assert.Equal(t, start, points[0].Timestamp)
+}
+
+func TestHistory_RollupMemoryLimit(t *testing.T) {
+	h := newHistory()
+	key := historyKey{Gauge, "temp"}
+	start := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	// Лимит на две сырые точки и три агрегата: минутные агрегаты вытесняют друг друга
+	maxBytes := 2*historyPointSize + 3*rollupBucketSize
+	for i := 0; i < 4; i++ {
+		h.add(key, start.Add(time.Duration(i)*time.Minute), float64(i), 0, 2, maxBytes)
+		h.rollup(start.Add(time.Duration(i+1)*time.Minute), maxBytes)
+		assert.LessOrEqual(t, h.bytes, maxBytes)
+	}
+
+	points := h.getRollup(key, ResolutionMinute, time.Time{}, time.Time{})
+	require.Len(t, points, 2)
+	assert.Equal(t, start.Add(2*time.Minute), points[0].Timestamp)
+	assert.Equal(t, start.Add(3*time.Minute), points[1].Timestamp)
+	points = h.getRollup(key, ResolutionHour, time.Time{}, time.Time{})
+	require.Len(t, points, 1)
+	assert.Equal(t, int64(4), points[0].Count)
+
+	// Новой серии не хватает памяти ни на точки, ни на агрегаты
+	other := historyKey{Gauge, "other"}
+	h.add(other, start, 1, 0, 2, maxBytes)
+	h.rollup(start.Add(5*time.Minute), maxBytes)
+	assert.Empty(t, h.getRollup(other, ResolutionMinute, time.Time{}, time.Time{}))
+	assert.Equal(t, maxBytes, h.bytes)
+}
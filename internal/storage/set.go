@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	// DefaultSetPrecision - точность HyperLogLog по умолчанию: 2^14 регистров, погрешность около 0.8%.
+	DefaultSetPrecision = 14
+	// MinSetPrecision и MaxSetPrecision ограничивают допустимую точность скетча.
+	MinSetPrecision = 4
+	MaxSetPrecision = 16
+
+	// setEncodingVersion - версия двоичного представления SetValue.
+	setEncodingVersion = 1
+)
+
+// ErrInvalidSet возвращается для скетча с некорректной точностью или регистрами.
+var ErrInvalidSet = errors.New("invalid set")
+
+// ErrSetPrecision возвращается при слиянии скетчей с разной точностью.
+var ErrSetPrecision = errors.New("set precision mismatch")
+
+// SetValue - скетч HyperLogLog для оценки числа уникальных элементов.
+// Сами элементы не хранятся: каждый хешируется, и в регистре, выбранном старшими битами хеша,
+// запоминается максимальная позиция первой единицы в остальных битах. Слияние скетчей
+// с одинаковой точностью - поэлементный максимум регистров, поэтому скетчи разных агентов
+// можно объединять в любом порядке.
+type SetValue struct {
+	Precision uint8  `json:"precision" msgpack:"precision"` // число бит индекса регистра
+	Registers []byte `json:"registers" msgpack:"registers"` // 2^Precision регистров
+}
+
+// NewSetValue создает пустой скетч с заданной точностью.
+func NewSetValue(precision uint8) SetValue {
+	return SetValue{
+		Precision: precision,
+		Registers: make([]byte, 1<<precision),
+	}
+}
+
+// Validate проверяет точность скетча, число регистров и их значения.
+func (s SetValue) Validate() error {
+	if s.Precision < MinSetPrecision || s.Precision > MaxSetPrecision {
+		return fmt.Errorf("%w: precision must be in [%d, %d]", ErrInvalidSet, MinSetPrecision, MaxSetPrecision)
+	}
+	if len(s.Registers) != 1<<s.Precision {
+		return fmt.Errorf("%w: %d registers for precision %d", ErrInvalidSet, len(s.Registers), s.Precision)
+	}
+	maxRank := byte(64 - s.Precision + 1)
+	for _, r := range s.Registers {
+		if r > maxRank {
+			return fmt.Errorf("%w: register value %d exceeds %d", ErrInvalidSet, r, maxRank)
+		}
+	}
+	return nil
+}
+
+// Add добавляет элемент множества.
+func (s *SetValue) Add(member string) {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	x := mix64(h.Sum64())
+
+	idx := x >> (64 - s.Precision)
+	rank := byte(bits.LeadingZeros64(x<<s.Precision|1<<(s.Precision-1)) + 1)
+	if rank > s.Registers[idx] {
+		s.Registers[idx] = rank
+	}
+}
+
+// Merge объединяет скетч с другим скетчем той же точности.
+func (s *SetValue) Merge(other SetValue) error {
+	if s.Precision != other.Precision || len(s.Registers) != len(other.Registers) {
+		return ErrSetPrecision
+	}
+	for i, r := range other.Registers {
+		if r > s.Registers[i] {
+			s.Registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Estimate возвращает оценку числа уникальных элементов.
+// Для малых значений используется линейный подсчет по числу пустых регистров.
+func (s SetValue) Estimate() int64 {
+	m := float64(len(s.Registers))
+	if m == 0 {
+		return 0
+	}
+
+	var sum float64
+	var zeros int
+	for _, r := range s.Registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+// Clone возвращает копию скетча, не разделяющую регистры с исходным.
+func (s SetValue) Clone() SetValue {
+	return SetValue{Precision: s.Precision, Registers: append([]byte(nil), s.Registers...)}
+}
+
+// String возвращает оценку числа уникальных элементов.
+func (s SetValue) String() string {
+	return strconv.FormatInt(s.Estimate(), 10)
+}
+
+// MarshalBinary кодирует скетч для колонки bytea: версия, точность и регистры.
+func (s SetValue) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 2+len(s.Registers))
+	data = append(data, setEncodingVersion, s.Precision)
+	return append(data, s.Registers...), nil
+}
+
+// UnmarshalBinary разбирает скетч, закодированный MarshalBinary.
+func (s *SetValue) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != setEncodingVersion {
+		return fmt.Errorf("%w: unsupported encoding", ErrInvalidSet)
+	}
+	v := SetValue{Precision: data[1], Registers: append([]byte(nil), data[2:]...)}
+	if err := v.Validate(); err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// mix64 перемешивает биты хеша (финализатор MurmurHash3), чтобы старшие биты FNV распределялись равномерно.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb3fe1a85ec53
+	x ^= x >> 33
+	return x
+}
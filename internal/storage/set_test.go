@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetValue_Estimate(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		s := NewSetValue(DefaultSetPrecision)
+		for i := 0; i < n; i++ {
+			member := fmt.Sprintf("user-%d", i)
+			s.Add(member)
+			s.Add(member) // повторы не учитываются
+		}
+		got := float64(s.Estimate())
+		assert.InDelta(t, float64(n), got, math.Max(1, 0.03*float64(n)), "n=%d", n)
+	}
+}
+
+func TestSetValue_Merge(t *testing.T) {
+	// Агенты видят пересекающиеся множества пользователей
+	a := NewSetValue(DefaultSetPrecision)
+	b := NewSetValue(DefaultSetPrecision)
+	for i := 0; i < 6000; i++ {
+		a.Add(fmt.Sprintf("session-%d", i))
+	}
+	for i := 4000; i < 10000; i++ {
+		b.Add(fmt.Sprintf("session-%d", i))
+	}
+
+	ab := a.Clone()
+	require.NoError(t, ab.Merge(b))
+	ba := b.Clone()
+	require.NoError(t, ba.Merge(a))
+	assert.Equal(t, ab, ba)
+	assert.InEpsilon(t, 10000, float64(ab.Estimate()), 0.03)
+
+	assert.ErrorIs(t, ab.Merge(NewSetValue(MinSetPrecision)), ErrSetPrecision)
+}
+
+func TestSetValue_Encoding(t *testing.T) {
+	s := NewSetValue(MinSetPrecision)
+	s.Add("alice")
+	s.Add("bob")
+
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+	var decoded SetValue
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, s, decoded)
+
+	jsonData, err := json.Marshal(s)
+	require.NoError(t, err)
+	decoded = SetValue{}
+	require.NoError(t, json.Unmarshal(jsonData, &decoded))
+	assert.Equal(t, s, decoded)
+
+	assert.ErrorIs(t, decoded.UnmarshalBinary([]byte{2, 4}), ErrInvalidSet)
+	assert.ErrorIs(t, decoded.UnmarshalBinary([]byte{setEncodingVersion, 4, 0}), ErrInvalidSet)
+}
+
+func TestSetValue_Validate(t *testing.T) {
+	assert.NoError(t, NewSetValue(DefaultSetPrecision).Validate())
+	assert.ErrorIs(t, NewSetValue(MaxSetPrecision+1).Validate(), ErrInvalidSet)
+	assert.ErrorIs(t, SetValue{Precision: 4, Registers: make([]byte, 8)}.Validate(), ErrInvalidSet)
+
+	s := NewSetValue(MinSetPrecision)
+	s.Registers[0] = 64
+	assert.ErrorIs(t, s.Validate(), ErrInvalidSet)
+}
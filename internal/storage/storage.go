@@ -1,7 +1,18 @@
 package storage
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrMetricNotFound возвращается, если метрики с указанным типом и ключом серии нет в хранилище.
+var ErrMetricNotFound = errors.New("metric not found")
+
 // Storage определяет интерфейс для хранения и управления метриками.
 // Реализации этого интерфейса обеспечивают сохранение метрик в памяти или базе данных.
+//
+// Метрика идентифицируется именем и набором меток. В методах, принимающих name,
+// это ключ серии, построенный SeriesKey; для метрик без меток он совпадает с именем.
 type Storage interface {
 	// SaveGaugeMetric сохраняет метрику типа gauge с указанным именем и значением.
 	// Возвращает ошибку, если операция завершилась неудачно.
@@ -20,9 +31,72 @@ type Storage interface {
 	// Если метрика не найдена, возвращается ошибка.
 	GetCounterMetric(name string) (int64, error)
 
-	// GetAllMetrics возвращает все сохраненные метрики в виде карты,
-	// где ключ - имя метрики, а значение - ее текущее значение.
-	GetAllMetrics() map[string]interface{}
+	// SaveHistogramMetric добавляет к гистограмме приращение h (счетчики бакетов, сумму и число наблюдений).
+	// Если гистограммы нет, она создается с границами h. Если границы отличаются
+	// от сохраненных, возвращается ErrHistogramBounds.
+	SaveHistogramMetric(name string, h HistogramValue) error
+
+	// GetHistogramMetric возвращает текущее состояние гистограммы.
+	// Если метрика не найдена, возвращается ошибка.
+	GetHistogramMetric(name string) (HistogramValue, error)
+
+	// SaveSummaryMetric сливает скетч s с сохраненным скетчем summary.
+	// Если метрики нет, она создается из s. Если точность скетчей отличается,
+	// возвращается ErrSummaryAccuracy.
+	SaveSummaryMetric(name string, s SummaryValue) error
+
+	// GetSummaryMetric возвращает текущий скетч summary.
+	// Если метрика не найдена, возвращается ошибка.
+	GetSummaryMetric(name string) (SummaryValue, error)
+
+	// SaveSetMetric объединяет скетч s с сохраненным скетчем set.
+	// Если метрики нет, она создается из s. Если точность скетчей отличается,
+	// возвращается ErrSetPrecision.
+	SaveSetMetric(name string, s SetValue) error
+
+	// GetSetMetric возвращает текущий скетч set.
+	// Если метрика не найдена, возвращается ошибка.
+	GetSetMetric(name string) (SetValue, error)
+
+	// GetMetrics читает за один запрос к хранилищу метрики refs и возвращает найденные,
+	// разделенные по типам. Отсутствующие метрики и ссылки неизвестного типа пропускаются.
+	GetMetrics(refs []MetricRef) (MetricSet, error)
+
+	// ListAllMetrics возвращает все сохраненные метрики, разделенные по типам.
+	// Gauge и counter с одинаковым ключом серии возвращаются оба, каждый в карте своего типа.
+	ListAllMetrics() (MetricSet, error)
+
+	// ListChanges возвращает текущие значения метрик, записанных после ревизии since, метрики,
+	// удаленные после нее (в том числе по сроку хранения), и текущую ревизию. Ревизия хранилища
+	// монотонно растет с каждой записью и удалением. Если since = 0 или изменения после since
+	// неизвестны (например, since больше текущей ревизии), возвращаются все метрики с признаком Reset.
+	ListChanges(since int64) (ChangeSet, error)
+
+	// ListMetrics возвращает страницу gauge и counter с типом, значением и временем последнего сэмпла,
+	// отфильтрованных и упорядоченных по opts. Следующая страница запрашивается с курсором NextCursor;
+	// для чужого курсора возвращается ErrInvalidCursor.
+	ListMetrics(opts ListOptions) (MetricPage, error)
+
+	// GetHistory возвращает точки серии name типа metricType (gauge или counter) с временем в [from, to]
+	// по возрастанию времени: сырые сэмплы или агрегаты с разрешением res. Для ResolutionAuto
+	// разрешение выбирается по длине интервала. Нулевые from и to не ограничивают интервал.
+	// Для других типов возвращается ErrHistoryType, для неизвестной серии - ошибка.
+	GetHistory(metricType, name string, from, to time.Time, res Resolution) ([]HistoryPoint, error)
+
+	// GetHistories возвращает точки истории серий refs (gauge и counter) с временем в [from, to]
+	// за одно обращение к хранилищу. Разрешение одно для всех серий; для ResolutionAuto оно выбирается
+	// по длине [from, to]. Ключ результата - ссылка с каноническим ключом серии; отсутствующие серии
+	// и серии без точек в результат не попадают. Для ссылок других типов возвращается ErrHistoryType.
+	GetHistories(refs []MetricRef, from, to time.Time, res Resolution) (map[MetricRef][]HistoryPoint, error)
+
+	// DeleteMetric удаляет метрику типа metricType с указанным именем вместе с ее историей.
+	// Если метрика не найдена, возвращается ErrMetricNotFound.
+	DeleteMetric(metricType, name string) error
+
+	// DeleteMetrics удаляет метрики refs вместе с их историей за одну операцию: либо удаляются все
+	// найденные метрики, либо, при ошибке, ни одна. Возвращает ссылки на удаленные метрики в порядке refs;
+	// отсутствующие метрики пропускаются, для ссылки неизвестного типа возвращается ошибка.
+	DeleteMetrics(refs []MetricRef) ([]MetricRef, error)
 
 	// UpdateMetricsBatch обновляет несколько метрик одновременно.
 	// Принимает массив структур Metrics и обновляет соответствующие метрики в хранилище.
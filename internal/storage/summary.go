@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultSummaryAccuracy - относительная точность квантилей скетча по умолчанию (1%).
+	DefaultSummaryAccuracy = 0.01
+	// SummaryMaxBins - максимальное число бинов в каждой половине скетча.
+	// При превышении младшие бины объединяются, что снижает точность только для малых по модулю значений.
+	SummaryMaxBins = 2048
+	// summaryMinValue - значения меньше по модулю попадают в нулевой бин.
+	summaryMinValue = 1e-9
+)
+
+// DefaultSummaryQuantiles - квантили, которые возвращаются для summary по умолчанию.
+var DefaultSummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// ErrInvalidSummary возвращается для скетча с некорректной точностью или счетчиками.
+var ErrInvalidSummary = errors.New("invalid summary")
+
+// ErrSummaryAccuracy возвращается при слиянии скетчей с разной относительной точностью.
+var ErrSummaryAccuracy = errors.New("summary accuracy mismatch")
+
+// SummaryValue - скетч DDSketch для вычисления квантилей с гарантированной относительной точностью.
+// Наблюдение v > 0 попадает в бин с индексом ceil(log_gamma(v)), где gamma = (1+a)/(1-a),
+// отрицательные значения хранятся отдельно по модулю. Слияние скетчей с одинаковой точностью
+// сводится к сложению счетчиков бинов, поэтому результат не зависит от порядка и числа агентов.
+type SummaryValue struct {
+	Accuracy float64       `json:"accuracy" msgpack:"accuracy"`                     // относительная точность a
+	Positive map[int]int64 `json:"positive,omitempty" msgpack:"positive,omitempty"` // бины положительных значений
+	Negative map[int]int64 `json:"negative,omitempty" msgpack:"negative,omitempty"` // бины отрицательных значений по модулю
+	Zero     int64         `json:"zero" msgpack:"zero"`                             // число значений, близких к нулю
+	Count    int64         `json:"count" msgpack:"count"`                           // общее число наблюдений
+	Sum      float64       `json:"sum" msgpack:"sum"`                               // сумма наблюдений
+	Min      float64       `json:"min" msgpack:"min"`                               // минимальное наблюдение
+	Max      float64       `json:"max" msgpack:"max"`                               // максимальное наблюдение
+}
+
+// NewSummaryValue создает пустой скетч с заданной относительной точностью.
+func NewSummaryValue(accuracy float64) SummaryValue {
+	return SummaryValue{
+		Accuracy: accuracy,
+		Positive: make(map[int]int64),
+		Negative: make(map[int]int64),
+	}
+}
+
+// Validate проверяет точность скетча и согласованность счетчиков бинов с Count.
+func (s SummaryValue) Validate() error {
+	if !(s.Accuracy > 0 && s.Accuracy < 1) {
+		return fmt.Errorf("%w: accuracy must be in (0, 1)", ErrInvalidSummary)
+	}
+	if s.Zero < 0 {
+		return fmt.Errorf("%w: negative zero count", ErrInvalidSummary)
+	}
+	total := s.Zero
+	for _, bins := range []map[int]int64{s.Positive, s.Negative} {
+		if len(bins) > SummaryMaxBins {
+			return fmt.Errorf("%w: more than %d bins", ErrInvalidSummary, SummaryMaxBins)
+		}
+		for _, c := range bins {
+			if c < 0 {
+				return fmt.Errorf("%w: negative bin count", ErrInvalidSummary)
+			}
+			total += c
+		}
+	}
+	if total != s.Count {
+		return fmt.Errorf("%w: bins hold %d observations but count is %d", ErrInvalidSummary, total, s.Count)
+	}
+	if s.Count > 0 && s.Min > s.Max {
+		return fmt.Errorf("%w: min is greater than max", ErrInvalidSummary)
+	}
+	return nil
+}
+
+// Add добавляет одно наблюдение. NaN и бесконечности пропускаются.
+func (s *SummaryValue) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	switch {
+	case v > summaryMinValue:
+		s.Positive = addBin(s.Positive, s.index(v), 1)
+	case v < -summaryMinValue:
+		s.Negative = addBin(s.Negative, s.index(-v), 1)
+	default:
+		s.Zero++
+	}
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+}
+
+// Merge добавляет к скетчу наблюдения другого скетча с той же точностью.
+func (s *SummaryValue) Merge(other SummaryValue) error {
+	if s.Accuracy != other.Accuracy {
+		return ErrSummaryAccuracy
+	}
+	if other.Count == 0 {
+		return nil
+	}
+	for i, c := range other.Positive {
+		s.Positive = addBin(s.Positive, i, c)
+	}
+	for i, c := range other.Negative {
+		s.Negative = addBin(s.Negative, i, c)
+	}
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Zero += other.Zero
+	s.Count += other.Count
+	s.Sum += other.Sum
+	return nil
+}
+
+// Quantile возвращает оценку квантиля q из [0, 1] с относительной погрешностью не более Accuracy.
+// Для пустого скетча возвращается NaN.
+func (s SummaryValue) Quantile(q float64) float64 {
+	if s.Count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	rank := q * float64(s.Count-1)
+	var seen int64
+	value := math.NaN()
+
+	// Бины обходятся по возрастанию значений: отрицательные от больших по модулю, ноль, положительные
+	for _, i := range sortedBins(s.Negative, true) {
+		seen += s.Negative[i]
+		if float64(seen) > rank {
+			value = -s.binValue(i)
+			break
+		}
+	}
+	if math.IsNaN(value) {
+		seen += s.Zero
+		if float64(seen) > rank {
+			value = 0
+		}
+	}
+	if math.IsNaN(value) {
+		for _, i := range sortedBins(s.Positive, false) {
+			seen += s.Positive[i]
+			if float64(seen) > rank {
+				value = s.binValue(i)
+				break
+			}
+		}
+	}
+	if math.IsNaN(value) {
+		value = s.Max
+	}
+	return math.Min(math.Max(value, s.Min), s.Max)
+}
+
+// Clone возвращает копию скетча, не разделяющую карты с исходным.
+func (s SummaryValue) Clone() SummaryValue {
+	c := s
+	c.Positive = make(map[int]int64, len(s.Positive))
+	for i, v := range s.Positive {
+		c.Positive[i] = v
+	}
+	c.Negative = make(map[int]int64, len(s.Negative))
+	for i, v := range s.Negative {
+		c.Negative[i] = v
+	}
+	return c
+}
+
+// String возвращает краткое представление скетча с квантилями DefaultSummaryQuantiles.
+func (s SummaryValue) String() string {
+	var b strings.Builder
+	b.WriteString("count=" + strconv.FormatInt(s.Count, 10))
+	b.WriteString(" sum=" + strconv.FormatFloat(s.Sum, 'g', -1, 64))
+	if s.Count > 0 {
+		for _, q := range DefaultSummaryQuantiles {
+			b.WriteString(" p" + strconv.FormatFloat(q*100, 'g', -1, 64) + "=")
+			b.WriteString(strconv.FormatFloat(s.Quantile(q), 'g', 6, 64))
+		}
+	}
+	return b.String()
+}
+
+func (s SummaryValue) gamma() float64 {
+	return (1 + s.Accuracy) / (1 - s.Accuracy)
+}
+
+// index возвращает индекс бина для положительного значения v.
+func (s SummaryValue) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / math.Log(s.gamma())))
+}
+
+// binValue возвращает представительное значение бина, равноудаленное в относительных единицах от его границ.
+func (s SummaryValue) binValue(i int) float64 {
+	g := s.gamma()
+	return 2 * math.Pow(g, float64(i)) / (g + 1)
+}
+
+// addBin увеличивает счетчик бина и при превышении SummaryMaxBins объединяет младшие бины.
+func addBin(bins map[int]int64, i int, c int64) map[int]int64 {
+	if bins == nil {
+		bins = make(map[int]int64)
+	}
+	bins[i] += c
+	if len(bins) > SummaryMaxBins {
+		indexes := sortedBins(bins, false)
+		excess := len(indexes) - SummaryMaxBins
+		target := indexes[excess]
+		for _, j := range indexes[:excess] {
+			bins[target] += bins[j]
+			delete(bins, j)
+		}
+	}
+	return bins
+}
+
+// sortedBins возвращает индексы бинов по возрастанию (или по убыванию при desc).
+func sortedBins(bins map[int]int64, desc bool) []int {
+	indexes := make([]int, 0, len(bins))
+	for i := range bins {
+		indexes = append(indexes, i)
+	}
+	if desc {
+		sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+	} else {
+		sort.Ints(indexes)
+	}
+	return indexes
+}
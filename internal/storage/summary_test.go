@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryValue_Quantile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Наблюдения распределены между несколькими агентами, скетчи которых сливаются на сервере
+	var values []float64
+	merged := NewSummaryValue(DefaultSummaryAccuracy)
+	for agent := 0; agent < 8; agent++ {
+		sketch := NewSummaryValue(DefaultSummaryAccuracy)
+		for i := 0; i < 1000; i++ {
+			v := math.Exp(rng.NormFloat64()*2) - 0.5
+			values = append(values, v)
+			sketch.Add(v)
+		}
+		require.NoError(t, merged.Merge(sketch))
+	}
+	require.NoError(t, merged.Validate())
+	sort.Float64s(values)
+
+	assert.Equal(t, int64(len(values)), merged.Count)
+	assert.Equal(t, values[0], merged.Min)
+	assert.Equal(t, values[len(values)-1], merged.Max)
+
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 1} {
+		want := values[int(q*float64(len(values)-1))]
+		got := merged.Quantile(q)
+		assert.InEpsilon(t, want, got, DefaultSummaryAccuracy+1e-9, "quantile %v", q)
+	}
+}
+
+func TestSummaryValue_Merge(t *testing.T) {
+	a := NewSummaryValue(0.02)
+	a.Add(1)
+	a.Add(-3)
+	b := NewSummaryValue(0.02)
+	b.Add(0)
+	b.Add(10)
+
+	// Результат слияния не зависит от порядка
+	ab := a.Clone()
+	require.NoError(t, ab.Merge(b))
+	ba := b.Clone()
+	require.NoError(t, ba.Merge(a))
+	assert.Equal(t, ab, ba)
+	assert.Equal(t, int64(4), ab.Count)
+	assert.Equal(t, 8.0, ab.Sum)
+	assert.Equal(t, -3.0, ab.Min)
+	assert.Equal(t, 10.0, ab.Max)
+	assert.Equal(t, int64(1), ab.Zero)
+
+	assert.ErrorIs(t, ab.Merge(NewSummaryValue(0.01)), ErrSummaryAccuracy)
+	assert.True(t, math.IsNaN(NewSummaryValue(0.01).Quantile(0.5)))
+}
+
+func TestSummaryValue_MaxBins(t *testing.T) {
+	s := NewSummaryValue(0.001)
+	for i := 0; i < 3*SummaryMaxBins; i++ {
+		s.Add(math.Pow(1.01, float64(i)))
+	}
+	assert.Len(t, s.Positive, SummaryMaxBins)
+	assert.NoError(t, s.Validate())
+
+	// Старшие квантили не теряют точность при объединении младших бинов
+	assert.InEpsilon(t, s.Max, s.Quantile(1), 0.001)
+}
+
+func TestSummaryValue_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    SummaryValue
+	}{
+		{name: "Zero accuracy", s: SummaryValue{}},
+		{name: "Count mismatch", s: SummaryValue{Accuracy: 0.01, Positive: map[int]int64{1: 2}, Count: 3}},
+		{name: "Negative bin", s: SummaryValue{Accuracy: 0.01, Negative: map[int]int64{1: -1}, Count: -1}},
+		{name: "Min greater than max", s: SummaryValue{Accuracy: 0.01, Zero: 1, Count: 1, Min: 1, Max: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.s.Validate(), ErrInvalidSummary)
+		})
+	}
+}
+
+func TestSummaryValue_String(t *testing.T) {
+	s := NewSummaryValue(0.01)
+	assert.Equal(t, "count=0 sum=0", s.String())
+
+	s.Add(0)
+	assert.Equal(t, "count=1 sum=0 p50=0 p90=0 p99=0", s.String())
+}
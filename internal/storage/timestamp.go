@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OutOfOrderPolicy определяет, как хранилище обрабатывает gauge-сэмпл, время которого
+// раньше времени уже сохраненного значения серии (например, после повторной отправки агентом).
+type OutOfOrderPolicy string
+
+const (
+	// OutOfOrderAccept - сэмпл сохраняется независимо от времени (поведение по умолчанию).
+	OutOfOrderAccept OutOfOrderPolicy = "accept"
+	// OutOfOrderReject - запрос с устаревшим сэмплом отклоняется с ErrOutOfOrder.
+	OutOfOrderReject OutOfOrderPolicy = "reject"
+	// OutOfOrderLatest - устаревший сэмпл пропускается, остается значение с наибольшим временем.
+	OutOfOrderLatest OutOfOrderPolicy = "latest"
+)
+
+// ErrOutOfOrder возвращается для gauge-сэмпла старше сохраненного значения при политике OutOfOrderReject.
+var ErrOutOfOrder = errors.New("out-of-order sample")
+
+// ParseOutOfOrderPolicy разбирает политику: accept, reject или latest. Пустая строка означает accept.
+func ParseOutOfOrderPolicy(s string) (OutOfOrderPolicy, error) {
+	switch p := OutOfOrderPolicy(s); p {
+	case "":
+		return OutOfOrderAccept, nil
+	case OutOfOrderAccept, OutOfOrderReject, OutOfOrderLatest:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown out-of-order policy: %s", s)
+	}
+}
+
+// sampleTime возвращает время сэмпла: время сбора от клиента или, если оно не передано, текущее время.
+func sampleTime(ts *time.Time) time.Time {
+	if ts == nil || ts.IsZero() {
+		return time.Now().UTC()
+	}
+	return ts.UTC()
+}
+
+// checkOrder сравнивает время нового gauge-сэмпла со временем сохраненного значения.
+// Возвращает false, если сэмпл нужно пропустить, и ErrOutOfOrder, если его нужно отклонить.
+func (p OutOfOrderPolicy) checkOrder(current, ts time.Time) (bool, error) {
+	if current.IsZero() || !ts.Before(current) {
+		return true, nil
+	}
+	switch p {
+	case OutOfOrderReject:
+		return false, ErrOutOfOrder
+	case OutOfOrderLatest:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
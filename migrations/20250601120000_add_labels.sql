@@ -0,0 +1,21 @@
+-- +goose Up
+
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+ALTER TABLE gauges ADD PRIMARY KEY (name, labels);
+
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+ALTER TABLE counters ADD PRIMARY KEY (name, labels);
+
+-- +goose Down
+
+DELETE FROM gauges WHERE labels <> '{}';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+ALTER TABLE gauges ADD PRIMARY KEY (name);
+ALTER TABLE gauges DROP COLUMN IF EXISTS labels;
+
+DELETE FROM counters WHERE labels <> '{}';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+ALTER TABLE counters ADD PRIMARY KEY (name);
+ALTER TABLE counters DROP COLUMN IF EXISTS labels;
@@ -0,0 +1,13 @@
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS summaries (
+                                         name TEXT NOT NULL,
+                                         labels JSONB NOT NULL DEFAULT '{}',
+                                         value JSONB NOT NULL,
+                                         updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                         PRIMARY KEY (name, labels)
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS summaries;
@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- updated_at - время сэмпла, которое передает сервер (время сбора от агента или время получения)
+ALTER TABLE gauges ALTER COLUMN updated_at DROP DEFAULT;
+ALTER TABLE counters ALTER COLUMN updated_at DROP DEFAULT;
+ALTER TABLE histograms ALTER COLUMN updated_at DROP DEFAULT;
+ALTER TABLE summaries ALTER COLUMN updated_at DROP DEFAULT;
+ALTER TABLE sets ALTER COLUMN updated_at DROP DEFAULT;
+
+-- +goose Down
+
+ALTER TABLE gauges ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE counters ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE histograms ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE summaries ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE sets ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
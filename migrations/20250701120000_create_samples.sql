@@ -0,0 +1,28 @@
+-- +goose Up
+
+-- Журнал сэмплов: строка добавляется при каждом сохранении, таблицы gauges и counters
+-- остаются представлением последних значений
+CREATE TABLE IF NOT EXISTS gauge_samples (
+                                             name TEXT NOT NULL,
+                                             labels JSONB NOT NULL DEFAULT '{}',
+                                             value DOUBLE PRECISION NOT NULL,
+                                             ts TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS gauge_samples_series_ts_idx ON gauge_samples (name, labels, ts);
+CREATE INDEX IF NOT EXISTS gauge_samples_ts_idx ON gauge_samples (ts);
+
+-- value - накопленное значение counter после применения delta
+CREATE TABLE IF NOT EXISTS counter_samples (
+                                               name TEXT NOT NULL,
+                                               labels JSONB NOT NULL DEFAULT '{}',
+                                               delta BIGINT NOT NULL,
+                                               value BIGINT NOT NULL,
+                                               ts TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS counter_samples_series_ts_idx ON counter_samples (name, labels, ts);
+CREATE INDEX IF NOT EXISTS counter_samples_ts_idx ON counter_samples (ts);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS gauge_samples;
+DROP TABLE IF EXISTS counter_samples;
@@ -0,0 +1,34 @@
+-- +goose Up
+
+-- Агрегаты журналов сэмплов за минуту (resolution = 60) и час (resolution = 3600).
+-- Для gauge sum - сумма значений, для counter - сумма приращений; last - последнее значение в интервале
+CREATE TABLE IF NOT EXISTS gauge_rollups (
+                                             resolution INTEGER NOT NULL,
+                                             name TEXT NOT NULL,
+                                             labels JSONB NOT NULL DEFAULT '{}',
+                                             bucket TIMESTAMP NOT NULL,
+                                             min DOUBLE PRECISION NOT NULL,
+                                             max DOUBLE PRECISION NOT NULL,
+                                             sum DOUBLE PRECISION NOT NULL,
+                                             last DOUBLE PRECISION NOT NULL,
+                                             count BIGINT NOT NULL,
+                                             PRIMARY KEY (resolution, name, labels, bucket)
+);
+CREATE INDEX IF NOT EXISTS gauge_rollups_bucket_idx ON gauge_rollups (resolution, bucket);
+
+CREATE TABLE IF NOT EXISTS counter_rollups (
+                                               resolution INTEGER NOT NULL,
+                                               name TEXT NOT NULL,
+                                               labels JSONB NOT NULL DEFAULT '{}',
+                                               bucket TIMESTAMP NOT NULL,
+                                               sum BIGINT NOT NULL,
+                                               last BIGINT NOT NULL,
+                                               count BIGINT NOT NULL,
+                                               PRIMARY KEY (resolution, name, labels, bucket)
+);
+CREATE INDEX IF NOT EXISTS counter_rollups_bucket_idx ON counter_rollups (resolution, bucket);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS gauge_rollups;
+DROP TABLE IF EXISTS counter_rollups;
@@ -0,0 +1,93 @@
+-- +goose Up
+
+-- revision - ревизия последней записи метрики: номер записавшей транзакции (pg_current_xact_id) плюс один.
+-- Номера транзакций монотонно растут и выдаются без блокировок. Лента изменений отдает только ревизии
+-- не больше pg_snapshot_xmin текущего снимка: все транзакции с меньшими номерами уже завершены,
+-- поэтому еще не зафиксированная запись с меньшей ревизией не может появиться позже.
+-- Существующие метрики получают начальную ревизию 1.
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE histograms ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE summaries ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS gauges_revision_idx ON gauges (revision);
+CREATE INDEX IF NOT EXISTS counters_revision_idx ON counters (revision);
+CREATE INDEX IF NOT EXISTS histograms_revision_idx ON histograms (revision);
+CREATE INDEX IF NOT EXISTS summaries_revision_idx ON summaries (revision);
+CREATE INDEX IF NOT EXISTS sets_revision_idx ON sets (revision);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_metric_revision() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.revision := pg_current_xact_id()::TEXT::BIGINT + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER gauges_revision BEFORE INSERT OR UPDATE ON gauges
+    FOR EACH ROW EXECUTE FUNCTION set_metric_revision();
+CREATE TRIGGER counters_revision BEFORE INSERT OR UPDATE ON counters
+    FOR EACH ROW EXECUTE FUNCTION set_metric_revision();
+CREATE TRIGGER histograms_revision BEFORE INSERT OR UPDATE ON histograms
+    FOR EACH ROW EXECUTE FUNCTION set_metric_revision();
+CREATE TRIGGER summaries_revision BEFORE INSERT OR UPDATE ON summaries
+    FOR EACH ROW EXECUTE FUNCTION set_metric_revision();
+CREATE TRIGGER sets_revision BEFORE INSERT OR UPDATE ON sets
+    FOR EACH ROW EXECUTE FUNCTION set_metric_revision();
+
+-- metric_tombstones - удаленные метрики с ревизией удаления для ленты изменений
+CREATE TABLE IF NOT EXISTS metric_tombstones (
+                                                 type TEXT NOT NULL,
+                                                 name TEXT NOT NULL,
+                                                 labels JSONB NOT NULL DEFAULT '{}',
+                                                 revision BIGINT NOT NULL,
+                                                 PRIMARY KEY (type, name, labels)
+);
+CREATE INDEX IF NOT EXISTS metric_tombstones_revision_idx ON metric_tombstones (revision);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_metric_tombstone() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO metric_tombstones (type, name, labels, revision)
+    VALUES (TG_ARGV[0], OLD.name, OLD.labels, pg_current_xact_id()::TEXT::BIGINT + 1)
+    ON CONFLICT (type, name, labels) DO UPDATE SET revision = EXCLUDED.revision;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER gauges_tombstone AFTER DELETE ON gauges
+    FOR EACH ROW EXECUTE FUNCTION record_metric_tombstone('gauge');
+CREATE TRIGGER counters_tombstone AFTER DELETE ON counters
+    FOR EACH ROW EXECUTE FUNCTION record_metric_tombstone('counter');
+CREATE TRIGGER histograms_tombstone AFTER DELETE ON histograms
+    FOR EACH ROW EXECUTE FUNCTION record_metric_tombstone('histogram');
+CREATE TRIGGER summaries_tombstone AFTER DELETE ON summaries
+    FOR EACH ROW EXECUTE FUNCTION record_metric_tombstone('summary');
+CREATE TRIGGER sets_tombstone AFTER DELETE ON sets
+    FOR EACH ROW EXECUTE FUNCTION record_metric_tombstone('set');
+
+-- +goose Down
+
+DROP TRIGGER IF EXISTS gauges_tombstone ON gauges;
+DROP TRIGGER IF EXISTS counters_tombstone ON counters;
+DROP TRIGGER IF EXISTS histograms_tombstone ON histograms;
+DROP TRIGGER IF EXISTS summaries_tombstone ON summaries;
+DROP TRIGGER IF EXISTS sets_tombstone ON sets;
+DROP FUNCTION IF EXISTS record_metric_tombstone();
+DROP TABLE IF EXISTS metric_tombstones;
+
+DROP TRIGGER IF EXISTS gauges_revision ON gauges;
+DROP TRIGGER IF EXISTS counters_revision ON counters;
+DROP TRIGGER IF EXISTS histograms_revision ON histograms;
+DROP TRIGGER IF EXISTS summaries_revision ON summaries;
+DROP TRIGGER IF EXISTS sets_revision ON sets;
+DROP FUNCTION IF EXISTS set_metric_revision();
+
+ALTER TABLE gauges DROP COLUMN IF EXISTS revision;
+ALTER TABLE counters DROP COLUMN IF EXISTS revision;
+ALTER TABLE histograms DROP COLUMN IF EXISTS revision;
+ALTER TABLE summaries DROP COLUMN IF EXISTS revision;
+ALTER TABLE sets DROP COLUMN IF EXISTS revision;
@@ -0,0 +1,40 @@
+-- +goose Up
+
+-- deleted_at - время удаления метрики; записи старше срока хранения удаляются при применении правил хранения
+ALTER TABLE metric_tombstones ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS metric_tombstones_deleted_at_idx ON metric_tombstones (deleted_at);
+
+-- metric_tombstone_horizon - наибольшая ревизия удаленных из metric_tombstones записей (одна строка).
+-- Клиенты ленты изменений с меньшей ревизией могли не увидеть удаление и получают полный набор метрик.
+CREATE TABLE IF NOT EXISTS metric_tombstone_horizon (
+                                                        id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+                                                        revision BIGINT NOT NULL
+);
+INSERT INTO metric_tombstone_horizon (revision) VALUES (0) ON CONFLICT DO NOTHING;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_metric_tombstone() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO metric_tombstones (type, name, labels, revision)
+    VALUES (TG_ARGV[0], OLD.name, OLD.labels, pg_current_xact_id()::TEXT::BIGINT + 1)
+    ON CONFLICT (type, name, labels) DO UPDATE SET revision = EXCLUDED.revision, deleted_at = EXCLUDED.deleted_at;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_metric_tombstone() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO metric_tombstones (type, name, labels, revision)
+    VALUES (TG_ARGV[0], OLD.name, OLD.labels, pg_current_xact_id()::TEXT::BIGINT + 1)
+    ON CONFLICT (type, name, labels) DO UPDATE SET revision = EXCLUDED.revision;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TABLE IF EXISTS metric_tombstone_horizon;
+ALTER TABLE metric_tombstones DROP COLUMN IF EXISTS deleted_at;